@@ -5,3 +5,9 @@ export CGO_CFLAGS="-I/usr/local/include/tensorflow"
 export CGO_LDFLAGS="-L/usr/local/lib"
 
 go build -o dist/detectnsfw cmd/server/*.go
+go build -o dist/nsfwworker cmd/worker/*.go
+go build -o dist/archive cmd/archive/*.go
+go build -o dist/scan cmd/scan/*.go
+go build -o dist/nsfwcli cmd/cli/*.go
+go build -tags notensorflow -o dist/detectnsfw-dev cmd/server/*.go
+go build -o dist/mockidp cmd/mockidp/*.go
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// archiveEvent mirrors services.ArchiveEvent without pulling in the model packages
+type archiveEvent struct {
+	Entry *struct {
+		Index      int    `json:"index"`
+		Name       string `json:"name"`
+		Prediction struct {
+			NSFWPercentage float32 `json:"nsfw_percentage"`
+			SFWPercentage  float32 `json:"sfw_percentage"`
+			SHA256         string  `json:"sha256"`
+			Error          string  `json:"error"`
+			Success        bool    `json:"success"`
+		} `json:"prediction"`
+	} `json:"entry"`
+	Report *struct {
+		Archive   string `json:"archive"`
+		Total     int    `json:"total"`
+		Succeeded int    `json:"succeeded"`
+		Failed    int    `json:"failed"`
+	} `json:"report"`
+}
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "Base URL of the detection API")
+	output := flag.String("o", "", "Write the full JSON report to this file")
+	timeout := flag.Duration("timeout", 30*time.Minute, "Maximum time to wait for the report")
+	apiKey := flag.String("key", os.Getenv("NSFW_API_KEY"), "API key to authenticate with (default $NSFW_API_KEY)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: archive [flags] <archive.zip|archive.tar.gz>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	body, contentType, err := buildForm(flag.Arg(0))
+	if err != nil {
+		fmt.Println("Failed to read archive:", err)
+		os.Exit(1)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, *server+"/api/detect-nsfw/archive", body)
+	if err != nil {
+		fmt.Println("Failed to build request:", err)
+		os.Exit(1)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if *apiKey != "" {
+		req.Header.Set("X-API-Key", *apiKey)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Println("Failed to upload archive:", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(resp.Body)
+		fmt.Printf("Server returned %s: %s\n", resp.Status, raw)
+		os.Exit(1)
+	}
+
+	var out io.Writer = io.Discard
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Println("Failed to create report:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	// Entries arrive one JSON line at a time while the server works through the archive,
+	// the last line is the report
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if _, err := fmt.Fprintf(out, "%s\n", line); err != nil {
+			fmt.Println("Failed to write report:", err)
+			os.Exit(1)
+		}
+
+		var event archiveEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			fmt.Println("Failed to decode response:", err)
+			os.Exit(1)
+		}
+
+		switch {
+		case event.Entry != nil:
+			entry := event.Entry
+			if entry.Prediction.Success {
+				fmt.Printf("%d\t%s\tNSFW %.2f%%\tSFW %.2f%%\t%s\n", entry.Index, entry.Name, entry.Prediction.NSFWPercentage, entry.Prediction.SFWPercentage, entry.Prediction.SHA256)
+			} else {
+				fmt.Printf("%d\t%s\tERROR\t%s\n", entry.Index, entry.Name, entry.Prediction.Error)
+			}
+		case event.Report != nil:
+			report := event.Report
+			fmt.Printf("%s: %d entries, %d succeeded, %d failed\n", report.Archive, report.Total, report.Succeeded, report.Failed)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Println("Failed to read response:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Server closed the connection before reporting the whole archive")
+	os.Exit(1)
+}
+
+// buildForm wraps the archive in a multipart form under the 'archive' field
+func buildForm(path string) (io.Reader, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	part, err := writer.CreateFormFile("archive", filepath.Base(path))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err = io.Copy(part, file); err != nil {
+		return nil, "", err
+	}
+
+	if err = writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return &buf, writer.FormDataContentType(), nil
+}
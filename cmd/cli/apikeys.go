@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+)
+
+// apiKeys returns the API key service acting as the CLI user, logging to logs/cli.log
+func (a *app) apiKeys() (*services.APIKeyService, context.Context, error) {
+	repos, err := a.repos()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := logger.Init("logs/cli.log"); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	ctx := adminContext()
+	_, counters := a.authState()
+	return services.NewAPIKeyService(repos, auth.NewAPIKeyLimiter(counters)), ctx, nil
+}
+
+func apiKeyCreate(a *app, args []string) error {
+	usage := "usage: apikey create <client> [-rate n] [-daily n] [-monthly n] [-tenant name]"
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		return errors.New(usage)
+	}
+
+	fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+	rate := fs.Int("rate", 0, "Requests per minute, 0 = unlimited (default from api_keys.default_rate_limit_per_min)")
+	daily := fs.Int("daily", 0, "Requests per UTC day, 0 = unlimited (default from api_keys.default_daily_quota)")
+	monthly := fs.Int("monthly", 0, "Requests per UTC month, 0 = unlimited (default from api_keys.default_monthly_quota)")
+	tenantName := fs.String("tenant", "", "Tenant the client uploads to (default: the default tenant)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New(usage)
+	}
+
+	// limits that were not passed keep the configured defaults
+	req := models.CreateAPIKeyRequest{ClientName: args[0]}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "rate":
+			req.RateLimitPerMin = rate
+		case "daily":
+			req.DailyQuota = daily
+		case "monthly":
+			req.MonthlyQuota = monthly
+		}
+	})
+
+	tenantID, err := a.tenantID(*tenantName, models.DefaultTenantID)
+	if err != nil {
+		return err
+	}
+
+	keys, ctx, err := a.apiKeys()
+	if err != nil {
+		return err
+	}
+
+	key, err := keys.Create(middleware.WithTenant(ctx, tenantID), req)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("API key %d created for %s, it is not shown again:\n%s\n", key.ID, key.ClientName, key.Key)
+	return nil
+}
+
+func apiKeyList(a *app, args []string) error {
+	fs := flag.NewFlagSet("apikey list", flag.ContinueOnError)
+	tenantName := fs.String("tenant", "", "Only list keys of this tenant")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tenantID, err := a.tenantID(*tenantName, models.AllTenants)
+	if err != nil {
+		return err
+	}
+
+	keys, ctx, err := a.apiKeys()
+	if err != nil {
+		return err
+	}
+
+	list, err := keys.List(middleware.WithTenant(ctx, tenantID))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTENANT\tPREFIX\tCLIENT\tRATE/MIN\tDAILY\tMONTHLY\tSTATUS\tLAST USED\tCREATED")
+	for _, k := range list {
+		status := "active"
+		if k.RevokedAt != nil {
+			status = "revoked"
+		}
+		lastUsed := "never"
+		if k.LastUsedAt != nil {
+			lastUsed = k.LastUsedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.TenantID, k.Prefix, k.ClientName,
+			formatLimit(k.RateLimitPerMin), formatLimit(k.DailyQuota), formatLimit(k.MonthlyQuota),
+			status, lastUsed, k.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+func apiKeyRevoke(a *app, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: apikey revoke <id>")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid API key id: %s", args[0])
+	}
+
+	keys, ctx, err := a.apiKeys()
+	if err != nil {
+		return err
+	}
+
+	if _, err := keys.Revoke(ctx, id); err != nil {
+		return err
+	}
+
+	fmt.Println("API key revoked successfully!")
+	return nil
+}
+
+func formatLimit(limit int) string {
+	if limit == 0 {
+		return "-"
+	}
+	return strconv.Itoa(limit)
+}
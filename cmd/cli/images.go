@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+func imageList(a *app, args []string) error {
+	fs := flag.NewFlagSet("image list", flag.ContinueOnError)
+	reviewedFlag := fs.String("reviewed", "", "Only list reviewed (true) or unreviewed (false) images")
+	limit := fs.Int("limit", 50, "Number of images to list")
+	cursor := fs.Int("cursor", 0, "List images with an ID lower than this")
+	tenantName := fs.String("tenant", "", "Only list images of this tenant")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var reviewed *bool
+	if *reviewedFlag != "" {
+		v, err := strconv.ParseBool(*reviewedFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -reviewed value: %s", *reviewedFlag)
+		}
+		reviewed = &v
+	}
+
+	// the repository lists IDs below the cursor, so start above any existing ID
+	cursorID := *cursor
+	if cursorID == 0 {
+		cursorID = int(^uint32(0) >> 1)
+	}
+
+	tenantID, err := a.tenantID(*tenantName, models.AllTenants)
+	if err != nil {
+		return err
+	}
+
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	uploads, err := repos.Uploaded.ListUploadsCursor(context.Background(), tenantID, cursorID, *limit, reviewed)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTENANT\tSHA256\tLABEL\tCONFIDENCE\tREVIEWED\tNEW LABEL\tCREATED")
+	for _, u := range uploads {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%.2f\t%t\t%s\t%s\n",
+			u.ID, u.TenantID, u.FileHash, u.Label, u.Confidence, u.Reviewed, u.NewLabel, u.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+// imageTenant parses the arguments of the image commands working on one image, which
+// is looked up in the default tenant unless -tenant is given
+func imageTenant(a *app, name, usage string, n int, args []string) ([]string, int, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	tenantName := fs.String("tenant", "", "Tenant of the image (default: the default tenant)")
+
+	positional, err := parseArgs(fs, usage, n, args)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tenantID, err := a.tenantID(*tenantName, models.DefaultTenantID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return positional, tenantID, nil
+}
+
+func imageLabel(a *app, args []string) error {
+	positional, tenantID, err := imageTenant(a, "image label", "usage: image label <sha256> <NSFW|SFW> [-tenant name]", 2, args)
+	if err != nil {
+		return err
+	}
+
+	hash, label := positional[0], positional[1]
+	if label != "NSFW" && label != "SFW" {
+		return errors.New("invalid rating, expected NSFW or SFW")
+	}
+
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	if _, err := repos.Uploaded.LabelUpload(context.Background(), tenantID, hash, label, actor()); err != nil {
+		return err
+	}
+	a.invalidateCache(tenantID, hash)
+
+	fmt.Println("Image labeled successfully!")
+	return nil
+}
+
+func imageDelete(a *app, args []string) error {
+	positional, tenantID, err := imageTenant(a, "image delete", "usage: image delete <sha256> [-tenant name]", 1, args)
+	if err != nil {
+		return err
+	}
+	hash := positional[0]
+
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	path, err := repos.Uploaded.GetFilePathByHash(ctx, tenantID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to fetch file path: %w", err)
+	}
+	if path == "" {
+		return errors.New("image not found")
+	}
+
+	store, err := a.store()
+	if err != nil {
+		return err
+	}
+
+	if err = store.MoveToTrash(ctx, path); err != nil {
+		return fmt.Errorf("failed to move file to trash: %w", err)
+	}
+
+	rows, err := repos.Uploaded.DeleteImage(ctx, tenantID, hash, actor())
+	if err != nil {
+		store.RestoreFromTrash(ctx, path)
+		return err
+	}
+	if rows == 0 {
+		store.RestoreFromTrash(ctx, path)
+		return errors.New("image not found")
+	}
+	a.invalidateCache(tenantID, hash)
+
+	fmt.Println("Image moved to trash!")
+	return nil
+}
+
+func imageRestore(a *app, args []string) error {
+	positional, tenantID, err := imageTenant(a, "image restore", "usage: image restore <sha256> [-tenant name]", 1, args)
+	if err != nil {
+		return err
+	}
+	hash := positional[0]
+
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	img, err := repos.Uploaded.GetImageByHash(ctx, tenantID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to fetch image: %w", err)
+	}
+	if img == nil || img.DeletedAt == nil {
+		return errors.New("image not in trash")
+	}
+
+	store, err := a.store()
+	if err != nil {
+		return err
+	}
+
+	if err = store.RestoreFromTrash(ctx, img.FilePath); err != nil {
+		return fmt.Errorf("failed to restore file from trash: %w", err)
+	}
+
+	rows, err := repos.Uploaded.RestoreImage(ctx, tenantID, hash, actor())
+	if err != nil {
+		store.MoveToTrash(ctx, img.FilePath)
+		return err
+	}
+	if rows == 0 {
+		store.MoveToTrash(ctx, img.FilePath)
+		return errors.New("image not in trash")
+	}
+	a.invalidateCache(tenantID, hash)
+
+	fmt.Println("Image restored successfully!")
+	return nil
+}
+
+func imageHistory(a *app, args []string) error {
+	fs := flag.NewFlagSet("image history", flag.ContinueOnError)
+	tenantName := fs.String("tenant", "", "Only list events of this tenant")
+
+	positional, err := parseArgs(fs, "usage: image history <sha256> [-tenant name]", 1, args)
+	if err != nil {
+		return err
+	}
+
+	tenantID, err := a.tenantID(*tenantName, models.AllTenants)
+	if err != nil {
+		return err
+	}
+
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	events, err := repos.Events.ListImageEvents(context.Background(), tenantID, positional[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTENANT\tACTION\tUSER\tFROM\tTO")
+	for _, e := range events {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", e.CreatedAt.Format("2006-01-02 15:04:05"), e.TenantID, e.Action, e.Username, e.PreviousLabel, e.NewLabel)
+	}
+	return w.Flush()
+}
+
+// actor is the name recorded in image_events for changes made from the CLI
+func actor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/internal/driver/redis"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+// command is a single "<group> <action>" entry of the CLI
+type command struct {
+	usage string
+	run   func(app *app, args []string) error
+}
+
+var commands = map[string]command{
+	"user create":         {"user create <username> [-role role] [-tenant name] [-password-stdin]", userCreate},
+	"user list":           {"user list [-tenant name]", userList},
+	"user delete":         {"user delete <username>", userDelete},
+	"user role":           {"user role <username> <role>", userRole},
+	"user disable":        {"user disable <username>", userDisable},
+	"user enable":         {"user enable <username>", userEnable},
+	"user reset-password": {"user reset-password <username> [-password-stdin]", userResetPassword},
+	"user unlock":         {"user unlock <username>", userUnlock},
+	"user mfa-reset":      {"user mfa-reset <username>", userMFAReset},
+	"user tenant":         {"user tenant <username> <tenant>", userTenant},
+	"tenant create":       {"tenant create <name> [-threshold percent]", tenantCreate},
+	"tenant list":         {"tenant list", tenantList},
+	"tenant policy":       {"tenant policy <name> <threshold>", tenantPolicy},
+	"apikey create":       {"apikey create <client> [-rate n] [-daily n] [-monthly n] [-tenant name]", apiKeyCreate},
+	"apikey list":         {"apikey list [-tenant name]", apiKeyList},
+	"apikey revoke":       {"apikey revoke <id>", apiKeyRevoke},
+	"image list":          {"image list [-reviewed true|false] [-limit n] [-cursor id] [-tenant name]", imageList},
+	"image label":         {"image label <sha256> <NSFW|SFW> [-tenant name]", imageLabel},
+	"image delete":        {"image delete <sha256> [-tenant name]", imageDelete},
+	"image restore":       {"image restore <sha256> [-tenant name]", imageRestore},
+	"image history":       {"image history <sha256> [-tenant name]", imageHistory},
+	"trash list":          {"trash list", trashList},
+	"trash purge":         {"trash purge", trashPurge},
+	"files reconcile":     {"files reconcile [-repair] [-grace minutes]", filesReconcile},
+	"files previews":      {"files previews [-force]", filesPreviews},
+	"retention report":    {"retention report", retentionReport},
+	"retention run":       {"retention run", retentionRun},
+	"cache flush":         {"cache flush", cacheFlush},
+	"stats show":          {"stats show [-tenant name]", statsShow},
+	"model verify":        {"model verify [image]", modelVerify},
+	"migrate up":          {"migrate up", migrateUp},
+	"migrate down":        {"migrate down [-steps n]", migrateDown},
+	"migrate status":      {"migrate status", migrateStatus},
+}
+
+// app lazily opens the connections a command needs
+type app struct {
+	// dev works on the database of a server running with -dev, which keeps its state in
+	// process instead of Redis
+	dev          bool
+	conn         *sql.DB
+	repositories *repositories.Repositories
+	redisClient  *redis.RedisClient
+	files        *storage.Store
+}
+
+func (a *app) repos() (*repositories.Repositories, error) {
+	if a.repositories != nil {
+		return a.repositories, nil
+	}
+
+	conn, err := database.OpenDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	a.conn = conn
+	a.repositories = repositories.NewRepositories(conn, config.AppConfig.DB.Driver)
+	return a.repositories, nil
+}
+
+func (a *app) store() (*storage.Store, error) {
+	if a.files != nil {
+		return a.files, nil
+	}
+
+	store, err := storage.New(config.AppConfig.Storage, config.AppConfig.FileHandling)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up storage: %w", err)
+	}
+
+	a.files = store
+	return a.files, nil
+}
+
+func (a *app) redis() *redis.RedisClient {
+	if a.redisClient == nil {
+		a.redisClient = redis.NewRedisClient(
+			config.AppConfig.Redis.Addr,
+			config.AppConfig.Redis.Password,
+			config.AppConfig.Redis.DB,
+		)
+	}
+	return a.redisClient
+}
+
+// tenantID returns the ID of the tenant called name, given with -tenant, or fallback
+// when the flag was left out
+func (a *app) tenantID(name string, fallback int) (int, error) {
+	if name == "" {
+		return fallback, nil
+	}
+
+	repos, err := a.repos()
+	if err != nil {
+		return 0, err
+	}
+
+	tenant, err := repos.Tenants.GetTenantByName(context.Background(), name)
+	if err != nil {
+		return 0, err
+	}
+	if tenant == nil {
+		return 0, fmt.Errorf("tenant %s not found", name)
+	}
+	return tenant.ID, nil
+}
+
+// adminContext is the context commands call services with: they act as actor() on every
+// tenant, narrowed with middleware.WithTenant where a command works in one
+func adminContext() context.Context {
+	ctx := context.WithValue(context.Background(), middleware.UserKey, actor())
+	return middleware.WithTenant(ctx, models.AllTenants)
+}
+
+// parseArgs reads the n positional arguments of a command followed by the flags in fs
+func parseArgs(fs *flag.FlagSet, usage string, n int, args []string) ([]string, error) {
+	if len(args) < n {
+		return nil, errors.New(usage)
+	}
+	for _, arg := range args[:n] {
+		if strings.HasPrefix(arg, "-") {
+			return nil, errors.New(usage)
+		}
+	}
+
+	if err := fs.Parse(args[n:]); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, errors.New(usage)
+	}
+	return args[:n], nil
+}
+
+// invalidateCache drops the cached prediction of a tenant from Redis. In-process caches
+// of running servers are not reachable from here and expire on their own.
+func (a *app) invalidateCache(tenantID int, hash string) {
+	if a.dev {
+		return
+	}
+
+	if err := cache.NewRedis(a.redis()).Delete(context.Background(), cache.PredictionKey(tenantID, hash)); err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: failed to invalidate cached prediction:", err)
+	}
+}
+
+// predictionCache returns the prediction cache of the servers, nil in dev mode where it
+// only lives in the server process
+func (a *app) predictionCache() *cache.Metered {
+	if a.dev {
+		return nil
+	}
+
+	c, err := cache.New(config.AppConfig.Cache, a.redis())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: cached predictions will not be invalidated:", err)
+		return nil
+	}
+	return c
+}
+
+func (a *app) close() {
+	if a.conn != nil {
+		a.conn.Close()
+	}
+}
+
+func main() {
+	dev := flag.Bool("dev", false, "Work on the database of a server running with -dev")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	cmd, ok := commands[args[0]+" "+args[1]]
+	if !ok {
+		usage()
+		os.Exit(1)
+	}
+
+	// the same rule as the server: shared state lives in Redis unless running in dev mode
+	if *dev {
+		config.LoadDevConfig("./config.toml")
+	} else {
+		config.LoadConfig("./config.toml")
+	}
+
+	a := &app{dev: *dev}
+	err := cmd.run(a, args[2:])
+	a.close()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	lines := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		lines = append(lines, cmd.usage)
+	}
+	sort.Strings(lines)
+
+	fmt.Fprintln(os.Stderr, "Usage: nsfwcli [-dev] <command>")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, line := range lines {
+		fmt.Fprintln(os.Stderr, "  "+line)
+	}
+}
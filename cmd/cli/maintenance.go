@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+	"github.com/mlvieira/nsfwdetection/internal/tfmodel"
+)
+
+const defaultVerifyImage = "./python/model_test/test_image.jpg"
+
+func cacheFlush(a *app, args []string) error {
+	deleted, err := a.redis().DeleteKeys(context.Background(), cache.PredictionPattern)
+	if err != nil {
+		return fmt.Errorf("failed to flush cache: %w", err)
+	}
+
+	fmt.Printf("Flushed %d cached predictions\n", deleted)
+	return nil
+}
+
+func trashList(a *app, args []string) error {
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	images, err := repos.Uploaded.ListTrashCursor(context.Background(), models.AllTenants, math.MaxInt32, 1000)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TENANT\tSHA256\tLABEL\tDELETED")
+	for _, img := range images {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", img.TenantID, img.FileHash, img.NewLabel, img.DeletedAt.Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+func trashPurge(a *app, args []string) error {
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	if err := logger.Init("logs/cli.log"); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	store, err := a.store()
+	if err != nil {
+		return err
+	}
+
+	purged, err := services.NewTrashPurger(repos, store).PurgeExpired(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to purge trash: %w", err)
+	}
+
+	fmt.Printf("Purged %d images trashed more than %d days ago\n", purged, config.AppConfig.FileHandling.TrashRetentionDays)
+	return nil
+}
+
+func filesReconcile(a *app, args []string) error {
+	fs := flag.NewFlagSet("files reconcile", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "Fix the problems found instead of only listing them")
+	grace := fs.Int("grace", config.AppConfig.FileHandling.OrphanGraceMin, "Skip files and rows younger than this many minutes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	config.AppConfig.FileHandling.OrphanGraceMin = *grace
+
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	if err := logger.Init("logs/cli.log"); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	store, err := a.store()
+	if err != nil {
+		return err
+	}
+
+	report, err := services.NewReconciler(repos, store).Reconcile(context.Background(), *repair)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile files: %w", err)
+	}
+
+	if report.Total() == 0 {
+		fmt.Println("Files and database agree")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROBLEM\tFILE OR SHA256")
+	for _, path := range report.OrphanFiles {
+		fmt.Fprintf(w, "orphan file\t%s\n", path)
+	}
+	for _, hash := range report.MissingFiles {
+		fmt.Fprintf(w, "missing file\t%s\n", hash)
+	}
+	for _, path := range report.MisplacedFiles {
+		fmt.Fprintf(w, "misplaced file\t%s\n", path)
+	}
+	for _, path := range report.StaleTempFiles {
+		fmt.Fprintf(w, "stale temp file\t%s\n", path)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if *repair {
+		fmt.Println("Repairs done, failures are logged in logs/cli.log")
+	} else {
+		fmt.Println("Rerun with -repair to fix these")
+	}
+	return nil
+}
+
+func filesPreviews(a *app, args []string) error {
+	fs := flag.NewFlagSet("files previews", flag.ContinueOnError)
+	force := fs.Bool("force", false, "Render the previews of every image again, not only missing ones")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	if err := logger.Init("logs/cli.log"); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	store, err := a.store()
+	if err != nil {
+		return err
+	}
+
+	created, failed, err := services.NewPreviewGenerator(repos, store).Backfill(context.Background(), *force)
+	if err != nil {
+		return fmt.Errorf("failed to create previews: %w", err)
+	}
+
+	fmt.Printf("Created previews of %d images\n", created)
+	if failed > 0 {
+		fmt.Printf("%d images failed, see logs/cli.log\n", failed)
+	}
+	return nil
+}
+
+func retentionReport(a *app, args []string) error {
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	report, err := services.NewRetentionService(repos, nil, nil, config.AppConfig.Retention).Report(adminContext())
+	if err != nil {
+		return err
+	}
+
+	if !report.Enabled {
+		fmt.Println("Retention is disabled, rules only run through \"retention run\"")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tLABEL\tREVIEWED\tMAX AGE\tCUTOFF\tMATCHING")
+	for _, rule := range report.Rules {
+		reviewed := "any"
+		if rule.Reviewed != nil {
+			reviewed = fmt.Sprint(*rule.Reviewed)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%dd\t%s\t%d\n", rule.Name, rule.Label, reviewed, rule.MaxAgeDays, rule.Cutoff.Format("2006-01-02 15:04"), rule.Matching)
+	}
+	return w.Flush()
+}
+
+func retentionRun(a *app, args []string) error {
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	if err := logger.Init("logs/cli.log"); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	store, err := a.store()
+	if err != nil {
+		return err
+	}
+
+	deleted, err := services.NewRetentionService(repos, a.predictionCache(), store, config.AppConfig.Retention).Enforce(adminContext())
+	if err != nil {
+		return fmt.Errorf("failed to apply retention rules: %w", err)
+	}
+
+	fmt.Printf("Moved %d images to trash\n", deleted)
+	return nil
+}
+
+func statsShow(a *app, args []string) error {
+	fs := flag.NewFlagSet("stats show", flag.ContinueOnError)
+	tenantName := fs.String("tenant", "", "Only count the images and API keys of this tenant")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tenantID, err := a.tenantID(*tenantName, models.AllTenants)
+	if err != nil {
+		return err
+	}
+
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	// API usage is counted in Redis, a dev server leaves nothing to read from here
+	var apiKeys *services.APIKeyService
+	if !a.dev {
+		apiKeys = services.NewAPIKeyService(repos, auth.NewAPIKeyLimiter(cache.NewRedisCounter(a.redis())))
+	}
+
+	stats, err := services.NewAPIService(nil, repos, nil, nil, nil, apiKeys).FetchStats(middleware.WithTenant(adminContext(), tenantID))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Total images\t%d\n", stats.TotalImages)
+	fmt.Fprintf(w, "Reviewed images\t%d\n", stats.ReviewedImages)
+	fmt.Fprintf(w, "Unlabeled images\t%d\n", stats.UnlabeledImages)
+	fmt.Fprintf(w, "Average confidence\t%.2f\n", stats.AverageConfidence)
+	fmt.Fprintf(w, "Labeling efficiency\t%.2f%%\n", stats.LabelingEfficiency)
+
+	labels := make([]string, 0, len(stats.LabelDistribution))
+	for label := range stats.LabelDistribution {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Fprintf(w, "Reviewed as %s\t%d\n", label, stats.LabelDistribution[label])
+	}
+
+	clients := make([]string, 0, len(stats.ClientDistribution))
+	for client := range stats.ClientDistribution {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+	for _, client := range clients {
+		fmt.Fprintf(w, "Uploaded by %s\t%d\n", client, stats.ClientDistribution[client])
+	}
+
+	for _, usage := range stats.APIKeys {
+		fmt.Fprintf(w, "Requests of %s (%s)\t%d today of %s, %d this month of %s\n", usage.ClientName, usage.Prefix,
+			usage.RequestsToday, formatLimit(usage.DailyQuota), usage.RequestsThisMonth, formatLimit(usage.MonthlyQuota))
+	}
+
+	return w.Flush()
+}
+
+// modelVerify loads the configured model and runs it against a sample image
+func modelVerify(a *app, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: model verify [image]")
+	}
+
+	imagePath := defaultVerifyImage
+	if len(args) == 1 {
+		imagePath = args[0]
+	}
+
+	if err := logger.Init("logs/cli.log"); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	if err := tfmodel.LoadModel(config.AppConfig.Model.ModelPath); err != nil {
+		return err
+	}
+	defer tfmodel.SharedNSFWModel.Close()
+
+	prediction, err := tfmodel.SharedNSFWModel.DetectNSFW(imagePath)
+	if err != nil {
+		return fmt.Errorf("model failed on %s: %w", imagePath, err)
+	}
+
+	label, score := prediction.Label()
+	fmt.Printf("Model OK: %s classified as %s (%.2f%%) in %.3fs\n", imagePath, label, score, prediction.Duration)
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/migrate"
+)
+
+func (a *app) migrator() (*migrate.Migrator, error) {
+	if _, err := a.repos(); err != nil {
+		return nil, err
+	}
+	return migrate.ForDriver(a.conn, config.AppConfig.DB.Driver)
+}
+
+func migrateUp(a *app, args []string) error {
+	m, err := a.migrator()
+	if err != nil {
+		return err
+	}
+
+	ran, err := m.Up(context.Background())
+	for _, mig := range ran {
+		fmt.Printf("Applied %s_%s\n", mig.Version, mig.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(ran) == 0 {
+		fmt.Println("Database is up to date")
+	}
+	return nil
+}
+
+func migrateDown(a *app, args []string) error {
+	fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+	steps := fs.Int("steps", 1, "Number of migrations to roll back")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, err := a.migrator()
+	if err != nil {
+		return err
+	}
+
+	reverted, err := m.Down(context.Background(), *steps)
+	for _, mig := range reverted {
+		fmt.Printf("Reverted %s_%s\n", mig.Version, mig.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(reverted) == 0 {
+		fmt.Println("No migrations to roll back")
+	}
+	return nil
+}
+
+func migrateStatus(a *app, args []string) error {
+	m, err := a.migrator()
+	if err != nil {
+		return err
+	}
+
+	status, err := m.Status(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, s := range status {
+		state := "pending"
+		if s.Applied {
+			state = "applied"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Version, s.Name, state)
+	}
+	return w.Flush()
+}
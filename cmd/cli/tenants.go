@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+)
+
+// defaultThreshold is the NSFW threshold of new tenants, the one the model was tuned for
+const defaultThreshold = 50
+
+// tenants returns the tenant service acting as the CLI user, logging to logs/cli.log
+func (a *app) tenants() (*services.TenantService, context.Context, error) {
+	repos, err := a.repos()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := logger.Init("logs/cli.log"); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	ctx := adminContext()
+	return services.NewTenantService(repos), ctx, nil
+}
+
+func tenantCreate(a *app, args []string) error {
+	fs := flag.NewFlagSet("tenant create", flag.ContinueOnError)
+	threshold := fs.Float64("threshold", defaultThreshold, "NSFW percentage above which the tenant's images are labeled NSFW")
+
+	positional, err := parseArgs(fs, "usage: tenant create <name> [-threshold percent]", 1, args)
+	if err != nil {
+		return err
+	}
+
+	tenants, ctx, err := a.tenants()
+	if err != nil {
+		return err
+	}
+
+	tenant, err := tenants.Create(ctx, positional[0], float32(*threshold))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Tenant %d created as %s!\n", tenant.ID, tenant.Name)
+	return nil
+}
+
+func tenantList(a *app, args []string) error {
+	tenants, ctx, err := a.tenants()
+	if err != nil {
+		return err
+	}
+
+	list, err := tenants.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tNSFW THRESHOLD\tCREATED")
+	for _, t := range list {
+		fmt.Fprintf(w, "%d\t%s\t%.1f\t%s\n", t.ID, t.Name, t.NSFWThreshold, t.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+// tenantPolicy changes the NSFW threshold of a tenant. Images already uploaded keep their
+// label, running servers relabel cached predictions on their next lookup.
+func tenantPolicy(a *app, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: tenant policy <name> <threshold>")
+	}
+	threshold, err := strconv.ParseFloat(args[1], 32)
+	if err != nil {
+		return fmt.Errorf("invalid threshold: %s", args[1])
+	}
+
+	tenantID, err := a.tenantID(args[0], models.AllTenants)
+	if err != nil {
+		return err
+	}
+
+	tenants, ctx, err := a.tenants()
+	if err != nil {
+		return err
+	}
+
+	req := models.TenantPolicyRequest{NSFWThreshold: float32(threshold)}
+	if _, err := tenants.SetPolicy(middleware.WithTenant(ctx, tenantID), req); err != nil {
+		return err
+	}
+
+	fmt.Println("Tenant policy updated successfully!")
+	return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+	"golang.org/x/term"
+)
+
+// users returns the user service acting as the CLI user, logging to logs/cli.log
+func (a *app) users() (*services.UserService, context.Context, error) {
+	repos, err := a.repos()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := logger.Init("logs/cli.log"); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	ctx := adminContext()
+	revocations, loginFailures := a.authState()
+	tokens := auth.New(config.AppConfig.Security, revocations)
+	guard := auth.NewLoginGuard(config.AppConfig.Security, loginFailures)
+	mfa := services.NewMFAService(repos, guard)
+	sessions := services.NewAuthService(repos, tokens, guard, mfa)
+	return services.NewUserService(repos, sessions, mfa), ctx, nil
+}
+
+// authState returns the revoked access tokens and failed login counts shared with running
+// servers through Redis. A dev server keeps them in process, there revoked access tokens
+// expire on their own and lockouts cannot be lifted from here.
+func (a *app) authState() (cache.Cache, cache.Counter) {
+	if a.dev {
+		return cache.NewMemoryStore(), cache.NewMemoryCounter()
+	}
+	return cache.NewRedis(a.redis()), cache.NewRedisCounter(a.redis())
+}
+
+func userCreate(a *app, args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	role := fs.String("role", models.RoleReviewer, "Role of the user: "+strings.Join(models.Roles, ", "))
+	tenantName := fs.String("tenant", "", "Tenant of the user (default: the default tenant)")
+
+	username, password, err := parseCredentials(fs, "<username> [-role role] [-tenant name] [-password-stdin]", args)
+	if err != nil {
+		return err
+	}
+
+	tenantID, err := a.tenantID(*tenantName, models.DefaultTenantID)
+	if err != nil {
+		return err
+	}
+
+	users, ctx, err := a.users()
+	if err != nil {
+		return err
+	}
+
+	req := models.CreateUserRequest{Username: username, Password: password, Role: *role}
+	if _, err := users.CreateUser(middleware.WithTenant(ctx, tenantID), req); err != nil {
+		return err
+	}
+
+	fmt.Println("User created successfully!")
+	return nil
+}
+
+func userList(a *app, args []string) error {
+	fs := flag.NewFlagSet("user list", flag.ContinueOnError)
+	tenantName := fs.String("tenant", "", "Only list users of this tenant")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tenantID, err := a.tenantID(*tenantName, models.AllTenants)
+	if err != nil {
+		return err
+	}
+
+	repos, err := a.repos()
+	if err != nil {
+		return err
+	}
+
+	users, err := repos.User.ListUsers(context.Background(), tenantID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tROLE\tTENANT\tSTATUS\tCREATED\tUPDATED")
+	for _, u := range users {
+		status := "enabled"
+		if u.DisabledAt != nil {
+			status = "disabled"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n", u.ID, u.Username, u.Role, u.TenantID, status, u.CreatedAt.Format("2006-01-02 15:04"), u.UpdatedAt.Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+func userDelete(a *app, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: user delete <username>")
+	}
+
+	users, ctx, err := a.users()
+	if err != nil {
+		return err
+	}
+
+	if err := users.DeleteUser(ctx, args[0]); err != nil {
+		return err
+	}
+
+	fmt.Println("User deleted successfully!")
+	return nil
+}
+
+// userRole changes the role of a user. It applies from the user's next login.
+func userRole(a *app, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: user role <username> <role>")
+	}
+
+	users, ctx, err := a.users()
+	if err != nil {
+		return err
+	}
+
+	if _, err := users.SetRole(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+
+	fmt.Println("Role updated successfully! It applies from the next login.")
+	return nil
+}
+
+// userTenant moves a user to another tenant. It applies from the user's next login.
+func userTenant(a *app, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: user tenant <username> <tenant>")
+	}
+
+	users, ctx, err := a.users()
+	if err != nil {
+		return err
+	}
+
+	if _, err := users.SetTenant(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+
+	fmt.Println("Tenant updated successfully! It applies from the next login.")
+	return nil
+}
+
+func userDisable(a *app, args []string) error {
+	return setUserDisabled(a, args, true)
+}
+
+func userEnable(a *app, args []string) error {
+	return setUserDisabled(a, args, false)
+}
+
+func setUserDisabled(a *app, args []string, disabled bool) error {
+	if len(args) != 1 {
+		if disabled {
+			return errors.New("usage: user disable <username>")
+		}
+		return errors.New("usage: user enable <username>")
+	}
+
+	users, ctx, err := a.users()
+	if err != nil {
+		return err
+	}
+
+	if _, err := users.SetDisabled(ctx, args[0], disabled); err != nil {
+		return err
+	}
+
+	if disabled {
+		fmt.Println("User disabled successfully!")
+	} else {
+		fmt.Println("User enabled successfully!")
+	}
+	return nil
+}
+
+func userUnlock(a *app, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: user unlock <username>")
+	}
+
+	users, ctx, err := a.users()
+	if err != nil {
+		return err
+	}
+
+	if _, err := users.Unlock(ctx, args[0]); err != nil {
+		return err
+	}
+
+	if a.dev {
+		fmt.Fprintln(os.Stderr, "Warning: lockouts are only shared through Redis, running servers keep theirs")
+		return nil
+	}
+	fmt.Println("User unlocked successfully!")
+	return nil
+}
+
+func userMFAReset(a *app, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: user mfa-reset <username>")
+	}
+
+	users, ctx, err := a.users()
+	if err != nil {
+		return err
+	}
+
+	if _, err := users.ResetMFA(ctx, args[0]); err != nil {
+		return err
+	}
+
+	fmt.Println("Two-factor authentication reset successfully!")
+	return nil
+}
+
+func userResetPassword(a *app, args []string) error {
+	fs := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	username, password, err := parseCredentials(fs, "<username> [-password-stdin]", args)
+	if err != nil {
+		return err
+	}
+
+	users, ctx, err := a.users()
+	if err != nil {
+		return err
+	}
+
+	if _, err := users.ResetPassword(ctx, username, password); err != nil {
+		return err
+	}
+
+	fmt.Println("Password updated successfully!")
+	return nil
+}
+
+// parseCredentials reads "<username> [flags]" into fs and obtains the password
+// from stdin or an interactive prompt, never from the command line.
+func parseCredentials(fs *flag.FlagSet, usage string, args []string) (string, string, error) {
+	fromStdin := fs.Bool("password-stdin", false, "Read the password from stdin")
+
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		return "", "", fmt.Errorf("usage: %s %s", fs.Name(), usage)
+	}
+	username := args[0]
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return "", "", err
+	}
+	if fs.NArg() != 0 {
+		return "", "", errors.New("passwords are not accepted as arguments, use the prompt or -password-stdin")
+	}
+
+	var password string
+	var err error
+	if *fromStdin {
+		password, err = readPasswordStdin()
+	} else {
+		password, err = promptPassword()
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if password == "" {
+		return "", "", errors.New("password cannot be empty")
+	}
+
+	return username, password, nil
+}
+
+func readPasswordStdin() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password from stdin: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func promptPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("stdin is not a terminal, use -password-stdin")
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if string(password) != string(confirm) {
+		return "", errors.New("passwords do not match")
+	}
+
+	return string(password), nil
+}
@@ -0,0 +1,315 @@
+// Command mockidp is a minimal OpenID Connect provider for trying out single sign-on
+// locally. It signs ID tokens for a fixed list of users, picked from a form on login,
+// and implements just enough of the authorization code flow with PKCE for the server.
+// It keeps everything in memory and must not be used for anything but development.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type user struct {
+	Name   string
+	Groups []string
+}
+
+// grant is an authorization code waiting to be redeemed
+type grant struct {
+	user        user
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	expiresAt   time.Time
+}
+
+type provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	users        []user
+	autoUser     string
+	key          *rsa.PrivateKey
+	// keyID is derived from the key, a restarted provider has a new key under a new id
+	keyID string
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+func main() {
+	addr := flag.String("addr", ":3002", "Address to listen on")
+	issuer := flag.String("issuer", "http://localhost:3002", "Issuer URL, as configured in oidc.issuer_url")
+	clientID := flag.String("client-id", "nsfw-admin", "Client id accepted")
+	clientSecret := flag.String("client-secret", "", "Client secret required at the token endpoint, empty for a public client")
+	autoUser := flag.String("auto-user", "", "Log this user in without showing the form, for scripted tests")
+	var users []user
+	flag.Func("user", "User offered on login as name:group1,group2, repeatable (default alice:moderators and bob:admins)", func(value string) error {
+		name, groups, _ := strings.Cut(value, ":")
+		if name == "" {
+			return fmt.Errorf("missing user name in %q", value)
+		}
+		u := user{Name: name}
+		if groups != "" {
+			u.Groups = strings.Split(groups, ",")
+		}
+		users = append(users, u)
+		return nil
+	})
+	flag.Parse()
+
+	if len(users) == 0 {
+		users = []user{{Name: "alice", Groups: []string{"moderators"}}, {Name: "bob", Groups: []string{"admins"}}}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Failed to generate signing key: %v", err)
+	}
+
+	keySum := sha256.Sum256(key.PublicKey.N.Bytes())
+
+	p := &provider{
+		issuer:       strings.TrimSuffix(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		users:        users,
+		autoUser:     *autoUser,
+		key:          key,
+		keyID:        base64.RawURLEncoding.EncodeToString(keySum[:8]),
+		grants:       make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("GET /authorize", p.authorizeForm)
+	mux.HandleFunc("POST /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+
+	log.Printf("Mock identity provider %s listening on %s for client %s", p.issuer, *addr, p.clientID)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": p.keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<title>Mock identity provider</title>
+<h1>Sign in as</h1>
+<form method="post" action="/authorize">
+{{range $key, $values := .Params}}{{range $values}}<input type="hidden" name="{{$key}}" value="{{.}}">
+{{end}}{{end}}
+{{range .Users}}<p><button name="user" value="{{.Name}}">{{.Name}}</button> {{range .Groups}}{{.}} {{end}}</p>
+{{end}}
+<p><button name="deny" value="1">Deny</button></p>
+</form>`))
+
+// authorizeForm shows the users to log in as, or logs in -auto-user right away
+func (p *provider) authorizeForm(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	if err := p.checkAuthorize(params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if p.autoUser != "" {
+		params.Set("user", p.autoUser)
+		p.grant(w, r, params)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginPage.Execute(w, map[string]interface{}{"Params": params, "Users": p.users})
+}
+
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := r.PostForm
+	if err := p.checkAuthorize(params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if params.Get("deny") != "" {
+		redirect(w, r, params.Get("redirect_uri"), url.Values{"error": {"access_denied"}, "state": {params.Get("state")}})
+		return
+	}
+	p.grant(w, r, params)
+}
+
+func (p *provider) checkAuthorize(params url.Values) error {
+	switch {
+	case params.Get("client_id") != p.clientID:
+		return fmt.Errorf("unknown client_id")
+	case params.Get("response_type") != "code":
+		return fmt.Errorf("only response_type=code is supported")
+	case params.Get("redirect_uri") == "":
+		return fmt.Errorf("missing redirect_uri")
+	case params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256":
+		return fmt.Errorf("PKCE with S256 is required")
+	}
+	return nil
+}
+
+// grant issues an authorization code for the chosen user and sends the browser back
+func (p *provider) grant(w http.ResponseWriter, r *http.Request, params url.Values) {
+	var chosen *user
+	for i := range p.users {
+		if p.users[i].Name == params.Get("user") {
+			chosen = &p.users[i]
+		}
+	}
+	if chosen == nil {
+		http.Error(w, "unknown user", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.grants[code] = grant{
+		user:        *chosen,
+		clientID:    params.Get("client_id"),
+		redirectURI: params.Get("redirect_uri"),
+		nonce:       params.Get("nonce"),
+		challenge:   params.Get("code_challenge"),
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	redirect(w, r, params.Get("redirect_uri"), url.Values{"code": {code}, "state": {params.Get("state")}})
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || (p.clientSecret != "" && secret != p.clientSecret) {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, found := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		tokenError(w, "unsupported_grant_type")
+		return
+	case !found || time.Now().After(g.expiresAt) || g.clientID != clientID || g.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, "invalid_grant")
+		return
+	case base64.RawURLEncoding.EncodeToString(verifier[:]) != g.challenge:
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                p.issuer,
+		"sub":                "mock-" + g.user.Name,
+		"aud":                clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              g.nonce,
+		"preferred_username": g.user.Name,
+		"email":              g.user.Name + "@example.com",
+		"groups":             g.user.Groups,
+	})
+	idToken.Header["kid"] = p.keyID
+
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, target string, params url.Values) {
+	separator := "?"
+	if strings.Contains(target, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, target+separator+params.Encode(), http.StatusFound)
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Failed to generate random value: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// checkpoint records the paths that have been scanned, one per line,
+// so an interrupted scan can pick up where it stopped.
+// A checkpoint without a file only tracks the current run.
+type checkpoint struct {
+	file *os.File
+	done map[string]bool
+	mu   sync.Mutex
+}
+
+func openCheckpoint(path string) (*checkpoint, error) {
+	cp := &checkpoint{done: make(map[string]bool)}
+	if path == "" {
+		return cp, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		cp.done[scanner.Text()] = true
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	cp.file = file
+	return cp, nil
+}
+
+// Done reports whether path was scanned by a previous run
+func (c *checkpoint) Done(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.done[path]
+}
+
+// Len returns the number of paths in the checkpoint
+func (c *checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.done)
+}
+
+// Mark records path as scanned
+func (c *checkpoint) Mark(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.done[path] = true
+	if c.file != nil {
+		c.file.WriteString(path + "\n")
+	}
+}
+
+func (c *checkpoint) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
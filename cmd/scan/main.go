@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/preview"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+	"github.com/mlvieira/nsfwdetection/internal/tfmodel"
+	"github.com/mlvieira/nsfwdetection/internal/validation"
+	"github.com/mlvieira/nsfwdetection/internal/worker"
+)
+
+type scanner struct {
+	repositories *repositories.Repositories
+	store        *storage.Store
+	tenant       *models.Tenant
+	output       resultWriter
+	checkpoint   *checkpoint
+	mu           sync.Mutex
+	scanned      int
+	failed       int
+	skipped      int
+}
+
+func main() {
+	configPath := flag.String("config", "./config.toml", "Path to config.toml")
+	format := flag.String("format", "csv", "Output format: csv or jsonl")
+	outputPath := flag.String("o", "", "Output file (default stdout)")
+	checkpointPath := flag.String("checkpoint", "", "Checkpoint file used to resume an interrupted scan")
+	record := flag.Bool("record", false, "Record results in uploaded_images and copy files to the upload storage")
+	tenantName := flag.String("tenant", "", "Tenant to record results for, labeled with its threshold (default: the default tenant)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: scan [flags] <directory>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	root := flag.Arg(0)
+
+	config.LoadConfig(*configPath)
+
+	if err := logger.Init("logs/scan.log"); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	if err := tfmodel.LoadModel(config.AppConfig.Model.ModelPath); err != nil {
+		log.Fatalf("Failed to load model: %v", err)
+	}
+	defer tfmodel.SharedNSFWModel.Close()
+
+	worker.InitWorkerPool(tfmodel.SharedNSFWModel)
+	defer worker.ShutdownWorkerPool()
+
+	s := &scanner{}
+
+	if *record {
+		conn, err := database.OpenDB()
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer conn.Close()
+
+		s.repositories = repositories.NewRepositories(conn, config.AppConfig.DB.Driver)
+
+		s.store, err = storage.New(config.AppConfig.Storage, config.AppConfig.FileHandling)
+		if err != nil {
+			log.Fatalf("Failed to set up storage: %v", err)
+		}
+
+		if *tenantName != "" {
+			s.tenant, err = s.repositories.Tenants.GetTenantByName(context.Background(), *tenantName)
+		} else {
+			s.tenant, err = s.repositories.Tenants.GetTenant(context.Background(), models.DefaultTenantID)
+		}
+		if err != nil {
+			log.Fatalf("Failed to fetch tenant: %v", err)
+		}
+		if s.tenant == nil {
+			log.Fatalf("Tenant %s not found", *tenantName)
+		}
+	}
+
+	cp, err := openCheckpoint(*checkpointPath)
+	if err != nil {
+		log.Fatalf("Failed to open checkpoint: %v", err)
+	}
+	defer cp.Close()
+	s.checkpoint = cp
+
+	out := os.Stdout
+	if *outputPath != "" {
+		// append when resuming so earlier results are kept
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if cp.Len() > 0 {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		out, err = os.OpenFile(*outputPath, flags, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open output file: %v", err)
+		}
+		defer out.Close()
+	}
+
+	s.output, err = newResultWriter(*format, out, cp.Len() == 0)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer s.output.Flush()
+
+	// an interrupted scan finishes the files in flight, so the checkpoint and the output agree
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	startTime := time.Now()
+	err = s.run(ctx, root)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf("Scan failed: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Scanned %d files (%d failed, %d skipped from checkpoint) in %s\n",
+		s.scanned, s.failed, s.skipped, time.Since(startTime).Round(time.Second))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Interrupted, run again with the same -checkpoint to resume")
+	}
+}
+
+// run walks root and feeds every file not yet in the checkpoint to a bounded set of goroutines.
+// The number of in-flight jobs never exceeds the worker pool queue so no job is dropped.
+// Once ctx is done no more files are fed, the ones in flight are finished.
+func (s *scanner) run(ctx context.Context, root string) error {
+	paths := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < worker.MaxJobs; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for path := range paths {
+				s.scan(id, path)
+			}
+		}(i)
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logger.Error("Failed to access %s: %v", path, err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if s.checkpoint.Done(path) {
+			s.skipped++
+			return nil
+		}
+
+		select {
+		case paths <- path:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	close(paths)
+	wg.Wait()
+
+	return err
+}
+
+// scan runs a single file through validation and inference and records the result
+func (s *scanner) scan(id int, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		s.write(path, result{Path: path, Error: err.Error()})
+		return
+	}
+	defer file.Close()
+
+	if err := validation.ValidateFileType(file); err != nil {
+		// not an image we can classify, don't count it as a failure
+		s.checkpoint.Mark(path)
+		return
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		s.write(path, result{Path: path, Error: err.Error()})
+		return
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	resultChan := make(chan *tfmodel.Prediction, 1)
+	worker.SubmitJob(worker.Job{
+		ID:          id,
+		FilePath:    path,
+		ResultsChan: resultChan,
+	})
+	prediction := <-resultChan
+
+	res := result{Path: path, SHA256: hash}
+	if !prediction.Success {
+		res.Error = prediction.Error
+		s.write(path, res)
+		return
+	}
+
+	res.NSFWPercentage = prediction.NSFWPercentage
+	res.SFWPercentage = prediction.SFWPercentage
+	res.Label, _ = s.label(prediction)
+
+	if s.repositories != nil {
+		prediction.SHA256 = hash
+		if err := s.recordUpload(path, prediction); err != nil {
+			logger.Error("Failed to record %s: %v", path, err)
+			res.Error = err.Error()
+		}
+	}
+
+	s.write(path, res)
+}
+
+// recordUpload copies the file into the upload storage and inserts it into uploaded_images,
+// unless it is recorded already. An image in the trash comes back as a new upload.
+func (s *scanner) recordUpload(path string, prediction *tfmodel.Prediction) error {
+	ctx := context.Background()
+	key := storage.Key(s.tenant.ID, prediction.SHA256, path)
+
+	existing, err := s.repositories.Uploaded.GetImageByHash(ctx, s.tenant.ID, prediction.SHA256)
+	if err != nil {
+		return fmt.Errorf("failed to look up upload: %w", err)
+	}
+	if existing != nil && existing.DeletedAt == nil {
+		return nil
+	}
+
+	if err := s.store.PutFile(ctx, key, path); err != nil {
+		return fmt.Errorf("failed to copy file to uploads: %w", err)
+	}
+
+	if err := preview.Store(ctx, s.store, prediction.SHA256, path); err != nil {
+		logger.Error("Failed to create previews of %s: %v", path, err)
+	}
+
+	label, score := s.label(prediction)
+	img := models.UploadedImage{
+		TenantID:   s.tenant.ID,
+		FilePath:   key,
+		FileHash:   prediction.SHA256,
+		Label:      label,
+		NewLabel:   "unlabeled",
+		Confidence: score,
+		Reviewed:   false,
+	}
+
+	if _, err := s.repositories.Uploaded.UploadImage(ctx, img); err != nil {
+		return err
+	}
+
+	// the image was in the trash and has been taken out by recording it again
+	if existing != nil {
+		if err := s.store.RemoveFromTrash(ctx, existing.FilePath); err != nil {
+			logger.Error("Failed to remove trashed copy %s: %v", existing.FilePath, err)
+		}
+	}
+	return nil
+}
+
+// label applies the threshold of the tenant results are recorded for, without recording
+// the model's most likely label
+func (s *scanner) label(prediction *tfmodel.Prediction) (string, float32) {
+	if s.tenant != nil {
+		return prediction.LabelAt(s.tenant.NSFWThreshold)
+	}
+	return prediction.Label()
+}
+
+// write outputs a result and marks the path as done in the checkpoint
+func (s *scanner) write(path string, res result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scanned++
+	if res.Error != "" {
+		s.failed++
+	}
+
+	if err := s.output.Write(res); err != nil {
+		logger.Error("Failed to write result for %s: %v", path, err)
+		return
+	}
+	s.checkpoint.Mark(path)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/tfmodel"
+	"github.com/mlvieira/nsfwdetection/internal/worker"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "scan-test")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := logger.Init(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+	worker.InitWorkerPool(tfmodel.NewFakeModel())
+
+	code := m.Run()
+	worker.ShutdownWorkerPool()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// writeImages creates n distinct PNG files spread over two directories and returns their paths
+func writeImages(t *testing.T, root string, n int) []string {
+	t.Helper()
+
+	paths := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("batch%d", i%2))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+		img.Set(0, 0, color.RGBA{R: uint8(i), G: uint8(i >> 8), A: 255})
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%03d.png", i))
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// interrupting cancels the scan once it has written after results, like Ctrl-C would
+type interrupting struct {
+	resultWriter
+	after  int
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	written int
+}
+
+func (w *interrupting) Write(res result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.written++
+	if w.written == w.after {
+		w.cancel()
+	}
+	return w.resultWriter.Write(res)
+}
+
+// scanned returns the paths of the results in the JSON lines of out
+func scanned(t *testing.T, out *bytes.Buffer) []string {
+	t.Helper()
+
+	var paths []string
+	lines := bufio.NewScanner(out)
+	for lines.Scan() {
+		var res result
+		if err := json.Unmarshal(lines.Bytes(), &res); err != nil {
+			t.Fatalf("invalid result %q: %v", lines.Text(), err)
+		}
+		if res.Error != "" {
+			t.Fatalf("scan of %s failed: %s", res.Path, res.Error)
+		}
+		paths = append(paths, res.Path)
+	}
+	return paths
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	root := t.TempDir()
+	images := writeImages(t, root, 2*worker.MaxJobs+10)
+	// files that aren't images are checkpointed without a result
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkpointPath := filepath.Join(t.TempDir(), "scan.checkpoint")
+
+	// first run, interrupted after a few results
+	cp, err := openCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first bytes.Buffer
+	out, _ := newResultWriter("jsonl", &first, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &scanner{output: &interrupting{resultWriter: out, after: 3, cancel: cancel}, checkpoint: cp}
+	if err := s.run(ctx, root); !errors.Is(err, context.Canceled) {
+		t.Fatalf("run of the interrupted scan = %v, want context.Canceled", err)
+	}
+	cp.Close()
+
+	firstPaths := scanned(t, &first)
+	if len(firstPaths) < 3 || len(firstPaths) >= len(images) {
+		t.Fatalf("interrupted scan wrote %d of %d results", len(firstPaths), len(images))
+	}
+
+	// second run, resumed from the checkpoint
+	cp, err = openCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	done := cp.Len()
+
+	var second bytes.Buffer
+	out, _ = newResultWriter("jsonl", &second, false)
+	resumed := &scanner{output: out, checkpoint: cp}
+	if err := resumed.run(context.Background(), root); err != nil {
+		t.Fatalf("run of the resumed scan = %v", err)
+	}
+	if resumed.skipped != done {
+		t.Fatalf("resumed scan skipped %d files, the checkpoint holds %d", resumed.skipped, done)
+	}
+
+	seen := make(map[string]int)
+	for _, path := range append(firstPaths, scanned(t, &second)...) {
+		seen[path]++
+	}
+	for _, path := range images {
+		if seen[path] != 1 {
+			t.Errorf("%s scanned %d times, want once", path, seen[path])
+		}
+	}
+	if len(seen) != len(images) {
+		t.Errorf("results for %d files, want %d", len(seen), len(images))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// result is a single line of scan output
+type result struct {
+	Path           string  `json:"path"`
+	SHA256         string  `json:"sha256,omitempty"`
+	Label          string  `json:"label,omitempty"`
+	NSFWPercentage float32 `json:"nsfw_percentage"`
+	SFWPercentage  float32 `json:"sfw_percentage"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// resultWriter writes scan results in a specific format.
+// Every result is flushed immediately so the checkpoint never gets ahead of the output.
+type resultWriter interface {
+	Write(res result) error
+	Flush() error
+}
+
+func newResultWriter(format string, w io.Writer, header bool) (resultWriter, error) {
+	switch format {
+	case "csv":
+		cw := &csvWriter{w: csv.NewWriter(w)}
+		if header {
+			if err := cw.writeRecord([]string{"path", "sha256", "label", "nsfw_percentage", "sfw_percentage", "error"}); err != nil {
+				return nil, err
+			}
+		}
+		return cw, nil
+	case "jsonl":
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(res result) error {
+	return c.writeRecord([]string{
+		res.Path,
+		res.SHA256,
+		res.Label,
+		strconv.FormatFloat(float64(res.NSFWPercentage), 'f', 2, 32),
+		strconv.FormatFloat(float64(res.SFWPercentage), 'f', 2, 32),
+		res.Error,
+	})
+}
+
+func (c *csvWriter) writeRecord(record []string) error {
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(res result) error {
+	return j.enc.Encode(res)
+}
+
+func (j *jsonlWriter) Flush() error {
+	return nil
+}
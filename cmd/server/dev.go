@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+)
+
+const (
+	devUsername = "dev"
+	devPassword = "dev"
+)
+
+// seedDevUser creates the dev/dev admin account when the database has no users yet
+func seedDevUser(ctx context.Context, repos *repositories.Repositories) error {
+	users, err := repos.User.ListUsers(ctx, models.AllTenants)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return nil
+	}
+
+	hashedPassword, err := utils.HashPassword(devPassword)
+	if err != nil {
+		return err
+	}
+
+	if err := repos.User.AddUser(ctx, models.User{Username: devUsername, Password: hashedPassword, Role: models.RoleAdmin, TenantID: models.DefaultTenantID}); err != nil {
+		return err
+	}
+
+	logger.Info("Created dev user %q with password %q", devUsername, devPassword)
+	return nil
+}
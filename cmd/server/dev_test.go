@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/migrate"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "server-test")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := logger.Init(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// TestDevMode sets up what the server needs the way -dev does, from a directory without
+// a config file and with neither MySQL nor Redis around, and seeds the dev user twice
+func TestDevMode(t *testing.T) {
+	ctx := context.Background()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	previous := config.AppConfig
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		config.AppConfig = previous
+	})
+
+	config.AppConfig = config.Config{}
+	config.LoadDevConfig("./config.toml")
+
+	conn, err := database.OpenDB()
+	if err != nil {
+		t.Fatalf("OpenDB = %v", err)
+	}
+	defer conn.Close()
+
+	migrator, err := migrate.ForDriver(conn, config.AppConfig.DB.Driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up = %v", err)
+	}
+	repos := repositories.NewRepositories(conn, config.AppConfig.DB.Driver)
+
+	// dev mode has no Redis client
+	if _, err := cache.New(config.AppConfig.Cache, nil); err != nil {
+		t.Fatalf("cache.New = %v", err)
+	}
+	if _, err := storage.New(config.AppConfig.Storage, config.AppConfig.FileHandling); err != nil {
+		t.Fatalf("storage.New = %v", err)
+	}
+
+	// the server seeds on every start, restarting must not fail or add users
+	for i := 0; i < 2; i++ {
+		if err := seedDevUser(ctx, repos); err != nil {
+			t.Fatalf("seedDevUser run %d = %v", i+1, err)
+		}
+	}
+
+	users, err := repos.User.ListUsers(ctx, models.AllTenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != devUsername || users[0].Role != models.RoleAdmin {
+		t.Fatalf("users after seeding twice = %+v, want the dev admin only", users)
+	}
+}
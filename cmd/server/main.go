@@ -1,50 +1,159 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
 	"github.com/mlvieira/nsfwdetection/internal/config"
-	"github.com/mlvieira/nsfwdetection/internal/driver/mysql"
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
 	"github.com/mlvieira/nsfwdetection/internal/driver/redis"
 	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/migrate"
+	"github.com/mlvieira/nsfwdetection/internal/models"
 	"github.com/mlvieira/nsfwdetection/internal/repositories"
 	"github.com/mlvieira/nsfwdetection/internal/router"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
 	"github.com/mlvieira/nsfwdetection/internal/tfmodel"
 	"github.com/mlvieira/nsfwdetection/internal/worker"
 )
 
 func main() {
-	config.LoadConfig("./config.toml")
+	configPath := flag.String("config", "./config.toml", "Path to config.toml")
+	dev := flag.Bool("dev", false, "Run self-contained with SQLite and an in-process cache, no MySQL or Redis needed")
+	fakeModel := flag.Bool("fake-model", false, "Use a deterministic fake classifier instead of the TensorFlow model")
+	flag.Parse()
+
+	if *dev {
+		config.LoadDevConfig(*configPath)
+	} else {
+		config.LoadConfig(*configPath)
+	}
 
 	if err := logger.Init("logs/app.log"); err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
 
-	if err := tfmodel.LoadModel(config.AppConfig.Model.ModelPath); err != nil {
-		logger.Fatalf("Failed to load model: %v", err)
+	var redisClient *redis.RedisClient
+	if *dev {
+		logger.Info("Running in dev mode with database %s", config.AppConfig.DB.Path)
+	} else {
+		redisClient = redis.NewRedisClient(
+			config.AppConfig.Redis.Addr,
+			config.AppConfig.Redis.Password,
+			config.AppConfig.Redis.DB,
+		)
+
+		if config.AppConfig.Worker.Mode == "redis" {
+			logger.Info("Dispatching inference to Redis stream %s", config.AppConfig.Worker.QueueStream)
+			worker.UseQueue(worker.NewRedisQueue(redisClient.Client(), config.AppConfig.Worker))
+		}
 	}
-	defer tfmodel.SharedNSFWModel.Close()
 
-	worker.InitWorkerPool(tfmodel.SharedNSFWModel)
-	defer worker.ShutdownWorkerPool()
+	predictionCache, err := cache.New(config.AppConfig.Cache, redisClient)
+	if err != nil {
+		logger.Fatalf("Failed to set up cache: %v", err)
+	}
+
+	if config.AppConfig.Worker.Mode != "redis" {
+		var detector tfmodel.Detector
+		if *fakeModel {
+			logger.Info("Using the fake classifier, predictions are derived from file hashes")
+			detector = tfmodel.NewFakeModel()
+		} else {
+			if err := tfmodel.LoadModel(config.AppConfig.Model.ModelPath); err != nil {
+				logger.Fatalf("Failed to load model: %v", err)
+			}
+			detector = tfmodel.SharedNSFWModel
+		}
+		defer detector.Close()
 
-	conn, err := mysql.OpenDB()
+		worker.InitWorkerPool(detector)
+	}
+	defer worker.ShutdownQueue()
+
+	conn, err := database.OpenDB()
 	if err != nil {
 		logger.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer conn.Close()
 
-	redisClient := redis.NewRedisClient(
-		config.AppConfig.Redis.Addr,
-		config.AppConfig.Redis.Password,
-		config.AppConfig.Redis.DB,
-	)
+	if config.AppConfig.DB.AutoMigrate {
+		migrator, err := migrate.ForDriver(conn, config.AppConfig.DB.Driver)
+		if err != nil {
+			logger.Fatalf("Failed to load migrations: %v", err)
+		}
+
+		ran, err := migrator.Up(context.Background())
+		if err != nil {
+			logger.Fatalf("Failed to migrate database: %v", err)
+		}
+		for _, m := range ran {
+			logger.Info("Applied migration %s_%s", m.Version, m.Name)
+		}
+	}
+
+	repositories := repositories.NewRepositories(conn, config.AppConfig.DB.Driver)
+
+	store, err := storage.New(config.AppConfig.Storage, config.AppConfig.FileHandling)
+	if err != nil {
+		logger.Fatalf("Failed to set up storage: %v", err)
+	}
+
+	// background jobs work on the whole database, contexts without a tenant see nothing
+	jobs := middleware.WithTenant(context.Background(), models.AllTenants)
 
-	repositories := repositories.NewRepositories(conn)
+	purgeInterval := time.Duration(config.AppConfig.FileHandling.TrashPurgeIntervalMin) * time.Minute
+	go services.NewTrashPurger(repositories, store).Run(jobs, purgeInterval)
+
+	if minutes := config.AppConfig.FileHandling.ReconcileIntervalMin; minutes > 0 {
+		go services.NewReconciler(repositories, store).Run(jobs, time.Duration(minutes)*time.Minute, config.AppConfig.FileHandling.ReconcileRepair)
+	}
 
-	mux := router.SetupRoutes(repositories, redisClient)
+	if config.AppConfig.Retention.Enabled {
+		retentionInterval := time.Duration(config.AppConfig.Retention.IntervalMin) * time.Minute
+		go services.NewRetentionService(repositories, predictionCache, store, config.AppConfig.Retention).Run(jobs, retentionInterval)
+	}
+
+	// revoked tokens, single sign-on logins in progress, failed logins, API usage and rate
+	// limits must be seen by every instance, keeping them in process only serves dev mode.
+	// The in-process store never evicts, dropping an entry early would let a revoked token in.
+	var authState cache.Cache = cache.NewMemoryStore()
+	var counters cache.Counter = cache.NewMemoryCounter()
+	var buckets cache.Buckets = cache.NewMemoryBuckets()
+	if redisClient != nil {
+		authState = cache.NewRedis(redisClient)
+		counters = cache.NewRedisCounter(redisClient)
+		buckets = cache.NewRedisBuckets(redisClient)
+	}
+	tokens := auth.New(config.AppConfig.Security, authState)
+	guard := auth.NewLoginGuard(config.AppConfig.Security, counters)
+	mfa := services.NewMFAService(repositories, guard)
+	sessions := services.NewAuthService(repositories, tokens, guard, mfa)
+	go sessions.Run(jobs, time.Hour)
+
+	var sso *services.SSOService
+	if config.AppConfig.OIDC.Enabled {
+		logger.Info("Single sign-on enabled with issuer %s", config.AppConfig.OIDC.IssuerURL)
+		sso = services.NewSSOService(repositories, auth.NewOIDCProvider(config.AppConfig.OIDC, authState), sessions)
+	}
+
+	apiKeys := services.NewAPIKeyService(repositories, auth.NewAPIKeyLimiter(counters))
+
+	var mux http.Handler = router.SetupRoutes(repositories, predictionCache, store, tokens, sessions, mfa, sso, apiKeys, buckets)
+
+	if *dev {
+		if err := seedDevUser(context.Background(), repositories); err != nil {
+			logger.Fatalf("Failed to create dev user: %v", err)
+		}
+	}
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.AppConfig.Server.Port),
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/driver/redis"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/tfmodel"
+	"github.com/mlvieira/nsfwdetection/internal/worker"
+)
+
+func main() {
+	config.LoadConfig("./config.toml")
+
+	if err := logger.Init("logs/worker.log"); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	if err := tfmodel.LoadModel(config.AppConfig.Model.ModelPath); err != nil {
+		logger.Fatalf("Failed to load model: %v", err)
+	}
+	defer tfmodel.SharedNSFWModel.Close()
+
+	redisClient := redis.NewRedisClient(
+		config.AppConfig.Redis.Addr,
+		config.AppConfig.Redis.Password,
+		config.AppConfig.Redis.DB,
+	)
+
+	concurrency := config.AppConfig.Worker.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "worker"
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		name := fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), i)
+		consumer := worker.NewRedisConsumer(
+			redisClient.Client(),
+			tfmodel.SharedNSFWModel,
+			config.AppConfig.Worker,
+			name,
+			config.AppConfig.FileHandling.TempUploadDir,
+		)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := consumer.Run(ctx); err != nil {
+				logger.Error("Consumer %s failed: %v", name, err)
+				stop()
+			}
+		}()
+	}
+
+	logger.Info("Worker started with %d consumers on stream %s", concurrency, config.AppConfig.Worker.QueueStream)
+	wg.Wait()
+	logger.Info("Worker shut down successfully")
+}
@@ -1,12 +1,14 @@
 import Login from './routes/Login.svelte';
 import Label from './routes/Label.svelte';
 import Stats from './routes/Stats.svelte';
+import Security from './routes/Security.svelte';
 
 const routes = {
     '/': Login,
     '/login': Login,
     '/label': Label,
     '/stats': Stats,
+    '/security': Security,
 };
 
 export default routes;
@@ -1,7 +1,54 @@
+import { get } from 'svelte/store';
+import { clearSession, refreshToken, setSession, token } from '../stores/auth';
+
 const BASE_URL = import.meta.env.VITE_BASE_URL || 'http://localhost:3001';
 
+// fileURL resolves an image URL returned by the admin API against the backend
+export function fileURL(path) {
+  return `${BASE_URL}${path}`;
+}
+
+// refreshing is shared so concurrent requests that hit an expired token refresh once
+let refreshing = null;
+
+function refreshSession() {
+  if (!refreshing) {
+    const current = get(refreshToken);
+    refreshing = (current ? fetch(`${BASE_URL}/admin/refresh`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ refresh_token: current }),
+    }) : Promise.resolve(null))
+      .then(async (res) => {
+        if (!res?.ok) {
+          clearSession();
+          return '';
+        }
+        setSession(await res.json());
+        return get(token);
+      })
+      .catch(() => '')
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+}
+
 async function handleFetch(url, options) {
-  const res = await fetch(url, options);
+  let res = await fetch(url, options);
+
+  // the access token expired or was revoked, retry once with a refreshed one
+  if (res.status === 401 && options?.headers?.Authorization) {
+    const newToken = await refreshSession();
+    if (newToken) {
+      res = await fetch(url, {
+        ...options,
+        headers: { ...options.headers, Authorization: `Bearer ${newToken}` },
+      });
+    }
+  }
+
   if (!res.ok) {
     const errorMessage = (await res.json())?.error || "Request failed";
     throw new Error(errorMessage);
@@ -19,6 +66,60 @@ export async function loginUser(username, password) {
   });
 }
 
+// postJSON sends an unauthenticated step of the login flow
+async function postJSON(path, body) {
+  return handleFetch(`${BASE_URL}${path}`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(body),
+  });
+}
+
+// completeMFA finishes a login that answered with mfa_required
+export async function completeMFA(mfaToken, code) {
+  return postJSON('/admin/login/mfa', { mfa_token: mfaToken, code });
+}
+
+// beginLoginEnrollment fetches a TOTP secret for a login that answered with mfa_enrollment_required
+export async function beginLoginEnrollment(mfaToken) {
+  return postJSON('/admin/login/mfa/enroll', { mfa_token: mfaToken });
+}
+
+export async function completeLoginEnrollment(mfaToken, code) {
+  return postJSON('/admin/login/mfa/enroll/verify', { mfa_token: mfaToken, code });
+}
+
+// ssoInfo tells whether the server offers single sign-on
+export async function ssoInfo() {
+  return handleFetch(`${BASE_URL}/admin/oidc`, { method: 'GET' });
+}
+
+// ssoLoginURL starts single sign-on, which comes back to the login page with a token
+export function ssoLoginURL() {
+  return `${BASE_URL}/admin/oidc/login`;
+}
+
+export async function completeSSO(ssoToken) {
+  return postJSON('/admin/login/sso', { token: ssoToken });
+}
+
+// logoutUser revokes the session on the server, the tokens are dropped either way
+export async function logoutUser() {
+  const url = `${BASE_URL}/admin/logout`;
+  try {
+    await fetch(url, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${get(token)}`,
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ refresh_token: get(refreshToken) }),
+    });
+  } finally {
+    clearSession();
+  }
+}
+
 export async function fetchUploads(cursorId, limit, reviewed, jwtToken) {
   const url = `${BASE_URL}/admin/images`
   const body = {
@@ -95,4 +196,42 @@ export async function stats(jwtToken) {
       Authorization: `Bearer ${jwtToken}`,
     },
   });
-}
\ No newline at end of file
+}
+
+export async function mfaStatus(jwtToken) {
+  const url = `${BASE_URL}/admin/mfa`;
+  return handleFetch(url, {
+    method: 'GET',
+    headers: {
+      Authorization: `Bearer ${jwtToken}`,
+    },
+  });
+}
+
+// mfaAction posts a code, if any, to one of the 2FA endpoints of the current user
+async function mfaAction(path, code, jwtToken) {
+  return handleFetch(`${BASE_URL}/admin/mfa/${path}`, {
+    method: 'POST',
+    headers: {
+      Authorization: `Bearer ${jwtToken}`,
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ code }),
+  });
+}
+
+export async function beginEnrollment(jwtToken) {
+  return mfaAction('enroll', '', jwtToken);
+}
+
+export async function confirmEnrollment(code, jwtToken) {
+  return mfaAction('verify', code, jwtToken);
+}
+
+export async function disableMFA(code, jwtToken) {
+  return mfaAction('disable', code, jwtToken);
+}
+
+export async function regenerateRecoveryCodes(code, jwtToken) {
+  return mfaAction('recovery-codes', code, jwtToken);
+}
@@ -1,4 +1,5 @@
-import { writable } from 'svelte/store';
+import { get, writable } from 'svelte/store';
+import { token as sessionToken } from '../stores/auth';
 import { uploads, newUploads } from "../stores/uploads"
 import { completedRatings, pendingRatings } from '../stores/ratings';
 import {
@@ -43,7 +44,8 @@ export function initWebSocket(token) {
 
   console.log('[WebSocket] Connecting to:', wsUrl);
 
-  socket = new WebSocket(wsUrl, [token]);
+  const ws = new WebSocket(wsUrl, [token]);
+  socket = ws;
 
   socket.onopen = () => {
     console.log('[WebSocket] Connected');
@@ -71,6 +73,11 @@ export function initWebSocket(token) {
   };
 
   socket.onclose = () => {
+    // replaced by a connection with a refreshed token, or closed on purpose
+    if (socket !== ws) {
+      return;
+    }
+
     console.log('[WebSocket] Disconnected');
     socket = null;
     isWebSocketConnected.set(false);
@@ -78,7 +85,8 @@ export function initWebSocket(token) {
     if (reconnectAttempts < maxReconnectAttempts) {
       reconnectAttempts++;
       console.log(`[WebSocket] Attempting reconnect (${reconnectAttempts}/${maxReconnectAttempts})...`);
-      setTimeout(() => initWebSocket(token), reconnectDelay);
+      // the token may have been refreshed since this connection was opened
+      setTimeout(() => initWebSocket(get(sessionToken)), reconnectDelay);
     } else {
       console.error('[WebSocket] Max reconnect attempts reached. Connection failed.');
     }
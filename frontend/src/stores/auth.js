@@ -1,4 +1,4 @@
-import { writable } from 'svelte/store';
+import { derived, writable } from 'svelte/store';
 
 export const token = writable(localStorage.getItem('token') || '');
 
@@ -9,3 +9,43 @@ token.subscribe((val) => {
     localStorage.removeItem('token');
   }
 });
+
+// refreshToken is exchanged for a new pair when the short lived token expires
+export const refreshToken = writable(localStorage.getItem('refreshToken') || '');
+
+refreshToken.subscribe((val) => {
+  if (val) {
+    localStorage.setItem('refreshToken', val);
+  } else {
+    localStorage.removeItem('refreshToken');
+  }
+});
+
+// setSession stores the tokens returned by login and refresh
+export function setSession(data) {
+  refreshToken.set(data.refresh_token || '');
+  token.set(data.token || '');
+}
+
+export function clearSession() {
+  refreshToken.set('');
+  token.set('');
+}
+
+// roles from least to most privileged, matching the server
+const roles = ['viewer', 'reviewer', 'senior_reviewer', 'admin'];
+
+// role is read from the token so the UI only offers what the server allows
+export const role = derived(token, ($token) => {
+  try {
+    const payload = $token.split('.')[1].replace(/-/g, '+').replace(/_/g, '/');
+    return JSON.parse(atob(payload)).role || '';
+  } catch {
+    return '';
+  }
+});
+
+export function hasRole(current, required) {
+  const level = roles.indexOf(current);
+  return level >= 0 && level >= roles.indexOf(required);
+}
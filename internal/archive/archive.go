@@ -0,0 +1,238 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// Limits bounds how much an archive may expand to
+type Limits struct {
+	MaxEntries          int     // Maximum number of regular files
+	MaxEntrySize        int64   // Maximum uncompressed size of a single file in bytes
+	MaxTotalSize        int64   // Maximum uncompressed size of all files in bytes
+	MaxCompressionRatio float64 // Maximum uncompressed/compressed ratio for a zip entry
+}
+
+// Entry is a file extracted from an archive
+type Entry struct {
+	Name     string // Path inside the archive
+	TempPath string // Location of the extracted file, empty when Err is set
+	Err      error  // Reason the entry was skipped
+}
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported archive format, expected zip, tar or tar.gz")
+	ErrTooManyEntries    = errors.New("archive has too many entries")
+	ErrTooLarge          = errors.New("archive expands beyond the allowed size")
+)
+
+// Extract writes every regular file in the archive to its own temp file in destDir.
+// Entry names are never used as filesystem paths, so crafted names can't escape destDir;
+// unsafe names are still reported and skipped. Callers must remove the returned temp files.
+func Extract(file io.ReaderAt, size int64, destDir string, limits Limits) ([]Entry, error) {
+	mime, err := mimetype.DetectReader(io.NewSectionReader(file, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect archive type: %w", err)
+	}
+
+	x := &extractor{destDir: destDir, limits: limits}
+
+	switch mime.String() {
+	case "application/zip":
+		err = x.extractZip(file, size)
+	case "application/gzip":
+		gz, gzErr := gzip.NewReader(io.NewSectionReader(file, 0, size))
+		if gzErr != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", gzErr)
+		}
+		defer gz.Close()
+		err = x.extractTar(gz)
+	case "application/x-tar":
+		err = x.extractTar(io.NewSectionReader(file, 0, size))
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+
+	if err != nil {
+		Cleanup(x.entries)
+		return nil, err
+	}
+
+	return x.entries, nil
+}
+
+// Cleanup removes the temp files of extracted entries
+func Cleanup(entries []Entry) {
+	for _, e := range entries {
+		if e.TempPath != "" {
+			os.Remove(e.TempPath)
+		}
+	}
+}
+
+type extractor struct {
+	destDir string
+	limits  Limits
+	entries []Entry
+	files   int
+	total   int64
+}
+
+func (x *extractor) extractZip(file io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(file, size)
+	if err != nil {
+		return fmt.Errorf("failed to open zip: %w", err)
+	}
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		if err := x.admit(); err != nil {
+			return err
+		}
+
+		if err := checkName(f.Name); err != nil {
+			x.skip(f.Name, err)
+			continue
+		}
+
+		if f.CompressedSize64 > 0 && x.limits.MaxCompressionRatio > 0 &&
+			float64(f.UncompressedSize64)/float64(f.CompressedSize64) > x.limits.MaxCompressionRatio {
+			x.skip(f.Name, fmt.Errorf("compression ratio exceeds %.0f", x.limits.MaxCompressionRatio))
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			x.skip(f.Name, fmt.Errorf("failed to open entry: %w", err))
+			continue
+		}
+		err = x.write(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (x *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := x.admit(); err != nil {
+			return err
+		}
+
+		if err := checkName(hdr.Name); err != nil {
+			x.skip(hdr.Name, err)
+			continue
+		}
+
+		if err := x.write(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// admit counts a regular file against the entry limit
+func (x *extractor) admit() error {
+	x.files++
+	if x.limits.MaxEntries > 0 && x.files > x.limits.MaxEntries {
+		return ErrTooManyEntries
+	}
+	return nil
+}
+
+func (x *extractor) skip(name string, err error) {
+	x.entries = append(x.entries, Entry{Name: name, Err: err})
+}
+
+// write copies an entry to a temp file, enforcing the size limits on the bytes actually read
+// rather than on the sizes declared in the archive headers.
+// Only exceeding the total size aborts the whole archive.
+func (x *extractor) write(name string, r io.Reader) error {
+	limit := x.limits.MaxEntrySize
+	if x.limits.MaxTotalSize > 0 {
+		remaining := x.limits.MaxTotalSize - x.total
+		if remaining <= 0 {
+			return ErrTooLarge
+		}
+		if limit <= 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+
+	tempFile, err := os.CreateTemp(x.destDir, "archive-*"+strings.ToLower(filepath.Ext(name)))
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer tempFile.Close()
+
+	var src io.Reader = r
+	if limit > 0 {
+		src = io.LimitReader(r, limit+1)
+	}
+
+	n, err := io.Copy(tempFile, src)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		x.skip(name, fmt.Errorf("failed to extract entry: %w", err))
+		return nil
+	}
+
+	if limit > 0 && n > limit {
+		os.Remove(tempFile.Name())
+		if x.limits.MaxEntrySize > 0 && n > x.limits.MaxEntrySize {
+			x.skip(name, fmt.Errorf("entry exceeds %d bytes", x.limits.MaxEntrySize))
+			return nil
+		}
+		return ErrTooLarge
+	}
+
+	x.total += n
+	x.entries = append(x.entries, Entry{Name: name, TempPath: tempFile.Name()})
+
+	return nil
+}
+
+// checkName rejects absolute paths and paths climbing out of the archive root
+func checkName(name string) error {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return errors.New("unsafe path: absolute")
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return errors.New("unsafe path: parent directory reference")
+		}
+	}
+
+	return nil
+}
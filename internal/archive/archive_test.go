@@ -0,0 +1,272 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// file is an entry of a fixture archive
+type file struct {
+	name string
+	data []byte
+}
+
+func zipFixture(t *testing.T, files ...file) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarFixture(t *testing.T, files ...file) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzFixture(t *testing.T, files ...file) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(tarFixture(t, files...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var formats = []struct {
+	name  string
+	build func(*testing.T, ...file) []byte
+}{
+	{"zip", zipFixture},
+	{"tar", tarFixture},
+	{"tar.gz", tarGzFixture},
+}
+
+// extract runs Extract into a fresh directory nested in a parent that must stay empty
+func extract(t *testing.T, data []byte, limits Limits) ([]Entry, string, error) {
+	t.Helper()
+
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "dest")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Extract(bytes.NewReader(data), int64(len(data)), dest, limits)
+
+	siblings, readErr := os.ReadDir(parent)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if len(siblings) != 1 {
+		t.Fatalf("files written outside of the destination directory: %v", siblings)
+	}
+	return entries, dest, err
+}
+
+// assertEmpty fails unless dir holds no files, i.e. everything extracted was cleaned up
+func assertEmpty(t *testing.T, dir string) {
+	t.Helper()
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("%d temp files left behind", len(files))
+	}
+}
+
+func TestExtractRejectsUnsafeNames(t *testing.T) {
+	names := []string{
+		"../escape.jpg",
+		"images/../../escape.jpg",
+		"/etc/escape.jpg",
+		`..\escape.jpg`,
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			files := []file{{"images/ok.jpg", []byte("ok")}}
+			for _, name := range names {
+				files = append(files, file{name, []byte("evil")})
+			}
+
+			entries, _, err := extract(t, format.build(t, files...), Limits{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer Cleanup(entries)
+
+			if len(entries) != len(files) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(files))
+			}
+			for _, e := range entries {
+				safe := e.Name == "images/ok.jpg"
+				if safe && (e.Err != nil || e.TempPath == "") {
+					t.Errorf("%s was not extracted: %v", e.Name, e.Err)
+				}
+				if !safe && (e.Err == nil || e.TempPath != "") {
+					t.Errorf("%s was extracted, want it skipped", e.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractKeepsDuplicateNames(t *testing.T) {
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			data := format.build(t, file{"a.jpg", []byte("first")}, file{"a.jpg", []byte("second")})
+
+			entries, _, err := extract(t, data, Limits{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer Cleanup(entries)
+
+			if len(entries) != 2 {
+				t.Fatalf("got %d entries, want 2", len(entries))
+			}
+			for i, want := range []string{"first", "second"} {
+				got, err := os.ReadFile(entries[i].TempPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("entry %d holds %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractEntryLimit(t *testing.T) {
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			var files []file
+			for i := 0; i < 4; i++ {
+				files = append(files, file{strings.Repeat("x", i+1) + ".jpg", []byte("data")})
+			}
+
+			_, dest, err := extract(t, format.build(t, files...), Limits{MaxEntries: 3})
+			if !errors.Is(err, ErrTooManyEntries) {
+				t.Fatalf("got %v, want ErrTooManyEntries", err)
+			}
+			assertEmpty(t, dest)
+		})
+	}
+}
+
+func TestExtractTotalSize(t *testing.T) {
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			data := format.build(t,
+				file{"a.jpg", bytes.Repeat([]byte("a"), 600)},
+				file{"b.jpg", bytes.Repeat([]byte("b"), 600)},
+			)
+
+			_, dest, err := extract(t, data, Limits{MaxTotalSize: 1000})
+			if !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("got %v, want ErrTooLarge", err)
+			}
+			assertEmpty(t, dest)
+		})
+	}
+}
+
+func TestExtractEntrySize(t *testing.T) {
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			data := format.build(t,
+				file{"big.jpg", bytes.Repeat([]byte("b"), 2000)},
+				file{"small.jpg", []byte("small")},
+			)
+
+			entries, _, err := extract(t, data, Limits{MaxEntrySize: 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer Cleanup(entries)
+
+			if len(entries) != 2 {
+				t.Fatalf("got %d entries, want 2", len(entries))
+			}
+			if entries[0].Err == nil || entries[0].TempPath != "" {
+				t.Error("oversized entry was extracted")
+			}
+			if entries[1].Err != nil {
+				t.Errorf("small entry was skipped: %v", entries[1].Err)
+			}
+		})
+	}
+}
+
+func TestExtractCompressionRatio(t *testing.T) {
+	bomb := file{"bomb.jpg", make([]byte, 1<<20)}
+	data := zipFixture(t, bomb, file{"ok.jpg", []byte("not compressible enough")})
+
+	entries, _, err := extract(t, data, Limits{MaxCompressionRatio: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Cleanup(entries)
+
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if entries[0].Err == nil || entries[0].TempPath != "" {
+		t.Error("entry above the compression ratio was extracted")
+	}
+	if entries[1].Err != nil {
+		t.Errorf("ordinary entry was skipped: %v", entries[1].Err)
+	}
+}
+
+func TestExtractUnsupportedFormat(t *testing.T) {
+	data := []byte("just some text, not an archive")
+
+	_, _, err := extract(t, data, Limits{})
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("got %v, want ErrUnsupportedFormat", err)
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to recognize
+const apiKeyPrefix = "nsfw_"
+
+// apiKeyListedLength is how much of a key is kept in the clear to tell keys apart
+const apiKeyListedLength = len(apiKeyPrefix) + 8
+
+// GenerateAPIKey returns a new API key and the prefix it is listed under
+func GenerateAPIKey() (key, prefix string, err error) {
+	secret, err := randomString()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	key = apiKeyPrefix + secret
+	return key, key[:apiKeyListedLength], nil
+}
+
+// HashAPIKey returns what is stored for an API key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Quotas reported by QuotaError
+const (
+	LimitDaily   = "daily"
+	LimitMonthly = "monthly"
+)
+
+// QuotaError is returned while an API key is over one of its quotas
+type QuotaError struct {
+	Limit      string
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	if e.Limit == LimitDaily {
+		return "Daily quota exceeded"
+	}
+	return "Monthly quota exceeded"
+}
+
+// APIKeyLimiter enforces the quotas of API keys. Requests are counted per key in the
+// current UTC day and month. Requests refused by a quota still count, so the counts show
+// how far a client went over. The per minute rate limit of a key is a token bucket, see
+// middleware.APIKeyRateLimit.
+type APIKeyLimiter struct {
+	counter cache.Counter
+}
+
+func NewAPIKeyLimiter(counter cache.Counter) *APIKeyLimiter {
+	return &APIKeyLimiter{counter: counter}
+}
+
+// Allow counts a request made with key and returns a *QuotaError if it is over a quota
+func (l *APIKeyLimiter) Allow(ctx context.Context, key *models.APIKey) error {
+	now := time.Now().UTC()
+	dayReset, monthReset := nextDay(now).Sub(now), nextMonth(now).Sub(now)
+
+	// both windows count every request before either quota is checked, so a request over
+	// the daily quota still uses up the month. Keys without quotas are counted for the stats.
+	today, err := l.counter.Incr(ctx, dailyKey(key.ID, now), dayReset)
+	if err != nil {
+		return fmt.Errorf("failed to count api request: %w", err)
+	}
+	month, err := l.counter.Incr(ctx, monthlyKey(key.ID, now), monthReset)
+	if err != nil {
+		return fmt.Errorf("failed to count api request: %w", err)
+	}
+
+	// over both quotas, the client has to wait for the later reset
+	if key.MonthlyQuota > 0 && month > int64(key.MonthlyQuota) {
+		return &QuotaError{Limit: LimitMonthly, RetryAfter: monthReset}
+	}
+	if key.DailyQuota > 0 && today > int64(key.DailyQuota) {
+		return &QuotaError{Limit: LimitDaily, RetryAfter: dayReset}
+	}
+	return nil
+}
+
+// Usage returns the requests made with key in the current UTC day and month
+func (l *APIKeyLimiter) Usage(ctx context.Context, key *models.APIKey) (today, month int64, err error) {
+	now := time.Now().UTC()
+
+	if today, err = l.counter.Get(ctx, dailyKey(key.ID, now)); err != nil {
+		return 0, 0, fmt.Errorf("failed to read api usage: %w", err)
+	}
+	if month, err = l.counter.Get(ctx, monthlyKey(key.ID, now)); err != nil {
+		return 0, 0, fmt.Errorf("failed to read api usage: %w", err)
+	}
+	return today, month, nil
+}
+
+func nextDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func dailyKey(id int, now time.Time) string {
+	return "apikey:" + strconv.Itoa(id) + ":day:" + now.Format("20060102")
+}
+
+func monthlyKey(id int, now time.Time) string {
+	return "apikey:" + strconv.Itoa(id) + ":month:" + now.Format("200601")
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+func TestAPIKeyHash(t *testing.T) {
+	key, prefix, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix) || !strings.HasPrefix(key, prefix) || len(prefix) != apiKeyListedLength {
+		t.Fatalf("GenerateAPIKey = %q listed as %q", key, prefix)
+	}
+
+	if HashAPIKey(" "+key+"\n") != HashAPIKey(key) {
+		t.Fatal("surrounding spaces change the hash of a key")
+	}
+	if other, _, _ := GenerateAPIKey(); HashAPIKey(other) == HashAPIKey(key) {
+		t.Fatal("two keys share a hash")
+	}
+}
+
+// allow calls Allow n times and returns the error of the last call
+func allow(t *testing.T, l *APIKeyLimiter, key *models.APIKey, n int) error {
+	t.Helper()
+
+	var err error
+	for i := 0; i < n; i++ {
+		if err = l.Allow(context.Background(), key); err != nil && i < n-1 {
+			t.Fatalf("request %d of %d refused: %v", i+1, n, err)
+		}
+	}
+	return err
+}
+
+func TestAPIKeyQuotas(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   models.APIKey
+		limit string
+		max   time.Duration
+	}{
+		{"daily", models.APIKey{ID: 1, DailyQuota: 3, MonthlyQuota: 100}, LimitDaily, 24 * time.Hour},
+		{"monthly", models.APIKey{ID: 2, DailyQuota: 100, MonthlyQuota: 3}, LimitMonthly, 31 * 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			l := NewAPIKeyLimiter(cache.NewMemoryCounter())
+
+			if err := allow(t, l, &tt.key, 3); err != nil {
+				t.Fatalf("request within the quota refused: %v", err)
+			}
+
+			var quotaErr *QuotaError
+			if err := l.Allow(ctx, &tt.key); !errors.As(err, &quotaErr) {
+				t.Fatalf("Allow over the quota = %v, want a *QuotaError", err)
+			}
+			if quotaErr.Limit != tt.limit || quotaErr.RetryAfter <= 0 || quotaErr.RetryAfter > tt.max {
+				t.Fatalf("Allow over the quota = %+v, want the %s quota until its reset", quotaErr, tt.limit)
+			}
+
+			// refused requests still count, in both windows
+			today, month, err := l.Usage(ctx, &tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if today != 4 || month != 4 {
+				t.Fatalf("Usage = %d today, %d this month", today, month)
+			}
+
+			// quotas are per key
+			other := models.APIKey{ID: 99, DailyQuota: 1, MonthlyQuota: 1}
+			if err := l.Allow(ctx, &other); err != nil {
+				t.Fatalf("Allow of another key = %v", err)
+			}
+		})
+	}
+}
+
+// TestAPIKeyOverDailyQuota checks requests refused by the daily quota use up the month,
+// a client can't make more requests in a month by going over its daily quota
+func TestAPIKeyOverDailyQuota(t *testing.T) {
+	ctx := context.Background()
+	l := NewAPIKeyLimiter(cache.NewMemoryCounter())
+	key := &models.APIKey{ID: 1, DailyQuota: 1, MonthlyQuota: 3}
+
+	if err := l.Allow(ctx, key); err != nil {
+		t.Fatalf("request within the quotas refused: %v", err)
+	}
+
+	var quotaErr *QuotaError
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(ctx, key); !errors.As(err, &quotaErr) || quotaErr.Limit != LimitDaily {
+			t.Fatalf("Allow over the daily quota = %v, want the daily quota", err)
+		}
+	}
+	if _, month, err := l.Usage(ctx, key); err != nil || month != 3 {
+		t.Fatalf("Usage = %d this month, %v, want every request counted", month, err)
+	}
+
+	// over both quotas, the month decides when the key works again
+	if err := l.Allow(ctx, key); !errors.As(err, &quotaErr) || quotaErr.Limit != LimitMonthly {
+		t.Fatalf("Allow over both quotas = %v, want the monthly quota", err)
+	}
+}
+
+func TestAPIKeyUnlimited(t *testing.T) {
+	l := NewAPIKeyLimiter(cache.NewMemoryCounter())
+	key := &models.APIKey{ID: 1}
+
+	if err := allow(t, l, key, 50); err != nil {
+		t.Fatalf("request of an unlimited key refused: %v", err)
+	}
+
+	// requests are counted for the stats either way
+	today, month, err := l.Usage(context.Background(), key)
+	if err != nil || today != 50 || month != 50 {
+		t.Fatalf("Usage = %d, %d, %v, want 50 requests", today, month, err)
+	}
+}
+
+func TestQuotaResets(t *testing.T) {
+	tests := []struct {
+		now       time.Time
+		nextDay   time.Time
+		nextMonth time.Time
+	}{
+		{
+			time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC),
+			time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			time.Date(2026, 1, 31, 23, 59, 59, 0, time.UTC),
+			time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		if got := nextDay(tt.now); !got.Equal(tt.nextDay) {
+			t.Errorf("nextDay(%s) = %s, want %s", tt.now, got, tt.nextDay)
+		}
+		if got := nextMonth(tt.now); !got.Equal(tt.nextMonth) {
+			t.Errorf("nextMonth(%s) = %s, want %s", tt.now, got, tt.nextMonth)
+		}
+	}
+}
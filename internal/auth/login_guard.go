@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+)
+
+// maxLoginDelay caps the wait between failed logins before the lockout takes over
+const maxLoginDelay = 30 * time.Second
+
+// ThrottledError is returned while logins for a username or from an address are held back
+type ThrottledError struct {
+	// Locked is set for a lockout, as opposed to the short wait after a failed attempt
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	seconds := int(math.Ceil(e.RetryAfter.Seconds()))
+	if e.Locked {
+		return fmt.Sprintf("Too many failed logins, locked for %d seconds", seconds)
+	}
+	return fmt.Sprintf("Too many failed logins, try again in %d seconds", seconds)
+}
+
+// LoginGuard slows down password guessing. Failed logins are counted per username and
+// per client address. From the second failure for a username on, each one makes the next
+// attempt for it wait twice as long. Reaching the maximum locks the username or address
+// out for a while. Addresses are not delayed, many users may share one behind a NAT.
+// A failure restarts the window, so counts only fall back to zero after a quiet period.
+type LoginGuard struct {
+	counter       cache.Counter
+	maxAttempts   int
+	maxIPAttempts int
+	window        time.Duration
+	lockout       time.Duration
+}
+
+func NewLoginGuard(cfg config.SecurityConfig, counter cache.Counter) *LoginGuard {
+	return &LoginGuard{
+		counter:       counter,
+		maxAttempts:   cfg.LoginMaxAttempts,
+		maxIPAttempts: cfg.LoginIPMaxAttempts,
+		window:        time.Duration(cfg.LoginWindowMin) * time.Minute,
+		lockout:       time.Duration(cfg.LoginLockoutMin) * time.Minute,
+	}
+}
+
+// Check returns a *ThrottledError if username or ip may not try to log in yet
+func (g *LoginGuard) Check(ctx context.Context, username, ip string) error {
+	for _, subject := range subjects(username, ip) {
+		if err := g.check(ctx, subject); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *LoginGuard) check(ctx context.Context, subject string) error {
+	locked, err := g.counter.TTL(ctx, lockKey(subject))
+	if err != nil {
+		return fmt.Errorf("failed to check login lockout: %w", err)
+	}
+	if locked > 0 {
+		return &ThrottledError{Locked: true, RetryAfter: locked}
+	}
+
+	wait, err := g.counter.TTL(ctx, waitKey(subject))
+	if err != nil {
+		return fmt.Errorf("failed to check login delay: %w", err)
+	}
+	if wait > 0 {
+		return &ThrottledError{RetryAfter: wait}
+	}
+
+	return nil
+}
+
+// Fail records a failed login and reports whether it locked the username or address out
+func (g *LoginGuard) Fail(ctx context.Context, username, ip string) (locked bool, err error) {
+	userLocked, err := g.fail(ctx, userSubject(username), g.maxAttempts, true)
+	if err != nil {
+		return false, err
+	}
+
+	ipLocked, err := g.fail(ctx, ipSubject(ip), g.maxIPAttempts, false)
+	return userLocked || ipLocked, err
+}
+
+func (g *LoginGuard) fail(ctx context.Context, subject string, max int, delay bool) (bool, error) {
+	failures, err := g.counter.Incr(ctx, failKey(subject), g.window)
+	if err != nil {
+		return false, fmt.Errorf("failed to count failed login: %w", err)
+	}
+
+	if failures >= int64(max) {
+		if _, err := g.counter.Incr(ctx, lockKey(subject), g.lockout); err != nil {
+			return false, fmt.Errorf("failed to lock login: %w", err)
+		}
+		return true, g.counter.Delete(ctx, failKey(subject), waitKey(subject))
+	}
+
+	if delay && failures >= 2 {
+		if _, err := g.counter.Incr(ctx, waitKey(subject), loginDelay(failures)); err != nil {
+			return false, fmt.Errorf("failed to delay login: %w", err)
+		}
+	}
+
+	return false, nil
+}
+
+// Succeed forgets the failures of username. Those of the address are kept, or a single
+// valid account would let its holder keep guessing the passwords of others.
+func (g *LoginGuard) Succeed(ctx context.Context, username string) error {
+	return g.counter.Delete(ctx, failKey(userSubject(username)), waitKey(userSubject(username)))
+}
+
+// Unlock lifts the lockout of username and forgets its failures
+func (g *LoginGuard) Unlock(ctx context.Context, username string) error {
+	subject := userSubject(username)
+	return g.counter.Delete(ctx, lockKey(subject), failKey(subject), waitKey(subject))
+}
+
+// loginDelay doubles from one second at the second failure
+func loginDelay(failures int64) time.Duration {
+	delay := time.Second << uint(failures-2)
+	if delay <= 0 || delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+	return delay
+}
+
+// subjects lists what failures are counted for
+func subjects(username, ip string) []string {
+	return []string{userSubject(username), ipSubject(ip)}
+}
+
+func ipSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// userSubject compares usernames case insensitively, like MySQL does, so case variants share a count
+func userSubject(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func failKey(subject string) string {
+	return "login:failures:" + subject
+}
+
+func waitKey(subject string) string {
+	return "login:wait:" + subject
+}
+
+func lockKey(subject string) string {
+	return "login:locked:" + subject
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+)
+
+func newGuard() *LoginGuard {
+	return NewLoginGuard(config.SecurityConfig{
+		LoginMaxAttempts:   3,
+		LoginIPMaxAttempts: 5,
+		LoginWindowMin:     15,
+		LoginLockoutMin:    15,
+	}, cache.NewMemoryCounter())
+}
+
+// throttled returns the *ThrottledError of err, failing the test if there is none
+func throttled(t *testing.T, err error) *ThrottledError {
+	t.Helper()
+
+	var throttledErr *ThrottledError
+	if !errors.As(err, &throttledErr) {
+		t.Fatalf("got %v, want a *ThrottledError", err)
+	}
+	return throttledErr
+}
+
+func fail(t *testing.T, g *LoginGuard, username, ip string) bool {
+	t.Helper()
+
+	locked, err := g.Fail(context.Background(), username, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return locked
+}
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{2, time.Second},
+		{3, 2 * time.Second},
+		{4, 4 * time.Second},
+		{6, 16 * time.Second},
+		{7, maxLoginDelay},
+		{64, maxLoginDelay},
+		{1000, maxLoginDelay},
+	}
+
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginGuardDelaysAndLocksUser(t *testing.T) {
+	ctx := context.Background()
+	g := newGuard()
+
+	if fail(t, g, "alice", "10.0.0.1") {
+		t.Fatal("first failure locked the user out")
+	}
+	if err := g.Check(ctx, "alice", "10.0.0.1"); err != nil {
+		t.Fatalf("Check after one failure = %v, want no delay", err)
+	}
+
+	fail(t, g, "alice", "10.0.0.1")
+	delayed := throttled(t, g.Check(ctx, "alice", "10.0.0.1"))
+	if delayed.Locked || delayed.RetryAfter <= 0 || delayed.RetryAfter > time.Second {
+		t.Fatalf("Check after two failures = %+v, want a delay of up to a second", delayed)
+	}
+
+	// the delay is per user, the address alone is not held back
+	if err := g.Check(ctx, "bob", "10.0.0.1"); err != nil {
+		t.Fatalf("Check(bob) = %v", err)
+	}
+
+	if !fail(t, g, "ALICE", "10.0.0.2") {
+		t.Fatal("reaching the maximum, in another case, did not lock the user out")
+	}
+	locked := throttled(t, g.Check(ctx, "alice", "10.0.0.3"))
+	if !locked.Locked || locked.RetryAfter <= 14*time.Minute {
+		t.Fatalf("Check after lockout = %+v, want a lockout of 15 minutes", locked)
+	}
+
+	if err := g.Unlock(ctx, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Check(ctx, "alice", "10.0.0.3"); err != nil {
+		t.Fatalf("Check after Unlock = %v", err)
+	}
+}
+
+func TestLoginGuardLocksAddress(t *testing.T) {
+	ctx := context.Background()
+	g := newGuard()
+
+	// spraying one password over many accounts only locks the address
+	for i, username := range []string{"a", "b", "c", "d"} {
+		if fail(t, g, username, "10.0.0.1") {
+			t.Fatalf("failure %d locked the address out early", i+1)
+		}
+	}
+
+	// a successful login doesn't clear the failures of its address
+	if err := g.Succeed(ctx, "e"); err != nil {
+		t.Fatal(err)
+	}
+	if !fail(t, g, "e", "10.0.0.1") {
+		t.Fatal("reaching the maximum did not lock the address out")
+	}
+
+	if !throttled(t, g.Check(ctx, "f", "10.0.0.1")).Locked {
+		t.Fatal("Check from a locked address was not locked out")
+	}
+	if err := g.Check(ctx, "f", "10.0.0.2"); err != nil {
+		t.Fatalf("Check from another address = %v", err)
+	}
+}
+
+func TestLoginGuardSucceed(t *testing.T) {
+	ctx := context.Background()
+	g := newGuard()
+
+	fail(t, g, "alice", "10.0.0.1")
+	fail(t, g, "alice", "10.0.0.1")
+	if err := g.Succeed(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.Check(ctx, "alice", "10.0.0.1"); err != nil {
+		t.Fatalf("Check after Succeed = %v", err)
+	}
+	if fail(t, g, "alice", "10.0.0.1") {
+		t.Fatal("failures before the successful login were still counted")
+	}
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+)
+
+const testClientID = "nsfw-detector"
+
+// fakeIdP is an identity provider answering the authorization code flow with signed ID tokens
+type fakeIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// codes maps issued authorization codes to the login they belong to
+	codes map[string]url.Values
+	// claims changes the claims of the next ID tokens
+	claims func(jwt.MapClaims)
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			Kty: "RSA",
+			Kid: "idp",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// authorize plays the user logging in at the provider and returns the code of the callback
+func (idp *fakeIdP) authorize(t *testing.T, authURL string) (state, code string) {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != testClientID {
+		t.Fatalf("authorization request %v", query)
+	}
+
+	code, _ = randomString()
+	idp.mu.Lock()
+	idp.codes[code] = query
+	idp.mu.Unlock()
+
+	return query.Get("state"), code
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	idp.mu.Lock()
+	login, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	claimsFunc := idp.claims
+	idp.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || login.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":    idp.URL,
+		"aud":    testClientID,
+		"sub":    "a1b2c3",
+		"email":  "alice@example.com",
+		"groups": []string{"moderators"},
+		"nonce":  login.Get("nonce"),
+		"iat":    now.Unix(),
+		"exp":    now.Add(5 * time.Minute).Unix(),
+	}
+	if claimsFunc != nil {
+		claimsFunc(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "idp"
+	signed, _ := token.SignedString(idp.key)
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+}
+
+// shortStates keeps login states for ttl, whatever the provider asks for
+type shortStates struct {
+	cache.Cache
+	ttl time.Duration
+}
+
+func (s shortStates) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.Cache.Set(ctx, key, value, s.ttl)
+}
+
+func newProvider(idp *fakeIdP, states cache.Cache) *OIDCProvider {
+	return NewOIDCProvider(config.OIDCConfig{
+		IssuerURL:     idp.URL,
+		ClientID:      testClientID,
+		RedirectURL:   "https://detector.example.com/api/auth/sso/callback",
+		Scopes:        []string{"openid", "email"},
+		UsernameClaim: "email",
+		GroupsClaim:   "groups",
+	}, states)
+}
+
+func TestOIDCExchange(t *testing.T) {
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	p := newProvider(idp, cache.NewMemoryStore())
+
+	authURL, err := p.AuthURL(ctx)
+	if err != nil {
+		t.Fatalf("AuthURL = %v", err)
+	}
+	state, code := idp.authorize(t, authURL)
+
+	identity, err := p.Exchange(ctx, state, code)
+	if err != nil {
+		t.Fatalf("Exchange = %v", err)
+	}
+	if identity.Subject != "a1b2c3" || identity.Username != "alice@example.com" || len(identity.Groups) != 1 || identity.Groups[0] != "moderators" {
+		t.Fatalf("Exchange = %+v", identity)
+	}
+
+	// a state is good for one callback
+	if _, err := p.Exchange(ctx, state, code); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("Exchange(used state) = %v, want ErrOIDCState", err)
+	}
+	if _, err := p.Exchange(ctx, "made-up", code); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("Exchange(unknown state) = %v, want ErrOIDCState", err)
+	}
+}
+
+func TestOIDCStateExpires(t *testing.T) {
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	p := newProvider(idp, shortStates{Cache: cache.NewMemoryStore(), ttl: 10 * time.Millisecond})
+
+	authURL, err := p.AuthURL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := idp.authorize(t, authURL)
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := p.Exchange(ctx, state, code); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("Exchange(expired state) = %v, want ErrOIDCState", err)
+	}
+}
+
+// TestOIDCCodeOfAnotherLogin checks a code can't be completed with the state of another
+// login, the verifier of that state does not match the code's challenge
+func TestOIDCCodeOfAnotherLogin(t *testing.T) {
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	p := newProvider(idp, cache.NewMemoryStore())
+
+	victimURL, _ := p.AuthURL(ctx)
+	_, victimCode := idp.authorize(t, victimURL)
+	attackerURL, _ := p.AuthURL(ctx)
+	attackerState, _ := idp.authorize(t, attackerURL)
+
+	if _, err := p.Exchange(ctx, attackerState, victimCode); err == nil {
+		t.Fatal("Exchange accepted the code of another login")
+	}
+}
+
+func TestOIDCRejectsTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims func(jwt.MapClaims)
+	}{
+		{"nonce of another login", func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{"no nonce", func(c jwt.MapClaims) { delete(c, "nonce") }},
+		{"other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{"issued to another party", func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientID, "another-client"}
+			c["azp"] = "another-client"
+		}},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+		{"no username", func(c jwt.MapClaims) { delete(c, "email") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			idp := newFakeIdP(t)
+			idp.claims = tt.claims
+			p := newProvider(idp, cache.NewMemoryStore())
+
+			authURL, err := p.AuthURL(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, code := idp.authorize(t, authURL)
+
+			if identity, err := p.Exchange(ctx, state, code); err == nil {
+				t.Fatalf("Exchange = %+v, want an error", identity)
+			}
+			// the failed callback used the state up
+			if _, err := p.Exchange(ctx, state, code); !errors.Is(err, ErrOIDCState) {
+				t.Fatalf("Exchange(used state) = %v, want ErrOIDCState", err)
+			}
+		})
+	}
+}
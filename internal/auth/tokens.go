@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+const (
+	// PurposeMFA marks the token of a login waiting for its second factor
+	PurposeMFA = "mfa"
+	// PurposeMFAEnroll marks the token of a login that must set up 2FA before it completes
+	PurposeMFAEnroll = "mfa_enroll"
+	// PurposeSSO marks the token handed to the admin panel after a single sign-on login
+	PurposeSSO = "sso"
+
+	// challengeTTL is how long an unfinished login may wait for its second step
+	challengeTTL = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed, expired or not signed by a known key
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrRevoked is returned for tokens revoked by a logout or a change to their user
+	ErrRevoked = errors.New("token revoked")
+)
+
+// Tokens issues and verifies short lived access tokens.
+//
+// Tokens are HS256 JWTs carrying the id of the signing key in their kid header, so the
+// key can be rotated without logging everybody out: new tokens are signed with the first
+// configured key and any configured key is accepted. A token can be revoked on its own,
+// by its jti, or together with every token issued to its user before a point in time.
+// Revocations are kept in revoked until the tokens they cover would have expired anyway.
+type Tokens struct {
+	keys    []config.JWTKey
+	ttl     time.Duration
+	revoked cache.Cache
+}
+
+func New(cfg config.SecurityConfig, revoked cache.Cache) *Tokens {
+	return &Tokens{
+		keys:    cfg.JWTKeys,
+		ttl:     time.Duration(cfg.AccessTokenTTLMin) * time.Minute,
+		revoked: revoked,
+	}
+}
+
+// TTL returns how long issued tokens are valid
+func (t *Tokens) TTL() time.Duration {
+	return t.ttl
+}
+
+// Issue signs a new access token for a user of tenantID
+func (t *Tokens) Issue(username, role string, tenantID int) (string, *models.Claims, error) {
+	return t.sign(username, role, tenantID, "", t.ttl)
+}
+
+// IssueChallenge signs a token for a login that passed its password check but still has
+// a step for purpose to go. It is not accepted as an access token.
+func (t *Tokens) IssueChallenge(username, role, purpose string) (string, *models.Claims, error) {
+	return t.sign(username, role, models.AllTenants, purpose, challengeTTL)
+}
+
+func (t *Tokens) sign(username, role string, tenantID int, purpose string, ttl time.Duration) (string, *models.Claims, error) {
+	now := time.Now()
+	claims := &models.Claims{
+		Username: username,
+		Role:     role,
+		TenantID: tenantID,
+		Purpose:  purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	key := t.keys[0]
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.ID
+
+	signed, err := token.SignedString([]byte(key.Secret))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return signed, claims, nil
+}
+
+// Parse verifies an access token and checks that it was not revoked
+func (t *Tokens) Parse(ctx context.Context, tokenStr string) (*models.Claims, error) {
+	return t.parse(ctx, tokenStr, "")
+}
+
+// ParseChallenge verifies a token issued by IssueChallenge for purpose
+func (t *Tokens) ParseChallenge(ctx context.Context, tokenStr, purpose string) (*models.Claims, error) {
+	return t.parse(ctx, tokenStr, purpose)
+}
+
+func (t *Tokens) parse(ctx context.Context, tokenStr, purpose string) (*models.Claims, error) {
+	claims := &models.Claims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, t.key,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: missing jti or iat", ErrInvalidToken)
+	}
+	if claims.Purpose != purpose {
+		return nil, fmt.Errorf("%w: token is for %q", ErrInvalidToken, claims.Purpose)
+	}
+	// tokens issued before tenants existed belong to the default tenant
+	if purpose == "" && claims.TenantID == models.AllTenants {
+		claims.TenantID = models.DefaultTenantID
+	}
+
+	if err := t.checkRevoked(ctx, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// key returns the secret named by the kid header of token
+func (t *Tokens) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range t.keys {
+		if key.ID == kid {
+			return []byte(key.Secret), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// checkRevoked fails closed: a token that cannot be checked is not accepted
+func (t *Tokens) checkRevoked(ctx context.Context, claims *models.Claims) error {
+	_, err := t.revoked.Get(ctx, revokedTokenKey(claims.ID))
+	if err == nil {
+		return ErrRevoked
+	}
+	if !errors.Is(err, cache.ErrMiss) {
+		return fmt.Errorf("failed to check token revocation: %w", err)
+	}
+
+	value, err := t.revoked.Get(ctx, revokedUserKey(claims.Username))
+	if errors.Is(err, cache.ErrMiss) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check token revocation: %w", err)
+	}
+
+	cutoff, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if claims.IssuedAt.Unix() <= cutoff {
+		return ErrRevoked
+	}
+
+	return nil
+}
+
+// Revoke revokes a single token until it expires
+func (t *Tokens) Revoke(ctx context.Context, claims *models.Claims) error {
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+	return t.revoked.Set(ctx, revokedTokenKey(claims.ID), "1", ttl)
+}
+
+// RevokeUser revokes every token issued to username up to now. Token times only have
+// second precision, so a token issued in the same second is revoked as well.
+func (t *Tokens) RevokeUser(ctx context.Context, username string) error {
+	return t.revoked.Set(ctx, revokedUserKey(username), strconv.FormatInt(time.Now().Unix(), 10), t.ttl)
+}
+
+func revokedTokenKey(jti string) string {
+	return "auth:revoked:" + jti
+}
+
+func revokedUserKey(username string) string {
+	return "auth:revoked-user:" + username
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+// brokenCache fails every call, like Redis while it is unreachable
+type brokenCache struct{}
+
+var errUnreachable = errors.New("connection refused")
+
+func (brokenCache) Get(ctx context.Context, key string) (string, error) {
+	return "", errUnreachable
+}
+
+func (brokenCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return errUnreachable
+}
+
+func (brokenCache) Delete(ctx context.Context, key string) error {
+	return errUnreachable
+}
+
+func newTokens(revoked cache.Cache, keys ...config.JWTKey) *Tokens {
+	return New(config.SecurityConfig{JWTKeys: keys, AccessTokenTTLMin: 15}, revoked)
+}
+
+var (
+	oldKey = config.JWTKey{ID: "2025", Secret: "old secret"}
+	newKey = config.JWTKey{ID: "2026", Secret: "new secret"}
+)
+
+func TestRevoke(t *testing.T) {
+	ctx := context.Background()
+	tokens := newTokens(cache.NewMemoryStore(), newKey)
+
+	token, claims, err := tokens.Issue("alice", models.RoleAdmin, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := tokens.Issue("alice", models.RoleAdmin, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := tokens.Parse(ctx, token)
+	if err != nil {
+		t.Fatalf("Parse = %v", err)
+	}
+	if parsed.Username != "alice" || parsed.TenantID != 2 || parsed.ID != claims.ID {
+		t.Fatalf("Parse = %+v", parsed)
+	}
+
+	if err := tokens.Revoke(ctx, claims); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Parse(ctx, token); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("Parse(revoked) = %v, want ErrRevoked", err)
+	}
+	if _, err := tokens.Parse(ctx, other); err != nil {
+		t.Fatalf("revoking one token revoked another: %v", err)
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	ctx := context.Background()
+	tokens := newTokens(cache.NewMemoryStore(), newKey)
+
+	alice, _, _ := tokens.Issue("alice", models.RoleAdmin, 1)
+	bob, _, _ := tokens.Issue("bob", models.RoleAdmin, 1)
+
+	if err := tokens.RevokeUser(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Parse(ctx, alice); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("Parse(alice) = %v, want ErrRevoked", err)
+	}
+	if _, err := tokens.Parse(ctx, bob); err != nil {
+		t.Fatalf("Parse(bob) = %v", err)
+	}
+
+	// token times have second precision, wait for a token issued after the cutoff
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	fresh, _, _ := tokens.Issue("alice", models.RoleAdmin, 1)
+	if _, err := tokens.Parse(ctx, fresh); err != nil {
+		t.Fatalf("Parse(issued after revocation) = %v", err)
+	}
+}
+
+// TestRevocationFailsClosed checks tokens are refused when revocations can't be read
+func TestRevocationFailsClosed(t *testing.T) {
+	token, _, err := newTokens(cache.NewMemoryStore(), newKey).Issue("alice", models.RoleAdmin, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = newTokens(brokenCache{}, newKey).Parse(context.Background(), token)
+	if err == nil || !errors.Is(err, errUnreachable) {
+		t.Fatalf("Parse with an unreachable store = %v, want an error", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	revoked := cache.NewMemoryStore()
+
+	before := newTokens(revoked, oldKey)
+	during := newTokens(revoked, newKey, oldKey)
+	after := newTokens(revoked, newKey)
+
+	oldToken, _, _ := before.Issue("alice", models.RoleAdmin, 1)
+	newToken, _, _ := during.Issue("alice", models.RoleAdmin, 1)
+
+	tests := []struct {
+		name   string
+		tokens *Tokens
+		token  string
+		valid  bool
+	}{
+		{"old token while both keys are configured", during, oldToken, true},
+		{"new token while both keys are configured", during, newToken, true},
+		{"new token after the old key is dropped", after, newToken, true},
+		{"old token after the old key is dropped", after, oldToken, false},
+		{"new token on a server without the new key", before, newToken, false},
+		{"token signed with another secret", newTokens(revoked, config.JWTKey{ID: newKey.ID, Secret: "forged"}), newToken, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.tokens.Parse(ctx, tt.token)
+			if tt.valid && err != nil {
+				t.Fatalf("Parse = %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Parse = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestChallengeTokens(t *testing.T) {
+	ctx := context.Background()
+	tokens := newTokens(cache.NewMemoryStore(), newKey)
+
+	access, _, _ := tokens.Issue("alice", models.RoleAdmin, 1)
+	challenge, _, _ := tokens.IssueChallenge("alice", models.RoleAdmin, PurposeMFA)
+
+	if _, err := tokens.Parse(ctx, challenge); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Parse(challenge) = %v, want ErrInvalidToken", err)
+	}
+	if _, err := tokens.ParseChallenge(ctx, access, PurposeMFA); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("ParseChallenge(access token) = %v, want ErrInvalidToken", err)
+	}
+	if _, err := tokens.ParseChallenge(ctx, challenge, PurposeMFAEnroll); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("ParseChallenge(other purpose) = %v, want ErrInvalidToken", err)
+	}
+	if _, err := tokens.ParseChallenge(ctx, challenge, PurposeMFA); err != nil {
+		t.Fatalf("ParseChallenge = %v", err)
+	}
+}
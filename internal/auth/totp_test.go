@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// the last six digits of the eight digit codes of RFC 6238, appendix B
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(rfcSecret, tt.code, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("ValidateTOTP(%s at %d) rejected a valid code", tt.code, tt.unix)
+			continue
+		}
+		if step != tt.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(%s at %d) = step %d, want %d", tt.code, tt.unix, step, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	// 1111111111 is in step 37037037, whose code is 050471
+	const code = "050471"
+	at := func(unix int64) time.Time { return time.Unix(unix, 0) }
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		now    time.Time
+		valid  bool
+	}{
+		{"current step", rfcSecret, code, at(1111111111), true},
+		{"one step late", rfcSecret, code, at(1111111111 + totpPeriod), true},
+		{"one step early", rfcSecret, code, at(1111111111 - totpPeriod), true},
+		{"two steps late", rfcSecret, code, at(1111111111 + 2*totpPeriod), false},
+		{"two steps early", rfcSecret, code, at(1111111111 - 2*totpPeriod), false},
+		{"surrounding spaces", rfcSecret, " " + code + "\n", at(1111111111), true},
+		{"lower case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code, at(1111111111), true},
+		{"wrong code", rfcSecret, "050472", at(1111111111), false},
+		{"too short", rfcSecret, "50471", at(1111111111), false},
+		{"eight digits", rfcSecret, "14050471", at(1111111111), false},
+		{"empty", rfcSecret, "", at(1111111111), false},
+		{"other secret", "JBSWY3DPEHPK3PXP", code, at(1111111111), false},
+		{"invalid secret", "not base32!", code, at(1111111111), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, tt.now); ok != tt.valid {
+				t.Fatalf("ValidateTOTP = %v, want %v", ok, tt.valid)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := GenerateTOTPSecret()
+	if secret == other {
+		t.Fatal("two secrets are the same")
+	}
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+
+	now := time.Now()
+	if _, ok := ValidateTOTP(secret, totpCode(key, now.Unix()/totpPeriod), now); !ok {
+		t.Fatal("the code of a generated secret was rejected")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("recovery code %q does not match xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q generated twice", code)
+		}
+		seen[code] = true
+	}
+
+	hash := HashRecoveryCode("abcde-fghij")
+	for _, typed := range []string{"ABCDE-FGHIJ", "abcdefghij", " abcde fghij ", "Abcde-fghij\n"} {
+		if HashRecoveryCode(typed) != hash {
+			t.Errorf("HashRecoveryCode(%q) differs from the code as issued", typed)
+		}
+	}
+	if HashRecoveryCode("abcde-fghik") == hash {
+		t.Error("different codes share a hash")
+	}
+}
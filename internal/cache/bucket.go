@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/mlvieira/nsfwdetection/internal/driver/redis"
+)
+
+// BucketResult is the state of a token bucket after a request took from it
+type BucketResult struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left
+	Remaining int
+	// RetryAfter is how long until the next token, zero while there are tokens left
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// Buckets keeps token buckets, for rate limiting requests
+type Buckets interface {
+	// Take removes a token from the bucket under key, which holds up to burst tokens and
+	// refills at rate tokens per second. Buckets start full.
+	Take(ctx context.Context, key string, rate float64, burst int) (BucketResult, error)
+}
+
+// bucketResult derives what Take reports from the tokens left after a request
+func bucketResult(allowed bool, tokens, rate float64, burst int) BucketResult {
+	result := BucketResult{
+		Allowed:    allowed,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: tokenTime(float64(burst)-tokens, rate),
+	}
+	if tokens < 1 {
+		result.RetryAfter = tokenTime(1-tokens, rate)
+	}
+	return result
+}
+
+func tokenTime(tokens, rate float64) time.Duration {
+	return time.Duration(tokens / rate * float64(time.Second))
+}
+
+// takeScript refills and takes from a bucket atomically. It reads the clock of the Redis
+// server, so the clocks of the instances sharing the bucket do not have to agree.
+var takeScript = goredis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.max(1000, math.ceil((burst - tokens) * 1000 / rate)))
+return {allowed, tostring(tokens)}
+`)
+
+// RedisBuckets keeps token buckets in Redis, shared by every instance
+type RedisBuckets struct {
+	client *redis.RedisClient
+}
+
+func NewRedisBuckets(client *redis.RedisClient) *RedisBuckets {
+	return &RedisBuckets{client: client}
+}
+
+func (r *RedisBuckets) Take(ctx context.Context, key string, rate float64, burst int) (BucketResult, error) {
+	reply, err := takeScript.Run(ctx, r.client.Client(), []string{key}, rate, burst).Slice()
+	if err != nil {
+		return BucketResult{}, err
+	}
+
+	allowed, _ := reply[0].(int64)
+	tokens, err := strconv.ParseFloat(reply[1].(string), 64)
+	if err != nil {
+		return BucketResult{}, err
+	}
+
+	return bucketResult(allowed == 1, tokens, rate, burst), nil
+}
+
+type bucketEntry struct {
+	tokens float64
+	at     time.Time
+	fullAt time.Time
+}
+
+// MemoryBuckets keeps token buckets in process. Contents are lost on restart and not
+// shared between instances.
+type MemoryBuckets struct {
+	mu      sync.Mutex
+	entries map[string]bucketEntry
+	// sweepAt is the size at which full buckets are dropped, they are the same as new ones
+	sweepAt int
+}
+
+func NewMemoryBuckets() *MemoryBuckets {
+	return &MemoryBuckets{entries: make(map[string]bucketEntry), sweepAt: 1024}
+}
+
+func (b *MemoryBuckets) Take(ctx context.Context, key string, rate float64, burst int) (BucketResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := b.entries[key]
+	if !ok {
+		entry = bucketEntry{tokens: float64(burst), at: now}
+	}
+
+	tokens := math.Min(float64(burst), entry.tokens+now.Sub(entry.at).Seconds()*rate)
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	b.entries[key] = bucketEntry{tokens: tokens, at: now, fullAt: now.Add(tokenTime(float64(burst)-tokens, rate))}
+
+	if len(b.entries) >= b.sweepAt {
+		for k, e := range b.entries {
+			if !now.Before(e.fullAt) {
+				delete(b.entries, k)
+			}
+		}
+		b.sweepAt = 2 * len(b.entries)
+		if b.sweepAt < 1024 {
+			b.sweepAt = 1024
+		}
+	}
+
+	return bucketResult(allowed, tokens, rate, burst), nil
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/driver/redis"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+// ErrMiss is returned by Get when the key is not cached
+var ErrMiss = errors.New("cache: miss")
+
+// Cache stores string values with an expiration
+type Cache interface {
+	// Get returns the value for key, ErrMiss if it isn't cached or another error if the backend failed
+	Get(ctx context.Context, key string) (string, error)
+	// Set stores value for ttl, zero means no expiration
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Supported values for the backend setting of the [cache] config
+const (
+	BackendRedis   = "redis"
+	BackendMemory  = "memory"
+	BackendLayered = "layered"
+)
+
+// PredictionKey is the key a prediction is cached under. Each tenant has its own
+// thresholds and reviews, so it caches its own predictions.
+func PredictionKey(tenantID int, sha256Hash string) string {
+	return "nsfw:" + strconv.Itoa(tenantID) + ":" + sha256Hash
+}
+
+// PredictionPattern matches every key made by PredictionKey and nothing else under nsfw:,
+// like the job stream of the worker, so flushing predictions leaves queued jobs alone.
+const PredictionPattern = "nsfw:[0-9]*:*"
+
+// predictionTenant returns the tenant of a key made by PredictionKey, models.NoTenant for
+// other keys
+func predictionTenant(key string) int {
+	rest, ok := strings.CutPrefix(key, "nsfw:")
+	if !ok {
+		return models.NoTenant
+	}
+	id, _, _ := strings.Cut(rest, ":")
+	tenantID, err := strconv.Atoi(id)
+	if err != nil {
+		return models.NoTenant
+	}
+	return tenantID
+}
+
+// New builds the cache selected in cfg, wrapped with hit/miss metrics.
+// redisClient may be nil when the backend is memory.
+func New(cfg config.CacheConfig, redisClient *redis.RedisClient) (*Metered, error) {
+	switch cfg.Backend {
+	case BackendRedis:
+		return NewMetered(NewRedis(redisClient)), nil
+	case BackendMemory:
+		return NewMetered(NewLRU(cfg.MemorySize)), nil
+	case BackendLayered:
+		localTTL := time.Duration(cfg.MemoryTTLSec) * time.Second
+		return NewMetered(NewLayered(NewLRU(cfg.MemorySize), NewRedis(redisClient), localTTL)), nil
+	default:
+		return nil, fmt.Errorf("unsupported cache backend: %s", cfg.Backend)
+	}
+}
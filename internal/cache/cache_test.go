@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/mlvieira/nsfwdetection/internal/driver/redis"
+)
+
+// TestPredictionPattern checks the pattern, read as a Redis glob, matches the keys of
+// predictions only. Keys hold no '/', so path.Match reads it the same way as SCAN.
+func TestPredictionPattern(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{PredictionKey(1, "0a1b2c"), true},
+		{PredictionKey(1234, "0a1b2c"), true},
+		{"nsfw:jobs", false},
+		{"nsfw:jobs:result:9f8e7d", false},
+		{"ratelimit:ip:203.0.113.1", false},
+	}
+
+	for _, tt := range tests {
+		if got, err := path.Match(PredictionPattern, tt.key); err != nil || got != tt.want {
+			t.Errorf("%q matches %q = %v, %v, want %v", PredictionPattern, tt.key, got, err, tt.want)
+		}
+	}
+}
+
+// TestFlushPredictions checks deleting the keys of PredictionPattern, as `nsfwcli cache
+// flush` does, leaves the job stream of the worker and its result lists in place
+func TestFlushPredictions(t *testing.T) {
+	addr := os.Getenv("NSFW_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("NSFW_TEST_REDIS_ADDR not set")
+	}
+
+	ctx := context.Background()
+	rs := redis.NewRedisClient(addr, "", 0)
+	client := rs.Client()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Fatalf("failed to connect to Redis at %s: %v", addr, err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	const stream, result = "nsfw:jobs", "nsfw:jobs:result:flush-test"
+	predictions := []string{PredictionKey(1, "0a1b2c"), PredictionKey(42, "3d4e5f")}
+
+	id, err := client.XAdd(ctx, &goredis.XAddArgs{Stream: stream, Values: map[string]interface{}{"job": "flush-test"}}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.XDel(ctx, stream, id) })
+
+	if err := client.RPush(ctx, result, "{}").Err(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Del(ctx, result) })
+
+	for _, key := range predictions {
+		if err := rs.SetValue(ctx, key, "{}", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := rs.DeleteKeys(ctx, PredictionPattern); err != nil {
+		t.Fatalf("DeleteKeys = %v", err)
+	}
+
+	if n, err := client.Exists(ctx, predictions...).Result(); err != nil || n != 0 {
+		t.Fatalf("%d predictions left after the flush, %v", n, err)
+	}
+	if n, err := client.XLen(ctx, stream).Result(); err != nil || n == 0 {
+		t.Fatalf("job stream has %d entries after the flush, %v", n, err)
+	}
+	if n, err := client.Exists(ctx, result).Result(); err != nil || n != 1 {
+		t.Fatalf("result list removed by the flush, %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/mlvieira/nsfwdetection/internal/driver/redis"
+)
+
+// Counter keeps expiring counters, for tracking events such as failed logins
+type Counter interface {
+	// Incr adds one to key, (re)starts its expiration at ttl and returns the new count
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Get returns the count of key, zero if it does not exist
+	Get(ctx context.Context, key string) (int64, error)
+	// TTL returns how long key is kept, zero if it does not exist
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// RedisCounter keeps counters in Redis, shared by every instance
+type RedisCounter struct {
+	client *redis.RedisClient
+}
+
+func NewRedisCounter(client *redis.RedisClient) *RedisCounter {
+	return &RedisCounter{client: client}
+}
+
+func (r *RedisCounter) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	pipe := r.client.Client().TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.PExpire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (r *RedisCounter) Get(ctx context.Context, key string) (int64, error) {
+	count, err := r.client.Client().Get(ctx, key).Int64()
+	if err == goredis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (r *RedisCounter) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.Client().PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// missing keys and keys without expiration report negative values
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (r *RedisCounter) Delete(ctx context.Context, keys ...string) error {
+	return r.client.Client().Del(ctx, keys...).Err()
+}
+
+type counterEntry struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// MemoryCounter keeps counters in process. Contents are lost on restart and not shared
+// between instances.
+type MemoryCounter struct {
+	mu      sync.Mutex
+	entries map[string]counterEntry
+	// sweepAt is the size at which expired entries are dropped, so keys that are
+	// never read again do not pile up
+	sweepAt int
+}
+
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{entries: make(map[string]counterEntry), sweepAt: 1024}
+}
+
+func (c *MemoryCounter) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expiresAt) {
+		entry = counterEntry{}
+	}
+	entry.count++
+	entry.expiresAt = now.Add(ttl)
+	c.entries[key] = entry
+
+	if len(c.entries) >= c.sweepAt {
+		for k, e := range c.entries {
+			if !now.Before(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		c.sweepAt = 2 * len(c.entries)
+		if c.sweepAt < 1024 {
+			c.sweepAt = 1024
+		}
+	}
+
+	return entry.count, nil
+}
+
+func (c *MemoryCounter) Get(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return 0, nil
+	}
+	return entry.count, nil
+}
+
+func (c *MemoryCounter) TTL(ctx context.Context, key string) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return 0, nil
+	}
+
+	ttl := time.Until(entry.expiresAt)
+	if ttl <= 0 {
+		delete(c.entries, key)
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (c *MemoryCounter) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Layered keeps recently used entries in process in front of a shared cache.
+// Reads that miss locally go to the shared cache and fill the local one; writes and
+// deletes go to both. When the shared cache is down the local layer keeps serving.
+//
+// Another instance only sees a Delete once its local copy expires, so localTTL bounds
+// how long a stale entry can be served after an invalidation.
+type Layered struct {
+	local    Cache
+	remote   Cache
+	localTTL time.Duration
+}
+
+// NewLayered creates a cache reading local first, then remote
+func NewLayered(local, remote Cache, localTTL time.Duration) *Layered {
+	return &Layered{local: local, remote: remote, localTTL: localTTL}
+}
+
+func (c *Layered) Get(ctx context.Context, key string) (string, error) {
+	if value, err := c.local.Get(ctx, key); err == nil {
+		return value, nil
+	}
+
+	value, err := c.remote.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	c.local.Set(ctx, key, value, c.localTTL)
+	return value, nil
+}
+
+func (c *Layered) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	localTTL := c.localTTL
+	if ttl > 0 && (localTTL <= 0 || ttl < localTTL) {
+		localTTL = ttl
+	}
+
+	return errors.Join(
+		c.local.Set(ctx, key, value, localTTL),
+		c.remote.Set(ctx, key, value, ttl),
+	)
+}
+
+func (c *Layered) Delete(ctx context.Context, key string) error {
+	return errors.Join(
+		c.local.Delete(ctx, key),
+		c.remote.Delete(ctx, key),
+	)
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// MemoryStore is an in-process Cache that never evicts: entries are only dropped once they
+// expire, so it suits state that must not be forgotten early, like token revocations.
+// Contents are lost on restart and not shared between instances.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	// sweepAt is the size at which expired entries are dropped, so keys that are
+	// never read again do not pile up
+	sweepAt int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry), sweepAt: 1024}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return "", ErrMiss
+	}
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		delete(s.entries, key)
+		return "", ErrMiss
+	}
+	return entry.value, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	now := time.Now()
+	entry := memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = entry
+
+	if len(s.entries) >= s.sweepAt {
+		for k, e := range s.entries {
+			if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.sweepAt = 2 * len(s.entries)
+		if s.sweepAt < 1024 {
+			s.sweepAt = 1024
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
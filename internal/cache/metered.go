@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+// Metered counts hits, misses and backend errors of the Cache it wraps, per tenant of the
+// prediction keys it is called with
+type Metered struct {
+	Cache
+
+	mu     sync.Mutex
+	meters map[int]*meter
+}
+
+// meter holds the counters of one tenant
+type meter struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+	errors atomic.Int64
+}
+
+// NewMetered wraps c with counters
+func NewMetered(c Cache) *Metered {
+	return &Metered{Cache: c, meters: make(map[int]*meter)}
+}
+
+func (m *Metered) Get(ctx context.Context, key string) (string, error) {
+	value, err := m.Cache.Get(ctx, key)
+
+	counters := m.meter(predictionTenant(key))
+	switch {
+	case err == nil:
+		counters.hits.Add(1)
+	case errors.Is(err, ErrMiss):
+		counters.misses.Add(1)
+	default:
+		counters.misses.Add(1)
+		counters.errors.Add(1)
+	}
+
+	return value, err
+}
+
+func (m *Metered) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	err := m.Cache.Set(ctx, key, value, ttl)
+	if err != nil {
+		m.meter(predictionTenant(key)).errors.Add(1)
+	}
+	return err
+}
+
+func (m *Metered) Delete(ctx context.Context, key string) error {
+	err := m.Cache.Delete(ctx, key)
+	if err != nil {
+		m.meter(predictionTenant(key)).errors.Add(1)
+	}
+	return err
+}
+
+// Stats returns the counters of tenantID since startup, models.AllTenants adds up those
+// of every tenant
+func (m *Metered) Stats(tenantID int) models.CacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stats models.CacheStats
+	for id, counters := range m.meters {
+		if tenantID != models.AllTenants && id != tenantID {
+			continue
+		}
+		stats.Hits += counters.hits.Load()
+		stats.Misses += counters.misses.Load()
+		stats.Errors += counters.errors.Load()
+	}
+
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+
+	return stats
+}
+
+// meter returns the counters of tenantID, creating them on first use
+func (m *Metered) meter(tenantID int) *meter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counters, ok := m.meters[tenantID]
+	if !ok {
+		counters = &meter{}
+		m.meters[tenantID] = counters
+	}
+	return counters
+}
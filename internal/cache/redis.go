@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/mlvieira/nsfwdetection/internal/driver/redis"
+)
+
+// Redis is a Cache shared by every server instance
+type Redis struct {
+	client *redis.RedisClient
+}
+
+// NewRedis creates a cache on top of client
+func NewRedis(client *redis.RedisClient) *Redis {
+	return &Redis{client: client}
+}
+
+func (r *Redis) Get(ctx context.Context, key string) (string, error) {
+	value, err := r.client.GetValue(ctx, key)
+	if err == goredis.Nil {
+		return "", ErrMiss
+	}
+	return value, err
+}
+
+func (r *Redis) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return r.client.SetValue(ctx, key, value, ttl)
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.DeleteKey(ctx, key)
+}
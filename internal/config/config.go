@@ -1,9 +1,17 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"net/netip"
 	"os"
+	"slices"
 
+	"github.com/mlvieira/nsfwdetection/internal/models"
 	"github.com/pelletier/go-toml/v2"
 )
 
@@ -11,10 +19,16 @@ import (
 type Config struct {
 	Server       ServerConfig       `toml:"server"`
 	Redis        RedisConfig        `toml:"redis"`
+	Cache        CacheConfig        `toml:"cache"`
 	DB           DBConfig           `toml:"database"`
 	FileHandling FileHandlingConfig `toml:"file_handling"`
+	Storage      StorageConfig      `toml:"storage"`
 	Model        ModelConfig        `toml:"model"`
 	Security     SecurityConfig     `toml:"security"`
+	OIDC         OIDCConfig         `toml:"oidc"`
+	APIKeys      APIKeysConfig      `toml:"api_keys"`
+	Worker       WorkerConfig       `toml:"worker"`
+	Retention    RetentionConfig    `toml:"retention"`
 }
 
 // AppConfig stores the loaded configuration
@@ -31,14 +45,375 @@ func LoadConfig(configPath string) {
 		log.Fatalf("Failed to parse config file: %v", err)
 	}
 
+	finalize()
+}
+
+// LoadDevConfig reads the TOML configuration file if there is one and switches to the
+// self-contained dev setup: an SQLite database under ./data that is migrated on start,
+// an in-process cache and in-process inference. Settings not needed by dev mode keep their configured values.
+func LoadDevConfig(configPath string) {
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to load config file: %v", err)
+	}
+
+	if err == nil {
+		if err = toml.Unmarshal(data, &AppConfig); err != nil {
+			log.Fatalf("Failed to parse config file: %v", err)
+		}
+	}
+
+	applyDevDefaults(&AppConfig)
+
+	finalize()
+}
+
+// finalize applies defaults, converts units and creates the upload directories
+func finalize() {
 	AppConfig.FileHandling.MaxFileSizeMB = AppConfig.FileHandling.MaxFileSizeMB << 20
 
-	if err = os.MkdirAll(AppConfig.FileHandling.TempUploadDir, os.ModePerm); err != nil {
+	applyServerDefaults(&AppConfig.Server)
+
+	applyArchiveDefaults(&AppConfig.FileHandling)
+
+	applyTrashDefaults(&AppConfig.FileHandling)
+
+	applyPreviewDefaults(&AppConfig.FileHandling)
+
+	if AppConfig.DB.Driver == "" {
+		AppConfig.DB.Driver = "mysql"
+	}
+
+	applyWorkerDefaults(&AppConfig.Worker)
+
+	applyCacheDefaults(&AppConfig.Cache)
+
+	applyRetentionDefaults(&AppConfig.Retention)
+
+	applyStorageDefaults(&AppConfig.Storage)
+
+	applySecurityDefaults(&AppConfig.Security)
+
+	applyOIDCDefaults(&AppConfig.OIDC, AppConfig.Server)
+
+	applyAPIKeyDefaults(&AppConfig.APIKeys)
+
+	if err := os.MkdirAll(AppConfig.FileHandling.TempUploadDir, os.ModePerm); err != nil {
 		log.Fatalf("Failed to create temp upload directory: %v", err)
 	}
 
-	if err = os.MkdirAll(AppConfig.FileHandling.UploadDir, os.ModePerm); err != nil {
+	if err := os.MkdirAll(AppConfig.FileHandling.UploadDir, os.ModePerm); err != nil {
 		log.Fatalf("Failed to create upload directory: %v", err)
+	}
+
+	if err := os.MkdirAll(AppConfig.FileHandling.TrashDir, os.ModePerm); err != nil {
+		log.Fatalf("Failed to create trash directory: %v", err)
+	}
+
+	if err := os.MkdirAll(AppConfig.FileHandling.PreviewDir, os.ModePerm); err != nil {
+		log.Fatalf("Failed to create preview directory: %v", err)
+	}
+}
+
+// applyDevDefaults points storage at ./data and fills in what an empty config lacks
+func applyDevDefaults(c *Config) {
+	c.DB.Driver = "sqlite"
+	c.DB.Path = "./data/dev.db"
+	c.DB.AutoMigrate = true
+	c.Worker.Mode = "local"
+	c.Cache.Backend = "memory"
+
+	if c.Server.Port == 0 {
+		c.Server.Port = 3001
+	}
+	if c.Server.DomainName == "" {
+		c.Server.DomainName = "http://localhost:5173"
+	}
+	if c.FileHandling.UploadDir == "" {
+		c.FileHandling.UploadDir = "./data/uploads"
+	}
+	if c.FileHandling.TempUploadDir == "" {
+		c.FileHandling.TempUploadDir = "./data/temp_uploads"
+	}
+	if c.FileHandling.TrashDir == "" {
+		c.FileHandling.TrashDir = "./data/trash"
+	}
+	if c.FileHandling.PreviewDir == "" {
+		c.FileHandling.PreviewDir = "./data/previews"
+	}
+	if c.FileHandling.MaxFileSizeMB == 0 {
+		c.FileHandling.MaxFileSizeMB = 50
+	}
+	if c.Security.JWTSecretKey == "" {
+		c.Security.JWTSecretKey = "dev-secret-key"
+	}
+}
+
+// applyServerDefaults parses the trusted proxies and sizes the per address token bucket,
+// which holds a second worth of requests unless burst says otherwise
+func applyServerDefaults(s *ServerConfig) {
+	if s.ReqPerSec < 0 {
+		log.Fatalf("server.req_per_sec must not be negative, use 0 to disable the limit")
+	}
+	if s.Burst <= 0 {
+		s.Burst = int(math.Max(1, math.Ceil(s.ReqPerSec)))
+	}
+
+	s.TrustedProxyNets = nil
+	for _, proxy := range s.TrustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				log.Fatalf("Invalid address or CIDR range in server.trusted_proxies: %s", proxy)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		s.TrustedProxyNets = append(s.TrustedProxyNets, prefix.Masked())
+	}
+}
+
+// applyArchiveDefaults fills in archive upload limits and converts them to bytes
+func applyArchiveDefaults(f *FileHandlingConfig) {
+	if f.MaxArchiveSizeMB <= 0 {
+		f.MaxArchiveSizeMB = 1024
+	}
+	if f.MaxArchiveEntries <= 0 {
+		f.MaxArchiveEntries = 10000
+	}
+	if f.MaxArchiveExtractedMB <= 0 {
+		f.MaxArchiveExtractedMB = 4096
+	}
+	if f.MaxCompressionRatio <= 0 {
+		f.MaxCompressionRatio = 100
+	}
+
+	f.MaxArchiveSizeMB = f.MaxArchiveSizeMB << 20
+	f.MaxArchiveExtractedMB = f.MaxArchiveExtractedMB << 20
+}
+
+// applyPreviewDefaults fills in where thumbnails and blurred previews are kept
+func applyPreviewDefaults(f *FileHandlingConfig) {
+	if f.PreviewDir == "" {
+		f.PreviewDir = "./previews"
+	}
+}
+
+// applyTrashDefaults fills in trash settings left out of older config files
+func applyTrashDefaults(f *FileHandlingConfig) {
+	if f.TrashDir == "" {
+		f.TrashDir = "./trash"
+	}
+	if f.TrashRetentionDays <= 0 {
+		f.TrashRetentionDays = 30
+	}
+	if f.TrashPurgeIntervalMin <= 0 {
+		f.TrashPurgeIntervalMin = 60
+	}
+	if f.OrphanGraceMin <= 0 {
+		f.OrphanGraceMin = 60
+	}
+}
+
+// applyWorkerDefaults fills in worker settings left out of older config files
+func applyWorkerDefaults(w *WorkerConfig) {
+	if w.Mode == "" {
+		w.Mode = "local"
+	}
+	if w.QueueStream == "" {
+		w.QueueStream = "nsfw:jobs"
+	}
+	if w.ConsumerGroup == "" {
+		w.ConsumerGroup = "nsfw-workers"
+	}
+	if w.VisibilityTimeoutSec <= 0 {
+		w.VisibilityTimeoutSec = 10
+	}
+	if w.MaxDeliveries <= 0 {
+		w.MaxDeliveries = 3
+	}
+	if w.ResultTimeoutSec <= 0 {
+		w.ResultTimeoutSec = 30
+	}
+	if err := checkWorkerTimeouts(*w); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// checkWorkerTimeouts checks the timeouts of the Redis stream. A job left by a crashed
+// worker is reclaimed within twice the visibility timeout, the API must still be waiting
+// by then or the prediction of the second worker is thrown away. Local mode has no stream.
+func checkWorkerTimeouts(w WorkerConfig) error {
+	if w.Mode == "redis" && 2*w.VisibilityTimeoutSec >= w.ResultTimeoutSec {
+		return errors.New("worker.result_timeout_sec must be more than twice worker.visibility_timeout_sec")
+	}
+	return nil
+}
+
+// applyCacheDefaults fills in cache settings left out of older config files
+func applyCacheDefaults(c *CacheConfig) {
+	if c.Backend == "" {
+		c.Backend = "redis"
+	}
+	if c.PredictionTTLSec <= 0 {
+		c.PredictionTTLSec = 3600
+	}
+	if c.MemorySize <= 0 {
+		c.MemorySize = 10000
+	}
+	if c.MemoryTTLSec <= 0 {
+		c.MemoryTTLSec = 60
+	}
+}
+
+// applySecurityDefaults falls back to the JWT secret for signing image URLs, or to a random
+// key when neither is set, in which case URLs stop working when the server restarts
+func applySecurityDefaults(s *SecurityConfig) {
+	if s.AccessTokenTTLMin <= 0 {
+		s.AccessTokenTTLMin = 15
+	}
+	if s.RefreshTokenTTLHours <= 0 {
+		s.RefreshTokenTTLHours = 168
+	}
+	if s.LoginMaxAttempts <= 0 {
+		s.LoginMaxAttempts = 5
+	}
+	if s.LoginIPMaxAttempts <= 0 {
+		s.LoginIPMaxAttempts = 20
+	}
+	if s.LoginWindowMin <= 0 {
+		s.LoginWindowMin = 15
+	}
+	if s.LoginLockoutMin <= 0 {
+		s.LoginLockoutMin = 15
+	}
+	if s.SignedURLTTLSec <= 0 {
+		s.SignedURLTTLSec = 900
+	}
+	if s.MFAIssuer == "" {
+		s.MFAIssuer = "NSFW Detector"
+	}
+	for _, role := range s.MFARequiredRoles {
+		if !models.ValidRole(role) {
+			log.Fatalf("Unknown role in security.mfa_required_roles: %s", role)
+		}
+	}
+
+	// jwt_secret_key is a single key ring for configs written before key rotation
+	if len(s.JWTKeys) == 0 {
+		if s.JWTSecretKey == "" {
+			s.JWTSecretKey = randomKey()
+			log.Println("No jwt_keys or jwt_secret_key set, tokens are signed with a random key and do not survive a restart")
+		}
+		s.JWTKeys = []JWTKey{{ID: "default", Secret: s.JWTSecretKey}}
+	}
+
+	seen := make(map[string]bool, len(s.JWTKeys))
+	for _, key := range s.JWTKeys {
+		if key.ID == "" || key.Secret == "" {
+			log.Fatalf("Every entry of security.jwt_keys needs an id and a secret")
+		}
+		if seen[key.ID] {
+			log.Fatalf("Duplicate security.jwt_keys id: %s", key.ID)
+		}
+		seen[key.ID] = true
+	}
+
+	if s.URLSigningKey == "" {
+		s.URLSigningKey = s.JWTKeys[0].Secret
+	}
+}
+
+func applyOIDCDefaults(o *OIDCConfig, server ServerConfig) {
+	if !o.Enabled {
 		return
 	}
+
+	if o.IssuerURL == "" || o.ClientID == "" || o.RedirectURL == "" {
+		log.Fatalf("oidc.issuer_url, oidc.client_id and oidc.redirect_url are required when OIDC is enabled")
+	}
+	if o.Name == "" {
+		o.Name = "SSO"
+	}
+	if o.FrontendURL == "" {
+		o.FrontendURL = server.DomainName
+	}
+	if len(o.Scopes) == 0 {
+		o.Scopes = []string{"openid", "profile", "email"}
+	}
+	if !slices.Contains(o.Scopes, "openid") {
+		o.Scopes = append([]string{"openid"}, o.Scopes...)
+	}
+	if o.UsernameClaim == "" {
+		o.UsernameClaim = "preferred_username"
+	}
+	if o.GroupsClaim == "" {
+		o.GroupsClaim = "groups"
+	}
+
+	for group, role := range o.RoleMapping {
+		if !models.ValidRole(role) {
+			log.Fatalf("Unknown role for group %s in oidc.role_mapping: %s", group, role)
+		}
+	}
+	if o.DefaultRole != "" && !models.ValidRole(o.DefaultRole) {
+		log.Fatalf("Unknown oidc.default_role: %s", o.DefaultRole)
+	}
+}
+
+// applyAPIKeyDefaults rejects negative limits, 0 already means unlimited
+func applyAPIKeyDefaults(a *APIKeysConfig) {
+	if a.DefaultRateLimitPerMin < 0 || a.DefaultDailyQuota < 0 || a.DefaultMonthlyQuota < 0 {
+		log.Fatalf("api_keys limits must not be negative, use 0 for unlimited")
+	}
+}
+
+// randomKey returns 32 random bytes, hex encoded
+func randomKey() string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+	return hex.EncodeToString(key)
+}
+
+func applyStorageDefaults(s *StorageConfig) {
+	if s.Backend == "" {
+		s.Backend = "local"
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.UploadsPrefix == "" {
+		s.UploadsPrefix = "uploads/"
+	}
+	if s.TrashPrefix == "" {
+		s.TrashPrefix = "trash/"
+	}
+	if s.PreviewsPrefix == "" {
+		s.PreviewsPrefix = "previews/"
+	}
+}
+
+// applyRetentionDefaults fills in retention settings and rejects rules that would match nothing or everything
+func applyRetentionDefaults(r *RetentionConfig) {
+	if r.IntervalMin <= 0 {
+		r.IntervalMin = 60
+	}
+	if r.BatchSize <= 0 {
+		r.BatchSize = 500
+	}
+
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.MaxAgeDays <= 0 {
+			log.Fatalf("Retention rule %s: max_age_days must be positive", rule.Name)
+		}
+		if rule.Label != "" && rule.Label != "NSFW" && rule.Label != "SFW" {
+			log.Fatalf("Retention rule %s: label must be NSFW, SFW or empty", rule.Name)
+		}
+	}
 }
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWorkerTimeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		worker  WorkerConfig
+		wantErr bool
+	}{
+		{"redis", WorkerConfig{Mode: "redis", VisibilityTimeoutSec: 10, ResultTimeoutSec: 30}, false},
+		{"redis reclaiming after the caller gave up", WorkerConfig{Mode: "redis", VisibilityTimeoutSec: 15, ResultTimeoutSec: 30}, true},
+		{"local", WorkerConfig{Mode: "local", VisibilityTimeoutSec: 10, ResultTimeoutSec: 30}, false},
+		// local mode has no stream to reclaim jobs from, its visibility timeout is unused
+		{"local with a long visibility timeout", WorkerConfig{Mode: "local", VisibilityTimeoutSec: 60, ResultTimeoutSec: 30}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkWorkerTimeouts(tt.worker); (err != nil) != tt.wantErr {
+				t.Fatalf("checkWorkerTimeouts = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestWorkerDefaultsLocal checks a local worker config with timeouts only the stream
+// would reject loads, applyWorkerDefaults exits the test binary otherwise
+func TestWorkerDefaultsLocal(t *testing.T) {
+	w := WorkerConfig{VisibilityTimeoutSec: 60}
+	applyWorkerDefaults(&w)
+
+	if w.Mode != "local" || w.ResultTimeoutSec != 30 {
+		t.Fatalf("applyWorkerDefaults = %+v", w)
+	}
+}
+
+// inTempDir runs the rest of the test from a temporary directory and restores the
+// configuration, dev mode creates its data under the working directory
+func inTempDir(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	previous := AppConfig
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		AppConfig = previous
+	})
+}
+
+func TestLoadDevConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"no config file", ""},
+		// dev mode ignores the database, cache and worker of a production config
+		{"production config", `
+[database]
+driver = "mysql"
+host = "db.example.com"
+
+[redis]
+addr = "redis.example.com:6379"
+
+[cache]
+backend = "redis"
+
+[worker]
+mode = "redis"
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+			AppConfig = Config{}
+
+			if tt.config != "" {
+				if err := os.WriteFile("config.toml", []byte(tt.config), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			LoadDevConfig("config.toml")
+
+			if AppConfig.DB.Driver != "sqlite" || AppConfig.DB.Path != "./data/dev.db" || !AppConfig.DB.AutoMigrate {
+				t.Fatalf("database = %+v, want a migrated SQLite file under ./data", AppConfig.DB)
+			}
+			if AppConfig.Cache.Backend != "memory" || AppConfig.Worker.Mode != "local" {
+				t.Fatalf("cache %q, worker %q, want both in process", AppConfig.Cache.Backend, AppConfig.Worker.Mode)
+			}
+			for _, dir := range []string{"data/uploads", "data/temp_uploads", "data/trash", "data/previews"} {
+				if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+					t.Fatalf("directory %s not created: %v", dir, err)
+				}
+			}
+		})
+	}
+}
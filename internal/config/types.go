@@ -1,17 +1,34 @@
 package config
 
+import "net/netip"
+
 type DBConfig struct {
-	Username string `toml:"username"`
-	Password string `toml:"password"`
-	Host     string `toml:"host"`
-	Database string `toml:"database"`
-	Port     int    `toml:"port"`
+	Driver      string `toml:"driver"`
+	Path        string `toml:"path"`
+	SSLMode     string `toml:"sslmode"`
+	Username    string `toml:"username"`
+	Password    string `toml:"password"`
+	Host        string `toml:"host"`
+	Database    string `toml:"database"`
+	Port        int    `toml:"port"`
+	AutoMigrate bool   `toml:"auto_migrate"`
 }
 
 type FileHandlingConfig struct {
-	UploadDir     string `toml:"upload_dir"`
-	TempUploadDir string `toml:"temp_upload_dir"`
-	MaxFileSizeMB int64  `toml:"max_file_size_mb"`
+	UploadDir             string  `toml:"upload_dir"`
+	TempUploadDir         string  `toml:"temp_upload_dir"`
+	TrashDir              string  `toml:"trash_dir"`
+	PreviewDir            string  `toml:"preview_dir"`
+	TrashRetentionDays    int     `toml:"trash_retention_days"`
+	TrashPurgeIntervalMin int     `toml:"trash_purge_interval_min"`
+	ReconcileIntervalMin  int     `toml:"reconcile_interval_min"`
+	ReconcileRepair       bool    `toml:"reconcile_repair"`
+	OrphanGraceMin        int     `toml:"orphan_grace_min"`
+	MaxFileSizeMB         int64   `toml:"max_file_size_mb"`
+	MaxArchiveSizeMB      int64   `toml:"max_archive_size_mb"`
+	MaxArchiveEntries     int     `toml:"max_archive_entries"`
+	MaxArchiveExtractedMB int64   `toml:"max_archive_extracted_mb"`
+	MaxCompressionRatio   float64 `toml:"max_compression_ratio"`
 }
 
 type ModelConfig struct {
@@ -19,14 +36,62 @@ type ModelConfig struct {
 }
 
 type SecurityConfig struct {
-	JWTSecretKey string `toml:"jwt_secret_key"`
+	JWTSecretKey         string   `toml:"jwt_secret_key"`
+	JWTKeys              []JWTKey `toml:"jwt_keys"`
+	AccessTokenTTLMin    int      `toml:"access_token_ttl_min"`
+	RefreshTokenTTLHours int      `toml:"refresh_token_ttl_hours"`
+	LoginMaxAttempts     int      `toml:"login_max_attempts"`
+	LoginIPMaxAttempts   int      `toml:"login_ip_max_attempts"`
+	LoginWindowMin       int      `toml:"login_window_min"`
+	LoginLockoutMin      int      `toml:"login_lockout_min"`
+	MFARequiredRoles     []string `toml:"mfa_required_roles"`
+	MFAIssuer            string   `toml:"mfa_issuer"`
+	URLSigningKey        string   `toml:"url_signing_key"`
+	SignedURLTTLSec      int      `toml:"signed_url_ttl_sec"`
 }
 
+// OIDCConfig enables single sign-on through an OpenID Connect identity provider. Users
+// are provisioned on their first login, with the highest role any of their groups maps to.
+type OIDCConfig struct {
+	Enabled       bool              `toml:"enabled"`
+	Name          string            `toml:"name"`
+	IssuerURL     string            `toml:"issuer_url"`
+	ClientID      string            `toml:"client_id"`
+	ClientSecret  string            `toml:"client_secret"`
+	RedirectURL   string            `toml:"redirect_url"`
+	FrontendURL   string            `toml:"frontend_url"`
+	Scopes        []string          `toml:"scopes"`
+	UsernameClaim string            `toml:"username_claim"`
+	GroupsClaim   string            `toml:"groups_claim"`
+	RoleMapping   map[string]string `toml:"role_mapping"`
+	DefaultRole   string            `toml:"default_role"`
+}
+
+// APIKeysConfig controls access to the detection API. Limits are per key, 0 means
+// unlimited, and the defaults apply to keys created without limits of their own.
+type APIKeysConfig struct {
+	AllowAnonymous         bool `toml:"allow_anonymous"`
+	DefaultRateLimitPerMin int  `toml:"default_rate_limit_per_min"`
+	DefaultDailyQuota      int  `toml:"default_daily_quota"`
+	DefaultMonthlyQuota    int  `toml:"default_monthly_quota"`
+}
+
+// JWTKey is a key that signs or verifies access tokens, identified by the kid header
+type JWTKey struct {
+	ID     string `toml:"id"`
+	Secret string `toml:"secret"`
+}
+
+// ServerConfig holds the listener settings. ReqPerSec and Burst size the token bucket
+// of each client address, for requests made without an API key.
 type ServerConfig struct {
-	Port       int     `toml:"port"`
-	ReqPerSec  float64 `toml:"req_per_sec"`
-	Burst      int     `toml:"burst"`
-	DomainName string  `toml:"domain_name"`
+	Port           int      `toml:"port"`
+	ReqPerSec      float64  `toml:"req_per_sec"`
+	Burst          int      `toml:"burst"`
+	DomainName     string   `toml:"domain_name"`
+	TrustedProxies []string `toml:"trusted_proxies"`
+	// TrustedProxyNets is TrustedProxies parsed, addresses become single address prefixes
+	TrustedProxyNets []netip.Prefix `toml:"-"`
 }
 
 type RedisConfig struct {
@@ -34,3 +99,51 @@ type RedisConfig struct {
 	Password string `toml:"password"`
 	DB       int    `toml:"db"`
 }
+
+type WorkerConfig struct {
+	Mode                 string `toml:"mode"`
+	QueueStream          string `toml:"queue_stream"`
+	ConsumerGroup        string `toml:"consumer_group"`
+	Concurrency          int    `toml:"concurrency"`
+	VisibilityTimeoutSec int    `toml:"visibility_timeout_sec"`
+	MaxDeliveries        int64  `toml:"max_deliveries"`
+	ResultTimeoutSec     int    `toml:"result_timeout_sec"`
+}
+
+type CacheConfig struct {
+	Backend          string `toml:"backend"`
+	PredictionTTLSec int    `toml:"prediction_ttl_sec"`
+	MemorySize       int    `toml:"memory_size"`
+	MemoryTTLSec     int    `toml:"memory_ttl_sec"`
+}
+
+// StorageConfig selects where uploaded images are kept. The local backend uses
+// the upload and trash directories of FileHandlingConfig.
+type StorageConfig struct {
+	Backend        string `toml:"backend"`
+	Endpoint       string `toml:"endpoint"`
+	Region         string `toml:"region"`
+	Bucket         string `toml:"bucket"`
+	AccessKey      string `toml:"access_key"`
+	SecretKey      string `toml:"secret_key"`
+	PathStyle      bool   `toml:"path_style"`
+	UploadsPrefix  string `toml:"uploads_prefix"`
+	TrashPrefix    string `toml:"trash_prefix"`
+	PreviewsPrefix string `toml:"previews_prefix"`
+}
+
+type RetentionConfig struct {
+	Enabled     bool            `toml:"enabled"`
+	IntervalMin int             `toml:"interval_min"`
+	BatchSize   int             `toml:"batch_size"`
+	Rules       []RetentionRule `toml:"rules"`
+}
+
+// RetentionRule matches images by their current label, the moderator's once reviewed,
+// and review state. Matching images older than MaxAgeDays are deleted.
+type RetentionRule struct {
+	Name       string `toml:"name" json:"name"`
+	Label      string `toml:"label" json:"label,omitempty"`
+	Reviewed   *bool  `toml:"reviewed" json:"reviewed,omitempty"`
+	MaxAgeDays int    `toml:"max_age_days" json:"max_age_days"`
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/driver/mysql"
+	"github.com/mlvieira/nsfwdetection/internal/driver/postgres"
+	"github.com/mlvieira/nsfwdetection/internal/driver/sqlite"
+)
+
+// Supported values for the driver setting of the [database] config
+const (
+	MySQL    = "mysql"
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// OpenDB opens a connection using the driver selected in the config
+func OpenDB() (*sql.DB, error) {
+	switch config.AppConfig.DB.Driver {
+	case MySQL:
+		return mysql.OpenDB()
+	case SQLite:
+		return sqlite.OpenDB()
+	case Postgres:
+		return postgres.OpenDB()
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", config.AppConfig.DB.Driver)
+	}
+}
+
+// Rebind rewrites the ? placeholders of query into the style expected by driver
+func Rebind(driver, query string) string {
+	if driver != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// IsDuplicate reports whether err is a unique constraint violation for driver
+func IsDuplicate(driver string, err error) bool {
+	switch driver {
+	case MySQL:
+		return mysql.IsDuplicate(err)
+	case SQLite:
+		return sqlite.IsDuplicate(err)
+	case Postgres:
+		return postgres.IsDuplicate(err)
+	default:
+		return false
+	}
+}
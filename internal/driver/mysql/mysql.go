@@ -2,10 +2,11 @@ package mysql
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/mlvieira/nsfwdetection/internal/config"
 )
 
@@ -40,3 +41,9 @@ func OpenDB() (*sql.DB, error) {
 
 	return db, nil
 }
+
+// IsDuplicate reports whether err is a duplicate key error
+func IsDuplicate(err error) bool {
+	var sqlErr *mysql.MySQLError
+	return errors.As(err, &sqlErr) && sqlErr.Number == 1062
+}
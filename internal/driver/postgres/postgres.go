@@ -0,0 +1,54 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+)
+
+const (
+	maxOpenDBConn = 25
+	maxIdleDBConn = 25
+	maxDBLifetime = 5 * time.Minute
+)
+
+// OpenDB opens a new database connection and pings it.
+func OpenDB() (*sql.DB, error) {
+	sslMode := config.AppConfig.DB.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		config.AppConfig.DB.Host,
+		config.AppConfig.DB.Port,
+		config.AppConfig.DB.Username,
+		config.AppConfig.DB.Password,
+		config.AppConfig.DB.Database,
+		sslMode)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(maxOpenDBConn)
+	db.SetMaxIdleConns(maxIdleDBConn)
+	db.SetConnMaxLifetime(maxDBLifetime)
+
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// IsDuplicate reports whether err is a unique constraint violation
+func IsDuplicate(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -37,3 +37,23 @@ func (rs *RedisClient) GetValue(ctx context.Context, key string) (string, error)
 func (rs *RedisClient) DeleteKey(ctx context.Context, key string) error {
 	return rs.client.Del(ctx, key).Err()
 }
+
+// Client exposes the underlying go-redis client for features beyond plain key/value access
+func (rs *RedisClient) Client() *redis.Client {
+	return rs.client
+}
+
+// DeleteKeys deletes every key matching pattern and returns how many were removed
+func (rs *RedisClient) DeleteKeys(ctx context.Context, pattern string) (int, error) {
+	deleted := 0
+
+	iter := rs.client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		if err := rs.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, iter.Err()
+}
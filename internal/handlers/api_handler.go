@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/mlvieira/nsfwdetection/internal/logger"
@@ -23,35 +25,38 @@ func NewAPIHandlers(h *Handlers, api *services.APIService) *APIHandlers {
 	}
 }
 
-func (a *APIHandlers) Login(w http.ResponseWriter, r *http.Request) {
-	var req models.LoginRequest
-
+func (a *APIHandlers) PaginationUploads(w http.ResponseWriter, r *http.Request) {
+	var req models.PaginatedRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("Failed to decode request: %v", err)
 		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	tokenString, err := a.Services.Login(r.Context(), req.Username, req.Password)
+	response, err := a.Services.PaginationUploads(r.Context(), req.ID, req.Limit, req.Reviewed)
 	if err != nil {
-		utils.WriteJSONError(w, http.StatusUnauthorized, err.Error())
+		logger.Error("Error fetching uploads: %v", err)
+		utils.WriteJSONError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	utils.WriteJSONResponse(w, http.StatusOK, models.LoginResponse{Token: tokenString})
-
+	utils.WriteJSONResponse(w, http.StatusOK, response)
 }
 
-func (a *APIHandlers) PaginationUploads(w http.ResponseWriter, r *http.Request) {
-	var req models.PaginatedRequest
+func (a *APIHandlers) LabelImage(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+
+	var req models.LabelRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	response, err := a.Services.PaginationUploads(r.Context(), req.ID, req.Limit, req.Reviewed)
+	response, err := a.Services.LabelImage(r.Context(), hash, req, a.Hub)
+	if errors.Is(err, services.ErrRelabelForbidden) {
+		utils.WriteJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
 	if err != nil {
-		logger.Error("Error fetching uploads: %v", err)
 		utils.WriteJSONError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -59,7 +64,7 @@ func (a *APIHandlers) PaginationUploads(w http.ResponseWriter, r *http.Request)
 	utils.WriteJSONResponse(w, http.StatusOK, response)
 }
 
-func (a *APIHandlers) LabelImage(w http.ResponseWriter, r *http.Request) {
+func (a *APIHandlers) DeleteImage(w http.ResponseWriter, r *http.Request) {
 	hash := chi.URLParam(r, "hash")
 
 	var req models.LabelRequest
@@ -68,7 +73,7 @@ func (a *APIHandlers) LabelImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := a.Services.LabelImage(r.Context(), hash, req, a.Hub)
+	response, err := a.Services.DeleteImage(r.Context(), hash, req, a.Hub)
 	if err != nil {
 		utils.WriteJSONError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -77,7 +82,7 @@ func (a *APIHandlers) LabelImage(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSONResponse(w, http.StatusOK, response)
 }
 
-func (a *APIHandlers) DeleteImage(w http.ResponseWriter, r *http.Request) {
+func (a *APIHandlers) RestoreImage(w http.ResponseWriter, r *http.Request) {
 	hash := chi.URLParam(r, "hash")
 
 	var req models.LabelRequest
@@ -86,7 +91,41 @@ func (a *APIHandlers) DeleteImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := a.Services.DeleteImage(r.Context(), hash, req, a.Hub)
+	response, err := a.Services.RestoreImage(r.Context(), hash, req)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+func (a *APIHandlers) Trash(w http.ResponseWriter, r *http.Request) {
+	cursor, err := queryInt(r, "cursor")
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	response, err := a.Services.ListTrash(r.Context(), cursor, limit)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+func (a *APIHandlers) ImageHistory(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+
+	response, err := a.Services.ImageHistory(r.Context(), hash)
 	if err != nil {
 		utils.WriteJSONError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -95,6 +134,47 @@ func (a *APIHandlers) DeleteImage(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSONResponse(w, http.StatusOK, response)
 }
 
+func (a *APIHandlers) AuditFeed(w http.ResponseWriter, r *http.Request) {
+	cursor, err := queryInt(r, "cursor")
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	response, err := a.Services.AuditFeed(r.Context(), cursor, limit)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+func (a *APIHandlers) RetentionReport(w http.ResponseWriter, r *http.Request) {
+	response, err := a.Services.RetentionReport(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+// queryInt parses an optional integer query parameter, 0 when absent
+func queryInt(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
 func (a *APIHandlers) Stats(w http.ResponseWriter, r *http.Request) {
 	response, err := a.Services.FetchStats(r.Context())
 	if err != nil {
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+)
+
+type APIKeyHandlers struct {
+	*Handlers
+	Services *services.APIKeyService
+}
+
+func NewAPIKeyHandlers(h *Handlers, apiKeys *services.APIKeyService) *APIKeyHandlers {
+	return &APIKeyHandlers{
+		Handlers: h,
+		Services: apiKeys,
+	}
+}
+
+func (a *APIKeyHandlers) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.Services.List(r.Context())
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, keys)
+}
+
+// CreateKey issues a key, the response is the only time it is shown
+func (a *APIKeyHandlers) CreateKey(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	key, err := a.Services.Create(r.Context(), req)
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, key)
+}
+
+func (a *APIKeyHandlers) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid API key id")
+		return
+	}
+
+	key, err := a.Services.Revoke(r.Context(), id)
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, key)
+}
+
+func writeAPIKeyError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrInvalidAPIKey):
+		status = http.StatusBadRequest
+	case errors.Is(err, services.ErrAPIKeyNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrAPIKeyRevoked):
+		status = http.StatusConflict
+	}
+	utils.WriteJSONError(w, status, err.Error())
+}
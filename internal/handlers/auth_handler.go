@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+)
+
+type AuthHandlers struct {
+	*Handlers
+	Services *services.AuthService
+}
+
+func NewAuthHandlers(h *Handlers, sessions *services.AuthService) *AuthHandlers {
+	return &AuthHandlers{
+		Handlers: h,
+		Services: sessions,
+	}
+}
+
+func (a *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
+	var req models.LoginRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Error("Failed to decode request: %v", err)
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	response, err := a.Services.Login(r.Context(), req.Username, req.Password, utils.GetClientIP(r))
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+// LoginMFA is the second login step for users with 2FA enabled
+func (a *AuthHandlers) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req models.MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	response, err := a.Services.CompleteMFA(r.Context(), req.MFAToken, req.Code, utils.GetClientIP(r))
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+// BeginMFAEnrollment hands out a TOTP secret to a login that must enroll first
+func (a *AuthHandlers) BeginMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	var req models.MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	enrollment, err := a.Services.BeginMFAEnrollment(r.Context(), req.MFAToken)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, enrollment)
+}
+
+// CompleteMFAEnrollment confirms a forced enrollment and finishes its login
+func (a *AuthHandlers) CompleteMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	var req models.MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	response, err := a.Services.CompleteMFAEnrollment(r.Context(), req.MFAToken, req.Code, utils.GetClientIP(r))
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+// LoginSSO exchanges the token of a single sign-on callback for a session
+func (a *AuthHandlers) LoginSSO(w http.ResponseWriter, r *http.Request) {
+	var req models.SSORequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	response, err := a.Services.CompleteSSO(r.Context(), req.Token, utils.GetClientIP(r))
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+func (a *AuthHandlers) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	response, err := a.Services.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+// Logout revokes the caller's access token and the session of the refresh token in the
+// body, if any. The body is optional.
+func (a *AuthHandlers) Logout(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+	}
+
+	if err := a.Services.Logout(r.Context(), middleware.Claims(r.Context()), req.RefreshToken); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"event": "logout", "status": "success"})
+}
+
+// writeAuthError maps auth service errors to status codes
+func writeAuthError(w http.ResponseWriter, err error) {
+	var throttled *auth.ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		utils.WriteJSONError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, services.ErrInvalidCredentials), errors.Is(err, services.ErrInvalidRefreshToken),
+		errors.Is(err, services.ErrInvalidMFAToken), errors.Is(err, services.ErrInvalidMFACode):
+		utils.WriteJSONError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, services.ErrMFAEnabled), errors.Is(err, services.ErrMFANotEnabled):
+		utils.WriteJSONError(w, http.StatusConflict, err.Error())
+	default:
+		logger.Error("Authentication failed: %v", err)
+		utils.WriteJSONError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+	"github.com/mlvieira/nsfwdetection/internal/urlsign"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+)
+
+// ServeFile streams the object of backend named by the wildcard of the route, honoring Range
+// requests. The URL must carry a valid signature from the admin API instead of a token, so
+// it works in <img> tags and can be cached by the browser until it expires.
+func ServeFile(backend storage.Backend, signer *urlsign.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := chi.URLParam(r, "*")
+
+		remaining, err := signer.Verify(r.URL.Path, r.URL.Query())
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusForbidden, "Invalid or expired link")
+			return
+		}
+
+		obj, err := backend.Open(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+				utils.WriteJSONError(w, http.StatusNotFound, "File not found")
+				return
+			}
+			logger.Error("Failed to open %s: %v", key, err)
+			utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to read file")
+			return
+		}
+		defer obj.Close()
+
+		w.Header().Set("Content-Type", storage.ContentType(key))
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(remaining.Seconds())))
+		http.ServeContent(w, r, key, obj.Info().ModTime, obj)
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+	"github.com/mlvieira/nsfwdetection/internal/urlsign"
+)
+
+func TestServeFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "tenants", "2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tenants", "2", "abc.jpg"), []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	signer := urlsign.New("secret", time.Minute)
+	mux := chi.NewRouter()
+	mux.Get("/admin/files/*", ServeFile(storage.NewLocal(dir), signer))
+
+	const file = "/admin/files/tenants/2/abc.jpg"
+
+	tests := []struct {
+		name string
+		link string
+		want int
+	}{
+		{"valid", signer.Sign(file), http.StatusOK},
+		{"unsigned", file, http.StatusForbidden},
+		{"expired", urlsign.New("secret", -time.Second).Sign(file), http.StatusForbidden},
+		{"signed with another key", urlsign.New("other secret", time.Minute).Sign(file), http.StatusForbidden},
+		{"signature of another file", strings.Replace(signer.Sign("/admin/files/tenants/2/xyz.jpg"), "xyz", "abc", 1), http.StatusForbidden},
+		{"signature of another tenant", strings.Replace(signer.Sign("/admin/files/tenants/3/abc.jpg"), "/3/", "/2/", 1), http.StatusForbidden},
+		{"tampered signature", signer.Sign(file) + "00", http.StatusForbidden},
+		{"missing file", signer.Sign("/admin/files/tenants/2/missing.jpg"), http.StatusNotFound},
+		{"escaping key", signer.Sign("/admin/files/../secret.jpg"), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.link, nil))
+
+			if rec.Code != tt.want {
+				t.Fatalf("GET %s = %d, want %d: %s", tt.link, rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK && rec.Body.String() != "image" {
+				t.Fatalf("GET %s returned %q", tt.link, rec.Body)
+			}
+		})
+	}
+}
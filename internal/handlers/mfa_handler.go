@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+)
+
+// MFAHandlers let the authenticated user manage their own two-factor authentication
+type MFAHandlers struct {
+	*Handlers
+	Services *services.MFAService
+}
+
+func NewMFAHandlers(h *Handlers, mfa *services.MFAService) *MFAHandlers {
+	return &MFAHandlers{
+		Handlers: h,
+		Services: mfa,
+	}
+}
+
+func (m *MFAHandlers) Status(w http.ResponseWriter, r *http.Request) {
+	status, err := m.Services.Status(r.Context(), middleware.Username(r.Context()))
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, status)
+}
+
+func (m *MFAHandlers) BeginEnrollment(w http.ResponseWriter, r *http.Request) {
+	enrollment, err := m.Services.BeginEnrollment(r.Context(), middleware.Username(r.Context()))
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, enrollment)
+}
+
+func (m *MFAHandlers) ConfirmEnrollment(w http.ResponseWriter, r *http.Request) {
+	var req models.MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	codes, err := m.Services.ConfirmEnrollment(r.Context(), middleware.Username(r.Context()), req.Code, utils.GetClientIP(r))
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (m *MFAHandlers) Disable(w http.ResponseWriter, r *http.Request) {
+	var req models.MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := m.Services.Disable(r.Context(), middleware.Username(r.Context()), req.Code, utils.GetClientIP(r)); err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"event": "mfa_disabled", "status": "success"})
+}
+
+func (m *MFAHandlers) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req models.MFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	codes, err := m.Services.RegenerateRecoveryCodes(r.Context(), middleware.Username(r.Context()), req.Code, utils.GetClientIP(r))
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// writeMFAError maps the errors of MFAService to status codes. A wrong code is a 400,
+// not a 401, which clients take for an expired access token.
+func writeMFAError(w http.ResponseWriter, err error) {
+	var throttled *auth.ThrottledError
+	status := http.StatusInternalServerError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		status = http.StatusTooManyRequests
+	case errors.Is(err, services.ErrInvalidMFACode):
+		status = http.StatusBadRequest
+	case errors.Is(err, services.ErrUserNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrMFAEnabled), errors.Is(err, services.ErrMFANotEnabled), errors.Is(err, services.ErrMFARequired):
+		status = http.StatusConflict
+	}
+	utils.WriteJSONError(w, status, err.Error())
+}
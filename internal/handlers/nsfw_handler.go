@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 	"time"
 
@@ -48,3 +49,62 @@ func (n *NSFWHandlers) NSFWHandler(w http.ResponseWriter, r *http.Request) {
 	n.Services.WriteJSONResponse(w, http.StatusOK, output)
 
 }
+
+// ArchiveHandler processes a ZIP or TAR archive of images for NSFW detection
+func (n *NSFWHandlers) ArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	clientIP := utils.GetClientIP(r)
+	logger.Info("Archive request received from: %s", clientIP)
+
+	file, header, err := n.Services.ValidateArchive(w, r)
+	if err != nil {
+		n.Services.SendErrorResponse(w, err.Error(), startTime, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// Entries are streamed as JSON lines while they are processed, the response only
+	// starts with the first one so archives rejected as a whole still get an error status
+	stream := &archiveStream{w: w, enc: json.NewEncoder(w)}
+
+	report, err := n.Services.ProcessArchive(r.Context(), file, header, func(entry services.ArchiveEntryResult) error {
+		return stream.send(services.ArchiveEvent{Entry: &entry})
+	})
+	if err != nil {
+		if stream.started {
+			logger.Error("Archive %s interrupted: %v", header.Filename, err)
+			return
+		}
+		logger.Error("Failed to process archive %s: %v", header.Filename, err)
+		n.Services.SendErrorResponse(w, err.Error(), startTime, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := stream.send(services.ArchiveEvent{Report: report}); err != nil {
+		logger.Error("Failed to send report of archive %s: %v", header.Filename, err)
+	}
+}
+
+// archiveStream writes archive events as newline delimited JSON, flushing after each line
+type archiveStream struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	started bool
+}
+
+func (s *archiveStream) send(event services.ArchiveEvent) error {
+	if !s.started {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+
+	if err := s.enc.Encode(event); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
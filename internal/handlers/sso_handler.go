@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+)
+
+// SSOHandlers run the browser side of single sign-on: the redirect to the identity
+// provider and its callback, which sends the browser back to the admin panel
+type SSOHandlers struct {
+	*Handlers
+	Services *services.SSOService
+}
+
+func NewSSOHandlers(h *Handlers, sso *services.SSOService) *SSOHandlers {
+	return &SSOHandlers{
+		Handlers: h,
+		Services: sso,
+	}
+}
+
+// SSOInfo tells the admin panel whether single sign-on is configured
+func SSOInfo(w http.ResponseWriter, r *http.Request) {
+	info := models.SSOInfo{Enabled: config.AppConfig.OIDC.Enabled}
+	if info.Enabled {
+		info.Name = config.AppConfig.OIDC.Name
+	}
+	utils.WriteJSONResponse(w, http.StatusOK, info)
+}
+
+func (s *SSOHandlers) Start(w http.ResponseWriter, r *http.Request) {
+	authURL, err := s.Services.Start(r.Context())
+	if err != nil {
+		s.backToPanel(w, r, "sso_error", err.Error())
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (s *SSOHandlers) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		logger.Info("Identity provider refused SSO login from %s: %s %s", utils.GetClientIP(r), providerError, query.Get("error_description"))
+		s.backToPanel(w, r, "sso_error", "Single sign-on was cancelled or refused")
+		return
+	}
+
+	token, err := s.Services.Callback(r.Context(), query.Get("state"), query.Get("code"), utils.GetClientIP(r))
+	if err != nil {
+		s.backToPanel(w, r, "sso_error", err.Error())
+		return
+	}
+
+	s.backToPanel(w, r, "sso", token)
+}
+
+// backToPanel redirects to the login page of the admin panel with a query parameter
+func (s *SSOHandlers) backToPanel(w http.ResponseWriter, r *http.Request, key, value string) {
+	target := strings.TrimSuffix(config.AppConfig.OIDC.FrontendURL, "/") + "/#/login?" + url.Values{key: {value}}.Encode()
+	http.Redirect(w, r, target, http.StatusFound)
+}
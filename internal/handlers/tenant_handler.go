@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+)
+
+type TenantHandlers struct {
+	*Handlers
+	Services *services.TenantService
+}
+
+func NewTenantHandlers(h *Handlers, tenants *services.TenantService) *TenantHandlers {
+	return &TenantHandlers{
+		Handlers: h,
+		Services: tenants,
+	}
+}
+
+// CurrentTenant returns the tenant of the logged in user with its policy
+func (t *TenantHandlers) CurrentTenant(w http.ResponseWriter, r *http.Request) {
+	tenant, err := t.Services.Current(r.Context())
+	if err != nil {
+		writeTenantError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, tenant)
+}
+
+func (t *TenantHandlers) SetPolicy(w http.ResponseWriter, r *http.Request) {
+	var req models.TenantPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	tenant, err := t.Services.SetPolicy(r.Context(), req)
+	if err != nil {
+		writeTenantError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, tenant)
+}
+
+func writeTenantError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrInvalidTenant):
+		status = http.StatusBadRequest
+	case errors.Is(err, services.ErrTenantNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrTenantExists):
+		status = http.StatusConflict
+	}
+	utils.WriteJSONError(w, status, err.Error())
+}
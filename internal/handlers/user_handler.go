@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/services"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+)
+
+type UserHandlers struct {
+	*Handlers
+	Services *services.UserService
+}
+
+func NewUserHandlers(h *Handlers, users *services.UserService) *UserHandlers {
+	return &UserHandlers{
+		Handlers: h,
+		Services: users,
+	}
+}
+
+func (u *UserHandlers) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := u.Services.ListUsers(r.Context())
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, users)
+}
+
+func (u *UserHandlers) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	user, err := u.Services.CreateUser(r.Context(), req)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, user)
+}
+
+func (u *UserHandlers) SetRole(w http.ResponseWriter, r *http.Request) {
+	var req models.RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	user, err := u.Services.SetRole(r.Context(), chi.URLParam(r, "username"), req.Role)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, user)
+}
+
+func (u *UserHandlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.PasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	user, err := u.Services.ResetPassword(r.Context(), chi.URLParam(r, "username"), req.Password)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, user)
+}
+
+func (u *UserHandlers) DisableUser(w http.ResponseWriter, r *http.Request) {
+	u.setDisabled(w, r, true)
+}
+
+func (u *UserHandlers) EnableUser(w http.ResponseWriter, r *http.Request) {
+	u.setDisabled(w, r, false)
+}
+
+func (u *UserHandlers) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	user, err := u.Services.SetDisabled(r.Context(), chi.URLParam(r, "username"), disabled)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, user)
+}
+
+func (u *UserHandlers) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, err := u.Services.Unlock(r.Context(), chi.URLParam(r, "username"))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, user)
+}
+
+func (u *UserHandlers) ResetMFA(w http.ResponseWriter, r *http.Request) {
+	user, err := u.Services.ResetMFA(r.Context(), chi.URLParam(r, "username"))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, user)
+}
+
+func (u *UserHandlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if err := u.Services.DeleteUser(r.Context(), username); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"event": "user_deleted", "username": username, "status": "success"})
+}
+
+// writeUserError maps the errors of UserService to status codes
+func writeUserError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrInvalidUser):
+		status = http.StatusBadRequest
+	case errors.Is(err, services.ErrUserNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrUserExists), errors.Is(err, services.ErrLastAdmin), errors.Is(err, services.ErrSelfChange):
+		status = http.StatusConflict
+	}
+	utils.WriteJSONError(w, status, err.Error())
+}
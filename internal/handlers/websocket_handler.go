@@ -4,15 +4,13 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
+	"github.com/mlvieira/nsfwdetection/internal/auth"
 	"github.com/mlvieira/nsfwdetection/internal/config"
 	"github.com/mlvieira/nsfwdetection/internal/models"
 	"github.com/mlvieira/nsfwdetection/internal/websockets"
 )
 
-var jwtSecretKey = []byte(config.AppConfig.Security.JWTSecretKey)
-
 // WebSocket upgrader
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
@@ -22,7 +20,7 @@ var upgrader = websocket.Upgrader{
 }
 
 // HandleWebSocket handles new WebSocket connections
-func HandleWebSocket(hub *websockets.Hub) http.HandlerFunc {
+func HandleWebSocket(hub *websockets.Hub, tokens *auth.Tokens) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenStr := r.Header.Get("Sec-WebSocket-Protocol")
 		if tokenStr == "" {
@@ -30,25 +28,29 @@ func HandleWebSocket(hub *websockets.Hub) http.HandlerFunc {
 			return
 		}
 
-		claims := &models.Claims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey, nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := tokens.Parse(r.Context(), tokenStr)
+		if err != nil {
 			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
 			return
 		}
 
+		// uploads are broadcast with links to the images
+		if !models.HasRole(claims.Role, models.RoleReviewer) {
+			http.Error(w, "Forbidden: Insufficient permissions", http.StatusForbidden)
+			return
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Println("Failed to upgrade WebSocket:", err)
 			return
 		}
 
+		// only uploads and reviews of the user's own tenant are sent
 		client := &websockets.Client{
-			Conn: conn,
-			Send: make(chan []byte, 256),
+			Conn:     conn,
+			Send:     make(chan []byte, 256),
+			TenantID: claims.TenantID,
 		}
 
 		hub.Register <- client
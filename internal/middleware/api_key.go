@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+)
+
+const APIKeyKey = ContextKey("api_key")
+
+// APIKeyChecker looks up the key a request is made with
+type APIKeyChecker interface {
+	// Authenticate returns the active key matching secret, or nil if there is none
+	Authenticate(ctx context.Context, secret string) (*models.APIKey, error)
+}
+
+// APIKeyCounter counts the requests made with a key against its quotas
+type APIKeyCounter interface {
+	// Allow returns a *auth.QuotaError if key is over one of its quotas
+	Allow(ctx context.Context, key *models.APIKey) error
+}
+
+// APIKey returns the key stored by APIKeyAuth, or nil for anonymous requests
+func APIKey(ctx context.Context) *models.APIKey {
+	key, _ := ctx.Value(APIKeyKey).(*models.APIKey)
+	return key
+}
+
+// APIKeyAuth requires an API key in the X-API-Key header or as a bearer token, and makes
+// the request for the tenant of the key. With allowAnonymous, requests without a key are
+// let through for the default tenant, an invalid key is refused either way.
+func APIKeyAuth(keys APIKeyChecker, allowAnonymous bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret := apiKeyFromRequest(r)
+			if secret == "" {
+				if allowAnonymous {
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), TenantKey, models.DefaultTenantID)))
+					return
+				}
+				utils.WriteJSONError(w, http.StatusUnauthorized, "Missing API key")
+				return
+			}
+
+			key, err := keys.Authenticate(r.Context(), secret)
+			if err != nil {
+				logger.Error("Failed to check API key: %v", err)
+				utils.WriteJSONError(w, http.StatusInternalServerError, "Failed to check API key")
+				return
+			}
+			if key == nil {
+				utils.WriteJSONError(w, http.StatusUnauthorized, "Invalid API key")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), APIKeyKey, key)
+			ctx = context.WithValue(ctx, TenantKey, key.TenantID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// APIKeyQuota counts requests authenticated by APIKeyAuth against the daily and monthly
+// quotas of their key. Quotas are not enforced when they cannot be counted, detection
+// keeps working if Redis is briefly unavailable.
+func APIKeyQuota(keys APIKeyCounter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := APIKey(r.Context())
+			if key == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if err := keys.Allow(r.Context(), key); err != nil {
+				var quotaErr *auth.QuotaError
+				if !errors.As(err, &quotaErr) {
+					logger.Error("Failed to check quotas of API key %s: %v", key.Prefix, err)
+				} else {
+					logger.Info("Request of %s refused, %s quota reached", key.ClientName, quotaErr.Limit)
+					w.Header().Set("Retry-After", seconds(quotaErr.RetryAfter))
+					utils.WriteJSONError(w, http.StatusTooManyRequests, quotaErr.Error())
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiKeyFromRequest returns the key of the X-API-Key header, or of the Authorization header
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return strings.TrimSpace(key)
+	}
+
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && scheme == "Bearer" {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "middleware-test")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := logger.Init(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// staticKeys authenticates the keys of its map
+type staticKeys map[string]*models.APIKey
+
+func (k staticKeys) Authenticate(ctx context.Context, secret string) (*models.APIKey, error) {
+	return k[secret], nil
+}
+
+// counterFunc adapts a function to APIKeyCounter
+type counterFunc func(ctx context.Context, key *models.APIKey) error
+
+func (f counterFunc) Allow(ctx context.Context, key *models.APIKey) error {
+	return f(ctx, key)
+}
+
+// tenantEcho answers with the tenant the request was let through for
+var tenantEcho = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	tenantID, _ := r.Context().Value(TenantKey).(int)
+	w.Write([]byte(strconv.Itoa(tenantID)))
+})
+
+func TestAPIKeyAuth(t *testing.T) {
+	keys := staticKeys{"nsfw_valid": {ID: 1, TenantID: 3, ClientName: "gallery"}}
+
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		anonymous bool
+		want      int
+		tenant    string
+	}{
+		{"key header", "X-API-Key", "nsfw_valid", false, http.StatusOK, "3"},
+		{"bearer token", "Authorization", "Bearer nsfw_valid", false, http.StatusOK, "3"},
+		{"missing key", "", "", false, http.StatusUnauthorized, ""},
+		{"anonymous", "", "", true, http.StatusOK, strconv.Itoa(models.DefaultTenantID)},
+		{"invalid key", "X-API-Key", "nsfw_guess", false, http.StatusUnauthorized, ""},
+		{"invalid key with anonymous access", "X-API-Key", "nsfw_guess", true, http.StatusUnauthorized, ""},
+		{"other scheme", "Authorization", "Basic nsfw_valid", false, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/detect-nsfw", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			APIKeyAuth(keys, tt.anonymous)(tenantEcho).ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && w.Body.String() != tt.tenant {
+				t.Fatalf("request let through for tenant %s, want %s", w.Body.String(), tt.tenant)
+			}
+		})
+	}
+}
+
+func TestAPIKeyQuota(t *testing.T) {
+	keys := staticKeys{"nsfw_valid": {ID: 1, TenantID: 3, DailyQuota: 2}}
+	handler := APIKeyAuth(keys, true)(APIKeyQuota(auth.NewAPIKeyLimiter(cache.NewMemoryCounter()))(tenantEcho))
+
+	request := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/detect-nsfw", nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request("nsfw_valid"); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the quota = %d", i+1, w.Code)
+		}
+	}
+
+	w := request("nsfw_valid")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the daily quota = %d, want 429", w.Code)
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry <= 0 || retry > 24*60*60 {
+		t.Fatalf("Retry-After = %q, want the seconds until midnight UTC", w.Header().Get("Retry-After"))
+	}
+
+	// anonymous requests have no quota
+	if w := request(""); w.Code != http.StatusOK {
+		t.Fatalf("anonymous request = %d", w.Code)
+	}
+}
+
+// TestAPIKeyQuotaUnavailable checks requests go through while quotas can't be counted
+func TestAPIKeyQuotaUnavailable(t *testing.T) {
+	keys := staticKeys{"nsfw_valid": {ID: 1, TenantID: 3, DailyQuota: 1}}
+	broken := counterFunc(func(ctx context.Context, key *models.APIKey) error {
+		return errors.New("connection refused")
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/detect-nsfw", nil)
+	r.Header.Set("X-API-Key", "nsfw_valid")
+	w := httptest.NewRecorder()
+	APIKeyAuth(keys, false)(APIKeyQuota(broken)(tenantEcho)).ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want the request let through", w.Code)
+	}
+}
@@ -2,49 +2,102 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
 	"github.com/mlvieira/nsfwdetection/internal/models"
 	"github.com/mlvieira/nsfwdetection/internal/utils"
 )
 
 type ContextKey string
 
-var jwtSecretKey = config.AppConfig.Security.JWTSecretKey
+const (
+	UserKey   = ContextKey("user")
+	RoleKey   = ContextKey("role")
+	ClaimsKey = ContextKey("claims")
+	TenantKey = ContextKey("tenant")
+)
 
-const UserKey = ContextKey("user")
+// Username returns the authenticated username stored by JWTAuth, or "" outside authenticated routes
+func Username(ctx context.Context) string {
+	username, _ := ctx.Value(UserKey).(string)
+	return username
+}
 
-// JWTAuth validates the JWT token in the Authorization header
-func JWTAuth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			utils.WriteJSONError(w, http.StatusUnauthorized, "Missing Authorization header")
-			return
-		}
+// Role returns the role of the authenticated user stored by JWTAuth, or "" outside authenticated routes
+func Role(ctx context.Context) string {
+	role, _ := ctx.Value(RoleKey).(string)
+	return role
+}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			utils.WriteJSONError(w, http.StatusUnauthorized, "Invalid Authorization header format")
-			return
-		}
+// Claims returns the claims of the access token checked by JWTAuth, or nil outside authenticated routes
+func Claims(ctx context.Context) *models.Claims {
+	claims, _ := ctx.Value(ClaimsKey).(*models.Claims)
+	return claims
+}
 
-		tokenStr := parts[1]
+// TenantID returns the tenant a request is made for, set by JWTAuth and APIKeyAuth, or
+// by WithTenant outside of requests. It is models.NoTenant when none was set.
+func TenantID(ctx context.Context) int {
+	tenantID, ok := ctx.Value(TenantKey).(int)
+	if !ok {
+		return models.NoTenant
+	}
+	return tenantID
+}
 
-		claims := &models.Claims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(jwtSecretKey), nil
-		})
+// WithTenant makes ctx work in tenantID like requests of its users do. The CLI and
+// background jobs pass models.AllTenants to work on the whole database.
+func WithTenant(ctx context.Context, tenantID int) context.Context {
+	return context.WithValue(ctx, TenantKey, tenantID)
+}
 
-		if err != nil || !token.Valid {
-			utils.WriteJSONError(w, http.StatusUnauthorized, "Invalid token")
-			return
-		}
+// JWTAuth validates the access token in the Authorization header, including whether it was revoked
+func JWTAuth(tokens *auth.Tokens) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				utils.WriteJSONError(w, http.StatusUnauthorized, "Missing Authorization header")
+				return
+			}
 
-		ctx := context.WithValue(r.Context(), UserKey, claims.Username)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				utils.WriteJSONError(w, http.StatusUnauthorized, "Invalid Authorization header format")
+				return
+			}
+
+			claims, err := tokens.Parse(r.Context(), parts[1])
+			if err != nil {
+				if !errors.Is(err, auth.ErrInvalidToken) && !errors.Is(err, auth.ErrRevoked) {
+					logger.Error("Failed to check token: %v", err)
+				}
+				utils.WriteJSONError(w, http.StatusUnauthorized, "Invalid token")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserKey, claims.Username)
+			ctx = context.WithValue(ctx, RoleKey, claims.Role)
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
+			ctx = context.WithValue(ctx, TenantKey, claims.TenantID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireRole rejects users whose role grants less than role. It must run after JWTAuth.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !models.HasRole(Role(r.Context()), role) {
+				utils.WriteJSONError(w, http.StatusForbidden, "Insufficient permissions")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
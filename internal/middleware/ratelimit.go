@@ -1,37 +1,83 @@
 package middleware
 
 import (
+	"fmt"
+	"math"
 	"net/http"
-	"sync"
+	"strconv"
+	"time"
 
-	"golang.org/x/time/rate"
-
-	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
 )
 
-var (
-	once    sync.Once
-	limiter *rate.Limiter
-)
+// RateLimit gives every client address a token bucket in buckets, sized by perSecond and
+// burst. It comes before authentication, so every request takes from the bucket of its
+// address, including requests with a wrong API key or password. A perSecond of 0 turns
+// it off. Responses carry the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers, and Retry-After once the bucket is empty. Requests are let
+// through when the buckets cannot be reached, so Redis being briefly unavailable does not
+// take detection down.
+func RateLimit(buckets cache.Buckets, perSecond float64, burst int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if take(w, r, buckets, "ratelimit:ip:"+utils.GetClientIP(r), perSecond, burst) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// APIKeyRateLimit gives every API key a token bucket in buckets holding a minute of its
+// rate_limit_per_min, on top of the bucket of the address. Requests authenticated by
+// APIKeyAuth take from both, keys with no rate limit only from the bucket of their
+// address. The headers are those of RateLimit, for the bucket of the key.
+func APIKeyRateLimit(buckets cache.Buckets) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey := APIKey(r.Context())
+			if apiKey == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := "ratelimit:key:" + strconv.Itoa(apiKey.ID)
+			if take(w, r, buckets, key, float64(apiKey.RateLimitPerMin)/60, apiKey.RateLimitPerMin) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// take removes a token from the bucket under key and reports whether the request may go
+// on. Refused requests are answered here. A rate of 0 or less means no limit.
+func take(w http.ResponseWriter, r *http.Request, buckets cache.Buckets, key string, rate float64, size int) bool {
+	if rate <= 0 {
+		return true
+	}
+
+	result, err := buckets.Take(r.Context(), key, rate, size)
+	if err != nil {
+		logger.Error("Failed to check rate limit of %s: %v", key, err)
+		return true
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(size))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", seconds(result.ResetAfter))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", size, seconds(time.Duration(float64(size)/rate*float64(time.Second)))))
 
-// ensureLimiter makes sure limiter is only initialized once.
-func ensureLimiter() {
-	once.Do(func() {
-		rps := config.AppConfig.Server.ReqPerSec
-		burst := config.AppConfig.Server.Burst
-		limiter = rate.NewLimiter(rate.Limit(rps), burst)
-	})
+	if !result.Allowed {
+		header.Set("Retry-After", seconds(result.RetryAfter))
+		utils.WriteJSONError(w, http.StatusTooManyRequests, "Too Many Requests")
+		return false
+	}
+	return true
 }
 
-// RateLimit is a minimal middleware that applies the global limiter to each request.
-func RateLimit(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ensureLimiter()
-
-		if !limiter.Allow() {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+// seconds formats d as whole seconds, rounded up
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
 }
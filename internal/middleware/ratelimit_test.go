@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+)
+
+// slowRate refills so slowly that no bucket refills during a test
+const slowRate = 0.001
+
+// apiChain wires the limiters around APIKeyAuth the way the router does for /api
+func apiChain(buckets cache.Buckets, perSecond float64, burst int) http.Handler {
+	keys := staticKeys{
+		"nsfw_unlimited": {ID: 1, TenantID: 2},
+		"nsfw_limited":   {ID: 2, TenantID: 2, RateLimitPerMin: 2},
+	}
+	return RateLimit(buckets, perSecond, burst)(APIKeyAuth(keys, true)(APIKeyRateLimit(buckets)(tenantEcho)))
+}
+
+// brokenBuckets fails every call, like Redis while it is unreachable
+type brokenBuckets struct{}
+
+func (brokenBuckets) Take(ctx context.Context, key string, rate float64, burst int) (cache.BucketResult, error) {
+	return cache.BucketResult{}, errors.New("connection refused")
+}
+
+func send(h http.Handler, ip, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/detect-nsfw", nil)
+	r.RemoteAddr = ip + ":40000"
+	if key != "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimitAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{"anonymous", "", http.StatusOK},
+		{"key without a rate limit", "nsfw_unlimited", http.StatusOK},
+		// guesses are answered 401 until the address runs out of tokens
+		{"invalid key", "nsfw_guess", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := apiChain(cache.NewMemoryBuckets(), slowRate, 3)
+
+			for i := 0; i < 3; i++ {
+				if w := send(h, "203.0.113.1", tt.key); w.Code != tt.want {
+					t.Fatalf("request %d = %d, want %d", i+1, w.Code, tt.want)
+				}
+			}
+
+			w := send(h, "203.0.113.1", tt.key)
+			if w.Code != http.StatusTooManyRequests {
+				t.Fatalf("request over the burst = %d, want 429", w.Code)
+			}
+			if w.Header().Get("RateLimit-Limit") != "3" || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("Retry-After") == "" {
+				t.Fatalf("headers of the refused request = %v", w.Header())
+			}
+
+			if w := send(h, "203.0.113.2", tt.key); w.Code != tt.want {
+				t.Fatalf("request from another address = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	h := apiChain(cache.NewMemoryBuckets(), slowRate, 10)
+
+	for i := 0; i < 2; i++ {
+		w := send(h, "203.0.113.1", "nsfw_limited")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d = %d", i+1, w.Code)
+		}
+		if w.Header().Get("RateLimit-Limit") != "2" {
+			t.Fatalf("RateLimit-Limit = %q, want the limit of the key", w.Header().Get("RateLimit-Limit"))
+		}
+	}
+
+	// the bucket of the key is shared by every address using it
+	if w := send(h, "203.0.113.2", "nsfw_limited"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the key's rate limit = %d, want 429", w.Code)
+	}
+	if w := send(h, "203.0.113.1", ""); w.Code != http.StatusOK {
+		t.Fatalf("anonymous request from the same address = %d", w.Code)
+	}
+}
+
+// TestRateLimitKeyWithinAddress checks a key's own rate limit doesn't lift the limit of its address
+func TestRateLimitKeyWithinAddress(t *testing.T) {
+	h := apiChain(cache.NewMemoryBuckets(), slowRate, 1)
+
+	if w := send(h, "203.0.113.1", "nsfw_limited"); w.Code != http.StatusOK {
+		t.Fatalf("first request = %d", w.Code)
+	}
+	if w := send(h, "203.0.113.1", "nsfw_limited"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the address burst = %d, want 429", w.Code)
+	}
+}
+
+func TestRateLimitOff(t *testing.T) {
+	h := apiChain(cache.NewMemoryBuckets(), 0, 0)
+
+	for i := 0; i < 20; i++ {
+		if w := send(h, "203.0.113.1", "nsfw_unlimited"); w.Code != http.StatusOK {
+			t.Fatalf("request %d without limits = %d", i+1, w.Code)
+		}
+	}
+	if w := send(h, "203.0.113.1", "nsfw_guess"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid key = %d, want 401", w.Code)
+	}
+}
+
+func TestRateLimitUnavailable(t *testing.T) {
+	h := apiChain(brokenBuckets{}, slowRate, 1)
+
+	for i := 0; i < 3; i++ {
+		if w := send(h, "203.0.113.1", "nsfw_limited"); w.Code != http.StatusOK {
+			t.Fatalf("request %d while buckets are unreachable = %d", i+1, w.Code)
+		}
+	}
+}
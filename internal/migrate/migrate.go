@@ -0,0 +1,281 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/migrations"
+)
+
+// Migration is a single schema change with its rollback
+type Migration struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a migration has been applied
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies migrations and records them in the schema_migration table
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// New loads the migrations found in fsys for a database using driver
+func New(db *sql.DB, driver string, fsys fs.FS) (*Migrator, error) {
+	list, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, driver: driver, migrations: list}, nil
+}
+
+// ForDriver creates a migrator for the migrations embedded in the binary for driver
+func ForDriver(db *sql.DB, driver string) (*Migrator, error) {
+	fsys, err := migrations.For(driver)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s: %w", driver, err)
+	}
+
+	return New(db, driver, fsys)
+}
+
+// load parses <version>_<name>.up.sql / .down.sql pairs sorted by version
+func load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[string]*Migration)
+	for _, file := range files {
+		base := strings.TrimSuffix(file, ".sql")
+
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", file)
+		}
+		base = strings.TrimSuffix(base, "."+direction)
+
+		version, name, ok := strings.Cut(base, "_")
+		if !ok || len(version) != 14 {
+			return nil, fmt.Errorf("migration %s must be named <14 digit version>_<name>", file)
+		}
+
+		contents, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %s_%s has no up file", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list, nil
+}
+
+// ensureTable creates schema_migration if needed. The layout matches the one soda uses,
+// so databases previously migrated with soda are picked up as-is.
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migration (
+			version varchar(14) NOT NULL,
+			PRIMARY KEY (version)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migration table: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[string]bool, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT version FROM schema_migration`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// Status lists every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		status = append(status, Status{Migration: mig, Applied: applied[mig.Version]})
+	}
+
+	return status, nil
+}
+
+// Up applies all pending migrations in order and returns the ones it ran
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, mig := range m.migrations {
+		if applied[mig.Version] {
+			continue
+		}
+
+		if err := m.run(ctx, mig.Up, `INSERT INTO schema_migration (version) VALUES (?)`, mig.Version); err != nil {
+			return ran, fmt.Errorf("migration %s_%s failed: %w", mig.Version, mig.Name, err)
+		}
+		ran = append(ran, mig)
+	}
+
+	return ran, nil
+}
+
+// Down rolls back the last steps applied migrations and returns the ones it reverted
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		mig := m.migrations[i]
+		if !applied[mig.Version] {
+			continue
+		}
+
+		if mig.Down == "" {
+			return reverted, fmt.Errorf("migration %s_%s has no down file", mig.Version, mig.Name)
+		}
+
+		if err := m.run(ctx, mig.Down, `DELETE FROM schema_migration WHERE version = ?`, mig.Version); err != nil {
+			return reverted, fmt.Errorf("rollback of %s_%s failed: %w", mig.Version, mig.Name, err)
+		}
+		reverted = append(reverted, mig)
+	}
+
+	return reverted, nil
+}
+
+// run executes the statements of a migration file and updates schema_migration in one transaction.
+// MySQL commits DDL implicitly, so there a failed migration may be partially applied.
+func (m *Migrator) run(ctx context.Context, script, bookkeeping, version string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	txn, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			txn.Rollback()
+		}
+	}()
+
+	for _, stmt := range splitStatements(script) {
+		if _, err = txn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err = txn.ExecContext(ctx, database.Rebind(m.driver, bookkeeping), version); err != nil {
+		return err
+	}
+
+	if err = txn.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// splitStatements breaks a script into statements on semicolons that end a line,
+// so drivers without multi-statement support can run it. Lines starting with -- are dropped.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+
+		if strings.HasSuffix(trimmed, ";") {
+			if stmt := strings.TrimSpace(current.String()); stmt != ";" {
+				statements = append(statements, strings.TrimSuffix(stmt, ";"))
+			}
+			current.Reset()
+		}
+	}
+
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		statements = append(statements, stmt)
+	}
+
+	return statements
+}
@@ -0,0 +1,152 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSQLite returns an empty SQLite database in a temp dir
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	conn, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func newMigrator(t *testing.T, conn *sql.DB, fsys fstest.MapFS) *Migrator {
+	t.Helper()
+
+	m, err := New(conn, database.SQLite, fsys)
+	if err != nil {
+		t.Fatalf("New = %v", err)
+	}
+	return m
+}
+
+func file(script string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(script)}
+}
+
+// recorded returns the versions in schema_migration
+func recorded(t *testing.T, conn *sql.DB) map[string]bool {
+	t.Helper()
+
+	rows, err := conn.Query(`SELECT version FROM schema_migration`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	versions := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		versions[version] = true
+	}
+	return versions
+}
+
+// schema creates a table and then alters it, the second migration fails if run first
+var schema = fstest.MapFS{
+	"20240301000000_add_label.up.sql":       file("ALTER TABLE images ADD COLUMN label TEXT;"),
+	"20240301000000_add_label.down.sql":     file("ALTER TABLE images DROP COLUMN label;"),
+	"20240101000000_create_images.up.sql":   file("CREATE TABLE images (\n  id INTEGER PRIMARY KEY\n);"),
+	"20240101000000_create_images.down.sql": file("DROP TABLE images;"),
+}
+
+func TestUpOrder(t *testing.T) {
+	conn := openSQLite(t)
+
+	ran, err := newMigrator(t, conn, schema).Up(context.Background())
+	if err != nil {
+		t.Fatalf("Up = %v", err)
+	}
+	if len(ran) != 2 || ran[0].Name != "create_images" || ran[1].Name != "add_label" {
+		t.Fatalf("Up ran %+v, want create_images then add_label", ran)
+	}
+
+	if _, err := conn.Exec(`INSERT INTO images (id, label) VALUES (1, 'SFW')`); err != nil {
+		t.Fatalf("schema after Up: %v", err)
+	}
+}
+
+func TestUpIdempotent(t *testing.T) {
+	ctx := context.Background()
+	conn := openSQLite(t)
+
+	if _, err := newMigrator(t, conn, schema).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ran, err := newMigrator(t, conn, schema).Up(ctx)
+	if err != nil || len(ran) != 0 {
+		t.Fatalf("second Up = %+v, %v, want nothing to run", ran, err)
+	}
+
+	// a migration added later is the only one run
+	grown := fstest.MapFS{"20240401000000_add_score.up.sql": file("ALTER TABLE images ADD COLUMN score REAL;")}
+	for name, f := range schema {
+		grown[name] = f
+	}
+	ran, err = newMigrator(t, conn, grown).Up(ctx)
+	if err != nil || len(ran) != 1 || ran[0].Name != "add_score" {
+		t.Fatalf("Up with a new migration = %+v, %v", ran, err)
+	}
+
+	status, err := newMigrator(t, conn, grown).Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if !s.Applied {
+			t.Fatalf("Status = %+v, want every migration applied", status)
+		}
+	}
+}
+
+func TestFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	conn := openSQLite(t)
+
+	broken := fstest.MapFS{
+		"20240101000000_create_images.up.sql": schema["20240101000000_create_images.up.sql"],
+		"20240201000000_add_events.up.sql":    file("CREATE TABLE events (id INTEGER PRIMARY KEY);\nALTER TABLE missing ADD COLUMN x TEXT;"),
+		"20240301000000_add_label.up.sql":     schema["20240301000000_add_label.up.sql"],
+	}
+
+	ran, err := newMigrator(t, conn, broken).Up(ctx)
+	if err == nil {
+		t.Fatal("Up of a broken migration succeeded")
+	}
+	if len(ran) != 1 || ran[0].Name != "create_images" {
+		t.Fatalf("Up ran %+v before failing, want create_images only", ran)
+	}
+
+	versions := recorded(t, conn)
+	if !versions["20240101000000"] || versions["20240201000000"] || versions["20240301000000"] {
+		t.Fatalf("schema_migration = %v, want the failed migration and the ones after it unrecorded", versions)
+	}
+	// the statement before the failing one was rolled back with it
+	if _, err := conn.Exec(`SELECT id FROM events`); err == nil {
+		t.Fatal("failed migration left its events table")
+	}
+
+	// once fixed, the next run picks up where the failed one stopped
+	broken["20240201000000_add_events.up.sql"] = file("CREATE TABLE events (id INTEGER PRIMARY KEY);")
+	ran, err = newMigrator(t, conn, broken).Up(ctx)
+	if err != nil || len(ran) != 2 || ran[0].Name != "add_events" {
+		t.Fatalf("Up after the fix = %+v, %v", ran, err)
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// APIKey lets a client call the detection API. Only the SHA-256 of the key is stored,
+// Prefix is its start, kept to tell keys apart. Limits of 0 mean unlimited.
+type APIKey struct {
+	ID              int        `json:"id"`
+	TenantID        int        `json:"tenant_id"`
+	Prefix          string     `json:"prefix"`
+	KeyHash         string     `json:"-"`
+	ClientName      string     `json:"client_name"`
+	RateLimitPerMin int        `json:"rate_limit_per_min"`
+	DailyQuota      int        `json:"daily_quota"`
+	MonthlyQuota    int        `json:"monthly_quota"`
+	CreatedBy       string     `json:"created_by"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// CreateAPIKeyRequest is the payload of POST /admin/api-keys. Limits left out get the
+// defaults of the [api_keys] config, 0 makes them unlimited.
+type CreateAPIKeyRequest struct {
+	ClientName      string `json:"client_name"`
+	RateLimitPerMin *int   `json:"rate_limit_per_min"`
+	DailyQuota      *int   `json:"daily_quota"`
+	MonthlyQuota    *int   `json:"monthly_quota"`
+}
+
+// CreatedAPIKey is a new key together with its secret, which is shown only once
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyUsage counts the requests of an active key in the current UTC day and month
+type APIKeyUsage struct {
+	ID                int    `json:"id"`
+	Prefix            string `json:"prefix"`
+	ClientName        string `json:"client_name"`
+	RequestsToday     int64  `json:"requests_today"`
+	RequestsThisMonth int64  `json:"requests_this_month"`
+	DailyQuota        int    `json:"daily_quota"`
+	MonthlyQuota      int    `json:"monthly_quota"`
+}
@@ -1,10 +1,55 @@
 package models
 
-import "github.com/golang-jwt/jwt/v5"
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles of admin users, each one allowed everything the previous ones are
+const (
+	// RoleViewer can read stats
+	RoleViewer = "viewer"
+	// RoleReviewer can also list, label and follow uploads
+	RoleReviewer = "reviewer"
+	// RoleSeniorReviewer can also change reviewed labels, browse the trash and read the audit trail
+	RoleSeniorReviewer = "senior_reviewer"
+	// RoleAdmin can do everything, including deleting images and managing users
+	RoleAdmin = "admin"
+)
+
+// Roles lists the roles from least to most privileged
+var Roles = []string{RoleViewer, RoleReviewer, RoleSeniorReviewer, RoleAdmin}
+
+// roleLevel returns the position of role in Roles, or -1 for unknown roles
+func roleLevel(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidRole reports whether role is one of Roles
+func ValidRole(role string) bool {
+	return roleLevel(role) >= 0
+}
+
+// HasRole reports whether role grants at least the permissions of required
+func HasRole(role, required string) bool {
+	level := roleLevel(role)
+	return level >= 0 && level >= roleLevel(required)
+}
 
 // Claims represents the JWT claims structure
 type Claims struct {
 	Username string `json:"username"`
+	Role     string `json:"role"`
+	// TenantID is the tenant of the user, tokens issued before tenants existed have none
+	TenantID int `json:"tenant_id,omitempty"`
+	// Purpose is set on the short lived tokens of an unfinished login, which are not access tokens
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -14,7 +59,101 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
-// LoginResponse represents the response containing the JWT token
+// LoginResponse is returned by login and refresh. Token is the access token, valid for
+// ExpiresIn seconds, and RefreshToken can be exchanged once for a new pair.
+//
+// When the password was right but a second factor is needed, only MFAToken is set along
+// with MFARequired, or with MFAEnrollmentRequired for users who must set up 2FA first.
+// RecoveryCodes are returned once, by the login that completes a forced enrollment.
 type LoginResponse struct {
+	Token                 string   `json:"token,omitempty"`
+	RefreshToken          string   `json:"refresh_token,omitempty"`
+	ExpiresIn             int      `json:"expires_in,omitempty"`
+	MFARequired           bool     `json:"mfa_required,omitempty"`
+	MFAEnrollmentRequired bool     `json:"mfa_enrollment_required,omitempty"`
+	MFAToken              string   `json:"mfa_token,omitempty"`
+	RecoveryCodes         []string `json:"recovery_codes,omitempty"`
+}
+
+// MFARequest is the payload of the second login step and of 2FA changes. MFAToken is
+// only used during login, Code is a TOTP code or a recovery code.
+type MFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// MFAStatus describes the 2FA setup of the current user
+type MFAStatus struct {
+	Enabled       bool       `json:"enabled"`
+	EnabledAt     *time.Time `json:"enabled_at,omitempty"`
+	Required      bool       `json:"required"`
+	RecoveryCodes int        `json:"recovery_codes_left"`
+}
+
+// MFAEnrollment is a new TOTP secret waiting for its first code. URI is the otpauth://
+// provisioning URI authenticator apps read from a QR code.
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodesResponse holds freshly generated recovery codes, shown only once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TOTP is the stored TOTP setup of a user. EnabledAt is nil while the secret awaits its
+// first code. LastStep is the time step of the last accepted code, which cannot be reused.
+type TOTP struct {
+	Secret    string
+	EnabledAt *time.Time
+	LastStep  int64
+}
+
+// SSOInfo tells the admin panel whether to offer single sign-on, under which name
+type SSOInfo struct {
+	Enabled bool   `json:"enabled"`
+	Name    string `json:"name,omitempty"`
+}
+
+// SSORequest is the payload of POST /admin/login/sso, with the token the single sign-on
+// callback passed to the admin panel
+type SSORequest struct {
 	Token string `json:"token"`
 }
+
+// RefreshRequest is the payload of POST /admin/refresh and POST /admin/logout
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken is a stored refresh token. Only the SHA-256 of the token is kept.
+// Every token issued by refreshing another one shares its FamilyID with it, so a
+// stolen token that is used twice can take the whole session down.
+type RefreshToken struct {
+	ID        int
+	TokenHash string
+	FamilyID  string
+	Username  string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// CreateUserRequest is the payload of POST /admin/users
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// RoleRequest is the payload of POST /admin/users/{username}/role
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// PasswordRequest is the payload of POST /admin/users/{username}/password
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
@@ -3,21 +3,58 @@ package models
 import "time"
 
 type User struct {
-	ID        int       `json:"id"`
-	Username  string    `json:"username"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           int        `json:"id"`
+	Username     string     `json:"username"`
+	Password     string     `json:"-"`
+	Role         string     `json:"role"`
+	TenantID     int        `json:"tenant_id"`
+	DisabledAt   *time.Time `json:"disabled_at,omitempty"`
+	MFAEnabledAt *time.Time `json:"mfa_enabled_at,omitempty"`
+	// OIDCSubject is the identity provider's id of users provisioned by single sign-on
+	OIDCSubject string    `json:"oidc_subject,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 type UploadedImage struct {
-	ID         int       `json:"id"`
-	FilePath   string    `json:"filepath"`
-	FileHash   string    `json:"filehash"`
-	Label      string    `json:"label"`
-	NewLabel   string    `json:"new_label"`
-	Confidence float32   `json:"confidence"`
-	Reviewed   bool      `json:"reviewed"`
+	ID           int        `json:"id"`
+	TenantID     int        `json:"tenant_id"`
+	FilePath     string     `json:"filepath"`
+	URL          string     `json:"url,omitempty"`
+	ThumbnailURL string     `json:"thumbnail_url,omitempty"`
+	PreviewURL   string     `json:"preview_url,omitempty"`
+	FileHash     string     `json:"filehash"`
+	Label        string     `json:"label"`
+	NewLabel     string     `json:"new_label"`
+	Confidence   float32    `json:"confidence"`
+	Reviewed     bool       `json:"reviewed"`
+	ReviewedAt   *time.Time `json:"reviewed_at,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	// ClientName is the API client whose key uploaded the image first
+	ClientName string    `json:"client_name,omitempty"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 }
+
+// Actions recorded in ImageEvent
+const (
+	EventLabel   = "label"
+	EventRelabel = "relabel"
+	EventDelete  = "delete"
+	EventRestore = "restore"
+	EventPurge   = "purge"
+	// EventReupload is recorded when an image in the trash is uploaded again
+	EventReupload = "reupload"
+)
+
+// ImageEvent is an entry of the audit trail of review actions
+type ImageEvent struct {
+	ID            int       `json:"id"`
+	TenantID      int       `json:"tenant_id"`
+	FileHash      string    `json:"filehash"`
+	Action        string    `json:"action"`
+	Username      string    `json:"username"`
+	PreviousLabel string    `json:"previous_label"`
+	NewLabel      string    `json:"new_label"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // ErrorResponse is a common structure for API errors
 type ErrorResponse struct {
 	Error   string `json:"error"`
@@ -39,4 +41,52 @@ type StatsResponse struct {
 	AverageConfidence  float64        `json:"average_confidence"`
 	LabelDistribution  map[string]int `json:"label_distribution"`
 	LabelingEfficiency float64        `json:"labeling_efficiency_percentage"`
+	// ClientDistribution counts the images first uploaded by each API client
+	ClientDistribution map[string]int `json:"client_distribution"`
+	APIKeys            []APIKeyUsage  `json:"api_keys,omitempty"`
+	Cache              *CacheStats    `json:"cache,omitempty"`
+}
+
+type CacheStats struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	Errors   int64   `json:"errors"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// EventsResponse is a page of the audit feed, pass NextCursor as cursor to get the next one
+type EventsResponse struct {
+	Data       []ImageEvent `json:"data"`
+	Count      int          `json:"count"`
+	NextCursor int          `json:"next_cursor,omitempty"`
+}
+
+// RetentionReport lists what each retention rule would delete if it ran now
+type RetentionReport struct {
+	Enabled bool                  `json:"enabled"`
+	Rules   []RetentionRuleReport `json:"rules"`
+}
+
+type RetentionRuleReport struct {
+	Name       string          `json:"name"`
+	Label      string          `json:"label,omitempty"`
+	Reviewed   *bool           `json:"reviewed,omitempty"`
+	MaxAgeDays int             `json:"max_age_days"`
+	Cutoff     time.Time       `json:"cutoff"`
+	Matching   int             `json:"matching"`
+	Sample     []UploadedImage `json:"sample"`
+}
+
+// ReconcileReport lists where uploaded files and uploaded_images rows disagree
+type ReconcileReport struct {
+	OrphanFiles    []string `json:"orphan_files"`
+	MissingFiles   []string `json:"missing_files"`
+	MisplacedFiles []string `json:"misplaced_files"`
+	StaleTempFiles []string `json:"stale_temp_files"`
+	Repaired       bool     `json:"repaired"`
+}
+
+// Total is the number of problems found
+func (r ReconcileReport) Total() int {
+	return len(r.OrphanFiles) + len(r.MissingFiles) + len(r.MisplacedFiles) + len(r.StaleTempFiles)
 }
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+const (
+	// AllTenants stands for every tenant, it is only used by the CLI and background jobs
+	// which work on the whole database
+	AllTenants = 0
+	// NoTenant is the tenant of a context that was given none. It matches no rows, so code
+	// reached without a tenant sees and changes nothing.
+	NoTenant = -1
+	// DefaultTenantID is the tenant created with the tenants table. Existing rows, anonymous
+	// API requests and users provisioned by single sign-on belong to it.
+	DefaultTenantID = 1
+)
+
+// Tenant is one of the products sharing the service. Uploads, review queues, stats, users
+// and API keys are kept apart per tenant. An image is NSFW for a tenant when the model
+// gives it more than NSFWThreshold percent.
+type Tenant struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	NSFWThreshold float32   `json:"nsfw_threshold"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TenantPolicyRequest is the payload of POST /admin/tenant/policy
+type TenantPolicyRequest struct {
+	NSFWThreshold float32 `json:"nsfw_threshold"`
+}
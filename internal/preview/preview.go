@@ -0,0 +1,101 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+
+	_ "github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+const (
+	// thumbnailSize is the longest side of a thumbnail in pixels
+	thumbnailSize = 320
+	// pixelSize is the longest side of the blurred preview before it is scaled back up,
+	// small enough that nothing explicit survives
+	pixelSize = 12
+	// blurSigma smooths the blocks of the scaled up preview
+	blurSigma = 6
+	quality   = 80
+)
+
+// Previews are the JPEG encoded thumbnail and blurred preview of an image
+type Previews struct {
+	Thumbnail []byte
+	Blurred   []byte
+}
+
+// Generate decodes an image and renders its previews. JPEG, PNG, GIF and still WebP images
+// are supported, other formats return an error.
+func Generate(r io.Reader) (Previews, error) {
+	img, err := imaging.Decode(r, imaging.AutoOrientation(true))
+	if err != nil {
+		return Previews{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	thumb := flatten(imaging.Fit(img, thumbnailSize, thumbnailSize, imaging.Lanczos))
+	bounds := thumb.Bounds()
+
+	pixelated := imaging.Fit(thumb, pixelSize, pixelSize, imaging.Box)
+	blurred := imaging.Resize(pixelated, bounds.Dx(), bounds.Dy(), imaging.NearestNeighbor)
+	blurred = imaging.Blur(blurred, blurSigma)
+
+	var previews Previews
+	if previews.Thumbnail, err = encode(thumb); err != nil {
+		return Previews{}, err
+	}
+	if previews.Blurred, err = encode(blurred); err != nil {
+		return Previews{}, err
+	}
+
+	return previews, nil
+}
+
+// Store renders the previews of the image file at filePath and saves them under hash
+func Store(ctx context.Context, store *storage.Store, hash, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return StoreFrom(ctx, store, hash, file)
+}
+
+// StoreFrom renders the previews of the image read from r and saves them under hash
+func StoreFrom(ctx context.Context, store *storage.Store, hash string, r io.Reader) error {
+	previews, err := Generate(r)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Previews.Put(ctx, storage.ThumbnailKey(hash), bytes.NewReader(previews.Thumbnail), int64(len(previews.Thumbnail))); err != nil {
+		return fmt.Errorf("failed to store thumbnail: %w", err)
+	}
+	if err := store.Previews.Put(ctx, storage.BlurredKey(hash), bytes.NewReader(previews.Blurred), int64(len(previews.Blurred))); err != nil {
+		return fmt.Errorf("failed to store blurred preview: %w", err)
+	}
+
+	return nil
+}
+
+// flatten draws img over white, JPEG has no transparency
+func flatten(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	background := imaging.New(bounds.Dx(), bounds.Dy(), color.White)
+	return imaging.Overlay(background, img, image.Point{}, 1)
+}
+
+func encode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(quality)); err != nil {
+		return nil, fmt.Errorf("failed to encode preview: %w", err)
+	}
+	return buf.Bytes(), nil
+}
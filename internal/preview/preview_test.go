@@ -0,0 +1,120 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+// fixture is a 400x200 black and white checkerboard of 10px squares whose top left
+// 40x40 corner is transparent
+const fixture = "testdata/checkerboard.png"
+
+// decode decodes a JPEG preview, failing the test on any other format
+func decode(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("preview is not a JPEG: %v", err)
+	}
+	return img
+}
+
+// gray returns the average of the color channels at x, y on a 0-255 scale
+func gray(img image.Image, x, y int) int {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return int(r+g+b) / 3 >> 8
+}
+
+// contrast returns the largest gray difference between horizontal neighbours of img
+func contrast(img image.Image) int {
+	bounds := img.Bounds()
+	largest := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X + 1; x < bounds.Max.X; x++ {
+			d := gray(img, x, y) - gray(img, x-1, y)
+			if d < 0 {
+				d = -d
+			}
+			largest = max(largest, d)
+		}
+	}
+	return largest
+}
+
+func TestGenerate(t *testing.T) {
+	file, err := os.Open(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	previews, err := Generate(file)
+	if err != nil {
+		t.Fatalf("Generate = %v", err)
+	}
+
+	thumb := decode(t, previews.Thumbnail)
+	if size := thumb.Bounds().Size(); size != image.Pt(thumbnailSize, thumbnailSize/2) {
+		t.Fatalf("thumbnail is %v, want the longest side fit to %d and the aspect kept", size, thumbnailSize)
+	}
+	// JPEG has no alpha, the transparent corner is drawn over white
+	if g := gray(thumb, 5, 5); g < 240 {
+		t.Fatalf("transparent corner of the thumbnail has gray %d, want white", g)
+	}
+	// the squares are still there, the thumbnail is only scaled
+	if c := contrast(thumb); c < 128 {
+		t.Fatalf("largest contrast of the thumbnail = %d, want the checkerboard kept", c)
+	}
+
+	blurred := decode(t, previews.Blurred)
+	if blurred.Bounds().Size() != thumb.Bounds().Size() {
+		t.Fatalf("blurred preview is %v, want the size of the thumbnail %v", blurred.Bounds().Size(), thumb.Bounds().Size())
+	}
+	if c := contrast(blurred); c > 16 {
+		t.Fatalf("largest contrast of the blurred preview = %d, want the checkerboard smoothed out", c)
+	}
+}
+
+func TestGenerateNotAnImage(t *testing.T) {
+	if _, err := Generate(strings.NewReader("not an image")); err == nil {
+		t.Fatal("Generate accepted a file that is not an image")
+	}
+}
+
+func TestStoreFrom(t *testing.T) {
+	ctx := context.Background()
+	store := &storage.Store{Previews: storage.NewLocal(filepath.Join(t.TempDir(), "previews"))}
+	hash := strings.Repeat("a", 64)
+
+	if err := Store(ctx, store, hash, fixture); err != nil {
+		t.Fatalf("Store = %v", err)
+	}
+
+	for _, key := range []string{storage.ThumbnailKey(hash), storage.BlurredKey(hash)} {
+		obj, err := store.Previews.Open(ctx, key)
+		if err != nil {
+			t.Fatalf("preview %s not stored: %v", key, err)
+		}
+		data := new(bytes.Buffer)
+		data.ReadFrom(obj)
+		obj.Close()
+		decode(t, data.Bytes())
+	}
+
+	// a failed render stores nothing
+	other := strings.Repeat("b", 64)
+	if err := StoreFrom(ctx, store, other, strings.NewReader("not an image")); err == nil {
+		t.Fatal("StoreFrom accepted a file that is not an image")
+	}
+	if _, err := store.Previews.Stat(ctx, storage.ThumbnailKey(other)); err == nil {
+		t.Fatal("thumbnail stored for a file that is not an image")
+	}
+}
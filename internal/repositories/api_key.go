@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+type apiKeyRepo struct {
+	db     *sql.DB
+	driver string
+}
+
+func NewAPIKeyRepository(db *sql.DB, driver string) APIKeyRepository {
+	return &apiKeyRepo{db: db, driver: driver}
+}
+
+func (a *apiKeyRepo) CreateKey(ctx context.Context, k models.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO api_keys
+			(tenant_id, prefix, key_hash, client_name, rate_limit_per_min, daily_quota, monthly_quota, created_by, created_at)
+			VALUES
+			(?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := a.db.ExecContext(ctx, database.Rebind(a.driver, query),
+		k.TenantID,
+		k.Prefix,
+		k.KeyHash,
+		k.ClientName,
+		k.RateLimitPerMin,
+		k.DailyQuota,
+		k.MonthlyQuota,
+		k.CreatedBy,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	return nil
+}
+
+// apiKeyColumns lists the columns read by scanAPIKey, in order
+const apiKeyColumns = `id, tenant_id, prefix, key_hash, client_name, rate_limit_per_min, daily_quota, monthly_quota, created_by, last_used_at, revoked_at, created_at`
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var k models.APIKey
+	var lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&k.ID, &k.TenantID, &k.Prefix, &k.KeyHash, &k.ClientName, &k.RateLimitPerMin, &k.DailyQuota, &k.MonthlyQuota,
+		&k.CreatedBy, &lastUsedAt, &revokedAt, &k.CreatedAt,
+	)
+	if err != nil {
+		return k, err
+	}
+
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+
+	return k, nil
+}
+
+// GetKey returns the key with id, or nil if there is none
+func (a *apiKeyRepo) GetKey(ctx context.Context, id int) (*models.APIKey, error) {
+	return a.getKey(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id)
+}
+
+// GetKeyByHash returns the key stored under keyHash, revoked or not, or nil if there is none
+func (a *apiKeyRepo) GetKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	return a.getKey(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, keyHash)
+}
+
+func (a *apiKeyRepo) getKey(ctx context.Context, query string, arg interface{}) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	k, err := scanAPIKey(a.db.QueryRowContext(ctx, database.Rebind(a.driver, query), arg))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch api key: %w", err)
+	}
+
+	return &k, nil
+}
+
+// ListKeys returns every key of tenantID, revoked ones included, oldest first
+func (a *apiKeyRepo) ListKeys(ctx context.Context, tenantID int) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tenant, args := tenantFilter(tenantID)
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE 1 = 1` + tenant + ` ORDER BY id`
+	rows, err := a.db.QueryContext(ctx, database.Rebind(a.driver, query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeKey revokes the key with id. It returns 0 when there is no such key or it was already revoked.
+func (a *apiKeyRepo) RevokeKey(ctx context.Context, id int) (int, error) {
+	query := `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+	return a.exec(ctx, "revoke api key", query, time.Now(), id)
+}
+
+// TouchKey records that the key with id was used at at, unless that was already
+// recorded after since, so busy keys do not cause a write on every request
+func (a *apiKeyRepo) TouchKey(ctx context.Context, id int, at, since time.Time) (int, error) {
+	query := `UPDATE api_keys SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`
+	return a.exec(ctx, "record api key use", query, at, id, since)
+}
+
+func (a *apiKeyRepo) exec(ctx context.Context, what, query string, args ...interface{}) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := a.db.ExecContext(ctx, database.Rebind(a.driver, query), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to %s: %w", what, err)
+	}
+
+	return affectedRows(result)
+}
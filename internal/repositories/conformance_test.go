@@ -0,0 +1,875 @@
+package repositories_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/migrate"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// backend is a database the conformance suite runs against.
+// SQLite always runs, MySQL and PostgreSQL run when their DSN is set in the environment.
+type backend struct {
+	driver    string
+	sqlDriver string
+	dsnEnv    string
+}
+
+// tenant owns the rows of every test but TestTenants
+const tenant = models.DefaultTenantID
+
+var backends = []backend{
+	{driver: database.SQLite, sqlDriver: "sqlite3"},
+	{driver: database.MySQL, sqlDriver: "mysql", dsnEnv: "NSFW_TEST_MYSQL_DSN"},
+	{driver: database.Postgres, sqlDriver: "postgres", dsnEnv: "NSFW_TEST_POSTGRES_DSN"},
+}
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "repositories-test")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := logger.Init(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// openBackend returns a migrated, empty database for b or skips the test
+func openBackend(t *testing.T, b backend) *sql.DB {
+	t.Helper()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_foreign_keys=on"
+	if b.dsnEnv != "" {
+		dsn = os.Getenv(b.dsnEnv)
+		if dsn == "" {
+			t.Skipf("%s not set", b.dsnEnv)
+		}
+	}
+
+	conn, err := sql.Open(b.sqlDriver, dsn)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", b.driver, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	migrator, err := migrate.ForDriver(conn, b.driver)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	for _, table := range []string{"uploaded_images", "users", "image_events", "refresh_tokens", "recovery_codes", "api_keys"} {
+		if _, err := conn.Exec("DELETE FROM " + table); err != nil {
+			t.Fatalf("failed to clear %s: %v", table, err)
+		}
+	}
+	if _, err := conn.Exec("DELETE FROM tenants WHERE id <> 1"); err != nil {
+		t.Fatalf("failed to clear tenants: %v", err)
+	}
+
+	return conn
+}
+
+// forEachBackend runs fn as a subtest against a fresh database of every backend
+func forEachBackend(t *testing.T, fn func(t *testing.T, repos *repositories.Repositories)) {
+	for _, b := range backends {
+		t.Run(b.driver, func(t *testing.T) {
+			conn := openBackend(t, b)
+			fn(t, repositories.NewRepositories(conn, b.driver))
+		})
+	}
+}
+
+func TestUserRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+
+		hash, err := utils.HashPassword("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repos.User.AddUser(ctx, models.User{Username: "alice", Password: hash, Role: models.RoleReviewer, TenantID: tenant}); err != nil {
+			t.Fatalf("AddUser: %v", err)
+		}
+		if err := repos.User.AddUser(ctx, models.User{Username: "alice", Password: hash, Role: models.RoleReviewer, TenantID: tenant}); err == nil {
+			t.Fatal("AddUser accepted a duplicate username")
+		}
+
+		user, err := repos.User.CheckLogin(ctx, "alice", "secret")
+		if err != nil || user.Username != "alice" || user.Role != models.RoleReviewer {
+			t.Fatalf("CheckLogin = %+v, %v", user, err)
+		}
+		if _, err := repos.User.CheckLogin(ctx, "alice", "wrong"); err == nil {
+			t.Fatal("CheckLogin accepted a wrong password")
+		}
+		if _, err := repos.User.CheckLogin(ctx, "bob", "secret"); err == nil {
+			t.Fatal("CheckLogin accepted an unknown user")
+		}
+
+		users, err := repos.User.ListUsers(ctx, tenant)
+		if err != nil || len(users) != 1 || users[0].CreatedAt.IsZero() {
+			t.Fatalf("ListUsers = %+v, %v", users, err)
+		}
+
+		if _, err := repos.User.UpdateRole(ctx, "alice", models.RoleAdmin); err != nil {
+			t.Fatalf("UpdateRole: %v", err)
+		}
+		if users, _ := repos.User.ListUsers(ctx, tenant); users[0].Role != models.RoleAdmin {
+			t.Fatalf("role after UpdateRole = %q", users[0].Role)
+		}
+		if _, err := repos.User.UpdateRole(ctx, "bob", models.RoleAdmin); err == nil {
+			t.Fatal("UpdateRole succeeded for an unknown user")
+		}
+
+		got, err := repos.User.GetUser(ctx, "alice")
+		if err != nil || got == nil || got.Role != models.RoleAdmin || got.CreatedAt.IsZero() || got.DisabledAt != nil {
+			t.Fatalf("GetUser = %+v, %v", got, err)
+		}
+		if got, err := repos.User.GetUser(ctx, "bob"); err != nil || got != nil {
+			t.Fatalf("GetUser(unknown) = %+v, %v", got, err)
+		}
+
+		if _, err := repos.User.SetDisabled(ctx, "alice", true); err != nil {
+			t.Fatalf("SetDisabled: %v", err)
+		}
+		if _, err := repos.User.CheckLogin(ctx, "alice", "secret"); err == nil {
+			t.Fatal("CheckLogin accepted a disabled user")
+		}
+		if got, _ := repos.User.GetUser(ctx, "alice"); got.DisabledAt == nil {
+			t.Fatal("DisabledAt not set after SetDisabled")
+		}
+		if _, err := repos.User.SetDisabled(ctx, "alice", false); err != nil {
+			t.Fatalf("SetDisabled(false): %v", err)
+		}
+		if _, err := repos.User.CheckLogin(ctx, "alice", "secret"); err != nil {
+			t.Fatalf("CheckLogin after enabling: %v", err)
+		}
+
+		newHash, _ := utils.HashPassword("changed")
+		if _, err := repos.User.UpdatePassword(ctx, "alice", newHash); err != nil {
+			t.Fatalf("UpdatePassword: %v", err)
+		}
+		if _, err := repos.User.CheckLogin(ctx, "alice", "changed"); err != nil {
+			t.Fatalf("CheckLogin after UpdatePassword: %v", err)
+		}
+		if _, err := repos.User.UpdatePassword(ctx, "bob", newHash); err == nil {
+			t.Fatal("UpdatePassword succeeded for an unknown user")
+		}
+
+		if _, err := repos.User.DeleteUser(ctx, "alice"); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := repos.User.DeleteUser(ctx, "alice"); err == nil {
+			t.Fatal("DeleteUser succeeded twice")
+		}
+	})
+}
+
+func TestOIDCUsers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+
+		// local users have no subject, several of them must not collide
+		for _, name := range []string{"local1", "local2"} {
+			if err := repos.User.AddUser(ctx, models.User{Username: name, Password: "x", Role: models.RoleViewer}); err != nil {
+				t.Fatalf("AddUser(%s): %v", name, err)
+			}
+		}
+		if err := repos.User.AddUser(ctx, models.User{Username: "carol", Role: models.RoleReviewer, OIDCSubject: "sub-1"}); err != nil {
+			t.Fatalf("AddUser(sso): %v", err)
+		}
+		if err := repos.User.AddUser(ctx, models.User{Username: "carol2", Role: models.RoleReviewer, OIDCSubject: "sub-1"}); err == nil {
+			t.Fatal("AddUser accepted a duplicate subject")
+		}
+
+		got, err := repos.User.GetUserByOIDCSubject(ctx, "sub-1")
+		if err != nil || got == nil || got.Username != "carol" || got.OIDCSubject != "sub-1" {
+			t.Fatalf("GetUserByOIDCSubject = %+v, %v", got, err)
+		}
+		if got, err := repos.User.GetUserByOIDCSubject(ctx, "sub-2"); got != nil || err != nil {
+			t.Fatalf("GetUserByOIDCSubject(unknown) = %+v, %v", got, err)
+		}
+		if local, _ := repos.User.GetUser(ctx, "local1"); local.OIDCSubject != "" {
+			t.Fatalf("local user has subject %q", local.OIDCSubject)
+		}
+
+		// provisioned users have no password to log in with
+		if _, err := repos.User.CheckLogin(ctx, "carol", ""); err == nil {
+			t.Fatal("CheckLogin accepted an empty password for an SSO user")
+		}
+	})
+}
+
+func seedUploads(t *testing.T, repos *repositories.Repositories) {
+	t.Helper()
+
+	images := []models.UploadedImage{
+		{TenantID: tenant, FilePath: "a.jpg", FileHash: "a", Label: "SFW", NewLabel: "unlabeled", Confidence: 90},
+		{TenantID: tenant, FilePath: "b.jpg", FileHash: "b", Label: "NSFW", NewLabel: "unlabeled", Confidence: 80, ClientName: "acme"},
+		{TenantID: tenant, FilePath: "c.jpg", FileHash: "c", Label: "NSFW", NewLabel: "unlabeled", Confidence: 70, ClientName: "acme"},
+	}
+
+	for _, img := range images {
+		if n, err := repos.Uploaded.UploadImage(context.Background(), img); err != nil || n != 1 {
+			t.Fatalf("UploadImage(%s) = %d, %v", img.FileHash, n, err)
+		}
+	}
+}
+
+func TestUploadedRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		seedUploads(t, repos)
+
+		// duplicates are skipped without writing anything
+		n, err := repos.Uploaded.UploadImage(ctx, models.UploadedImage{TenantID: tenant, FilePath: "a.jpg", FileHash: "a", Label: "SFW", NewLabel: "unlabeled"})
+		if err != nil || n != 0 {
+			t.Fatalf("UploadImage duplicate = %d, %v", n, err)
+		}
+
+		uploads, err := repos.Uploaded.ListUploadsCursor(ctx, tenant, 1<<30, 10, nil)
+		if err != nil || len(uploads) != 3 {
+			t.Fatalf("ListUploadsCursor = %d uploads, %v", len(uploads), err)
+		}
+		if uploads[0].FileHash != "c" || uploads[2].FileHash != "a" {
+			t.Fatalf("ListUploadsCursor not ordered newest first: %s..%s", uploads[0].FileHash, uploads[2].FileHash)
+		}
+
+		page, err := repos.Uploaded.ListUploadsCursor(ctx, tenant, uploads[0].ID, 1, nil)
+		if err != nil || len(page) != 1 || page[0].FileHash != "b" {
+			t.Fatalf("ListUploadsCursor page = %+v, %v", page, err)
+		}
+
+		if _, err := repos.Uploaded.LabelUpload(ctx, tenant, "b", "SFW", "alice"); err != nil {
+			t.Fatalf("LabelUpload: %v", err)
+		}
+		if _, err := repos.Uploaded.LabelUpload(ctx, tenant, "missing", "SFW", "alice"); err == nil {
+			t.Fatal("LabelUpload succeeded for an unknown hash")
+		}
+
+		img, err := repos.Uploaded.GetImageByHash(ctx, tenant, "b")
+		if err != nil || img == nil || !img.Reviewed || img.NewLabel != "SFW" || img.Label != "NSFW" || img.ReviewedAt == nil || img.ClientName != "acme" {
+			t.Fatalf("GetImageByHash = %+v, %v", img, err)
+		}
+		img, err = repos.Uploaded.GetImageByHash(ctx, tenant, "a")
+		if err != nil || img == nil || img.Reviewed || img.ReviewedAt != nil || img.ClientName != "" {
+			t.Fatalf("GetImageByHash(unreviewed) = %+v, %v", img, err)
+		}
+		img, err = repos.Uploaded.GetImageByHash(ctx, tenant, "missing")
+		if err != nil || img != nil {
+			t.Fatalf("GetImageByHash(missing) = %+v, %v", img, err)
+		}
+
+		reviewed := true
+		total, err := repos.Uploaded.ListTotalUploads(ctx, tenant, &reviewed)
+		if err != nil || total != 1 {
+			t.Fatalf("ListTotalUploads(reviewed) = %d, %v", total, err)
+		}
+		list, err := repos.Uploaded.ListUploadsCursor(ctx, tenant, 1<<30, 10, &reviewed)
+		if err != nil || len(list) != 1 || !list[0].Reviewed || list[0].NewLabel != "SFW" || list[0].ReviewedAt == nil {
+			t.Fatalf("ListUploadsCursor(reviewed) = %+v, %v", list, err)
+		}
+
+		path, err := repos.Uploaded.GetFilePathByHash(ctx, tenant, "a")
+		if err != nil || path != "a.jpg" {
+			t.Fatalf("GetFilePathByHash = %q, %v", path, err)
+		}
+		path, err = repos.Uploaded.GetFilePathByHash(ctx, tenant, "missing")
+		if err != nil || path != "" {
+			t.Fatalf("GetFilePathByHash(missing) = %q, %v", path, err)
+		}
+
+		if _, err := repos.Uploaded.DeleteImage(ctx, tenant, "a", "alice"); err != nil {
+			t.Fatalf("DeleteImage: %v", err)
+		}
+		if _, err := repos.Uploaded.DeleteImage(ctx, tenant, "a", "alice"); err == nil {
+			t.Fatal("DeleteImage succeeded twice")
+		}
+
+		total, err = repos.Uploaded.ListTotalUploads(ctx, tenant, nil)
+		if err != nil || total != 2 {
+			t.Fatalf("ListTotalUploads = %d, %v", total, err)
+		}
+	})
+}
+
+func TestStatsRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+
+		avg, err := repos.Stats.AverageConfidence(ctx, tenant)
+		if err != nil || avg != 0 {
+			t.Fatalf("AverageConfidence on empty table = %v, %v", avg, err)
+		}
+
+		seedUploads(t, repos)
+		repos.Uploaded.LabelUpload(ctx, tenant, "b", "NSFW", "alice")
+		repos.Uploaded.LabelUpload(ctx, tenant, "c", "SFW", "alice")
+
+		reviewed, unreviewed, err := repos.Stats.CountRevNonRevImages(ctx, tenant)
+		if err != nil || reviewed != 2 || unreviewed != 1 {
+			t.Fatalf("CountRevNonRevImages = %d, %d, %v", reviewed, unreviewed, err)
+		}
+
+		avg, err = repos.Stats.AverageConfidence(ctx, tenant)
+		if err != nil || avg < 79.9 || avg > 80.1 {
+			t.Fatalf("AverageConfidence = %v, %v", avg, err)
+		}
+
+		dist, err := repos.Stats.LabelDistribution(ctx, tenant)
+		if err != nil || dist["NSFW"] != 1 || dist["SFW"] != 1 {
+			t.Fatalf("LabelDistribution = %v, %v", dist, err)
+		}
+
+		efficiency, err := repos.Stats.LabelingEfficiency(ctx, tenant)
+		if err != nil || efficiency < 33.3 || efficiency > 33.4 {
+			t.Fatalf("LabelingEfficiency = %v, %v", efficiency, err)
+		}
+
+		clients, err := repos.Stats.ClientDistribution(ctx, tenant)
+		if err != nil || len(clients) != 1 || clients["acme"] != 2 {
+			t.Fatalf("ClientDistribution = %v, %v", clients, err)
+		}
+	})
+}
+
+func TestEventRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		seedUploads(t, repos)
+
+		repos.Uploaded.LabelUpload(ctx, tenant, "a", "NSFW", "alice")
+		repos.Uploaded.LabelUpload(ctx, tenant, "a", "SFW", "bob")
+		repos.Uploaded.LabelUpload(ctx, tenant, "b", "SFW", "alice")
+		repos.Uploaded.DeleteImage(ctx, tenant, "a", "carol")
+
+		// failed changes leave no trace
+		repos.Uploaded.LabelUpload(ctx, tenant, "missing", "SFW", "alice")
+		repos.Uploaded.DeleteImage(ctx, tenant, "missing", "alice")
+
+		history, err := repos.Events.ListImageEvents(ctx, tenant, "a")
+		if err != nil || len(history) != 3 {
+			t.Fatalf("ListImageEvents = %+v, %v", history, err)
+		}
+
+		want := []models.ImageEvent{
+			{Action: models.EventLabel, Username: "alice", PreviousLabel: "unlabeled", NewLabel: "NSFW"},
+			{Action: models.EventRelabel, Username: "bob", PreviousLabel: "NSFW", NewLabel: "SFW"},
+			{Action: models.EventDelete, Username: "carol", PreviousLabel: "SFW", NewLabel: ""},
+		}
+		for i, w := range want {
+			got := history[i]
+			if got.FileHash != "a" || got.Action != w.Action || got.Username != w.Username ||
+				got.PreviousLabel != w.PreviousLabel || got.NewLabel != w.NewLabel || got.CreatedAt.IsZero() {
+				t.Fatalf("event %d = %+v, want %+v", i, got, w)
+			}
+		}
+
+		feed, err := repos.Events.ListEventsCursor(ctx, tenant, 1<<30, 10)
+		if err != nil || len(feed) != 4 || feed[0].Action != models.EventDelete {
+			t.Fatalf("ListEventsCursor = %+v, %v", feed, err)
+		}
+
+		page, err := repos.Events.ListEventsCursor(ctx, tenant, feed[1].ID, 10)
+		if err != nil || len(page) != 2 || page[0].ID != feed[2].ID {
+			t.Fatalf("ListEventsCursor page = %+v, %v", page, err)
+		}
+	})
+}
+
+func TestRefreshTokenRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+		if got, err := repos.Tokens.GetToken(ctx, "missing"); got != nil || err != nil {
+			t.Fatalf("GetToken(missing) = %+v, %v", got, err)
+		}
+
+		for _, tok := range []models.RefreshToken{
+			{TokenHash: "a1", FamilyID: "fa", Username: "alice", ExpiresAt: expires},
+			{TokenHash: "a2", FamilyID: "fa", Username: "alice", ExpiresAt: expires},
+			{TokenHash: "b1", FamilyID: "fb", Username: "alice", ExpiresAt: expires},
+			{TokenHash: "c1", FamilyID: "fc", Username: "bob", ExpiresAt: time.Now().Add(-time.Hour)},
+		} {
+			if err := repos.Tokens.CreateToken(ctx, tok); err != nil {
+				t.Fatalf("CreateToken: %v", err)
+			}
+		}
+		if err := repos.Tokens.CreateToken(ctx, models.RefreshToken{TokenHash: "a1", FamilyID: "fx", Username: "x", ExpiresAt: expires}); err == nil {
+			t.Fatal("CreateToken with a duplicate hash succeeded")
+		}
+
+		tok, err := repos.Tokens.GetToken(ctx, "a1")
+		if err != nil || tok == nil || tok.FamilyID != "fa" || tok.Username != "alice" || !tok.ExpiresAt.Equal(expires) ||
+			tok.UsedAt != nil || tok.RevokedAt != nil || tok.CreatedAt.IsZero() {
+			t.Fatalf("GetToken = %+v, %v", tok, err)
+		}
+
+		// a token is only used once
+		if n, err := repos.Tokens.MarkUsed(ctx, tok.ID); n != 1 || err != nil {
+			t.Fatalf("MarkUsed = %d, %v", n, err)
+		}
+		if n, err := repos.Tokens.MarkUsed(ctx, tok.ID); n != 0 || err != nil {
+			t.Fatalf("MarkUsed(again) = %d, %v", n, err)
+		}
+		if tok, _ := repos.Tokens.GetToken(ctx, "a1"); tok.UsedAt == nil {
+			t.Fatal("used token has no used_at")
+		}
+
+		if n, err := repos.Tokens.RevokeFamily(ctx, "fa"); n != 2 || err != nil {
+			t.Fatalf("RevokeFamily = %d, %v", n, err)
+		}
+		a2, _ := repos.Tokens.GetToken(ctx, "a2")
+		if a2.RevokedAt == nil {
+			t.Fatal("revoked token has no revoked_at")
+		}
+		if n, err := repos.Tokens.MarkUsed(ctx, a2.ID); n != 0 || err != nil {
+			t.Fatalf("MarkUsed(revoked) = %d, %v", n, err)
+		}
+
+		if n, err := repos.Tokens.RevokeUser(ctx, "alice"); n != 1 || err != nil {
+			t.Fatalf("RevokeUser = %d, %v", n, err)
+		}
+
+		if n, err := repos.Tokens.DeleteExpired(ctx, time.Now()); n != 1 || err != nil {
+			t.Fatalf("DeleteExpired = %d, %v", n, err)
+		}
+		if tok, _ := repos.Tokens.GetToken(ctx, "c1"); tok != nil {
+			t.Fatalf("expired token still stored: %+v", tok)
+		}
+	})
+}
+
+func TestMFARepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		if err := repos.User.AddUser(ctx, models.User{Username: "alice", Password: "x", Role: models.RoleReviewer}); err != nil {
+			t.Fatalf("AddUser: %v", err)
+		}
+
+		if totp, err := repos.MFA.GetTOTP(ctx, "alice"); totp != nil || err != nil {
+			t.Fatalf("GetTOTP(no secret) = %+v, %v", totp, err)
+		}
+		if totp, err := repos.MFA.GetTOTP(ctx, "missing"); totp != nil || err != nil {
+			t.Fatalf("GetTOTP(missing) = %+v, %v", totp, err)
+		}
+		if err := repos.MFA.EnableTOTP(ctx, "alice", 1, nil); err == nil {
+			t.Fatal("EnableTOTP without a pending secret succeeded")
+		}
+
+		if n, err := repos.MFA.SetPendingTOTP(ctx, "alice", "SECRET"); n != 1 || err != nil {
+			t.Fatalf("SetPendingTOTP = %d, %v", n, err)
+		}
+		totp, err := repos.MFA.GetTOTP(ctx, "alice")
+		if err != nil || totp == nil || totp.Secret != "SECRET" || totp.EnabledAt != nil {
+			t.Fatalf("GetTOTP(pending) = %+v, %v", totp, err)
+		}
+
+		if err := repos.MFA.EnableTOTP(ctx, "alice", 100, []string{"h1", "h2", "h3"}); err != nil {
+			t.Fatalf("EnableTOTP: %v", err)
+		}
+		totp, _ = repos.MFA.GetTOTP(ctx, "alice")
+		if totp.EnabledAt == nil || totp.LastStep != 100 {
+			t.Fatalf("GetTOTP(enabled) = %+v", totp)
+		}
+		if user, _ := repos.User.GetUser(ctx, "alice"); user.MFAEnabledAt == nil {
+			t.Fatal("GetUser does not report 2FA as enabled")
+		}
+
+		// an enabled secret is not replaced by a new enrollment
+		if n, err := repos.MFA.SetPendingTOTP(ctx, "alice", "OTHER"); n != 0 || err != nil {
+			t.Fatalf("SetPendingTOTP(enabled) = %d, %v", n, err)
+		}
+
+		// steps only move forward
+		if n, err := repos.MFA.UseTOTPStep(ctx, "alice", 100); n != 0 || err != nil {
+			t.Fatalf("UseTOTPStep(same) = %d, %v", n, err)
+		}
+		if n, err := repos.MFA.UseTOTPStep(ctx, "alice", 101); n != 1 || err != nil {
+			t.Fatalf("UseTOTPStep(next) = %d, %v", n, err)
+		}
+
+		if n, err := repos.MFA.UseRecoveryCode(ctx, "alice", "h2"); n != 1 || err != nil {
+			t.Fatalf("UseRecoveryCode = %d, %v", n, err)
+		}
+		if n, err := repos.MFA.UseRecoveryCode(ctx, "alice", "h2"); n != 0 || err != nil {
+			t.Fatalf("UseRecoveryCode(again) = %d, %v", n, err)
+		}
+		if n, err := repos.MFA.CountRecoveryCodes(ctx, "alice"); n != 2 || err != nil {
+			t.Fatalf("CountRecoveryCodes = %d, %v", n, err)
+		}
+
+		if err := repos.MFA.ReplaceRecoveryCodes(ctx, "alice", []string{"h4"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+		if n, _ := repos.MFA.UseRecoveryCode(ctx, "alice", "h1"); n != 0 {
+			t.Fatal("replaced recovery code still works")
+		}
+		if n, _ := repos.MFA.CountRecoveryCodes(ctx, "alice"); n != 1 {
+			t.Fatalf("CountRecoveryCodes after replace = %d", n)
+		}
+
+		if n, err := repos.MFA.ClearTOTP(ctx, "alice"); n != 1 || err != nil {
+			t.Fatalf("ClearTOTP = %d, %v", n, err)
+		}
+		if totp, _ := repos.MFA.GetTOTP(ctx, "alice"); totp != nil {
+			t.Fatalf("GetTOTP after clear = %+v", totp)
+		}
+		if n, _ := repos.MFA.CountRecoveryCodes(ctx, "alice"); n != 0 {
+			t.Fatalf("CountRecoveryCodes after clear = %d", n)
+		}
+
+		// deleting a user takes their recovery codes along
+		repos.MFA.SetPendingTOTP(ctx, "alice", "SECRET")
+		repos.MFA.EnableTOTP(ctx, "alice", 1, []string{"h5"})
+		if _, err := repos.User.DeleteUser(ctx, "alice"); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if n, _ := repos.MFA.CountRecoveryCodes(ctx, "alice"); n != 0 {
+			t.Fatalf("CountRecoveryCodes after delete = %d", n)
+		}
+	})
+}
+
+func TestAPIKeyRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+
+		key := models.APIKey{TenantID: tenant, Prefix: "nsfw_abc", KeyHash: "h1", ClientName: "acme", RateLimitPerMin: 60, DailyQuota: 1000, CreatedBy: "alice"}
+		if err := repos.APIKeys.CreateKey(ctx, key); err != nil {
+			t.Fatalf("CreateKey: %v", err)
+		}
+		if err := repos.APIKeys.CreateKey(ctx, key); err == nil {
+			t.Fatal("CreateKey succeeded twice with the same hash")
+		}
+
+		got, err := repos.APIKeys.GetKeyByHash(ctx, "h1")
+		if err != nil || got == nil || got.ClientName != "acme" || got.RateLimitPerMin != 60 || got.DailyQuota != 1000 || got.MonthlyQuota != 0 || got.LastUsedAt != nil {
+			t.Fatalf("GetKeyByHash = %+v, %v", got, err)
+		}
+		if missing, err := repos.APIKeys.GetKeyByHash(ctx, "missing"); missing != nil || err != nil {
+			t.Fatalf("GetKeyByHash(missing) = %+v, %v", missing, err)
+		}
+		if byID, err := repos.APIKeys.GetKey(ctx, got.ID); byID == nil || byID.KeyHash != "h1" || err != nil {
+			t.Fatalf("GetKey = %+v, %v", byID, err)
+		}
+
+		// last use is only written once per period
+		now := time.Now()
+		if n, err := repos.APIKeys.TouchKey(ctx, got.ID, now, now.Add(-time.Minute)); n != 1 || err != nil {
+			t.Fatalf("TouchKey = %d, %v", n, err)
+		}
+		if n, err := repos.APIKeys.TouchKey(ctx, got.ID, now.Add(time.Second), now.Add(-time.Minute)); n != 0 || err != nil {
+			t.Fatalf("TouchKey(recent) = %d, %v", n, err)
+		}
+
+		if n, err := repos.APIKeys.RevokeKey(ctx, got.ID); n != 1 || err != nil {
+			t.Fatalf("RevokeKey = %d, %v", n, err)
+		}
+		if n, err := repos.APIKeys.RevokeKey(ctx, got.ID); n != 0 || err != nil {
+			t.Fatalf("RevokeKey(again) = %d, %v", n, err)
+		}
+
+		repos.APIKeys.CreateKey(ctx, models.APIKey{TenantID: tenant, Prefix: "nsfw_def", KeyHash: "h2", ClientName: "other", CreatedBy: "alice"})
+		keys, err := repos.APIKeys.ListKeys(ctx, tenant)
+		if err != nil || len(keys) != 2 || keys[0].RevokedAt == nil || keys[0].LastUsedAt == nil || keys[1].ClientName != "other" {
+			t.Fatalf("ListKeys = %+v, %v", keys, err)
+		}
+	})
+}
+
+func TestTrash(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		seedUploads(t, repos)
+
+		repos.Uploaded.LabelUpload(ctx, tenant, "a", "NSFW", "alice")
+		if _, err := repos.Uploaded.DeleteImage(ctx, tenant, "a", "alice"); err != nil {
+			t.Fatalf("DeleteImage: %v", err)
+		}
+
+		// trashed images are hidden from listings, lookups and stats
+		total, err := repos.Uploaded.ListTotalUploads(ctx, tenant, nil)
+		if err != nil || total != 2 {
+			t.Fatalf("ListTotalUploads = %d, %v", total, err)
+		}
+		path, err := repos.Uploaded.GetFilePathByHash(ctx, tenant, "a")
+		if err != nil || path != "" {
+			t.Fatalf("GetFilePathByHash(trashed) = %q, %v", path, err)
+		}
+		reviewed, _, err := repos.Stats.CountRevNonRevImages(ctx, tenant)
+		if err != nil || reviewed != 0 {
+			t.Fatalf("CountRevNonRevImages = %d, %v", reviewed, err)
+		}
+		if _, err := repos.Uploaded.LabelUpload(ctx, tenant, "a", "SFW", "alice"); err == nil {
+			t.Fatal("LabelUpload succeeded on a trashed image")
+		}
+
+		img, err := repos.Uploaded.GetImageByHash(ctx, tenant, "a")
+		if err != nil || img == nil || img.DeletedAt == nil {
+			t.Fatalf("GetImageByHash(trashed) = %+v, %v", img, err)
+		}
+
+		trash, err := repos.Uploaded.ListTrashCursor(ctx, tenant, 1<<30, 10)
+		if err != nil || len(trash) != 1 || trash[0].FileHash != "a" {
+			t.Fatalf("ListTrashCursor = %+v, %v", trash, err)
+		}
+		count, err := repos.Uploaded.CountTrash(ctx, tenant)
+		if err != nil || count != 1 {
+			t.Fatalf("CountTrash = %d, %v", count, err)
+		}
+
+		if _, err := repos.Uploaded.RestoreImage(ctx, tenant, "a", "bob"); err != nil {
+			t.Fatalf("RestoreImage: %v", err)
+		}
+		if _, err := repos.Uploaded.RestoreImage(ctx, tenant, "a", "bob"); err == nil {
+			t.Fatal("RestoreImage succeeded on an image not in the trash")
+		}
+		img, err = repos.Uploaded.GetImageByHash(ctx, tenant, "a")
+		if err != nil || img.DeletedAt != nil || img.NewLabel != "NSFW" {
+			t.Fatalf("GetImageByHash(restored) = %+v, %v", img, err)
+		}
+
+		repos.Uploaded.DeleteImage(ctx, tenant, "a", "alice")
+		repos.Uploaded.DeleteImage(ctx, tenant, "b", "alice")
+
+		expired, err := repos.Uploaded.ListTrashedBefore(ctx, time.Now().Add(-time.Hour), 10)
+		if err != nil || len(expired) != 0 {
+			t.Fatalf("ListTrashedBefore(past) = %+v, %v", expired, err)
+		}
+		expired, err = repos.Uploaded.ListTrashedBefore(ctx, time.Now().Add(time.Hour), 10)
+		if err != nil || len(expired) != 2 {
+			t.Fatalf("ListTrashedBefore(future) = %+v, %v", expired, err)
+		}
+
+		if _, err := repos.Uploaded.PurgeImage(ctx, tenant, "c", "system"); err == nil {
+			t.Fatal("PurgeImage removed an image that is not in the trash")
+		}
+		if _, err := repos.Uploaded.PurgeImage(ctx, tenant, "a", "system"); err != nil {
+			t.Fatalf("PurgeImage: %v", err)
+		}
+		if img, err := repos.Uploaded.GetImageByHash(ctx, tenant, "a"); err != nil || img != nil {
+			t.Fatalf("GetImageByHash(purged) = %+v, %v", img, err)
+		}
+
+		history, err := repos.Events.ListImageEvents(ctx, tenant, "a")
+		if err != nil || len(history) != 5 || history[2].Action != models.EventRestore || history[4].Action != models.EventPurge {
+			t.Fatalf("ListImageEvents = %+v, %v", history, err)
+		}
+
+		// uploading a trashed image again brings it back as a new upload waiting for review
+		repos.Uploaded.LabelUpload(ctx, tenant, "c", "SFW", "alice")
+		repos.Uploaded.DeleteImage(ctx, tenant, "c", "alice")
+		n, err := repos.Uploaded.UploadImage(ctx, models.UploadedImage{TenantID: tenant, FilePath: "c.png", FileHash: "c", Label: "SFW", NewLabel: "unlabeled", Confidence: 55, ClientName: "shop-app"})
+		if err != nil || n != 1 {
+			t.Fatalf("UploadImage(trashed) = %d, %v", n, err)
+		}
+		img, err = repos.Uploaded.GetImageByHash(ctx, tenant, "c")
+		if err != nil || img == nil || img.DeletedAt != nil || img.Reviewed || img.ReviewedAt != nil || img.NewLabel != "unlabeled" ||
+			img.Label != "SFW" || img.FilePath != "c.png" || img.ClientName != "shop-app" {
+			t.Fatalf("GetImageByHash(uploaded again) = %+v, %v", img, err)
+		}
+		history, err = repos.Events.ListImageEvents(ctx, tenant, "c")
+		if err != nil || len(history) != 3 || history[2].Action != models.EventReupload || history[2].Username != "shop-app" {
+			t.Fatalf("ListImageEvents(uploaded again) = %+v, %v", history, err)
+		}
+	})
+}
+
+func TestRetention(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+		seedUploads(t, repos)
+
+		// a reviewed image matches on its human label, not the model's
+		repos.Uploaded.LabelUpload(ctx, tenant, "b", "SFW", "alice")
+		repos.Uploaded.DeleteImage(ctx, tenant, "c", "alice")
+
+		future := time.Now().Add(time.Hour)
+		reviewed := true
+
+		tests := []struct {
+			name     string
+			label    string
+			reviewed *bool
+			before   time.Time
+			want     int
+		}{
+			{"past cutoff", "", nil, time.Now().Add(-time.Hour), 0},
+			{"any", "", nil, future, 2},
+			{"effective label", "SFW", nil, future, 2},
+			{"model label", "NSFW", nil, future, 0},
+			{"reviewed only", "SFW", &reviewed, future, 1},
+		}
+
+		for _, tt := range tests {
+			count, err := repos.Uploaded.CountExpired(ctx, tenant, tt.label, tt.reviewed, tt.before)
+			if err != nil || count != tt.want {
+				t.Fatalf("CountExpired(%s) = %d, %v, want %d", tt.name, count, err, tt.want)
+			}
+
+			images, err := repos.Uploaded.ListExpired(ctx, tenant, tt.label, tt.reviewed, tt.before, 10)
+			if err != nil || len(images) != tt.want {
+				t.Fatalf("ListExpired(%s) = %+v, %v, want %d", tt.name, images, err, tt.want)
+			}
+		}
+
+		images, err := repos.Uploaded.ListExpired(ctx, tenant, "", nil, future, 1)
+		if err != nil || len(images) != 1 || images[0].FileHash != "a" {
+			t.Fatalf("ListExpired(limit) = %+v, %v", images, err)
+		}
+	})
+}
+
+func TestTenants(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repos *repositories.Repositories) {
+		ctx := context.Background()
+
+		def, err := repos.Tenants.GetTenant(ctx, models.DefaultTenantID)
+		if err != nil || def == nil || def.Name != "default" || def.NSFWThreshold != 50 {
+			t.Fatalf("GetTenant(default) = %+v, %v", def, err)
+		}
+
+		if err := repos.Tenants.CreateTenant(ctx, models.Tenant{Name: "shop", NSFWThreshold: 80}); err != nil {
+			t.Fatalf("CreateTenant: %v", err)
+		}
+		if err := repos.Tenants.CreateTenant(ctx, models.Tenant{Name: "shop", NSFWThreshold: 80}); err == nil {
+			t.Fatal("CreateTenant accepted a duplicate name")
+		}
+		shop, err := repos.Tenants.GetTenantByName(ctx, "shop")
+		if err != nil || shop == nil || shop.ID == models.DefaultTenantID || shop.NSFWThreshold != 80 || shop.CreatedAt.IsZero() {
+			t.Fatalf("GetTenantByName = %+v, %v", shop, err)
+		}
+		if missing, err := repos.Tenants.GetTenantByName(ctx, "missing"); missing != nil || err != nil {
+			t.Fatalf("GetTenantByName(missing) = %+v, %v", missing, err)
+		}
+		if n, err := repos.Tenants.UpdatePolicy(ctx, shop.ID, 65); n != 1 || err != nil {
+			t.Fatalf("UpdatePolicy = %d, %v", n, err)
+		}
+		if tenants, err := repos.Tenants.ListTenants(ctx); err != nil || len(tenants) != 2 || tenants[1].NSFWThreshold != 65 {
+			t.Fatalf("ListTenants = %+v, %v", tenants, err)
+		}
+
+		// both tenants upload the same image, each gets a row of their own
+		seedUploads(t, repos)
+		_, err = repos.Uploaded.UploadImage(ctx, models.UploadedImage{TenantID: shop.ID, FilePath: "tenants/2/a.jpg", FileHash: "a", Label: "NSFW", NewLabel: "unlabeled", Confidence: 60, ClientName: "shop-app"})
+		if err != nil {
+			t.Fatalf("UploadImage(shop): %v", err)
+		}
+
+		if total, err := repos.Uploaded.ListTotalUploads(ctx, shop.ID, nil); err != nil || total != 1 {
+			t.Fatalf("ListTotalUploads(shop) = %d, %v", total, err)
+		}
+		if total, err := repos.Uploaded.ListTotalUploads(ctx, models.AllTenants, nil); err != nil || total != 4 {
+			t.Fatalf("ListTotalUploads(all) = %d, %v", total, err)
+		}
+		uploads, err := repos.Uploaded.ListUploadsCursor(ctx, shop.ID, 1<<30, 10, nil)
+		if err != nil || len(uploads) != 1 || uploads[0].TenantID != shop.ID || uploads[0].FilePath != "tenants/2/a.jpg" {
+			t.Fatalf("ListUploadsCursor(shop) = %+v, %v", uploads, err)
+		}
+		if n, err := repos.Uploaded.CountImagesByHash(ctx, "a"); n != 2 || err != nil {
+			t.Fatalf("CountImagesByHash = %d, %v", n, err)
+		}
+
+		// labels, trash and history of one tenant leave the other alone
+		if _, err := repos.Uploaded.LabelUpload(ctx, shop.ID, "a", "SFW", "sam"); err != nil {
+			t.Fatalf("LabelUpload(shop): %v", err)
+		}
+		if _, err := repos.Uploaded.LabelUpload(ctx, shop.ID, "b", "SFW", "sam"); err == nil {
+			t.Fatal("LabelUpload labeled an image of another tenant")
+		}
+		if img, _ := repos.Uploaded.GetImageByHash(ctx, tenant, "a"); img.Reviewed {
+			t.Fatal("labeling the image of one tenant reviewed it for the other")
+		}
+		if img, _ := repos.Uploaded.GetImageByHash(ctx, shop.ID, "b"); img != nil {
+			t.Fatalf("GetImageByHash returned an image of another tenant: %+v", img)
+		}
+		if _, err := repos.Uploaded.DeleteImage(ctx, shop.ID, "a", "sam"); err != nil {
+			t.Fatalf("DeleteImage(shop): %v", err)
+		}
+		if path, _ := repos.Uploaded.GetFilePathByHash(ctx, tenant, "a"); path != "a.jpg" {
+			t.Fatalf("GetFilePathByHash(default) after the other tenant deleted = %q", path)
+		}
+		if count, err := repos.Uploaded.CountTrash(ctx, tenant); count != 0 || err != nil {
+			t.Fatalf("CountTrash(default) = %d, %v", count, err)
+		}
+		if trash, err := repos.Uploaded.ListTrashCursor(ctx, shop.ID, 1<<30, 10); err != nil || len(trash) != 1 {
+			t.Fatalf("ListTrashCursor(shop) = %+v, %v", trash, err)
+		}
+
+		history, err := repos.Events.ListImageEvents(ctx, shop.ID, "a")
+		if err != nil || len(history) != 2 || history[0].TenantID != shop.ID || history[1].Action != models.EventDelete {
+			t.Fatalf("ListImageEvents(shop) = %+v, %v", history, err)
+		}
+		if history, _ := repos.Events.ListImageEvents(ctx, tenant, "a"); len(history) != 0 {
+			t.Fatalf("ListImageEvents(default) = %+v", history)
+		}
+		if feed, _ := repos.Events.ListEventsCursor(ctx, models.AllTenants, 1<<30, 10); len(feed) != 2 {
+			t.Fatalf("ListEventsCursor(all) = %+v", feed)
+		}
+
+		if _, err := repos.Uploaded.PurgeImage(ctx, shop.ID, "a", "system"); err != nil {
+			t.Fatalf("PurgeImage(shop): %v", err)
+		}
+		if n, _ := repos.Uploaded.CountImagesByHash(ctx, "a"); n != 1 {
+			t.Fatalf("CountImagesByHash after purge = %d", n)
+		}
+
+		repos.Uploaded.UploadImage(ctx, models.UploadedImage{TenantID: shop.ID, FilePath: "tenants/2/d.jpg", FileHash: "d", Label: "NSFW", NewLabel: "unlabeled", Confidence: 60, ClientName: "shop-app"})
+		if clients, err := repos.Stats.ClientDistribution(ctx, shop.ID); err != nil || len(clients) != 1 || clients["shop-app"] != 1 {
+			t.Fatalf("ClientDistribution(shop) = %v, %v", clients, err)
+		}
+		if avg, err := repos.Stats.AverageConfidence(ctx, shop.ID); err != nil || avg < 59.9 || avg > 60.1 {
+			t.Fatalf("AverageConfidence(shop) = %v, %v", avg, err)
+		}
+		if count, err := repos.Uploaded.CountExpired(ctx, shop.ID, "", nil, time.Now().Add(time.Hour)); count != 1 || err != nil {
+			t.Fatalf("CountExpired(shop) = %d, %v", count, err)
+		}
+
+		// users and keys belong to one tenant
+		repos.User.AddUser(ctx, models.User{Username: "alice", Password: "x", Role: models.RoleReviewer, TenantID: tenant})
+		repos.User.AddUser(ctx, models.User{Username: "sam", Password: "x", Role: models.RoleReviewer, TenantID: shop.ID})
+		if users, err := repos.User.ListUsers(ctx, shop.ID); err != nil || len(users) != 1 || users[0].Username != "sam" || users[0].TenantID != shop.ID {
+			t.Fatalf("ListUsers(shop) = %+v, %v", users, err)
+		}
+		if _, err := repos.User.SetTenant(ctx, "alice", shop.ID); err != nil {
+			t.Fatalf("SetTenant: %v", err)
+		}
+		if users, _ := repos.User.ListUsers(ctx, models.AllTenants); len(users) != 2 {
+			t.Fatalf("ListUsers(all) = %+v", users)
+		}
+		if users, _ := repos.User.ListUsers(ctx, tenant); len(users) != 0 {
+			t.Fatalf("ListUsers(default) after SetTenant = %+v", users)
+		}
+
+		repos.APIKeys.CreateKey(ctx, models.APIKey{TenantID: shop.ID, Prefix: "nsfw_shp", KeyHash: "hs", ClientName: "shop-app", CreatedBy: "sam"})
+		if key, _ := repos.APIKeys.GetKeyByHash(ctx, "hs"); key == nil || key.TenantID != shop.ID {
+			t.Fatalf("GetKeyByHash(shop) = %+v", key)
+		}
+		if keys, _ := repos.APIKeys.ListKeys(ctx, tenant); len(keys) != 0 {
+			t.Fatalf("ListKeys(default) = %+v", keys)
+		}
+	})
+}
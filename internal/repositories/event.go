@@ -0,0 +1,121 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+type eventRepo struct {
+	db     *sql.DB
+	driver string
+}
+
+func NewEventRepository(db *sql.DB, driver string) EventRepository {
+	return &eventRepo{db: db, driver: driver}
+}
+
+// insertEvent records e as part of txn, so the audit entry is only kept if the change is
+func insertEvent(ctx context.Context, txn *sql.Tx, driver string, e models.ImageEvent) error {
+	query := `INSERT INTO image_events
+			(tenant_id, file_hash, action, username, previous_label, new_label, created_at)
+			VALUES
+			(?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := txn.ExecContext(ctx, database.Rebind(driver, query),
+		e.TenantID,
+		e.FileHash,
+		e.Action,
+		e.Username,
+		e.PreviousLabel,
+		e.NewLabel,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record %s event: %w", e.Action, err)
+	}
+
+	return nil
+}
+
+// ListImageEvents returns the history of an image of tenantID, oldest first
+func (e *eventRepo) ListImageEvents(ctx context.Context, tenantID int, hash string) ([]models.ImageEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tenant, args := tenantFilter(tenantID)
+	query := `
+		SELECT 
+			` + eventColumns + ` 
+		FROM 
+			image_events
+		WHERE 
+			file_hash = ?` + tenant + `
+		ORDER BY
+			id ASC
+	`
+
+	return e.query(ctx, query, append([]interface{}{hash}, args...)...)
+}
+
+// ListEventsCursor returns up to limit events of tenantID older than cursorID, newest first
+func (e *eventRepo) ListEventsCursor(ctx context.Context, tenantID, cursorID, limit int) ([]models.ImageEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tenant, args := tenantFilter(tenantID)
+	query := `
+		SELECT 
+			` + eventColumns + ` 
+		FROM 
+			image_events
+		WHERE 
+			id < ?` + tenant + `
+		ORDER BY
+			id DESC
+		LIMIT ?
+	`
+
+	args = append([]interface{}{cursorID}, args...)
+	return e.query(ctx, query, append(args, limit)...)
+}
+
+// eventColumns lists the columns read by query, in order
+const eventColumns = `id, tenant_id, file_hash, action, username, previous_label, new_label, created_at`
+
+func (e *eventRepo) query(ctx context.Context, query string, args ...interface{}) ([]models.ImageEvent, error) {
+	rows, err := e.db.QueryContext(ctx, database.Rebind(e.driver, query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.ImageEvent
+	for rows.Next() {
+		var event models.ImageEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.TenantID,
+			&event.FileHash,
+			&event.Action,
+			&event.Username,
+			&event.PreviousLabel,
+			&event.NewLabel,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,226 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+type mfaRepo struct {
+	db     *sql.DB
+	driver string
+}
+
+func NewMFARepository(db *sql.DB, driver string) MFARepository {
+	return &mfaRepo{db: db, driver: driver}
+}
+
+// GetTOTP returns the TOTP setup of a user, or nil if they have none
+func (m *mfaRepo) GetTOTP(ctx context.Context, username string) (*models.TOTP, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE username = ?`
+
+	var secret sql.NullString
+	var enabledAt sql.NullTime
+	var totp models.TOTP
+
+	err := m.db.QueryRowContext(ctx, database.Rebind(m.driver, query), username).Scan(&secret, &enabledAt, &totp.LastStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch TOTP setup: %w", err)
+	}
+	if !secret.Valid || secret.String == "" {
+		return nil, nil
+	}
+
+	totp.Secret = secret.String
+	if enabledAt.Valid {
+		totp.EnabledAt = &enabledAt.Time
+	}
+
+	return &totp, nil
+}
+
+// SetPendingTOTP stores a new secret that is not enabled until EnableTOTP. It fails for
+// users who already have TOTP enabled.
+func (m *mfaRepo) SetPendingTOTP(ctx context.Context, username, secret string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE users SET totp_secret = ?, totp_enabled_at = NULL, totp_last_step = 0, updated_at = ? WHERE username = ? AND totp_enabled_at IS NULL`
+	result, err := m.db.ExecContext(ctx, database.Rebind(m.driver, query), secret, time.Now(), username)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+
+	return affectedRows(result)
+}
+
+// EnableTOTP enables the pending secret, records step as used and replaces the user's
+// recovery codes with codeHashes
+func (m *mfaRepo) EnableTOTP(ctx context.Context, username string, step int64, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	txn, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer txn.Rollback()
+
+	now := time.Now()
+	query := `UPDATE users SET totp_enabled_at = ?, totp_last_step = ?, updated_at = ? WHERE username = ? AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL`
+	result, err := txn.ExecContext(ctx, database.Rebind(m.driver, query), now, step, now, username)
+	if err != nil {
+		return fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+	if n, err := affectedRows(result); err != nil || n == 0 {
+		if err == nil {
+			err = fmt.Errorf("no pending TOTP secret for %s", username)
+		}
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, txn, m.driver, username, codeHashes); err != nil {
+		return err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep records step as the last accepted one. It returns 0 when step is not newer
+// than the last accepted step, so a code is only accepted once.
+func (m *mfaRepo) UseTOTPStep(ctx context.Context, username string, step int64) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE users SET totp_last_step = ? WHERE username = ? AND totp_last_step < ?`
+	result, err := m.db.ExecContext(ctx, database.Rebind(m.driver, query), step, username, step)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record TOTP step: %w", err)
+	}
+
+	return affectedRows(result)
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It returns 0 if the user has
+// no unused code with that hash.
+func (m *mfaRepo) UseRecoveryCode(ctx context.Context, username, codeHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE recovery_codes SET used_at = ? WHERE username = ? AND code_hash = ? AND used_at IS NULL`
+	result, err := m.db.ExecContext(ctx, database.Rebind(m.driver, query), time.Now(), username, codeHash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	return affectedRows(result)
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has
+func (m *mfaRepo) CountRecoveryCodes(ctx context.Context, username string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var count int
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE username = ? AND used_at IS NULL`
+	if err := m.db.QueryRowContext(ctx, database.Rebind(m.driver, query), username).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	return count, nil
+}
+
+// ReplaceRecoveryCodes drops every recovery code of a user and stores codeHashes instead
+func (m *mfaRepo) ReplaceRecoveryCodes(ctx context.Context, username string, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	txn, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer txn.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, txn, m.driver, username, codeHashes); err != nil {
+		return err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ClearTOTP removes the TOTP secret and recovery codes of a user
+func (m *mfaRepo) ClearTOTP(ctx context.Context, username string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	txn, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer txn.Rollback()
+
+	query := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = ? WHERE username = ?`
+	result, err := txn.ExecContext(ctx, database.Rebind(m.driver, query), time.Now(), username)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear TOTP: %w", err)
+	}
+
+	n, err := affectedRows(result)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := replaceRecoveryCodes(ctx, txn, m.driver, username, nil); err != nil {
+		return 0, err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return n, nil
+}
+
+// replaceRecoveryCodes swaps the recovery codes of a user within txn
+func replaceRecoveryCodes(ctx context.Context, txn *sql.Tx, driver, username string, codeHashes []string) error {
+	query := `DELETE FROM recovery_codes WHERE username = ?`
+	if _, err := txn.ExecContext(ctx, database.Rebind(driver, query), username); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	now := time.Now()
+	query = `INSERT INTO recovery_codes (username, code_hash, created_at) VALUES (?, ?, ?)`
+	for _, hash := range codeHashes {
+		if _, err := txn.ExecContext(ctx, database.Rebind(driver, query), username, hash, now); err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func affectedRows(result sql.Result) (int, error) {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch affected rows: %w", err)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,123 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+type refreshTokenRepo struct {
+	db     *sql.DB
+	driver string
+}
+
+func NewRefreshTokenRepository(db *sql.DB, driver string) RefreshTokenRepository {
+	return &refreshTokenRepo{db: db, driver: driver}
+}
+
+func (rr *refreshTokenRepo) CreateToken(ctx context.Context, t models.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO refresh_tokens
+			(token_hash, family_id, username, expires_at, created_at)
+			VALUES
+			(?, ?, ?, ?, ?)
+	`
+	_, err := rr.db.ExecContext(ctx, database.Rebind(rr.driver, query),
+		t.TokenHash,
+		t.FamilyID,
+		t.Username,
+		t.ExpiresAt,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// GetToken returns the token stored under tokenHash, or nil if there is none
+func (rr *refreshTokenRepo) GetToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT
+			id, token_hash, family_id, username, expires_at, used_at, revoked_at, created_at
+		FROM
+			refresh_tokens
+		WHERE
+			token_hash = ?
+	`
+
+	var t models.RefreshToken
+	var usedAt, revokedAt sql.NullTime
+
+	err := rr.db.QueryRowContext(ctx, database.Rebind(rr.driver, query), tokenHash).Scan(
+		&t.ID, &t.TokenHash, &t.FamilyID, &t.Username, &t.ExpiresAt, &usedAt, &revokedAt, &t.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch refresh token: %w", err)
+	}
+
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+
+	return &t, nil
+}
+
+// MarkUsed records that a token was exchanged. It returns 0 when the token was already
+// used or revoked, so of two concurrent refreshes with the same token only one wins.
+func (rr *refreshTokenRepo) MarkUsed(ctx context.Context, id int) (int, error) {
+	query := `UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL AND revoked_at IS NULL`
+	return rr.exec(ctx, "use refresh token", query, time.Now(), id)
+}
+
+// RevokeFamily revokes every token issued from the same login as familyID
+func (rr *refreshTokenRepo) RevokeFamily(ctx context.Context, familyID string) (int, error) {
+	query := `UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`
+	return rr.exec(ctx, "revoke refresh tokens", query, time.Now(), familyID)
+}
+
+// RevokeUser revokes every refresh token of a user
+func (rr *refreshTokenRepo) RevokeUser(ctx context.Context, username string) (int, error) {
+	query := `UPDATE refresh_tokens SET revoked_at = ? WHERE username = ? AND revoked_at IS NULL`
+	return rr.exec(ctx, "revoke refresh tokens", query, time.Now(), username)
+}
+
+// DeleteExpired removes tokens that expired before before, used or not
+func (rr *refreshTokenRepo) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	query := `DELETE FROM refresh_tokens WHERE expires_at < ?`
+	return rr.exec(ctx, "delete expired refresh tokens", query, before)
+}
+
+// exec runs query and returns the number of affected rows, which may be 0
+func (rr *refreshTokenRepo) exec(ctx context.Context, what, query string, args ...interface{}) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := rr.db.ExecContext(ctx, database.Rebind(rr.driver, query), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to %s: %w", what, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch affected rows: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
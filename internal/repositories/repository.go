@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/mlvieira/nsfwdetection/internal/models"
 )
@@ -10,34 +11,107 @@ import (
 type UserRepository interface {
 	CheckLogin(ctx context.Context, username, password string) (models.User, error)
 	AddUser(ctx context.Context, u models.User) error
+	GetUser(ctx context.Context, username string) (*models.User, error)
+	GetUserByOIDCSubject(ctx context.Context, subject string) (*models.User, error)
+	ListUsers(ctx context.Context, tenantID int) ([]models.User, error)
+	UpdatePassword(ctx context.Context, username, hashedPassword string) (int, error)
+	UpdateRole(ctx context.Context, username, role string) (int, error)
+	SetTenant(ctx context.Context, username string, tenantID int) (int, error)
+	SetDisabled(ctx context.Context, username string, disabled bool) (int, error)
+	DeleteUser(ctx context.Context, username string) (int, error)
 }
 
+// UploadedRepository stores the uploaded images of every tenant. Listing and counting
+// methods accept models.AllTenants, methods changing an image need its tenant.
 type UploadedRepository interface {
-	ListUploadsCursor(ctx context.Context, cursorID, limit int, reviewed *bool) ([]models.UploadedImage, error)
-	LabelUpload(ctx context.Context, hash, label string) (int, error)
-	UploadImage(ctx context.Context, img models.UploadedImage) error
-	ListTotalUploads(ctx context.Context, reviewed *bool) (int, error)
-	GetFilePathByHash(ctx context.Context, hash string) (string, error)
-	DeleteImage(ctx context.Context, hash string) (int, error)
+	ListUploadsCursor(ctx context.Context, tenantID, cursorID, limit int, reviewed *bool) ([]models.UploadedImage, error)
+	LabelUpload(ctx context.Context, tenantID int, hash, label, username string) (int, error)
+	UploadImage(ctx context.Context, img models.UploadedImage) (int, error)
+	ListTotalUploads(ctx context.Context, tenantID int, reviewed *bool) (int, error)
+	GetFilePathByHash(ctx context.Context, tenantID int, hash string) (string, error)
+	GetImageByHash(ctx context.Context, tenantID int, hash string) (*models.UploadedImage, error)
+	CountImagesByHash(ctx context.Context, hash string) (int, error)
+	DeleteImage(ctx context.Context, tenantID int, hash, username string) (int, error)
+	RestoreImage(ctx context.Context, tenantID int, hash, username string) (int, error)
+	ListTrashCursor(ctx context.Context, tenantID, cursorID, limit int) ([]models.UploadedImage, error)
+	CountTrash(ctx context.Context, tenantID int) (int, error)
+	ListTrashedBefore(ctx context.Context, before time.Time, limit int) ([]models.UploadedImage, error)
+	PurgeImage(ctx context.Context, tenantID int, hash, username string) (int, error)
+	ListExpired(ctx context.Context, tenantID int, label string, reviewed *bool, createdBefore time.Time, limit int) ([]models.UploadedImage, error)
+	CountExpired(ctx context.Context, tenantID int, label string, reviewed *bool, createdBefore time.Time) (int, error)
+}
+
+type EventRepository interface {
+	ListImageEvents(ctx context.Context, tenantID int, hash string) ([]models.ImageEvent, error)
+	ListEventsCursor(ctx context.Context, tenantID, cursorID, limit int) ([]models.ImageEvent, error)
+}
+
+type RefreshTokenRepository interface {
+	CreateToken(ctx context.Context, t models.RefreshToken) error
+	GetToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	MarkUsed(ctx context.Context, id int) (int, error)
+	RevokeFamily(ctx context.Context, familyID string) (int, error)
+	RevokeUser(ctx context.Context, username string) (int, error)
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+type MFARepository interface {
+	GetTOTP(ctx context.Context, username string) (*models.TOTP, error)
+	SetPendingTOTP(ctx context.Context, username, secret string) (int, error)
+	EnableTOTP(ctx context.Context, username string, step int64, codeHashes []string) error
+	UseTOTPStep(ctx context.Context, username string, step int64) (int, error)
+	UseRecoveryCode(ctx context.Context, username, codeHash string) (int, error)
+	CountRecoveryCodes(ctx context.Context, username string) (int, error)
+	ReplaceRecoveryCodes(ctx context.Context, username string, codeHashes []string) error
+	ClearTOTP(ctx context.Context, username string) (int, error)
+}
+
+type APIKeyRepository interface {
+	CreateKey(ctx context.Context, k models.APIKey) error
+	GetKey(ctx context.Context, id int) (*models.APIKey, error)
+	GetKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	ListKeys(ctx context.Context, tenantID int) ([]models.APIKey, error)
+	RevokeKey(ctx context.Context, id int) (int, error)
+	TouchKey(ctx context.Context, id int, at, since time.Time) (int, error)
 }
 
 type StatsRepository interface {
-	CountRevNonRevImages(ctx context.Context) (int, int, error)
-	AverageConfidence(ctx context.Context) (float64, error)
-	LabelDistribution(ctx context.Context) (map[string]int, error)
-	LabelingEfficiency(ctx context.Context) (float64, error)
+	CountRevNonRevImages(ctx context.Context, tenantID int) (int, int, error)
+	AverageConfidence(ctx context.Context, tenantID int) (float64, error)
+	LabelDistribution(ctx context.Context, tenantID int) (map[string]int, error)
+	LabelingEfficiency(ctx context.Context, tenantID int) (float64, error)
+	ClientDistribution(ctx context.Context, tenantID int) (map[string]int, error)
+}
+
+type TenantRepository interface {
+	CreateTenant(ctx context.Context, t models.Tenant) error
+	GetTenant(ctx context.Context, id int) (*models.Tenant, error)
+	GetTenantByName(ctx context.Context, name string) (*models.Tenant, error)
+	ListTenants(ctx context.Context) ([]models.Tenant, error)
+	UpdatePolicy(ctx context.Context, id int, nsfwThreshold float32) (int, error)
 }
 
 type Repositories struct {
 	User     UserRepository
 	Uploaded UploadedRepository
 	Stats    StatsRepository
+	Events   EventRepository
+	Tokens   RefreshTokenRepository
+	MFA      MFARepository
+	APIKeys  APIKeyRepository
+	Tenants  TenantRepository
 }
 
-func NewRepositories(conn *sql.DB) *Repositories {
+// NewRepositories creates the repositories for conn, writing SQL in the dialect of driver
+func NewRepositories(conn *sql.DB, driver string) *Repositories {
 	return &Repositories{
-		User:     NewUserRepository(conn),
-		Uploaded: NewUploadedRepository(conn),
-		Stats:    NewStatsRepository(conn),
+		User:     NewUserRepository(conn, driver),
+		Uploaded: NewUploadedRepository(conn, driver),
+		Stats:    NewStatsRepository(conn, driver),
+		Events:   NewEventRepository(conn, driver),
+		Tokens:   NewRefreshTokenRepository(conn, driver),
+		MFA:      NewMFARepository(conn, driver),
+		APIKeys:  NewAPIKeyRepository(conn, driver),
+		Tenants:  NewTenantRepository(conn, driver),
 	}
 }
@@ -4,27 +4,32 @@ import (
 	"context"
 	"database/sql"
 	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
 )
 
 type statsRepo struct {
-	db *sql.DB
+	db     *sql.DB
+	driver string
 }
 
-func NewStatsRepository(db *sql.DB) StatsRepository {
-	return &statsRepo{db: db}
+func NewStatsRepository(db *sql.DB, driver string) StatsRepository {
+	return &statsRepo{db: db, driver: driver}
 }
 
-func (s *statsRepo) CountRevNonRevImages(ctx context.Context) (int, int, error) {
+func (s *statsRepo) CountRevNonRevImages(ctx context.Context, tenantID int) (int, int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	counts := map[bool]int{true: 0, false: 0}
 
-	rows, err := s.db.QueryContext(ctx, `
+	tenant, args := tenantFilter(tenantID)
+	rows, err := s.db.QueryContext(ctx, database.Rebind(s.driver, `
 		SELECT reviewed, count(1) AS count
 		FROM uploaded_images
+		WHERE deleted_at IS NULL`+tenant+`
 		GROUP BY reviewed
-	`)
+	`), args...)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -46,15 +51,16 @@ func (s *statsRepo) CountRevNonRevImages(ctx context.Context) (int, int, error)
 	return counts[true], counts[false], nil
 }
 
-func (s *statsRepo) AverageConfidence(ctx context.Context) (float64, error) {
+func (s *statsRepo) AverageConfidence(ctx context.Context, tenantID int) (float64, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var avgConfidence float64
-	err := s.db.QueryRowContext(ctx, `
-		SELECT AVG(confidence) 
+	tenant, args := tenantFilter(tenantID)
+	err := s.db.QueryRowContext(ctx, database.Rebind(s.driver, `
+		SELECT COALESCE(AVG(confidence), 0)
 		FROM uploaded_images
-	`).Scan(&avgConfidence)
+		WHERE deleted_at IS NULL`+tenant), args...).Scan(&avgConfidence)
 	if err != nil {
 		return 0, err
 	}
@@ -62,18 +68,19 @@ func (s *statsRepo) AverageConfidence(ctx context.Context) (float64, error) {
 	return avgConfidence, nil
 }
 
-func (s *statsRepo) LabelDistribution(ctx context.Context) (map[string]int, error) {
+func (s *statsRepo) LabelDistribution(ctx context.Context, tenantID int) (map[string]int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	labelCounts := make(map[string]int)
 
-	rows, err := s.db.QueryContext(ctx, `
+	tenant, args := tenantFilter(tenantID)
+	rows, err := s.db.QueryContext(ctx, database.Rebind(s.driver, `
 		SELECT new_label, COUNT(1) 
 		FROM uploaded_images 
-		WHERE reviewed = true 
+		WHERE reviewed = true AND deleted_at IS NULL`+tenant+`
 		GROUP BY new_label
-	`)
+	`), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -91,25 +98,25 @@ func (s *statsRepo) LabelDistribution(ctx context.Context) (map[string]int, erro
 	return labelCounts, nil
 }
 
-func (s *statsRepo) LabelingEfficiency(ctx context.Context) (float64, error) {
+func (s *statsRepo) LabelingEfficiency(ctx context.Context, tenantID int) (float64, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var labeledCount, totalCount int
 
-	err := s.db.QueryRowContext(ctx, `
+	tenant, args := tenantFilter(tenantID)
+	err := s.db.QueryRowContext(ctx, database.Rebind(s.driver, `
 		SELECT COUNT(1) 
 		FROM uploaded_images 
-		WHERE reviewed = true AND new_label = label
-	`).Scan(&labeledCount)
+		WHERE reviewed = true AND new_label = label AND deleted_at IS NULL`+tenant), args...).Scan(&labeledCount)
 	if err != nil {
 		return 0, err
 	}
 
-	err = s.db.QueryRowContext(ctx, `
+	err = s.db.QueryRowContext(ctx, database.Rebind(s.driver, `
 		SELECT COUNT(1) 
 		FROM uploaded_images
-	`).Scan(&totalCount)
+		WHERE deleted_at IS NULL`+tenant), args...).Scan(&totalCount)
 	if err != nil {
 		return 0, err
 	}
@@ -121,3 +128,35 @@ func (s *statsRepo) LabelingEfficiency(ctx context.Context) (float64, error) {
 	efficiency := float64(labeledCount) / float64(totalCount) * 100.0
 	return efficiency, nil
 }
+
+// ClientDistribution counts the images uploaded with each API client's key, images
+// uploaded without a key are left out
+func (s *statsRepo) ClientDistribution(ctx context.Context, tenantID int) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	clientCounts := make(map[string]int)
+
+	tenant, args := tenantFilter(tenantID)
+	rows, err := s.db.QueryContext(ctx, database.Rebind(s.driver, `
+		SELECT client_name, COUNT(1)
+		FROM uploaded_images
+		WHERE client_name IS NOT NULL AND deleted_at IS NULL`+tenant+`
+		GROUP BY client_name
+	`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var client string
+		var count int
+		if err := rows.Scan(&client, &count); err != nil {
+			return nil, err
+		}
+		clientCounts[client] = count
+	}
+
+	return clientCounts, rows.Err()
+}
@@ -0,0 +1,113 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+type tenantRepo struct {
+	db     *sql.DB
+	driver string
+}
+
+func NewTenantRepository(db *sql.DB, driver string) TenantRepository {
+	return &tenantRepo{db: db, driver: driver}
+}
+
+// tenantFilter returns the condition restricting a query to the rows of tenantID, with its
+// argument. AllTenants matches every row, so both are empty.
+func tenantFilter(tenantID int) (string, []interface{}) {
+	if tenantID == models.AllTenants {
+		return "", nil
+	}
+	return " AND tenant_id = ?", []interface{}{tenantID}
+}
+
+func (t *tenantRepo) CreateTenant(ctx context.Context, tenant models.Tenant) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO tenants (name, nsfw_threshold, created_at) VALUES (?, ?, ?)`
+	_, err := t.db.ExecContext(ctx, database.Rebind(t.driver, query), tenant.Name, tenant.NSFWThreshold, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to create tenant: %w", err)
+	}
+
+	return nil
+}
+
+// tenantColumns lists the columns read by scanTenant, in order
+const tenantColumns = `id, name, nsfw_threshold, created_at`
+
+func scanTenant(row rowScanner) (models.Tenant, error) {
+	var tenant models.Tenant
+	err := row.Scan(&tenant.ID, &tenant.Name, &tenant.NSFWThreshold, &tenant.CreatedAt)
+	return tenant, err
+}
+
+// GetTenant returns the tenant with id, or nil if there is none
+func (t *tenantRepo) GetTenant(ctx context.Context, id int) (*models.Tenant, error) {
+	return t.getTenant(ctx, `SELECT `+tenantColumns+` FROM tenants WHERE id = ?`, id)
+}
+
+// GetTenantByName returns the tenant called name, or nil if there is none
+func (t *tenantRepo) GetTenantByName(ctx context.Context, name string) (*models.Tenant, error) {
+	return t.getTenant(ctx, `SELECT `+tenantColumns+` FROM tenants WHERE name = ?`, name)
+}
+
+func (t *tenantRepo) getTenant(ctx context.Context, query string, arg interface{}) (*models.Tenant, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tenant, err := scanTenant(t.db.QueryRowContext(ctx, database.Rebind(t.driver, query), arg))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch tenant: %w", err)
+	}
+
+	return &tenant, nil
+}
+
+// ListTenants returns every tenant, in the order they were created
+func (t *tenantRepo) ListTenants(ctx context.Context) ([]models.Tenant, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := t.db.QueryContext(ctx, `SELECT `+tenantColumns+` FROM tenants ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	defer rows.Close()
+
+	var tenants []models.Tenant
+	for rows.Next() {
+		tenant, err := scanTenant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tenant: %w", err)
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	return tenants, rows.Err()
+}
+
+// UpdatePolicy sets the NSFW threshold of a tenant
+func (t *tenantRepo) UpdatePolicy(ctx context.Context, id int, nsfwThreshold float32) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE tenants SET nsfw_threshold = ? WHERE id = ?`
+	result, err := t.db.ExecContext(ctx, database.Rebind(t.driver, query), nsfwThreshold, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update tenant policy: %w", err)
+	}
+
+	return affectedRows(result)
+}
@@ -6,26 +6,30 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/go-sql-driver/mysql"
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
 	"github.com/mlvieira/nsfwdetection/internal/logger"
 	"github.com/mlvieira/nsfwdetection/internal/models"
 )
 
 type uploadedRepo struct {
-	db *sql.DB
+	db     *sql.DB
+	driver string
 }
 
-func NewUploadedRepository(db *sql.DB) UploadedRepository {
-	return &uploadedRepo{db: db}
+func NewUploadedRepository(db *sql.DB, driver string) UploadedRepository {
+	return &uploadedRepo{db: db, driver: driver}
 }
 
-func (u *uploadedRepo) UploadImage(ctx context.Context, img models.UploadedImage) error {
+// UploadImage records a new upload and returns how many rows were written. An image of the
+// tenant already in the trash is brought back as a new upload waiting for review, an image
+// that is already live is left alone and nothing is written.
+func (u *uploadedRepo) UploadImage(ctx context.Context, img models.UploadedImage) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	txn, err := u.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
 	defer func() {
@@ -34,66 +38,130 @@ func (u *uploadedRepo) UploadImage(ctx context.Context, img models.UploadedImage
 		}
 	}()
 
-	query := `INSERT INTO uploaded_images
-			(file_path, file_hash, label, confidence, reviewed, created_at, updated_at)
-			VALUES
-			(?, ?, ?, ?, ?, ?, ?)
+	var clientName sql.NullString
+	if img.ClientName != "" {
+		clientName = sql.NullString{String: img.ClientName, Valid: true}
+	}
+
+	now := time.Now()
+	query := `UPDATE uploaded_images
+			SET file_path = ?, label = ?, new_label = 'unlabeled', confidence = ?, reviewed = ?, reviewed_at = NULL,
+				deleted_at = NULL, client_name = ?, created_at = ?, updated_at = ?
+			WHERE tenant_id = ? AND file_hash = ? AND deleted_at IS NOT NULL
 	`
-	_, err = txn.Exec(query,
+	result, err := txn.Exec(database.Rebind(u.driver, query),
 		img.FilePath,
-		img.FileHash,
 		img.Label,
 		img.Confidence,
 		img.Reviewed,
-		time.Now(),
-		time.Now(),
+		clientName,
+		now,
+		now,
+		img.TenantID,
+		img.FileHash,
 	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revive trashed image: %w", err)
+	}
 
+	revived, err := result.RowsAffected()
 	if err != nil {
-		if sqlErr, ok := err.(*mysql.MySQLError); ok && sqlErr.Number == 1062 {
-			logger.Info("File hash already exists, skipping insertion: %s", img.FileHash)
-			return nil
-		} else {
-			return fmt.Errorf("failed to insert uploaded image: %w", err)
+		return 0, fmt.Errorf("failed to fetch affected rows: %w", err)
+	}
+
+	if revived > 0 {
+		username := img.ClientName
+		if username == "" {
+			username = "anonymous"
+		}
+		err = insertEvent(ctx, txn, u.driver, models.ImageEvent{
+			TenantID: img.TenantID,
+			FileHash: img.FileHash,
+			Action:   models.EventReupload,
+			Username: username,
+			NewLabel: img.Label,
+		})
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		query = `INSERT INTO uploaded_images
+				(tenant_id, file_path, file_hash, label, confidence, reviewed, client_name, created_at, updated_at)
+				VALUES
+				(?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`
+		_, err = txn.Exec(database.Rebind(u.driver, query),
+			img.TenantID,
+			img.FilePath,
+			img.FileHash,
+			img.Label,
+			img.Confidence,
+			img.Reviewed,
+			clientName,
+			now,
+			now,
+		)
+		if err != nil {
+			if database.IsDuplicate(u.driver, err) {
+				logger.Info("File hash already exists, skipping insertion: %s", img.FileHash)
+				return 0, nil
+			}
+			return 0, fmt.Errorf("failed to insert uploaded image: %w", err)
 		}
 	}
 
 	if err = txn.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return nil
+	return 1, nil
 }
 
-func (u *uploadedRepo) ListUploadsCursor(ctx context.Context, cursorID, limit int, reviewed *bool) ([]models.UploadedImage, error) {
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-	defer cancel()
+// imageColumns lists the columns read by scanImage, in order
+const imageColumns = `id, tenant_id, file_path, file_hash, label, new_label, confidence, reviewed, reviewed_at, deleted_at, client_name, created_at, updated_at`
 
-	query := `
-		SELECT 
-			id, file_path, file_hash, label, new_label, confidence, reviewed, created_at 
-		FROM 
-			uploaded_images
-		WHERE 
-			(? IS NULL OR id < ?)
-	`
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	var args []interface{}
-	args = append(args, cursorID, cursorID)
+// scanImage reads a row selected with imageColumns
+func scanImage(row rowScanner) (models.UploadedImage, error) {
+	var img models.UploadedImage
+	var reviewedAt, deletedAt sql.NullTime
+	var clientName sql.NullString
+
+	err := row.Scan(
+		&img.ID,
+		&img.TenantID,
+		&img.FilePath,
+		&img.FileHash,
+		&img.Label,
+		&img.NewLabel,
+		&img.Confidence,
+		&img.Reviewed,
+		&reviewedAt,
+		&deletedAt,
+		&clientName,
+		&img.CreatedAt,
+		&img.UpdatedAt,
+	)
+	if err != nil {
+		return img, err
+	}
 
-	if reviewed != nil {
-		query += " AND reviewed = ?"
-		args = append(args, *reviewed)
+	if reviewedAt.Valid {
+		img.ReviewedAt = &reviewedAt.Time
 	}
+	if deletedAt.Valid {
+		img.DeletedAt = &deletedAt.Time
+	}
+	img.ClientName = clientName.String
 
-	query += `
-		ORDER BY
-			id DESC
-		LIMIT ?
-	`
-	args = append(args, limit)
+	return img, nil
+}
 
-	rows, err := u.db.QueryContext(ctx, query, args...)
+func (u *uploadedRepo) queryImages(ctx context.Context, query string, args ...interface{}) ([]models.UploadedImage, error) {
+	rows, err := u.db.QueryContext(ctx, database.Rebind(u.driver, query), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -101,17 +169,7 @@ func (u *uploadedRepo) ListUploadsCursor(ctx context.Context, cursorID, limit in
 
 	var uploads []models.UploadedImage
 	for rows.Next() {
-		var upload models.UploadedImage
-		err := rows.Scan(
-			&upload.ID,
-			&upload.FilePath,
-			&upload.FileHash,
-			&upload.Label,
-			&upload.NewLabel,
-			&upload.Confidence,
-			&upload.Reviewed,
-			&upload.CreatedAt,
-		)
+		upload, err := scanImage(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -125,8 +183,39 @@ func (u *uploadedRepo) ListUploadsCursor(ctx context.Context, cursorID, limit in
 	return uploads, nil
 }
 
-// LabelUpload updates the label for an image
-func (u *uploadedRepo) LabelUpload(ctx context.Context, hash, label string) (int, error) {
+func (u *uploadedRepo) ListUploadsCursor(ctx context.Context, tenantID, cursorID, limit int, reviewed *bool) ([]models.UploadedImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tenant, args := tenantFilter(tenantID)
+	query := `
+		SELECT 
+			` + imageColumns + ` 
+		FROM 
+			uploaded_images
+		WHERE 
+			id < ? AND deleted_at IS NULL` + tenant + `
+	`
+
+	args = append([]interface{}{cursorID}, args...)
+
+	if reviewed != nil {
+		query += " AND reviewed = ?"
+		args = append(args, *reviewed)
+	}
+
+	query += `
+		ORDER BY
+			id DESC
+		LIMIT ?
+	`
+	args = append(args, limit)
+
+	return u.queryImages(ctx, query, args...)
+}
+
+// LabelUpload updates the label for an image and records the change in image_events
+func (u *uploadedRepo) LabelUpload(ctx context.Context, tenantID int, hash, label, username string) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
@@ -141,8 +230,14 @@ func (u *uploadedRepo) LabelUpload(ctx context.Context, hash, label string) (int
 		}
 	}()
 
-	query := `UPDATE uploaded_images SET new_label = ?, updated_at = ?, reviewed = true WHERE file_hash = ?`
-	result, err := txn.Exec(query, label, time.Now(), hash)
+	previousLabel, reviewed, err := u.currentLabel(ctx, txn, tenantID, hash, false)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	query := `UPDATE uploaded_images SET new_label = ?, updated_at = ?, reviewed = true, reviewed_at = ? WHERE tenant_id = ? AND file_hash = ? AND deleted_at IS NULL`
+	result, err := txn.Exec(database.Rebind(u.driver, query), label, now, now, tenantID, hash)
 	if err != nil {
 		return 0, fmt.Errorf("failed to execute query: %w", err)
 	}
@@ -151,8 +246,22 @@ func (u *uploadedRepo) LabelUpload(ctx context.Context, hash, label string) (int
 	if err != nil {
 		return 0, fmt.Errorf("failed to fetch affected rows: %w", err)
 	}
-	if rowsAffected == 0 {
-		return int(rowsAffected), fmt.Errorf("no rows updated, image with hash %s not found", hash)
+
+	action := models.EventLabel
+	if reviewed {
+		action = models.EventRelabel
+	}
+
+	err = insertEvent(ctx, txn, u.driver, models.ImageEvent{
+		TenantID:      tenantID,
+		FileHash:      hash,
+		Action:        action,
+		Username:      username,
+		PreviousLabel: previousLabel,
+		NewLabel:      label,
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	if err = txn.Commit(); err != nil {
@@ -162,49 +271,73 @@ func (u *uploadedRepo) LabelUpload(ctx context.Context, hash, label string) (int
 	return int(rowsAffected), nil
 }
 
-func (u *uploadedRepo) ListTotalUploads(ctx context.Context, reviewed *bool) (int, error) {
+// currentLabel reads the reviewed label of an image of tenantID within txn, either a live or a trashed one
+func (u *uploadedRepo) currentLabel(ctx context.Context, txn *sql.Tx, tenantID int, hash string, trashed bool) (string, bool, error) {
+	var label string
+	var reviewed bool
+
+	query := `SELECT new_label, reviewed FROM uploaded_images WHERE tenant_id = ? AND file_hash = ? AND deleted_at IS NULL`
+	if trashed {
+		query = `SELECT new_label, reviewed FROM uploaded_images WHERE tenant_id = ? AND file_hash = ? AND deleted_at IS NOT NULL`
+	}
+
+	err := txn.QueryRowContext(ctx, database.Rebind(u.driver, query), tenantID, hash).Scan(&label, &reviewed)
+	if err == sql.ErrNoRows {
+		return "", false, fmt.Errorf("image with hash %s not found", hash)
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to fetch current label: %w", err)
+	}
+
+	return label, reviewed, nil
+}
+
+func (u *uploadedRepo) ListTotalUploads(ctx context.Context, tenantID int, reviewed *bool) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var count int
 
+	tenant, args := tenantFilter(tenantID)
 	query := `
 		SELECT 
 			count(1) 
 		FROM 
 			uploaded_images
+		WHERE 
+			deleted_at IS NULL` + tenant + `
 	`
 
-	var args []interface{}
 	if reviewed != nil {
-		query += " WHERE reviewed = ?"
+		query += " AND reviewed = ?"
 		args = append(args, *reviewed)
 	}
 
-	if err := u.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+	if err := u.db.QueryRowContext(ctx, database.Rebind(u.driver, query), args...).Scan(&count); err != nil {
 		return 0, err
 	}
 
 	return count, nil
 }
 
-func (u *uploadedRepo) GetFilePathByHash(ctx context.Context, hash string) (string, error) {
+func (u *uploadedRepo) GetFilePathByHash(ctx context.Context, tenantID int, hash string) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var filePath string
 
+	tenant, args := tenantFilter(tenantID)
 	query := `
 		SELECT 
 			file_path 
 		FROM 
 			uploaded_images
 		WHERE 
-			file_hash = ?
+			file_hash = ? AND deleted_at IS NULL` + tenant + `
 		LIMIT 1
 	`
 
-	if err := u.db.QueryRowContext(ctx, query, hash).Scan(&filePath); err != nil {
+	if err := u.db.QueryRowContext(ctx, database.Rebind(u.driver, query), append([]interface{}{hash}, args...)...).Scan(&filePath); err != nil {
 		if err == sql.ErrNoRows {
 			return "", nil
 		}
@@ -214,7 +347,61 @@ func (u *uploadedRepo) GetFilePathByHash(ctx context.Context, hash string) (stri
 	return filePath, nil
 }
 
-func (u *uploadedRepo) DeleteImage(ctx context.Context, hash string) (int, error) {
+// GetImageByHash returns the image of tenantID with hash, trashed or not, or nil if there is none
+func (u *uploadedRepo) GetImageByHash(ctx context.Context, tenantID int, hash string) (*models.UploadedImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tenant, args := tenantFilter(tenantID)
+	query := `
+		SELECT 
+			` + imageColumns + ` 
+		FROM 
+			uploaded_images
+		WHERE 
+			file_hash = ?` + tenant + `
+		ORDER BY
+			id ASC
+		LIMIT 1
+	`
+
+	img, err := scanImage(u.db.QueryRowContext(ctx, database.Rebind(u.driver, query), append([]interface{}{hash}, args...)...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &img, nil
+}
+
+// CountImagesByHash returns how many tenants have an image with hash, trashed or not.
+// Previews are shared by all of them and may only go once none is left.
+func (u *uploadedRepo) CountImagesByHash(ctx context.Context, hash string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var count int
+	query := `SELECT count(1) FROM uploaded_images WHERE file_hash = ?`
+	if err := u.db.QueryRowContext(ctx, database.Rebind(u.driver, query), hash).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// DeleteImage moves an image to the trash and records the deletion in image_events
+func (u *uploadedRepo) DeleteImage(ctx context.Context, tenantID int, hash, username string) (int, error) {
+	return u.setTrashed(ctx, tenantID, hash, username, true)
+}
+
+// RestoreImage takes an image out of the trash and records the restore in image_events
+func (u *uploadedRepo) RestoreImage(ctx context.Context, tenantID int, hash, username string) (int, error) {
+	return u.setTrashed(ctx, tenantID, hash, username, false)
+}
+
+func (u *uploadedRepo) setTrashed(ctx context.Context, tenantID int, hash, username string, trash bool) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
@@ -229,15 +416,142 @@ func (u *uploadedRepo) DeleteImage(ctx context.Context, hash string) (int, error
 		}
 	}()
 
-	query := `DELETE FROM uploaded_images WHERE file_hash = ?`
-	result, err := txn.Exec(query, hash)
+	currentLabel, _, err := u.currentLabel(ctx, txn, tenantID, hash, !trash)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	query := `UPDATE uploaded_images SET deleted_at = ?, updated_at = ? WHERE tenant_id = ? AND file_hash = ?`
+	deletedAt := sql.NullTime{Time: now, Valid: trash}
+
+	result, err := txn.Exec(database.Rebind(u.driver, query), deletedAt, now, tenantID, hash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update image: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch affected rows: %w", err)
+	}
+
+	event := models.ImageEvent{TenantID: tenantID, FileHash: hash, Username: username}
+	if trash {
+		event.Action = models.EventDelete
+		event.PreviousLabel = currentLabel
+	} else {
+		event.Action = models.EventRestore
+		event.NewLabel = currentLabel
+	}
+
+	if err = insertEvent(ctx, txn, u.driver, event); err != nil {
+		return 0, err
+	}
+
+	if err = txn.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+// ListTrashCursor returns trashed images with an ID below cursorID, most recent first
+func (u *uploadedRepo) ListTrashCursor(ctx context.Context, tenantID, cursorID, limit int) ([]models.UploadedImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tenant, args := tenantFilter(tenantID)
+	query := `
+		SELECT 
+			` + imageColumns + ` 
+		FROM 
+			uploaded_images
+		WHERE 
+			id < ? AND deleted_at IS NOT NULL` + tenant + `
+		ORDER BY
+			id DESC
+		LIMIT ?
+	`
+
+	args = append([]interface{}{cursorID}, args...)
+	return u.queryImages(ctx, query, append(args, limit)...)
+}
+
+func (u *uploadedRepo) CountTrash(ctx context.Context, tenantID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var count int
+	tenant, args := tenantFilter(tenantID)
+	query := `SELECT count(1) FROM uploaded_images WHERE deleted_at IS NOT NULL` + tenant
+	if err := u.db.QueryRowContext(ctx, database.Rebind(u.driver, query), args...).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ListTrashedBefore returns up to limit images of any tenant trashed before the given time, oldest first
+func (u *uploadedRepo) ListTrashedBefore(ctx context.Context, before time.Time, limit int) ([]models.UploadedImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT 
+			` + imageColumns + ` 
+		FROM 
+			uploaded_images
+		WHERE 
+			deleted_at IS NOT NULL AND deleted_at < ?
+		ORDER BY
+			deleted_at ASC
+		LIMIT ?
+	`
+
+	return u.queryImages(ctx, query, before, limit)
+}
+
+// PurgeImage permanently removes a trashed image and records the purge in image_events
+func (u *uploadedRepo) PurgeImage(ctx context.Context, tenantID int, hash, username string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	txn, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			txn.Rollback()
+		}
+	}()
+
+	previousLabel, _, err := u.currentLabel(ctx, txn, tenantID, hash, true)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `DELETE FROM uploaded_images WHERE tenant_id = ? AND file_hash = ? AND deleted_at IS NOT NULL`
+	result, err := txn.Exec(database.Rebind(u.driver, query), tenantID, hash)
 	if err != nil {
 		return 0, fmt.Errorf("failed to delete hash: %w", err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
-	if rowsAffected == 0 {
-		return 0, fmt.Errorf("no rows deleted, image with hash %s not found", hash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch affected rows: %w", err)
+	}
+
+	err = insertEvent(ctx, txn, u.driver, models.ImageEvent{
+		TenantID:      tenantID,
+		FileHash:      hash,
+		Action:        models.EventPurge,
+		Username:      username,
+		PreviousLabel: previousLabel,
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	if err = txn.Commit(); err != nil {
@@ -246,3 +560,60 @@ func (u *uploadedRepo) DeleteImage(ctx context.Context, hash string) (int, error
 
 	return int(rowsAffected), nil
 }
+
+// expiredFilter builds the WHERE clause matching live images of tenantID created before createdBefore
+// whose current label (the reviewed one once reviewed) is label. Empty label and nil reviewed match any.
+func expiredFilter(tenantID int, label string, reviewed *bool, createdBefore time.Time) (string, []interface{}) {
+	tenant, tenantArgs := tenantFilter(tenantID)
+	where := `deleted_at IS NULL AND created_at < ?` + tenant
+	args := append([]interface{}{createdBefore}, tenantArgs...)
+
+	if reviewed != nil {
+		where += ` AND reviewed = ?`
+		args = append(args, *reviewed)
+	}
+
+	if label != "" {
+		where += ` AND ((reviewed = true AND new_label = ?) OR (reviewed = false AND label = ?))`
+		args = append(args, label, label)
+	}
+
+	return where, args
+}
+
+// ListExpired returns up to limit images matching a retention rule, oldest first
+func (u *uploadedRepo) ListExpired(ctx context.Context, tenantID int, label string, reviewed *bool, createdBefore time.Time, limit int) ([]models.UploadedImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	where, args := expiredFilter(tenantID, label, reviewed, createdBefore)
+	query := `
+		SELECT 
+			` + imageColumns + ` 
+		FROM 
+			uploaded_images
+		WHERE 
+			` + where + `
+		ORDER BY
+			id ASC
+		LIMIT ?
+	`
+
+	return u.queryImages(ctx, query, append(args, limit)...)
+}
+
+// CountExpired returns how many images match a retention rule
+func (u *uploadedRepo) CountExpired(ctx context.Context, tenantID int, label string, reviewed *bool, createdBefore time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	where, args := expiredFilter(tenantID, label, reviewed, createdBefore)
+	query := `SELECT count(1) FROM uploaded_images WHERE ` + where
+
+	var count int
+	if err := u.db.QueryRowContext(ctx, database.Rebind(u.driver, query), args...).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
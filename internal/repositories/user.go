@@ -6,16 +6,18 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
 	"github.com/mlvieira/nsfwdetection/internal/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type userRepo struct {
-	db *sql.DB
+	db     *sql.DB
+	driver string
 }
 
-func NewUserRepository(db *sql.DB) UserRepository {
-	return &userRepo{db: db}
+func NewUserRepository(db *sql.DB, driver string) UserRepository {
+	return &userRepo{db: db, driver: driver}
 }
 
 func (ur *userRepo) AddUser(ctx context.Context, u models.User) error {
@@ -33,14 +35,22 @@ func (ur *userRepo) AddUser(ctx context.Context, u models.User) error {
 		}
 	}()
 
+	var subject sql.NullString
+	if u.OIDCSubject != "" {
+		subject = sql.NullString{String: u.OIDCSubject, Valid: true}
+	}
+
 	query := `INSERT INTO users
-			(username, password, created_at, updated_at)
+			(username, password, role, tenant_id, oidc_subject, created_at, updated_at)
 			VALUES
-			(?, ?, ?, ?)
+			(?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err = txn.Exec(query,
+	_, err = txn.Exec(database.Rebind(ur.driver, query),
 		u.Username,
 		u.Password,
+		u.Role,
+		u.TenantID,
+		subject,
 		time.Now(),
 		time.Now(),
 	)
@@ -55,18 +65,40 @@ func (ur *userRepo) AddUser(ctx context.Context, u models.User) error {
 	return nil
 }
 
+// userColumns lists the columns read by scanUser, in order
+const userColumns = `id, username, role, tenant_id, disabled_at, totp_enabled_at, oidc_subject, created_at, updated_at`
+
+// scanUser reads a row selected with userColumns, optionally followed by extra columns
+func scanUser(row rowScanner, extra ...interface{}) (models.User, error) {
+	var user models.User
+	var disabledAt, mfaEnabledAt sql.NullTime
+	var subject sql.NullString
+
+	dest := append([]interface{}{&user.ID, &user.Username, &user.Role, &user.TenantID, &disabledAt, &mfaEnabledAt, &subject, &user.CreatedAt, &user.UpdatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return user, err
+	}
+
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+	if mfaEnabledAt.Valid {
+		user.MFAEnabledAt = &mfaEnabledAt.Time
+	}
+	user.OIDCSubject = subject.String
+
+	return user, nil
+}
+
 func (ur *userRepo) CheckLogin(ctx context.Context, username, password string) (models.User, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	var user models.User
 	var hashedPassword string
 
-	query := `
-		SELECT id, username, password FROM users WHERE username = ?
-	`
+	query := `SELECT ` + userColumns + `, password FROM users WHERE username = ?`
 
-	err := ur.db.QueryRowContext(ctx, query, username).Scan(&user.ID, &user.Username, &hashedPassword)
+	user, err := scanUser(ur.db.QueryRowContext(ctx, database.Rebind(ur.driver, query), username), &hashedPassword)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return user, fmt.Errorf("invalid username or password")
@@ -78,5 +110,159 @@ func (ur *userRepo) CheckLogin(ctx context.Context, username, password string) (
 		return user, fmt.Errorf("invalid username or password")
 	}
 
+	if user.DisabledAt != nil {
+		return user, fmt.Errorf("user %s is disabled", username)
+	}
+
 	return user, nil
 }
+
+// GetUser returns the user named username, or nil if there is none
+func (ur *userRepo) GetUser(ctx context.Context, username string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = ?`
+
+	user, err := scanUser(ur.db.QueryRowContext(ctx, database.Rebind(ur.driver, query), username))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// GetUserByOIDCSubject returns the user provisioned for an identity provider subject, or
+// nil if there is none
+func (ur *userRepo) GetUserByOIDCSubject(ctx context.Context, subject string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE oidc_subject = ?`
+
+	user, err := scanUser(ur.db.QueryRowContext(ctx, database.Rebind(ur.driver, query), subject))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	return &user, nil
+}
+
+// ListUsers returns the users of tenantID, or every user for models.AllTenants
+func (ur *userRepo) ListUsers(ctx context.Context, tenantID int) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tenant, args := tenantFilter(tenantID)
+	query := `SELECT ` + userColumns + ` FROM users WHERE 1 = 1` + tenant + ` ORDER BY id`
+
+	rows, err := ur.db.QueryContext(ctx, database.Rebind(ur.driver, query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// UpdatePassword replaces the password hash of a user
+func (ur *userRepo) UpdatePassword(ctx context.Context, username, hashedPassword string) (int, error) {
+	return ur.update(ctx, username, "password", `UPDATE users SET password = ?, updated_at = ? WHERE username = ?`, hashedPassword)
+}
+
+// UpdateRole changes the role of a user
+func (ur *userRepo) UpdateRole(ctx context.Context, username, role string) (int, error) {
+	return ur.update(ctx, username, "role", `UPDATE users SET role = ?, updated_at = ? WHERE username = ?`, role)
+}
+
+// SetTenant moves a user to another tenant
+func (ur *userRepo) SetTenant(ctx context.Context, username string, tenantID int) (int, error) {
+	return ur.update(ctx, username, "tenant", `UPDATE users SET tenant_id = ?, updated_at = ? WHERE username = ?`, tenantID)
+}
+
+// SetDisabled disables a user, who can no longer log in, or enables them again
+func (ur *userRepo) SetDisabled(ctx context.Context, username string, disabled bool) (int, error) {
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now()
+		disabledAt = &now
+	}
+	return ur.update(ctx, username, "disabled state", `UPDATE users SET disabled_at = ?, updated_at = ? WHERE username = ?`, disabledAt)
+}
+
+// update runs query with value, the current time and username as arguments and fails if no user matched
+func (ur *userRepo) update(ctx context.Context, username, what, query string, value interface{}) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := ur.db.ExecContext(ctx, database.Rebind(ur.driver, query), value, time.Now(), username)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update %s: %w", what, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("no rows updated, user %s not found", username)
+	}
+
+	return int(rowsAffected), nil
+}
+
+// DeleteUser removes a user together with their recovery codes
+func (ur *userRepo) DeleteUser(ctx context.Context, username string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	txn, err := ur.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer txn.Rollback()
+
+	query := `DELETE FROM users WHERE username = ?`
+	result, err := txn.ExecContext(ctx, database.Rebind(ur.driver, query), username)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return 0, fmt.Errorf("no rows deleted, user %s not found", username)
+	}
+
+	query = `DELETE FROM recovery_codes WHERE username = ?`
+	if _, err := txn.ExecContext(ctx, database.Rebind(ur.driver, query), username); err != nil {
+		return 0, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
@@ -2,42 +2,65 @@ package router
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
 	"github.com/mlvieira/nsfwdetection/internal/config"
-	"github.com/mlvieira/nsfwdetection/internal/driver/redis"
 	"github.com/mlvieira/nsfwdetection/internal/handlers"
 	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
 	"github.com/mlvieira/nsfwdetection/internal/repositories"
 	"github.com/mlvieira/nsfwdetection/internal/services"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+	"github.com/mlvieira/nsfwdetection/internal/urlsign"
 	"github.com/mlvieira/nsfwdetection/internal/websockets"
 )
 
-func SetupRoutes(repositories *repositories.Repositories, redisClient *redis.RedisClient) http.Handler {
+func SetupRoutes(repositories *repositories.Repositories, predictionCache *cache.Metered, store *storage.Store, tokens *auth.Tokens, sessions *services.AuthService, mfa *services.MFAService, sso *services.SSOService, apiKeys *services.APIKeyService, buckets cache.Buckets) http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{config.AppConfig.Server.DomainName},
 		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Forwarded-For"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-API-Key"},
 		AllowCredentials: true,
+		ExposedHeaders:   []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
 		MaxAge:           300,
 	}))
 
 	hub := websockets.NewHub()
 	go hub.Run()
 
-	nsfwService := services.NewNSFWService(redisClient, hub, repositories)
-	apiService := services.NewAPIService(hub, repositories)
+	signer := urlsign.New(config.AppConfig.Security.URLSigningKey, time.Duration(config.AppConfig.Security.SignedURLTTLSec)*time.Second)
+
+	nsfwService := services.NewNSFWService(predictionCache, hub, repositories, store, signer)
+	apiService := services.NewAPIService(hub, repositories, predictionCache, store, signer, apiKeys)
 	handlersInstance := handlers.NewHandlers(repositories, hub)
 	nsfwHandlers := handlers.NewNSFWHandlers(handlersInstance, nsfwService)
 	apiHandlers := handlers.NewAPIHandlers(handlersInstance, apiService)
+	authHandlers := handlers.NewAuthHandlers(handlersInstance, sessions)
+	userHandlers := handlers.NewUserHandlers(handlersInstance, services.NewUserService(repositories, sessions, mfa))
+	mfaHandlers := handlers.NewMFAHandlers(handlersInstance, mfa)
+	apiKeyHandlers := handlers.NewAPIKeyHandlers(handlersInstance, apiKeys)
+	tenantHandlers := handlers.NewTenantHandlers(handlersInstance, services.NewTenantService(repositories))
+
+	rateLimit := middleware.RateLimit(buckets, config.AppConfig.Server.ReqPerSec, config.AppConfig.Server.Burst)
 
-	mux.Get("/ws", handlers.HandleWebSocket(hub))
+	mux.Get("/ws", handlers.HandleWebSocket(hub, tokens))
 
 	mux.Route("/api", func(r chi.Router) {
+		r.Use(
+			rateLimit,
+			middleware.APIKeyAuth(apiKeys, config.AppConfig.APIKeys.AllowAnonymous),
+			middleware.APIKeyRateLimit(buckets),
+			middleware.APIKeyQuota(apiKeys),
+		)
+
 		r.Post("/detect-nsfw", nsfwHandlers.NSFWHandler)
+		r.Post("/detect-nsfw/archive", nsfwHandlers.ArchiveHandler)
 	})
 
 	mux.Options("/*", func(w http.ResponseWriter, r *http.Request) {
@@ -45,16 +68,76 @@ func SetupRoutes(repositories *repositories.Repositories, redisClient *redis.Red
 	})
 
 	mux.Route("/admin", func(r chi.Router) {
-		r.Post("/login", apiHandlers.Login)
+		r.Group(func(r chi.Router) {
+			r.Use(rateLimit)
+
+			r.Post("/login", authHandlers.Login)
+			r.Post("/login/mfa", authHandlers.LoginMFA)
+			r.Post("/login/mfa/enroll", authHandlers.BeginMFAEnrollment)
+			r.Post("/login/mfa/enroll/verify", authHandlers.CompleteMFAEnrollment)
+			r.Post("/login/sso", authHandlers.LoginSSO)
+			r.Post("/refresh", authHandlers.Refresh)
+			r.Get("/oidc", handlers.SSOInfo)
+			if sso != nil {
+				ssoHandlers := handlers.NewSSOHandlers(handlersInstance, sso)
+				r.Get("/oidc/login", ssoHandlers.Start)
+				r.Get("/oidc/callback", ssoHandlers.Callback)
+			}
+		})
+
+		r.Get("/files/*", handlers.ServeFile(store.Uploads, signer))
+		r.Get("/previews/*", handlers.ServeFile(store.Previews, signer))
 
 		r.Group(func(r chi.Router) {
-			r.Use(middleware.JWTAuth)
+			r.Use(middleware.JWTAuth(tokens))
+
+			r.Post("/logout", authHandlers.Logout)
+			r.Get("/mfa", mfaHandlers.Status)
+			r.Post("/mfa/enroll", mfaHandlers.BeginEnrollment)
+			r.Post("/mfa/verify", mfaHandlers.ConfirmEnrollment)
+			r.Post("/mfa/disable", mfaHandlers.Disable)
+			r.Post("/mfa/recovery-codes", mfaHandlers.RegenerateRecoveryCodes)
+			r.With(middleware.RequireRole(models.RoleViewer)).Get("/stats", apiHandlers.Stats)
+			r.With(middleware.RequireRole(models.RoleViewer)).Get("/tenant", tenantHandlers.CurrentTenant)
+
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.RequireRole(models.RoleReviewer))
+
+				r.Get("/images", apiHandlers.PaginationUploads)
+				r.Post("/label/add/{hash}", apiHandlers.LabelImage)
+				r.Get("/history/{hash}", apiHandlers.ImageHistory)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.RequireRole(models.RoleSeniorReviewer))
+
+				r.Post("/label/update/{hash}", apiHandlers.LabelImage)
+				r.Get("/trash", apiHandlers.Trash)
+				r.Get("/events", apiHandlers.AuditFeed)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.RequireRole(models.RoleAdmin))
+
+				r.Post("/delete/{hash}", apiHandlers.DeleteImage)
+				r.Post("/restore/{hash}", apiHandlers.RestoreImage)
+				r.Get("/retention/report", apiHandlers.RetentionReport)
+				r.Post("/tenant/policy", tenantHandlers.SetPolicy)
+
+				r.Get("/users", userHandlers.ListUsers)
+				r.Post("/users", userHandlers.CreateUser)
+				r.Post("/users/{username}/role", userHandlers.SetRole)
+				r.Post("/users/{username}/password", userHandlers.ResetPassword)
+				r.Post("/users/{username}/disable", userHandlers.DisableUser)
+				r.Post("/users/{username}/enable", userHandlers.EnableUser)
+				r.Post("/users/{username}/unlock", userHandlers.UnlockUser)
+				r.Post("/users/{username}/mfa/reset", userHandlers.ResetMFA)
+				r.Post("/users/{username}/delete", userHandlers.DeleteUser)
 
-			r.Get("/images", apiHandlers.PaginationUploads)
-			r.Post("/label/add/{hash}", apiHandlers.LabelImage)
-			r.Post("/label/update/{hash}", apiHandlers.LabelImage)
-			r.Post("/delete/{hash}", apiHandlers.DeleteImage)
-			r.Get("/stats", apiHandlers.Stats)
+				r.Get("/api-keys", apiKeyHandlers.ListKeys)
+				r.Post("/api-keys", apiKeyHandlers.CreateKey)
+				r.Post("/api-keys/{id}/revoke", apiKeyHandlers.RevokeKey)
+			})
 		})
 	})
 
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/validation"
+)
+
+var (
+	// ErrInvalidAPIKey wraps rejected client names and limits of new keys
+	ErrInvalidAPIKey  = errors.New("Invalid API key settings")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrAPIKeyRevoked  = errors.New("API key already revoked")
+)
+
+// apiKeyTouchInterval is how often the last use of a busy key is written
+const apiKeyTouchInterval = time.Minute
+
+// APIKeyService issues the keys clients call the detection API with and checks them on
+// every request. Keys are only shown when they are created, revoking one takes effect
+// on its next request.
+type APIKeyService struct {
+	repositories *repositories.Repositories
+	limiter      *auth.APIKeyLimiter
+	defaults     config.APIKeysConfig
+}
+
+func NewAPIKeyService(repositories *repositories.Repositories, limiter *auth.APIKeyLimiter) *APIKeyService {
+	return &APIKeyService{
+		repositories: repositories,
+		limiter:      limiter,
+		defaults:     config.AppConfig.APIKeys,
+	}
+}
+
+// Create issues a key for a client. Limits left out of req get the configured defaults.
+func (s *APIKeyService) Create(ctx context.Context, req models.CreateAPIKeyRequest) (*models.CreatedAPIKey, error) {
+	if err := validation.ValidateClientName(req.ClientName); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAPIKey, err)
+	}
+
+	tenantID, err := ownTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key := models.APIKey{
+		ClientName:      req.ClientName,
+		RateLimitPerMin: limitOrDefault(req.RateLimitPerMin, s.defaults.DefaultRateLimitPerMin),
+		DailyQuota:      limitOrDefault(req.DailyQuota, s.defaults.DefaultDailyQuota),
+		MonthlyQuota:    limitOrDefault(req.MonthlyQuota, s.defaults.DefaultMonthlyQuota),
+		CreatedBy:       middleware.Username(ctx),
+		TenantID:        tenantID,
+	}
+	if key.RateLimitPerMin < 0 || key.DailyQuota < 0 || key.MonthlyQuota < 0 {
+		return nil, fmt.Errorf("%w: limits must not be negative, use 0 for unlimited", ErrInvalidAPIKey)
+	}
+
+	secret, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		logger.Error("Failed to generate API key: %v", err)
+		return nil, fmt.Errorf("Failed to create API key")
+	}
+	key.Prefix = prefix
+	key.KeyHash = auth.HashAPIKey(secret)
+
+	if err := s.repositories.APIKeys.CreateKey(ctx, key); err != nil {
+		logger.Error("Failed to create API key for %s: %v", req.ClientName, err)
+		return nil, fmt.Errorf("Failed to create API key")
+	}
+
+	created, err := s.repositories.APIKeys.GetKeyByHash(ctx, key.KeyHash)
+	if err != nil || created == nil {
+		logger.Error("Failed to fetch new API key of %s: %v", req.ClientName, err)
+		return nil, fmt.Errorf("Failed to create API key")
+	}
+
+	logger.Info("API key %s created for %s by %s", created.Prefix, created.ClientName, created.CreatedBy)
+	return &models.CreatedAPIKey{APIKey: *created, Key: secret}, nil
+}
+
+// List returns the keys of the tenant of ctx
+func (s *APIKeyService) List(ctx context.Context) ([]models.APIKey, error) {
+	keys, err := s.repositories.APIKeys.ListKeys(ctx, middleware.TenantID(ctx))
+	if err != nil {
+		logger.Error("Failed to list API keys: %v", err)
+		return nil, fmt.Errorf("Failed to list API keys")
+	}
+	return keys, nil
+}
+
+// Revoke stops the key with id from being accepted
+func (s *APIKeyService) Revoke(ctx context.Context, id int) (*models.APIKey, error) {
+	key, err := s.getKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+
+	if _, err := s.repositories.APIKeys.RevokeKey(ctx, id); err != nil {
+		logger.Error("Failed to revoke API key %d: %v", id, err)
+		return nil, fmt.Errorf("Failed to revoke API key")
+	}
+
+	logger.Info("API key %s of %s revoked by %s", key.Prefix, key.ClientName, middleware.Username(ctx))
+	return s.getKey(ctx, id)
+}
+
+// Authenticate returns the active key matching secret, or nil if there is none
+func (s *APIKeyService) Authenticate(ctx context.Context, secret string) (*models.APIKey, error) {
+	key, err := s.repositories.APIKeys.GetKeyByHash(ctx, auth.HashAPIKey(secret))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || key.RevokedAt != nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	if _, err := s.repositories.APIKeys.TouchKey(ctx, key.ID, now, now.Add(-apiKeyTouchInterval)); err != nil {
+		logger.Error("Failed to record use of API key %s: %v", key.Prefix, err)
+	}
+
+	return key, nil
+}
+
+// Allow counts a request made with key against its quotas, see auth.APIKeyLimiter
+func (s *APIKeyService) Allow(ctx context.Context, key *models.APIKey) error {
+	return s.limiter.Allow(ctx, key)
+}
+
+// Usage returns the requests of every active key of the tenant of ctx in the current UTC
+// day and month
+func (s *APIKeyService) Usage(ctx context.Context) ([]models.APIKeyUsage, error) {
+	keys, err := s.repositories.APIKeys.ListKeys(ctx, middleware.TenantID(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]models.APIKeyUsage, 0, len(keys))
+	for _, key := range keys {
+		if key.RevokedAt != nil {
+			continue
+		}
+
+		today, month, err := s.limiter.Usage(ctx, &key)
+		if err != nil {
+			return nil, err
+		}
+
+		usage = append(usage, models.APIKeyUsage{
+			ID:                key.ID,
+			Prefix:            key.Prefix,
+			ClientName:        key.ClientName,
+			RequestsToday:     today,
+			RequestsThisMonth: month,
+			DailyQuota:        key.DailyQuota,
+			MonthlyQuota:      key.MonthlyQuota,
+		})
+	}
+
+	return usage, nil
+}
+
+func (s *APIKeyService) getKey(ctx context.Context, id int) (*models.APIKey, error) {
+	key, err := s.repositories.APIKeys.GetKey(ctx, id)
+	if err != nil {
+		logger.Error("Failed to fetch API key %d: %v", id, err)
+		return nil, fmt.Errorf("Failed to fetch API key")
+	}
+	if key == nil || !inTenant(ctx, key.TenantID) {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+func limitOrDefault(limit *int, fallback int) int {
+	if limit == nil {
+		return fallback
+	}
+	return *limit
+}
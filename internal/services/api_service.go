@@ -3,64 +3,65 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
-	"time"
+	"math"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
 	"github.com/mlvieira/nsfwdetection/internal/config"
 	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
 	"github.com/mlvieira/nsfwdetection/internal/models"
 	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+	"github.com/mlvieira/nsfwdetection/internal/urlsign"
 	"github.com/mlvieira/nsfwdetection/internal/websockets"
 )
 
 type APIService struct {
 	hub          *websockets.Hub
 	repositories *repositories.Repositories
+	cache        *cache.Metered
+	store        *storage.Store
+	signer       *urlsign.Signer
+	apiKeys      *APIKeyService
 }
 
-var jwtSecretKey = []byte(config.AppConfig.Security.JWTSecretKey)
+// ErrRelabelForbidden is returned when a reviewer tries to change a label someone already reviewed
+var ErrRelabelForbidden = errors.New("Only senior reviewers can change a reviewed label")
 
-func NewAPIService(hub *websockets.Hub, repositories *repositories.Repositories) *APIService {
+func NewAPIService(hub *websockets.Hub, repositories *repositories.Repositories, cache *cache.Metered, store *storage.Store, signer *urlsign.Signer, apiKeys *APIKeyService) *APIService {
 	return &APIService{
 		hub:          hub,
 		repositories: repositories,
+		cache:        cache,
+		store:        store,
+		signer:       signer,
+		apiKeys:      apiKeys,
 	}
 }
 
-func (s *APIService) Login(ctx context.Context, username, password string) (string, error) {
-	user, err := s.repositories.User.CheckLogin(ctx, username, password)
-	if err != nil {
-		return "", fmt.Errorf("Invalid username or password")
-	}
-
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &models.Claims{
-		Username: user.Username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+// invalidateCache drops the cached prediction of hash for the tenant of ctx so its next
+// detect call sees the change
+func (s *APIService) invalidateCache(ctx context.Context, hash string) {
+	if s.cache == nil {
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecretKey)
-	if err != nil {
-		logger.Error("Failed to sign token: %v", err)
-		return "", fmt.Errorf("Failed to generate token")
+	if err := s.cache.Delete(ctx, cache.PredictionKey(middleware.TenantID(ctx), hash)); err != nil {
+		logger.Error("Failed to invalidate cache for %s: %v", hash, err)
 	}
-
-	return tokenString, nil
 }
 
 func (s *APIService) PaginationUploads(ctx context.Context, cursorID, limit int, reviewed *bool) (models.PaginatedResponse, error) {
-	uploads, err := s.repositories.Uploaded.ListUploadsCursor(ctx, cursorID, limit, reviewed)
+	tenantID := middleware.TenantID(ctx)
+
+	uploads, err := s.repositories.Uploaded.ListUploadsCursor(ctx, tenantID, cursorID, limit, reviewed)
 	if err != nil {
 		return models.PaginatedResponse{}, err
 	}
 
-	totalCount, err := s.repositories.Uploaded.ListTotalUploads(ctx, reviewed)
+	totalCount, err := s.repositories.Uploaded.ListTotalUploads(ctx, tenantID, reviewed)
 	if err != nil {
 		return models.PaginatedResponse{}, err
 	}
@@ -68,6 +69,9 @@ func (s *APIService) PaginationUploads(ctx context.Context, cursorID, limit int,
 	if uploads == nil {
 		uploads = []models.UploadedImage{}
 	}
+	for i := range uploads {
+		withURLs(s.signer, &uploads[i])
+	}
 
 	return models.PaginatedResponse{
 		Data:  uploads,
@@ -89,14 +93,26 @@ func (s *APIService) LabelImage(ctx context.Context, hash string, req models.Lab
 		return models.AckResponse{}, fmt.Errorf("Hash mismatch in URL and payload")
 	}
 
+	tenantID := middleware.TenantID(ctx)
+
+	if !models.HasRole(middleware.Role(ctx), models.RoleSeniorReviewer) {
+		img, err := s.repositories.Uploaded.GetImageByHash(ctx, tenantID, hash)
+		if err != nil {
+			return models.AckResponse{}, fmt.Errorf("Failed to fetch image")
+		}
+		if img != nil && img.Reviewed {
+			return models.AckResponse{}, ErrRelabelForbidden
+		}
+	}
+
 	status := map[string]string{
 		"event":  "in_progress",
 		"sha256": hash,
 	}
 	statusMsg, _ := json.Marshal(status)
-	s.hub.Broadcast <- statusMsg
+	s.hub.Broadcast <- websockets.Message{TenantID: tenantID, Data: statusMsg}
 
-	rows, err := s.repositories.Uploaded.LabelUpload(ctx, req.Sha256, req.Rating)
+	rows, err := s.repositories.Uploaded.LabelUpload(ctx, tenantID, req.Sha256, req.Rating, middleware.Username(ctx))
 	if err != nil {
 		return models.AckResponse{}, err
 	}
@@ -105,13 +121,15 @@ func (s *APIService) LabelImage(ctx context.Context, hash string, req models.Lab
 		return models.AckResponse{}, fmt.Errorf("Image not found")
 	}
 
+	s.invalidateCache(ctx, hash)
+
 	response := models.AckResponse{
 		Event:  "ack_rating",
 		Sha256: hash,
 		Status: "success",
 	}
 	statusMsg, _ = json.Marshal(response)
-	s.hub.Broadcast <- statusMsg
+	s.hub.Broadcast <- websockets.Message{TenantID: tenantID, Data: statusMsg}
 
 	return response, nil
 }
@@ -125,14 +143,16 @@ func (s *APIService) DeleteImage(ctx context.Context, hash string, req models.La
 		return models.AckResponse{}, fmt.Errorf("Hash mismatch in URL and payload")
 	}
 
+	tenantID := middleware.TenantID(ctx)
+
 	status := map[string]string{
 		"event":  "in_progress",
 		"sha256": hash,
 	}
 	statusMsg, _ := json.Marshal(status)
-	s.hub.Broadcast <- statusMsg
+	s.hub.Broadcast <- websockets.Message{TenantID: tenantID, Data: statusMsg}
 
-	path, err := s.repositories.Uploaded.GetFilePathByHash(ctx, req.Sha256)
+	path, err := s.repositories.Uploaded.GetFilePathByHash(ctx, tenantID, req.Sha256)
 	if err != nil {
 		return models.AckResponse{}, fmt.Errorf("Failed to fetch file path")
 	}
@@ -141,59 +161,195 @@ func (s *APIService) DeleteImage(ctx context.Context, hash string, req models.La
 		return models.AckResponse{}, fmt.Errorf("File does not exist")
 	}
 
-	_, fileName := filepath.Split(path)
-	backendPath := filepath.Join(config.AppConfig.FileHandling.UploadDir, fileName)
-
-	if err = os.Remove(backendPath); err != nil && !os.IsNotExist(err) {
-		return models.AckResponse{}, fmt.Errorf("Failed to delete file from filesystem")
+	if err = s.store.MoveToTrash(ctx, path); err != nil {
+		logger.Error("Failed to move %s to trash: %v", path, err)
+		return models.AckResponse{}, fmt.Errorf("Failed to move file to trash")
 	}
 
-	rows, err := s.repositories.Uploaded.DeleteImage(ctx, req.Sha256)
+	rows, err := s.repositories.Uploaded.DeleteImage(ctx, tenantID, req.Sha256, middleware.Username(ctx))
 	if err != nil {
+		s.store.RestoreFromTrash(ctx, path)
 		return models.AckResponse{}, fmt.Errorf("Failed to delete image from database")
 	}
 
 	if rows == 0 {
+		// deleted or trashed by another request since the path was read
+		s.store.RestoreFromTrash(ctx, path)
 		return models.AckResponse{}, fmt.Errorf("Image not found")
 	}
 
+	s.invalidateCache(ctx, hash)
+
 	response := models.AckResponse{
 		Event:  "ack_delete",
 		Sha256: hash,
 		Status: "success",
 	}
 	statusMsg, _ = json.Marshal(response)
-	s.hub.Broadcast <- statusMsg
+	s.hub.Broadcast <- websockets.Message{TenantID: tenantID, Data: statusMsg}
+
+	return response, nil
+}
+
+// RestoreImage takes a trashed image out of the trash
+func (s *APIService) RestoreImage(ctx context.Context, hash string, req models.LabelRequest) (models.AckResponse, error) {
+	if req.Event != "restore" {
+		return models.AckResponse{}, fmt.Errorf("Invalid event type")
+	}
+
+	if req.Sha256 != hash {
+		return models.AckResponse{}, fmt.Errorf("Hash mismatch in URL and payload")
+	}
+
+	tenantID := middleware.TenantID(ctx)
+
+	img, err := s.repositories.Uploaded.GetImageByHash(ctx, tenantID, hash)
+	if err != nil {
+		return models.AckResponse{}, fmt.Errorf("Failed to fetch image")
+	}
+
+	if img == nil || img.DeletedAt == nil {
+		return models.AckResponse{}, fmt.Errorf("Image not in trash")
+	}
+
+	if err = s.store.RestoreFromTrash(ctx, img.FilePath); err != nil {
+		logger.Error("Failed to restore %s from trash: %v", img.FilePath, err)
+		return models.AckResponse{}, fmt.Errorf("Failed to restore file from trash")
+	}
+
+	rows, err := s.repositories.Uploaded.RestoreImage(ctx, tenantID, hash, middleware.Username(ctx))
+	if err != nil {
+		s.store.MoveToTrash(ctx, img.FilePath)
+		return models.AckResponse{}, fmt.Errorf("Failed to restore image in database")
+	}
+
+	if rows == 0 {
+		// restored or purged by another request since the image was read
+		s.store.MoveToTrash(ctx, img.FilePath)
+		return models.AckResponse{}, fmt.Errorf("Image not in trash")
+	}
+
+	s.invalidateCache(ctx, hash)
+
+	response := models.AckResponse{
+		Event:  "ack_restore",
+		Sha256: hash,
+		Status: "success",
+	}
+	statusMsg, _ := json.Marshal(response)
+	s.hub.Broadcast <- websockets.Message{TenantID: tenantID, Data: statusMsg}
 
 	return response, nil
 }
 
+// ListTrash returns a page of trashed images, most recently uploaded first
+func (s *APIService) ListTrash(ctx context.Context, cursorID, limit int) (models.PaginatedResponse, error) {
+	if cursorID <= 0 {
+		cursorID = math.MaxInt32
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	images, err := s.repositories.Uploaded.ListTrashCursor(ctx, middleware.TenantID(ctx), cursorID, limit)
+	if err != nil {
+		return models.PaginatedResponse{}, fmt.Errorf("failed to fetch trash")
+	}
+
+	total, err := s.repositories.Uploaded.CountTrash(ctx, middleware.TenantID(ctx))
+	if err != nil {
+		return models.PaginatedResponse{}, fmt.Errorf("failed to count trash")
+	}
+
+	if images == nil {
+		images = []models.UploadedImage{}
+	}
+
+	return models.PaginatedResponse{
+		Data:  images,
+		Count: len(images),
+		Total: total,
+	}, nil
+}
+
+// ImageHistory returns every review action taken on an image, oldest first
+func (s *APIService) ImageHistory(ctx context.Context, hash string) ([]models.ImageEvent, error) {
+	events, err := s.repositories.Events.ListImageEvents(ctx, middleware.TenantID(ctx), hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image history")
+	}
+
+	if events == nil {
+		events = []models.ImageEvent{}
+	}
+
+	return events, nil
+}
+
+// AuditFeed returns a page of review actions across all images of the tenant, newest first
+func (s *APIService) AuditFeed(ctx context.Context, cursorID, limit int) (models.EventsResponse, error) {
+	if cursorID <= 0 {
+		cursorID = math.MaxInt32
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	events, err := s.repositories.Events.ListEventsCursor(ctx, middleware.TenantID(ctx), cursorID, limit)
+	if err != nil {
+		return models.EventsResponse{}, fmt.Errorf("failed to fetch audit feed")
+	}
+
+	response := models.EventsResponse{
+		Data:  events,
+		Count: len(events),
+	}
+
+	if events == nil {
+		response.Data = []models.ImageEvent{}
+	}
+
+	if len(events) == limit {
+		response.NextCursor = events[len(events)-1].ID
+	}
+
+	return response, nil
+}
+
+// FetchStats describes the uploads and reviews of the tenant of ctx
 func (s *APIService) FetchStats(ctx context.Context) (models.StatsResponse, error) {
-	totalImages, err := s.repositories.Uploaded.ListTotalUploads(ctx, nil)
+	tenantID := middleware.TenantID(ctx)
+
+	totalImages, err := s.repositories.Uploaded.ListTotalUploads(ctx, tenantID, nil)
 	if err != nil {
 		return models.StatsResponse{}, fmt.Errorf("failed to fetch count of uploads")
 	}
 
-	countLabeled, countUnlabeled, err := s.repositories.Stats.CountRevNonRevImages(ctx)
+	countLabeled, countUnlabeled, err := s.repositories.Stats.CountRevNonRevImages(ctx, tenantID)
 	if err != nil {
 		return models.StatsResponse{}, fmt.Errorf("failed to fetch count of reviewed and non reviewed images")
 	}
 
-	avgConfidence, err := s.repositories.Stats.AverageConfidence(ctx)
+	avgConfidence, err := s.repositories.Stats.AverageConfidence(ctx, tenantID)
 	if err != nil {
 		return models.StatsResponse{}, fmt.Errorf("failed to fetch avg for confidence")
 	}
 
-	labelDistribution, err := s.repositories.Stats.LabelDistribution(ctx)
+	labelDistribution, err := s.repositories.Stats.LabelDistribution(ctx, tenantID)
 	if err != nil {
 		return models.StatsResponse{}, fmt.Errorf("failed to fetch label distribution")
 	}
 
-	labelEfficiency, err := s.repositories.Stats.LabelingEfficiency(ctx)
+	labelEfficiency, err := s.repositories.Stats.LabelingEfficiency(ctx, tenantID)
 	if err != nil {
 		return models.StatsResponse{}, fmt.Errorf("failed to fetch label efficiency")
 	}
 
+	clientDistribution, err := s.repositories.Stats.ClientDistribution(ctx, tenantID)
+	if err != nil {
+		return models.StatsResponse{}, fmt.Errorf("failed to fetch client distribution")
+	}
+
 	response := models.StatsResponse{
 		TotalImages:        totalImages,
 		ReviewedImages:     countLabeled,
@@ -201,7 +357,27 @@ func (s *APIService) FetchStats(ctx context.Context) (models.StatsResponse, erro
 		AverageConfidence:  avgConfidence,
 		LabelDistribution:  labelDistribution,
 		LabelingEfficiency: labelEfficiency,
+		ClientDistribution: clientDistribution,
+	}
+
+	if s.apiKeys != nil {
+		usage, err := s.apiKeys.Usage(ctx)
+		if err != nil {
+			logger.Error("Failed to fetch API key usage: %v", err)
+			return models.StatsResponse{}, fmt.Errorf("failed to fetch api key usage")
+		}
+		response.APIKeys = usage
+	}
+
+	if s.cache != nil {
+		cacheStats := s.cache.Stats(tenantID)
+		response.Cache = &cacheStats
 	}
 
 	return response, nil
 }
+
+// RetentionReport lists what the configured retention rules would delete right now
+func (s *APIService) RetentionReport(ctx context.Context) (models.RetentionReport, error) {
+	return NewRetentionService(s.repositories, s.cache, s.store, config.AppConfig.Retention).Report(ctx)
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+	"github.com/mlvieira/nsfwdetection/internal/urlsign"
+	"github.com/mlvieira/nsfwdetection/internal/websockets"
+)
+
+// racingUploads loses every delete and restore to a concurrent request: its statements
+// affect no rows
+type racingUploads struct {
+	repositories.UploadedRepository
+}
+
+func (racingUploads) DeleteImage(ctx context.Context, tenantID int, hash, username string) (int, error) {
+	return 0, nil
+}
+
+func (racingUploads) RestoreImage(ctx context.Context, tenantID int, hash, username string) (int, error) {
+	return 0, nil
+}
+
+// TestTrashLostRace checks a delete or restore that changed no row puts the file back
+// where the database expects it
+func TestTrashLostRace(t *testing.T) {
+	repos := openRepositories(t)
+	store := newStore(t)
+	hub := websockets.NewHub()
+	go hub.Run()
+	s := NewAPIService(hub, repos, cache.NewMetered(cache.NewMemoryStore()), store, urlsign.New("test", time.Minute), nil)
+
+	ctx := asUser("alice", models.RoleAdmin, models.DefaultTenantID)
+	hash := addImage(t, repos, store, models.DefaultTenantID, strings.Repeat("a", 64))
+	key := storage.Key(models.DefaultTenantID, hash, "photo.jpg")
+	uploaded := repos.Uploaded
+
+	repos.Uploaded = racingUploads{uploaded}
+	if _, err := s.DeleteImage(ctx, hash, models.LabelRequest{Event: "delete", Sha256: hash}, hub); err == nil {
+		t.Fatal("DeleteImage succeeded without deleting a row")
+	}
+	if _, err := store.Uploads.Stat(ctx, key); err != nil {
+		t.Fatalf("file of the image left in the trash: %v", err)
+	}
+
+	repos.Uploaded = uploaded
+	if _, err := s.DeleteImage(ctx, hash, models.LabelRequest{Event: "delete", Sha256: hash}, hub); err != nil {
+		t.Fatalf("DeleteImage = %v", err)
+	}
+
+	repos.Uploaded = racingUploads{uploaded}
+	if _, err := s.RestoreImage(ctx, hash, models.LabelRequest{Event: "restore", Sha256: hash}); err == nil {
+		t.Fatal("RestoreImage succeeded without restoring a row")
+	}
+	if _, err := store.Trash.Stat(ctx, key); err != nil {
+		t.Fatalf("file of the trashed image left outside the trash: %v", err)
+	}
+	if _, err := store.Uploads.Stat(ctx, key); err == nil {
+		t.Fatal("file of the trashed image is still among the uploads")
+	}
+}
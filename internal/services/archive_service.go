@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/archive"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/tfmodel"
+)
+
+// ArchiveEntryResult is the outcome of one entry of an uploaded archive. Index is the position
+// of the entry in the archive, names can repeat.
+type ArchiveEntryResult struct {
+	Index      int                 `json:"index"`
+	Name       string              `json:"name"`
+	Prediction *tfmodel.Prediction `json:"prediction"`
+}
+
+// ArchiveReport summarizes an uploaded archive once every entry has been reported
+type ArchiveReport struct {
+	Archive   string  `json:"archive"`
+	Total     int     `json:"total"`
+	Succeeded int     `json:"succeeded"`
+	Failed    int     `json:"failed"`
+	Duration  float64 `json:"duration"`
+}
+
+// ArchiveEvent is a line of the streamed archive response, an entry or the final report
+type ArchiveEvent struct {
+	Entry  *ArchiveEntryResult `json:"entry,omitempty"`
+	Report *ArchiveReport      `json:"report,omitempty"`
+}
+
+// ValidateArchive parses the multipart form and retrieves the file sent in the 'archive' field.
+func (s *NSFWService) ValidateArchive(w http.ResponseWriter, r *http.Request) (multipart.File, *multipart.FileHeader, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.AppConfig.FileHandling.MaxArchiveSizeMB)
+
+	if err := r.ParseMultipartForm(config.AppConfig.FileHandling.MaxFileSizeMB); err != nil {
+		logger.Error("Failed to parse archive form: %v", err)
+		return nil, nil, errors.New("failed to parse form data")
+	}
+
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		logger.Error("No archive uploaded in request: %v", err)
+		return nil, nil, errors.New("no archive uploaded")
+	}
+
+	return file, header, nil
+}
+
+// ProcessArchive extracts an archive into the temp upload directory and runs every entry
+// through the same pipeline as individually uploaded files, handing each result to emit as
+// soon as it is known. Errors returned before the first call to emit reject the archive as
+// a whole, later ones mean the caller went away and the remaining entries were skipped.
+func (s *NSFWService) ProcessArchive(ctx context.Context, file multipart.File, header *multipart.FileHeader, emit func(ArchiveEntryResult) error) (*ArchiveReport, error) {
+	startTime := time.Now()
+	logger.Info("Processing archive: %s (%d bytes)", header.Filename, header.Size)
+
+	entries, err := archive.Extract(file, header.Size, config.AppConfig.FileHandling.TempUploadDir, archive.Limits{
+		MaxEntries:          config.AppConfig.FileHandling.MaxArchiveEntries,
+		MaxEntrySize:        config.AppConfig.FileHandling.MaxFileSizeMB,
+		MaxTotalSize:        config.AppConfig.FileHandling.MaxArchiveExtractedMB,
+		MaxCompressionRatio: config.AppConfig.FileHandling.MaxCompressionRatio,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract archive: %w", err)
+	}
+	defer archive.Cleanup(entries)
+
+	tenant, err := currentTenant(ctx, s.repositories)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ArchiveReport{
+		Archive: header.Filename,
+		Total:   len(entries),
+	}
+
+	for id, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			logger.Info("Archive %s abandoned after %d of %d entries", header.Filename, id, len(entries))
+			return nil, err
+		}
+		entryStartTime := time.Now()
+
+		var prediction *tfmodel.Prediction
+		if entry.Err != nil {
+			prediction = s.createPredictionError(id, entry.Err.Error(), entry.Name, entryStartTime)
+		} else {
+			prediction = s.processArchiveEntry(ctx, tenant, id, entry, entryStartTime)
+		}
+
+		if prediction.Success {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+
+		if err := emit(ArchiveEntryResult{Index: id, Name: entry.Name, Prediction: prediction}); err != nil {
+			logger.Error("Failed to report entry %d of archive %s: %v", id, header.Filename, err)
+			return nil, err
+		}
+	}
+
+	report.Duration = time.Since(startTime).Seconds()
+	logger.Info("Archive %s processed: %d entries, %d failed", header.Filename, report.Total, report.Failed)
+
+	return report, nil
+}
+
+// processArchiveEntry opens an extracted entry and hands it to processFile
+func (s *NSFWService) processArchiveEntry(ctx context.Context, tenant *models.Tenant, id int, entry archive.Entry, startTime time.Time) *tfmodel.Prediction {
+	file, err := os.Open(entry.TempPath)
+	if err != nil {
+		logger.Error("Failed to open extracted entry %s: %v", entry.Name, err)
+		return s.createPredictionError(id, "Failed to open file", entry.Name, startTime)
+	}
+	defer file.Close()
+
+	return s.processFile(ctx, tenant, id, entry.Name, file, startTime)
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+)
+
+var (
+	// ErrInvalidCredentials is returned by Login for unknown users, wrong passwords and disabled users
+	ErrInvalidCredentials = errors.New("Invalid username or password")
+	// ErrInvalidRefreshToken is returned by Refresh for tokens that are unknown, expired, used or revoked
+	ErrInvalidRefreshToken = errors.New("Invalid or expired refresh token")
+	// ErrInvalidMFAToken is returned for second login steps whose token is invalid, expired or used
+	ErrInvalidMFAToken = errors.New("Login expired, please sign in again")
+)
+
+// AuthService manages sessions: a login hands out a short lived access token and a
+// refresh token. A refresh token is exchanged once for a new pair, so a session lasts
+// as long as it keeps being used. Presenting a token that was already exchanged means
+// two parties hold it, and the whole session is revoked.
+//
+// Users with 2FA enabled, or whose role requires it, log in in two steps. The password
+// check hands out a short lived MFA token, which the second step exchanges together with
+// a code for the session, or uses to enroll first.
+type AuthService struct {
+	repositories *repositories.Repositories
+	tokens       *auth.Tokens
+	guard        *auth.LoginGuard
+	mfa          *MFAService
+	refreshTTL   time.Duration
+}
+
+func NewAuthService(repositories *repositories.Repositories, tokens *auth.Tokens, guard *auth.LoginGuard, mfa *MFAService) *AuthService {
+	return &AuthService{
+		repositories: repositories,
+		tokens:       tokens,
+		guard:        guard,
+		mfa:          mfa,
+		refreshTTL:   time.Duration(config.AppConfig.Security.RefreshTokenTTLHours) * time.Hour,
+	}
+}
+
+// Login checks a user's password and starts a session, or asks for the second step.
+// Attempts from ip are throttled by the login guard, which returns an
+// *auth.ThrottledError while they are held back.
+func (s *AuthService) Login(ctx context.Context, username, password, ip string) (models.LoginResponse, error) {
+	if err := s.guard.Check(ctx, username, ip); err != nil {
+		var throttled *auth.ThrottledError
+		if errors.As(err, &throttled) {
+			logger.Info("Login for %q from %s refused: %v", username, ip, err)
+		}
+		return models.LoginResponse{}, err
+	}
+
+	user, err := s.repositories.User.CheckLogin(ctx, username, password)
+	if err != nil {
+		logger.Info("Failed login for %q from %s: %v", username, ip, err)
+
+		locked, err := s.guard.Fail(ctx, username, ip)
+		if err != nil {
+			logger.Error("Failed to record failed login for %q: %v", username, err)
+		}
+		if locked {
+			logger.Info("Logins for %q or from %s locked out after repeated failures", username, ip)
+		}
+		return models.LoginResponse{}, ErrInvalidCredentials
+	}
+
+	// failures are only forgotten once the whole login succeeded, so the password
+	// check cannot be used to reset the throttling of code guesses
+	switch {
+	case user.MFAEnabledAt != nil:
+		return s.challenge(user.Username, user.Role, auth.PurposeMFA)
+	case s.mfa.Required(user.Role):
+		logger.Info("User %s must enroll in two-factor authentication", user.Username)
+		return s.challenge(user.Username, user.Role, auth.PurposeMFAEnroll)
+	}
+
+	return s.start(ctx, user, ip)
+}
+
+// CompleteMFA is the second login step, checking a TOTP code or a recovery code
+func (s *AuthService) CompleteMFA(ctx context.Context, mfaToken, code, ip string) (models.LoginResponse, error) {
+	claims, err := s.parseChallenge(ctx, mfaToken, auth.PurposeMFA)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	if err := s.mfa.Verify(ctx, claims.Username, code, ip); err != nil {
+		if errors.Is(err, ErrMFANotEnabled) {
+			return models.LoginResponse{}, ErrInvalidMFAToken
+		}
+		return models.LoginResponse{}, err
+	}
+
+	return s.finish(ctx, claims, ip)
+}
+
+// BeginMFAEnrollment hands a new secret to a login that must enroll before it completes
+func (s *AuthService) BeginMFAEnrollment(ctx context.Context, mfaToken string) (models.MFAEnrollment, error) {
+	claims, err := s.parseChallenge(ctx, mfaToken, auth.PurposeMFAEnroll)
+	if err != nil {
+		return models.MFAEnrollment{}, err
+	}
+	return s.mfa.BeginEnrollment(ctx, claims.Username)
+}
+
+// CompleteMFAEnrollment confirms the secret of a forced enrollment with a first code and
+// starts the session. The response carries the new recovery codes.
+func (s *AuthService) CompleteMFAEnrollment(ctx context.Context, mfaToken, code, ip string) (models.LoginResponse, error) {
+	claims, err := s.parseChallenge(ctx, mfaToken, auth.PurposeMFAEnroll)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	codes, err := s.mfa.ConfirmEnrollment(ctx, claims.Username, code, ip)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	response, err := s.finish(ctx, claims, ip)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+	response.RecoveryCodes = codes
+
+	return response, nil
+}
+
+// Unlock lifts a login lockout of username
+func (s *AuthService) Unlock(ctx context.Context, username string) error {
+	return s.guard.Unlock(ctx, username)
+}
+
+// Refresh exchanges a refresh token for a new pair. The role is read again, so a role
+// change applies to a session from its next refresh on.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (models.LoginResponse, error) {
+	stored, err := s.repositories.Tokens.GetToken(ctx, hashRefreshToken(refreshToken))
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+	if stored == nil || stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return models.LoginResponse{}, ErrInvalidRefreshToken
+	}
+
+	used, err := s.repositories.Tokens.MarkUsed(ctx, stored.ID)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+	if used == 0 {
+		logger.Info("Refresh token of %s was reused, revoking the session", stored.Username)
+		if _, err := s.repositories.Tokens.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			logger.Error("Failed to revoke session of %s: %v", stored.Username, err)
+		}
+		return models.LoginResponse{}, ErrInvalidRefreshToken
+	}
+
+	user, err := s.repositories.User.GetUser(ctx, stored.Username)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+	if user == nil || user.DisabledAt != nil {
+		return models.LoginResponse{}, ErrInvalidRefreshToken
+	}
+
+	return s.issue(ctx, *user, stored.FamilyID)
+}
+
+// Logout revokes the access token described by claims and, when given, the session of
+// refreshToken. A refresh token belonging to someone else is ignored.
+func (s *AuthService) Logout(ctx context.Context, claims *models.Claims, refreshToken string) error {
+	if err := s.tokens.Revoke(ctx, claims); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	stored, err := s.repositories.Tokens.GetToken(ctx, hashRefreshToken(refreshToken))
+	if err != nil {
+		return err
+	}
+	if stored == nil || stored.Username != claims.Username {
+		return nil
+	}
+
+	_, err = s.repositories.Tokens.RevokeFamily(ctx, stored.FamilyID)
+	return err
+}
+
+// EndSessions logs username out everywhere: refresh tokens are revoked and access
+// tokens already issued stop working right away
+func (s *AuthService) EndSessions(ctx context.Context, username string) error {
+	if _, err := s.repositories.Tokens.RevokeUser(ctx, username); err != nil {
+		return err
+	}
+	return s.RevokeAccessTokens(ctx, username)
+}
+
+// RevokeAccessTokens makes the access tokens already issued to username stop working.
+// Sessions go on with their next refresh, which picks up the user's current role.
+func (s *AuthService) RevokeAccessTokens(ctx context.Context, username string) error {
+	if err := s.tokens.RevokeUser(ctx, username); err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+	return nil
+}
+
+// Run deletes expired refresh tokens every interval until ctx is cancelled
+func (s *AuthService) Run(ctx context.Context, interval time.Duration) {
+	runPeriodically(ctx, "Refresh token cleanup", interval, func(ctx context.Context) (int, error) {
+		return s.repositories.Tokens.DeleteExpired(ctx, time.Now())
+	})
+}
+
+// CompleteSSO exchanges the token of a single sign-on callback for a session. The
+// identity provider is trusted with the second factor, local 2FA is not asked for.
+func (s *AuthService) CompleteSSO(ctx context.Context, ssoToken, ip string) (models.LoginResponse, error) {
+	claims, err := s.parseChallenge(ctx, ssoToken, auth.PurposeSSO)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+	return s.finish(ctx, claims, ip)
+}
+
+// challenge answers a correct password with the MFA token for the second step
+func (s *AuthService) challenge(username, role, purpose string) (models.LoginResponse, error) {
+	token, _, err := s.tokens.IssueChallenge(username, role, purpose)
+	if err != nil {
+		logger.Error("Failed to sign token: %v", err)
+		return models.LoginResponse{}, fmt.Errorf("Failed to generate token")
+	}
+
+	return models.LoginResponse{
+		MFARequired:           purpose == auth.PurposeMFA,
+		MFAEnrollmentRequired: purpose == auth.PurposeMFAEnroll,
+		MFAToken:              token,
+	}, nil
+}
+
+// parseChallenge verifies the MFA token of a second login step
+func (s *AuthService) parseChallenge(ctx context.Context, mfaToken, purpose string) (*models.Claims, error) {
+	claims, err := s.tokens.ParseChallenge(ctx, mfaToken, purpose)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrRevoked) {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+	return claims, nil
+}
+
+// finish ends a two step login: its MFA token is used up and the session starts with the
+// user's current role, unless they were disabled meanwhile
+func (s *AuthService) finish(ctx context.Context, claims *models.Claims, ip string) (models.LoginResponse, error) {
+	if err := s.tokens.Revoke(ctx, claims); err != nil {
+		return models.LoginResponse{}, fmt.Errorf("failed to revoke MFA token: %w", err)
+	}
+
+	user, err := s.repositories.User.GetUser(ctx, claims.Username)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+	if user == nil || user.DisabledAt != nil {
+		return models.LoginResponse{}, ErrInvalidMFAToken
+	}
+
+	return s.start(ctx, *user, ip)
+}
+
+// start begins a new session after a successful login
+func (s *AuthService) start(ctx context.Context, user models.User, ip string) (models.LoginResponse, error) {
+	if err := s.guard.Succeed(ctx, user.Username); err != nil {
+		logger.Error("Failed to reset failed logins of %s: %v", user.Username, err)
+	}
+	logger.Info("User %s logged in from %s", user.Username, ip)
+
+	return s.issue(ctx, user, uuid.New().String())
+}
+
+// issue creates an access token for the user's tenant and a refresh token in family
+func (s *AuthService) issue(ctx context.Context, user models.User, family string) (models.LoginResponse, error) {
+	accessToken, _, err := s.tokens.Issue(user.Username, user.Role, user.TenantID)
+	if err != nil {
+		logger.Error("Failed to sign token: %v", err)
+		return models.LoginResponse{}, fmt.Errorf("Failed to generate token")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return models.LoginResponse{}, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(secret)
+
+	err = s.repositories.Tokens.CreateToken(ctx, models.RefreshToken{
+		TokenHash: hashRefreshToken(refreshToken),
+		FamilyID:  family,
+		Username:  user.Username,
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+	})
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	return models.LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(s.tokens.TTL().Seconds()),
+	}, nil
+}
+
+// hashRefreshToken returns what is stored for a refresh token, so a leaked table
+// holds nothing that can be exchanged
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
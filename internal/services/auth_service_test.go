@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+func TestRefreshRotation(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	s := newAuthServices(repos)
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+
+	login, err := s.sessions.Login(ctx, "alice", testPassword, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Login = %v", err)
+	}
+	if login.Token == "" || login.RefreshToken == "" {
+		t.Fatalf("Login = %+v, want a token pair", login)
+	}
+
+	rotated, err := s.sessions.Refresh(ctx, login.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh = %v", err)
+	}
+	if rotated.RefreshToken == login.RefreshToken {
+		t.Fatal("Refresh handed back the same refresh token")
+	}
+	if _, err := s.tokens.Parse(ctx, rotated.Token); err != nil {
+		t.Fatalf("access token of the refresh is invalid: %v", err)
+	}
+
+	// the first token was exchanged already: somebody else holds it, end the session
+	if _, err := s.sessions.Refresh(ctx, login.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Refresh(reused) = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := s.sessions.Refresh(ctx, rotated.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Refresh after reuse = %v, want the whole session revoked", err)
+	}
+
+	if _, err := s.sessions.Refresh(ctx, "not a token"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Refresh(unknown) = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	s := newAuthServices(repos)
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+	addUser(t, repos, "bob", models.RoleAdmin, models.DefaultTenantID)
+
+	alice, _ := s.sessions.Login(ctx, "alice", testPassword, "10.0.0.1")
+	other, _ := s.sessions.Login(ctx, "alice", testPassword, "10.0.0.2")
+	bob, _ := s.sessions.Login(ctx, "bob", testPassword, "10.0.0.3")
+
+	claims, err := s.tokens.Parse(ctx, alice.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a refresh token of somebody else is left alone
+	if err := s.sessions.Logout(ctx, claims, bob.RefreshToken); err != nil {
+		t.Fatalf("Logout = %v", err)
+	}
+	if _, err := s.sessions.Refresh(ctx, bob.RefreshToken); err != nil {
+		t.Fatalf("Logout of alice ended the session of bob: %v", err)
+	}
+	if _, err := s.tokens.Parse(ctx, alice.Token); !errors.Is(err, auth.ErrRevoked) {
+		t.Fatalf("Parse after logout = %v, want ErrRevoked", err)
+	}
+
+	if err := s.sessions.Logout(ctx, claims, alice.RefreshToken); err != nil {
+		t.Fatalf("Logout = %v", err)
+	}
+	if _, err := s.sessions.Refresh(ctx, alice.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Refresh after logout = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	// other sessions of the same user go on
+	if _, err := s.tokens.Parse(ctx, other.Token); err != nil {
+		t.Fatalf("Logout ended another session: %v", err)
+	}
+	if _, err := s.sessions.Refresh(ctx, other.RefreshToken); err != nil {
+		t.Fatalf("Logout ended another session: %v", err)
+	}
+}
+
+func TestEndSessions(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	s := newAuthServices(repos)
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+	addUser(t, repos, "bob", models.RoleAdmin, models.DefaultTenantID)
+
+	first, _ := s.sessions.Login(ctx, "alice", testPassword, "10.0.0.1")
+	second, _ := s.sessions.Login(ctx, "alice", testPassword, "10.0.0.2")
+	bob, _ := s.sessions.Login(ctx, "bob", testPassword, "10.0.0.3")
+
+	if err := s.sessions.EndSessions(ctx, "alice"); err != nil {
+		t.Fatalf("EndSessions = %v", err)
+	}
+
+	for _, session := range []models.LoginResponse{first, second} {
+		if _, err := s.tokens.Parse(ctx, session.Token); !errors.Is(err, auth.ErrRevoked) {
+			t.Fatalf("Parse after EndSessions = %v, want ErrRevoked", err)
+		}
+		if _, err := s.sessions.Refresh(ctx, session.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Fatalf("Refresh after EndSessions = %v, want ErrInvalidRefreshToken", err)
+		}
+	}
+
+	if _, err := s.tokens.Parse(ctx, bob.Token); err != nil {
+		t.Fatalf("EndSessions of alice revoked bob: %v", err)
+	}
+}
+
+func TestRefreshDisabledUser(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	s := newAuthServices(repos)
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+
+	login, _ := s.sessions.Login(ctx, "alice", testPassword, "10.0.0.1")
+	if _, err := repos.User.SetDisabled(ctx, "alice", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.sessions.Refresh(ctx, login.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Refresh of a disabled user = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestLoginThrottled(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	s := newAuthServices(repos)
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+
+	for _, username := range []string{"alice", "ghost"} {
+		for i := 0; i < 2; i++ {
+			if _, err := s.sessions.Login(ctx, username, "wrong password", "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Login(%s, wrong password) = %v, want ErrInvalidCredentials", username, err)
+			}
+		}
+
+		// the right password is not even checked while the user is held back, unknown
+		// users are counted like known ones so delays don't reveal who exists
+		_, err := s.sessions.Login(ctx, username, testPassword, "10.0.0.2")
+		var throttled *auth.ThrottledError
+		if !errors.As(err, &throttled) || throttled.Locked {
+			t.Fatalf("Login(%s) while delayed = %v, want a delay", username, err)
+		}
+	}
+
+	if err := s.sessions.Unlock(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.sessions.Login(ctx, "alice", testPassword, "10.0.0.2"); err != nil {
+		t.Fatalf("Login after Unlock = %v", err)
+	}
+}
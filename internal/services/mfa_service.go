@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time
+const recoveryCodeCount = 10
+
+var (
+	// ErrInvalidMFACode is returned for wrong, reused or expired TOTP codes and unknown recovery codes
+	ErrInvalidMFACode = errors.New("Invalid authentication code")
+	// ErrMFAEnabled is returned when enrolling a user who already has 2FA enabled
+	ErrMFAEnabled = errors.New("Two-factor authentication is already enabled")
+	// ErrMFANotEnabled is returned for changes that need 2FA, or a pending enrollment, to exist
+	ErrMFANotEnabled = errors.New("Two-factor authentication is not enabled")
+	// ErrMFARequired is returned when disabling 2FA for a role that must use it
+	ErrMFARequired = errors.New("Two-factor authentication is required for your role")
+)
+
+// MFAService manages TOTP two-factor authentication. A user enrolls by fetching a new
+// secret and confirming it with a first code, which also hands out single use recovery
+// codes for a lost device. Codes are checked through the login guard, so guessing them
+// is throttled like guessing passwords.
+type MFAService struct {
+	repositories  *repositories.Repositories
+	guard         *auth.LoginGuard
+	issuer        string
+	requiredRoles map[string]bool
+}
+
+func NewMFAService(repositories *repositories.Repositories, guard *auth.LoginGuard) *MFAService {
+	required := make(map[string]bool)
+	for _, role := range config.AppConfig.Security.MFARequiredRoles {
+		required[role] = true
+	}
+
+	return &MFAService{
+		repositories:  repositories,
+		guard:         guard,
+		issuer:        config.AppConfig.Security.MFAIssuer,
+		requiredRoles: required,
+	}
+}
+
+// Required reports whether users with role must use 2FA
+func (s *MFAService) Required(role string) bool {
+	return s.requiredRoles[role]
+}
+
+// Status describes the 2FA setup of username
+func (s *MFAService) Status(ctx context.Context, username string) (models.MFAStatus, error) {
+	user, err := s.getUser(ctx, username)
+	if err != nil {
+		return models.MFAStatus{}, err
+	}
+
+	status := models.MFAStatus{
+		Enabled:   user.MFAEnabledAt != nil,
+		EnabledAt: user.MFAEnabledAt,
+		Required:  s.Required(user.Role),
+	}
+	if !status.Enabled {
+		return status, nil
+	}
+
+	left, err := s.repositories.MFA.CountRecoveryCodes(ctx, user.Username)
+	if err != nil {
+		logger.Error("Failed to count recovery codes of %s: %v", user.Username, err)
+		return models.MFAStatus{}, fmt.Errorf("Failed to fetch two-factor status")
+	}
+	status.RecoveryCodes = left
+
+	return status, nil
+}
+
+// BeginEnrollment stores a new secret for username, replacing any earlier unconfirmed one
+func (s *MFAService) BeginEnrollment(ctx context.Context, username string) (models.MFAEnrollment, error) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		logger.Error("Failed to generate TOTP secret: %v", err)
+		return models.MFAEnrollment{}, fmt.Errorf("Failed to start enrollment")
+	}
+
+	stored, err := s.repositories.MFA.SetPendingTOTP(ctx, username, secret)
+	if err != nil {
+		logger.Error("Failed to store TOTP secret of %s: %v", username, err)
+		return models.MFAEnrollment{}, fmt.Errorf("Failed to start enrollment")
+	}
+	if stored == 0 {
+		return models.MFAEnrollment{}, ErrMFAEnabled
+	}
+
+	return models.MFAEnrollment{Secret: secret, URI: auth.TOTPURI(s.issuer, username, secret)}, nil
+}
+
+// ConfirmEnrollment enables the pending secret of username once code matches it and
+// returns the new recovery codes
+func (s *MFAService) ConfirmEnrollment(ctx context.Context, username, code, ip string) ([]string, error) {
+	if err := s.guard.Check(ctx, username, ip); err != nil {
+		return nil, err
+	}
+
+	totp, err := s.repositories.MFA.GetTOTP(ctx, username)
+	if err != nil {
+		logger.Error("Failed to fetch TOTP setup of %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to confirm enrollment")
+	}
+	if totp == nil {
+		return nil, ErrMFANotEnabled
+	}
+	if totp.EnabledAt != nil {
+		return nil, ErrMFAEnabled
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return nil, s.fail(ctx, username, ip)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		logger.Error("Failed to generate recovery codes: %v", err)
+		return nil, fmt.Errorf("Failed to confirm enrollment")
+	}
+
+	if err := s.repositories.MFA.EnableTOTP(ctx, username, step, hashes); err != nil {
+		logger.Error("Failed to enable TOTP for %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to confirm enrollment")
+	}
+
+	logger.Info("Two-factor authentication enabled for %s", username)
+	return codes, nil
+}
+
+// Verify checks a TOTP code or a recovery code of username. A TOTP code is accepted once,
+// a recovery code is used up.
+func (s *MFAService) Verify(ctx context.Context, username, code, ip string) error {
+	if err := s.guard.Check(ctx, username, ip); err != nil {
+		return err
+	}
+
+	totp, err := s.repositories.MFA.GetTOTP(ctx, username)
+	if err != nil {
+		return fmt.Errorf("failed to fetch TOTP setup: %w", err)
+	}
+	if totp == nil || totp.EnabledAt == nil {
+		return ErrMFANotEnabled
+	}
+
+	if step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now()); ok {
+		accepted, err := s.repositories.MFA.UseTOTPStep(ctx, username, step)
+		if err != nil {
+			return err
+		}
+		if accepted == 0 {
+			return s.fail(ctx, username, ip)
+		}
+		return nil
+	}
+
+	used, err := s.repositories.MFA.UseRecoveryCode(ctx, username, auth.HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if used == 0 {
+		return s.fail(ctx, username, ip)
+	}
+
+	logger.Info("Recovery code of %s used from %s", username, ip)
+	return nil
+}
+
+// Disable turns 2FA off for username after checking one of their codes, unless their
+// role requires it
+func (s *MFAService) Disable(ctx context.Context, username, code, ip string) error {
+	user, err := s.getUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	if s.Required(user.Role) {
+		return ErrMFARequired
+	}
+
+	if err := s.Verify(ctx, user.Username, code, ip); err != nil {
+		return s.internal(err, "Failed to disable two-factor authentication")
+	}
+
+	if _, err := s.repositories.MFA.ClearTOTP(ctx, user.Username); err != nil {
+		logger.Error("Failed to clear TOTP of %s: %v", user.Username, err)
+		return fmt.Errorf("Failed to disable two-factor authentication")
+	}
+
+	logger.Info("Two-factor authentication disabled by %s", user.Username)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of username after checking one of their codes
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, username, code, ip string) ([]string, error) {
+	if err := s.Verify(ctx, username, code, ip); err != nil {
+		return nil, s.internal(err, "Failed to generate recovery codes")
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		logger.Error("Failed to generate recovery codes: %v", err)
+		return nil, fmt.Errorf("Failed to generate recovery codes")
+	}
+
+	if err := s.repositories.MFA.ReplaceRecoveryCodes(ctx, username, hashes); err != nil {
+		logger.Error("Failed to store recovery codes of %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to generate recovery codes")
+	}
+
+	logger.Info("Recovery codes of %s regenerated", username)
+	return codes, nil
+}
+
+// Reset removes the 2FA setup of username, for users who lost both their device and
+// their recovery codes. Users whose role requires 2FA enroll again at their next login.
+func (s *MFAService) Reset(ctx context.Context, username string) error {
+	if _, err := s.repositories.MFA.ClearTOTP(ctx, username); err != nil {
+		return fmt.Errorf("failed to reset TOTP: %w", err)
+	}
+	return nil
+}
+
+func (s *MFAService) getUser(ctx context.Context, username string) (*models.User, error) {
+	user, err := s.repositories.User.GetUser(ctx, username)
+	if err != nil {
+		logger.Error("Failed to fetch user %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to fetch user")
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// fail records a wrong code with the login guard
+func (s *MFAService) fail(ctx context.Context, username, ip string) error {
+	logger.Info("Invalid authentication code for %s from %s", username, ip)
+
+	locked, err := s.guard.Fail(ctx, username, ip)
+	if err != nil {
+		logger.Error("Failed to record failed login for %q: %v", username, err)
+	}
+	if locked {
+		logger.Info("Logins for %q or from %s locked out after repeated failures", username, ip)
+	}
+	return ErrInvalidMFACode
+}
+
+// internal passes the errors of Verify meant for users through and logs the others
+// behind message
+func (s *MFAService) internal(err error, message string) error {
+	var throttled *auth.ThrottledError
+	if errors.Is(err, ErrInvalidMFACode) || errors.Is(err, ErrMFANotEnabled) || errors.As(err, &throttled) {
+		return err
+	}
+	logger.Error("%s: %v", message, err)
+	return errors.New(message)
+}
+
+// newRecoveryCodes returns a fresh set of recovery codes and the hashes to store for them
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
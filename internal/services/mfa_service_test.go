@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+// totpAt computes the code an authenticator app shows for secret at the given time
+func totpAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		t.Fatalf("invalid TOTP secret %q: %v", secret, err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// enroll turns 2FA on for username and returns its secret and recovery codes, the
+// current TOTP step is used up by the confirmation
+func enroll(t *testing.T, s *authServices, username string, now time.Time) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrollment, err := s.mfa.BeginEnrollment(ctx, username)
+	if err != nil {
+		t.Fatalf("BeginEnrollment = %v", err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/") || !strings.Contains(enrollment.URI, enrollment.Secret) {
+		t.Fatalf("BeginEnrollment URI = %q", enrollment.URI)
+	}
+
+	codes, err := s.mfa.ConfirmEnrollment(ctx, username, totpAt(t, enrollment.Secret, now), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("ConfirmEnrollment = %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("ConfirmEnrollment returned %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	return enrollment.Secret, codes
+}
+
+func TestMFALogin(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	s := newAuthServices(repos)
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+
+	now := time.Now()
+	secret, _ := enroll(t, s, "alice", now)
+
+	if _, err := s.mfa.BeginEnrollment(ctx, "alice"); !errors.Is(err, ErrMFAEnabled) {
+		t.Fatalf("BeginEnrollment while enabled = %v, want ErrMFAEnabled", err)
+	}
+
+	login, err := s.sessions.Login(ctx, "alice", testPassword, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Login = %v", err)
+	}
+	if !login.MFARequired || login.MFAToken == "" || login.Token != "" || login.RefreshToken != "" {
+		t.Fatalf("Login with 2FA = %+v, want only an MFA token", login)
+	}
+	if _, err := s.tokens.Parse(ctx, login.MFAToken); err == nil {
+		t.Fatal("the MFA token is accepted as an access token")
+	}
+
+	// the step that confirmed the enrollment was used already
+	if _, err := s.sessions.CompleteMFA(ctx, login.MFAToken, totpAt(t, secret, now), "10.0.0.1"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("CompleteMFA(replayed code) = %v, want ErrInvalidMFACode", err)
+	}
+
+	next := totpAt(t, secret, now.Add(30*time.Second))
+	session, err := s.sessions.CompleteMFA(ctx, login.MFAToken, next, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("CompleteMFA = %v", err)
+	}
+	if _, err := s.tokens.Parse(ctx, session.Token); err != nil {
+		t.Fatalf("access token of CompleteMFA is invalid: %v", err)
+	}
+
+	// the challenge is used up with the login, and the code with its step
+	if _, err := s.sessions.CompleteMFA(ctx, login.MFAToken, totpAt(t, secret, now.Add(60*time.Second)), "10.0.0.1"); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Fatalf("CompleteMFA(used token) = %v, want ErrInvalidMFAToken", err)
+	}
+	again, _ := s.sessions.Login(ctx, "alice", testPassword, "10.0.0.1")
+	if _, err := s.sessions.CompleteMFA(ctx, again.MFAToken, next, "10.0.0.1"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("CompleteMFA(replayed code) = %v, want ErrInvalidMFACode", err)
+	}
+}
+
+func TestMFARecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	s := newAuthServices(repos)
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+	addUser(t, repos, "bob", models.RoleAdmin, models.DefaultTenantID)
+
+	_, codes := enroll(t, s, "alice", time.Now())
+
+	if err := s.mfa.Verify(ctx, "alice", codes[0], "10.0.0.1"); err != nil {
+		t.Fatalf("Verify(recovery code) = %v", err)
+	}
+	if err := s.mfa.Verify(ctx, "alice", codes[0], "10.0.0.1"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("Verify(used recovery code) = %v, want ErrInvalidMFACode", err)
+	}
+
+	// codes are accepted the way people type them
+	if err := s.mfa.Verify(ctx, "alice", strings.ToUpper(strings.ReplaceAll(codes[1], "-", " ")), "10.0.0.1"); err != nil {
+		t.Fatalf("Verify(retyped recovery code) = %v", err)
+	}
+
+	status, err := s.mfa.Status(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.RecoveryCodes != recoveryCodeCount-2 {
+		t.Fatalf("Status = %+v, want %d recovery codes left", status, recoveryCodeCount-2)
+	}
+
+	// recovery codes belong to one user
+	if err := s.mfa.Verify(ctx, "bob", codes[2], "10.0.0.1"); !errors.Is(err, ErrMFANotEnabled) {
+		t.Fatalf("Verify without 2FA = %v, want ErrMFANotEnabled", err)
+	}
+}
+
+func TestMFAGuessesThrottled(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	s := newAuthServices(repos)
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+
+	now := time.Now()
+	secret, _ := enroll(t, s, "alice", now)
+
+	for _, guess := range []string{"000000", "aaaaa-bbbbb"} {
+		if err := s.mfa.Verify(ctx, "alice", guess, "10.0.0.1"); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("Verify(%s) = %v, want ErrInvalidMFACode", guess, err)
+		}
+	}
+
+	// wrong codes count like wrong passwords, the right code waits out the delay
+	err := s.mfa.Verify(ctx, "alice", totpAt(t, secret, now.Add(30*time.Second)), "10.0.0.2")
+	var throttled *auth.ThrottledError
+	if !errors.As(err, &throttled) {
+		t.Fatalf("Verify while delayed = %v, want a delay", err)
+	}
+}
+
+func TestMFAEnrollmentRequired(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	s := newAuthServices(repos)
+	s.mfa.requiredRoles = map[string]bool{models.RoleAdmin: true}
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+
+	login, err := s.sessions.Login(ctx, "alice", testPassword, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Login = %v", err)
+	}
+	if !login.MFAEnrollmentRequired || login.MFARequired || login.Token != "" {
+		t.Fatalf("Login of a user who must enroll = %+v", login)
+	}
+
+	// an enrollment token can't skip the enrollment
+	if _, err := s.sessions.CompleteMFA(ctx, login.MFAToken, "000000", "10.0.0.1"); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Fatalf("CompleteMFA(enrollment token) = %v, want ErrInvalidMFAToken", err)
+	}
+
+	enrollment, err := s.sessions.BeginMFAEnrollment(ctx, login.MFAToken)
+	if err != nil {
+		t.Fatalf("BeginMFAEnrollment = %v", err)
+	}
+	session, err := s.sessions.CompleteMFAEnrollment(ctx, login.MFAToken, totpAt(t, enrollment.Secret, time.Now()), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("CompleteMFAEnrollment = %v", err)
+	}
+	if session.Token == "" || len(session.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("CompleteMFAEnrollment = %+v, want a session and recovery codes", session)
+	}
+
+	if err := s.mfa.Disable(ctx, "alice", session.RecoveryCodes[0], "10.0.0.1"); !errors.Is(err, ErrMFARequired) {
+		t.Fatalf("Disable for a required role = %v, want ErrMFARequired", err)
+	}
+}
@@ -16,12 +16,16 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mlvieira/nsfwdetection/internal/cache"
 	"github.com/mlvieira/nsfwdetection/internal/config"
-	"github.com/mlvieira/nsfwdetection/internal/driver/redis"
 	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
 	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/preview"
 	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
 	"github.com/mlvieira/nsfwdetection/internal/tfmodel"
+	"github.com/mlvieira/nsfwdetection/internal/urlsign"
 	"github.com/mlvieira/nsfwdetection/internal/validation"
 	"github.com/mlvieira/nsfwdetection/internal/websockets"
 	"github.com/mlvieira/nsfwdetection/internal/worker"
@@ -29,22 +33,31 @@ import (
 
 // NSFWService defines the business logic for NSFW processing
 type NSFWService struct {
-	redisClient  *redis.RedisClient
+	cache        cache.Cache
 	hub          *websockets.Hub
 	repositories *repositories.Repositories
+	store        *storage.Store
+	signer       *urlsign.Signer
 }
 
 // NewNSFWService creates a new instance of NSFWService
-func NewNSFWService(redisClient *redis.RedisClient, hub *websockets.Hub, repositories *repositories.Repositories) *NSFWService {
+func NewNSFWService(cache cache.Cache, hub *websockets.Hub, repositories *repositories.Repositories, store *storage.Store, signer *urlsign.Signer) *NSFWService {
 	return &NSFWService{
-		redisClient:  redisClient,
+		cache:        cache,
 		hub:          hub,
 		repositories: repositories,
+		store:        store,
+		signer:       signer,
 	}
 }
 
-// ProcessFiles handles NSFW processing for uploaded files
+// ProcessFiles handles NSFW processing for uploaded files, with the policy of the tenant of ctx
 func (s *NSFWService) ProcessFiles(ctx context.Context, files []*multipart.FileHeader) ([]*tfmodel.Prediction, error) {
+	tenant, err := currentTenant(ctx, s.repositories)
+	if err != nil {
+		return nil, err
+	}
+
 	var output []*tfmodel.Prediction
 
 	for id, fileHeader := range files {
@@ -59,99 +72,179 @@ func (s *NSFWService) ProcessFiles(ctx context.Context, files []*multipart.FileH
 		}
 		defer file.Close()
 
-		sha256Hash, err := s.computeSHA256(file)
-		if err != nil {
-			logger.Error("Failed to compute hash: %w", err)
-			output = append(output, s.createPredictionError(id, "Failed to compute hash", fileHeader.Filename, fileStartTime))
-			continue
-		}
-		file.Seek(0, io.SeekStart)
+		output = append(output, s.processFile(ctx, tenant, id, fileHeader.Filename, file, fileStartTime))
+	}
 
-		if err := validation.ValidateFileType(file); err != nil {
-			logger.Error("Failed to validate type: %w", err)
-			output = append(output, s.createPredictionError(id, err.Error(), fileHeader.Filename, fileStartTime))
-			continue
-		}
+	return output, nil
+}
 
-		cachedPrediction := s.checkCache(ctx, sha256Hash, id, fileStartTime)
-		if cachedPrediction != nil {
-			output = append(output, cachedPrediction)
-			continue
-		}
+// processFile runs a single file through hashing, validation, cache lookup, inference and storage.
+func (s *NSFWService) processFile(ctx context.Context, tenant *models.Tenant, id int, filename string, file multipart.File, fileStartTime time.Time) *tfmodel.Prediction {
+	sha256Hash, err := s.computeSHA256(file)
+	if err != nil {
+		logger.Error("Failed to compute hash: %w", err)
+		return s.createPredictionError(id, "Failed to compute hash", filename, fileStartTime)
+	}
+	file.Seek(0, io.SeekStart)
 
-		prediction := s.processPrediction(file, fileHeader, id, sha256Hash, fileStartTime)
-		if prediction == nil {
-			logger.Error("Model failed to determine score: %w", fileHeader.Filename)
-			output = append(output, s.createPredictionError(id, "Prediction failed", fileHeader.Filename, fileStartTime))
-			continue
+	if err := validation.ValidateFileType(file); err != nil {
+		logger.Error("Failed to validate type: %w", err)
+		return s.createPredictionError(id, err.Error(), filename, fileStartTime)
+	}
+
+	cachedPrediction := s.checkCache(ctx, tenant.ID, sha256Hash, id, fileStartTime)
+	if cachedPrediction != nil {
+		// the threshold may have changed since the prediction was cached
+		if cachedPrediction.Success && cachedPrediction.Source != tfmodel.SourceHuman {
+			cachedPrediction.SetModelDecision(tenant.NSFWThreshold)
 		}
+		return cachedPrediction
+	}
 
-		s.storeCache(ctx, sha256Hash, prediction)
+	prediction, tempPath := s.processPrediction(file, filename, id, sha256Hash, fileStartTime)
+	if prediction == nil {
+		logger.Error("Model failed to determine score: %w", filename)
+		return s.createPredictionError(id, "Prediction failed", filename, fileStartTime)
+	}
 
-		uploadedImage := s.CreateUploadedImage(prediction, fileHeader)
+	existing, err := s.repositories.Uploaded.GetImageByHash(ctx, tenant.ID, sha256Hash)
+	if err != nil {
+		logger.Error("Failed to look up %s: %v", sha256Hash, err)
+	}
 
-		if err = s.repositories.Uploaded.UploadImage(ctx, uploadedImage); err != nil {
-			logger.Error("Failed to save uploaded image to database: %v", err)
-			output = append(output, s.createPredictionError(id, "Failed to save image to database", fileHeader.Filename, fileStartTime))
-			continue
-		}
+	if prediction.Success {
+		prediction.SetModelDecision(tenant.NSFWThreshold)
+	}
+	applyReview(existing, prediction)
 
-		s.NotifyClients(uploadedImage)
+	s.storeCache(ctx, tenant.ID, sha256Hash, prediction)
 
-		output = append(output, prediction)
+	uploadedImage := s.CreateUploadedImage(prediction, tenant, filename)
+	if key := middleware.APIKey(ctx); key != nil {
+		uploadedImage.ClientName = key.ClientName
 	}
 
-	return output, nil
+	written, err := s.repositories.Uploaded.UploadImage(ctx, uploadedImage)
+	if err != nil {
+		os.Remove(tempPath)
+		logger.Error("Failed to save uploaded image to database: %v", err)
+		return s.createPredictionError(id, "Failed to save image to database", filename, fileStartTime)
+	}
+
+	// the image is already live, its file is stored and clients have been told about it
+	if written == 0 {
+		os.Remove(tempPath)
+		return prediction
+	}
+
+	// an image taken out of the trash by this upload gets the new file, the trashed copy goes
+	var trashed string
+	if existing != nil && existing.DeletedAt != nil {
+		logger.Info("Image %s uploaded again, taken out of the trash", sha256Hash)
+		trashed = existing.FilePath
+	}
+	s.storeFile(uploadedImage.FilePath, tempPath, trashed)
+
+	s.NotifyClients(uploadedImage)
+
+	return prediction
 }
 
-// checkCache retrieves a cached prediction result from Redis by SHA-256 hash.
-func (s *NSFWService) checkCache(ctx context.Context, sha256Hash string, id int, startTime time.Time) *tfmodel.Prediction {
-	cacheKey := fmt.Sprintf("nsfw:%s", sha256Hash)
-	cachedResult, err := s.redisClient.GetValue(ctx, cacheKey)
+// applyReview replaces the decision with the moderator's verdict if img, the stored image
+// with the predicted hash, has been reviewed. Relabeling invalidates the cache, so cached
+// predictions already carry the latest verdict.
+func applyReview(img *models.UploadedImage, prediction *tfmodel.Prediction) {
+	if img != nil && img.Reviewed && img.DeletedAt == nil {
+		prediction.ApplyReview(img.NewLabel, img.ReviewedAt)
+	}
+}
 
-	if err == nil && cachedResult != "" {
-		var cachedPrediction tfmodel.Prediction
+// storeFile copies the temp file of an upload to key in the background, the response doesn't
+// depend on it. trashed is the key of a copy in the trash to drop once stored, if any.
+func (s *NSFWService) storeFile(key, tempPath, trashed string) {
+	go func() {
+		defer os.Remove(tempPath)
 
-		if err := json.NewDecoder(bytes.NewReader([]byte(cachedResult))).Decode(&cachedPrediction); err == nil {
-			logger.Info("Cache hit for file (SHA256: %s)", sha256Hash)
-			cachedPrediction.ID = id
-			cachedPrediction.Timestamp = time.Now().Unix()
-			cachedPrediction.Duration = float64(time.Since(startTime).Seconds())
-			return &cachedPrediction
+		ctx := context.Background()
+		if err := s.store.PutFile(ctx, key, tempPath); err != nil {
+			logger.Error("Failed to store %s: %v", key, err)
+			return
 		}
+		logger.Info("File stored as: %s", key)
+
+		if trashed != "" {
+			if err := s.store.RemoveFromTrash(ctx, trashed); err != nil {
+				logger.Error("Failed to remove trashed copy %s: %v", trashed, err)
+			}
+		}
+	}()
+}
+
+// checkCache retrieves a cached prediction result of a tenant by SHA-256 hash.
+func (s *NSFWService) checkCache(ctx context.Context, tenantID int, sha256Hash string, id int, startTime time.Time) *tfmodel.Prediction {
+	cachedResult, err := s.cache.Get(ctx, cache.PredictionKey(tenantID, sha256Hash))
+	if err != nil {
+		if !errors.Is(err, cache.ErrMiss) {
+			logger.Error("Cache lookup failed for %s: %v", sha256Hash, err)
+		}
+		return nil
+	}
+
+	var cachedPrediction tfmodel.Prediction
+	if err := json.NewDecoder(bytes.NewReader([]byte(cachedResult))).Decode(&cachedPrediction); err != nil {
+		logger.Error("Failed to decode cached prediction for %s: %v", sha256Hash, err)
+		return nil
 	}
 
-	return nil
+	logger.Info("Cache hit for file (SHA256: %s)", sha256Hash)
+	cachedPrediction.ID = id
+	cachedPrediction.Timestamp = time.Now().Unix()
+	cachedPrediction.Duration = float64(time.Since(startTime).Seconds())
+	return &cachedPrediction
 }
 
-// storeCache saves a prediction result in Redis with a 60-minute expiration.
-func (s *NSFWService) storeCache(ctx context.Context, sha256Hash string, prediction *tfmodel.Prediction) {
-	cacheKey := fmt.Sprintf("nsfw:%s", sha256Hash)
+// storeCache saves a prediction result of a tenant in the cache for the configured TTL.
+func (s *NSFWService) storeCache(ctx context.Context, tenantID int, sha256Hash string, prediction *tfmodel.Prediction) {
+	cacheKey := cache.PredictionKey(tenantID, sha256Hash)
+	ttl := time.Duration(config.AppConfig.Cache.PredictionTTLSec) * time.Second
+
 	var buffer bytes.Buffer
-	if err := json.NewEncoder(&buffer).Encode(prediction); err == nil {
-		s.redisClient.SetValue(ctx, cacheKey, buffer.String(), 60*time.Minute)
-	} else {
+	if err := json.NewEncoder(&buffer).Encode(prediction); err != nil {
+		logger.Error("Failed to encode cache for key %s: %v", cacheKey, err)
+		return
+	}
+
+	if err := s.cache.Set(ctx, cacheKey, buffer.String(), ttl); err != nil {
 		logger.Error("Failed to store cache for key %s: %v", cacheKey, err)
 	}
 }
 
 // processPrediction saves the uploaded file temporarily and submits it to the worker pool for NSFW detection.
-func (s *NSFWService) processPrediction(file multipart.File, fileHeader *multipart.FileHeader, id int, sha256Hash string, startTime time.Time) *tfmodel.Prediction {
-	ext := filepath.Ext(fileHeader.Filename)
+// It returns the path of the temp file along with the prediction, the caller removes it.
+func (s *NSFWService) processPrediction(file multipart.File, filename string, id int, sha256Hash string, startTime time.Time) (*tfmodel.Prediction, string) {
+	ext := filepath.Ext(filename)
 
 	tempFile, err := os.CreateTemp(config.AppConfig.FileHandling.TempUploadDir, "upload-*"+ext)
 	if err != nil {
-		logger.Error("Failed to create temp file for: %s, Error: %v", fileHeader.Filename, err)
-		return nil
+		logger.Error("Failed to create temp file for: %s, Error: %v", filename, err)
+		return nil, ""
 	}
 	defer tempFile.Close()
 
 	_, err = io.Copy(tempFile, file)
 	if err != nil {
-		logger.Error("Failed to save temp file for: %s, Error: %v", fileHeader.Filename, err)
-		return nil
+		os.Remove(tempFile.Name())
+		logger.Error("Failed to save temp file for: %s, Error: %v", filename, err)
+		return nil, ""
 	}
 
+	// render the previews while the model runs, they are stored before the upload is
+	// announced so the review queue can load them right away
+	previewsDone := make(chan error, 1)
+	go func() {
+		previewsDone <- preview.Store(context.Background(), s.store, sha256Hash, tempFile.Name())
+	}()
+
 	resultChan := make(chan *tfmodel.Prediction, 1)
 	worker.SubmitJob(worker.Job{
 		ID:          id,
@@ -163,7 +256,7 @@ func (s *NSFWService) processPrediction(file multipart.File, fileHeader *multipa
 	select {
 	case prediction = <-resultChan:
 		logger.Info("Received result for job %d", id)
-	case <-time.After(5 * time.Second):
+	case <-time.After(time.Duration(config.AppConfig.Worker.ResultTimeoutSec) * time.Second):
 		logger.Error("Timeout for job %d", id)
 		prediction = &tfmodel.Prediction{
 			ID:        id,
@@ -173,26 +266,17 @@ func (s *NSFWService) processPrediction(file multipart.File, fileHeader *multipa
 			Duration:  float64(time.Since(startTime).Seconds()),
 		}
 	}
-	close(resultChan)
-
-	// use goroutine to move file to later rate
-	go func() {
-		destPath := filepath.Join(config.AppConfig.FileHandling.UploadDir, sha256Hash+ext)
 
-		if err = os.Rename(tempFile.Name(), destPath); err != nil {
-			logger.Error("Failed to move file to uploads: %v", err)
-			return
-		}
-
-		logger.Info("File saved to: %s", destPath)
-	}()
+	if err := <-previewsDone; err != nil {
+		logger.Error("Failed to create previews of %s: %v", filename, err)
+	}
 
 	prediction.SHA256 = sha256Hash
 	prediction.ID = id
 	prediction.Timestamp = time.Now().Unix()
 	prediction.Duration = float64(time.Since(startTime).Seconds())
 
-	return prediction
+	return prediction, tempFile.Name()
 }
 
 // createPredictionError generates a prediction result with an error message.
@@ -261,7 +345,7 @@ func (s *NSFWService) SendErrorResponse(w http.ResponseWriter, errorMsg string,
 	})
 }
 
-// NotifyClients sends a prediction result to all connected WebSocket clients
+// NotifyClients sends a prediction result to the WebSocket clients of the tenant of the upload
 func (s *NSFWService) NotifyClients(uploaded models.UploadedImage) {
 	image := map[string]any{
 		"event": "new_upload",
@@ -273,21 +357,16 @@ func (s *NSFWService) NotifyClients(uploaded models.UploadedImage) {
 		logger.Error("Failed to marshal WebSocket message: %v", err)
 		return
 	}
-	s.hub.Broadcast <- message
+	s.hub.Broadcast <- websockets.Message{TenantID: uploaded.TenantID, Data: message}
 }
 
-func (s *NSFWService) CreateUploadedImage(prediction *tfmodel.Prediction, fileHeader *multipart.FileHeader) models.UploadedImage {
-	label := "SFW"
-	score := prediction.SFWPercentage
-	if prediction.NSFWPercentage > prediction.SFWPercentage {
-		label = "NSFW"
-		score = prediction.NSFWPercentage
-	}
-
-	path := fmt.Sprintf("/static/uploads/%s%s", prediction.SHA256, filepath.Ext(fileHeader.Filename))
+// CreateUploadedImage records a prediction for tenant, labeled with its threshold
+func (s *NSFWService) CreateUploadedImage(prediction *tfmodel.Prediction, tenant *models.Tenant, filename string) models.UploadedImage {
+	label, score := prediction.LabelAt(tenant.NSFWThreshold)
 
 	uploadedImage := models.UploadedImage{
-		FilePath:   path,
+		TenantID:   tenant.ID,
+		FilePath:   storage.Key(tenant.ID, prediction.SHA256, filename),
 		FileHash:   prediction.SHA256,
 		Label:      label,
 		NewLabel:   "unlabeled",
@@ -296,6 +375,7 @@ func (s *NSFWService) CreateUploadedImage(prediction *tfmodel.Prediction, fileHe
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
+	withURLs(s.signer, &uploadedImage)
 
 	return uploadedImage
 }
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/preview"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+// previewBatchSize is the number of images read per query while backfilling previews
+const previewBatchSize = 500
+
+// PreviewGenerator creates the thumbnails and blurred previews of images uploaded before
+// previews existed, or whose previews failed to render
+type PreviewGenerator struct {
+	repositories *repositories.Repositories
+	store        *storage.Store
+}
+
+func NewPreviewGenerator(repositories *repositories.Repositories, store *storage.Store) *PreviewGenerator {
+	return &PreviewGenerator{repositories: repositories, store: store}
+}
+
+// Backfill renders the previews of images in the review queue that lack one, or of every
+// image with force set. It returns how many images got previews and how many failed.
+func (g *PreviewGenerator) Backfill(ctx context.Context, force bool) (created, failed int, err error) {
+	existing := make(map[string]bool)
+	if !force {
+		err := g.store.Previews.List(ctx, func(info storage.ObjectInfo) error {
+			existing[info.Key] = true
+			return nil
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to list stored previews: %w", err)
+		}
+	}
+
+	cursor := math.MaxInt32
+	for {
+		batch, err := g.repositories.Uploaded.ListUploadsCursor(ctx, models.AllTenants, cursor, previewBatchSize, nil)
+		if err != nil {
+			return created, failed, fmt.Errorf("failed to list uploads: %w", err)
+		}
+
+		for _, img := range batch {
+			if existing[storage.ThumbnailKey(img.FileHash)] && existing[storage.BlurredKey(img.FileHash)] {
+				continue
+			}
+
+			if err := g.generate(ctx, img); err != nil {
+				logger.Error("Failed to create previews of %s: %v", img.FileHash, err)
+				failed++
+				continue
+			}
+			created++
+		}
+
+		if len(batch) < previewBatchSize {
+			break
+		}
+		cursor = batch[len(batch)-1].ID
+	}
+
+	logger.Info("Preview backfill created %d previews, %d failed", created, failed)
+	return created, failed, nil
+}
+
+func (g *PreviewGenerator) generate(ctx context.Context, img models.UploadedImage) error {
+	obj, err := g.store.Uploads.Open(ctx, img.FilePath)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	return preview.StoreFrom(ctx, g.store, img.FileHash, obj)
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+// previewKeys lists the keys of the stored previews
+func previewKeys(t *testing.T, store *storage.Store) []string {
+	t.Helper()
+	var keys []string
+	err := store.Previews.List(context.Background(), func(info storage.ObjectInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// TestPreviewsSharedAcrossTenants checks tenants uploading the same image share one set of
+// previews, and purging the copy of one tenant keeps them for the other
+func TestPreviewsSharedAcrossTenants(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	store := newStore(t)
+
+	image, err := os.ReadFile("../preview/testdata/checkerboard.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := strings.Repeat("a", 64)
+	tenants := []int{addTenant(t, repos, "acme"), addTenant(t, repos, "globex")}
+	for _, tenantID := range tenants {
+		addImage(t, repos, store, tenantID, hash)
+		key := storage.Key(tenantID, hash, "photo.jpg")
+		if err := store.Uploads.Put(ctx, key, bytes.NewReader(image), int64(len(image))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g := NewPreviewGenerator(repos, store)
+	if _, failed, err := g.Backfill(ctx, false); err != nil || failed != 0 {
+		t.Fatalf("Backfill = %d failed, %v", failed, err)
+	}
+	keys := previewKeys(t, store)
+	if len(keys) != 2 || !exists(t, store.Previews, storage.ThumbnailKey(hash)) || !exists(t, store.Previews, storage.BlurredKey(hash)) {
+		t.Fatalf("stored previews %v, want one thumbnail and one blurred preview for the hash", keys)
+	}
+	if created, _, err := g.Backfill(ctx, false); err != nil || created != 0 {
+		t.Fatalf("second Backfill created %d previews, %v, want none", created, err)
+	}
+
+	// a negative retention purges what was just trashed
+	purger := &TrashPurger{repositories: repos, store: store, retention: -time.Minute}
+	trash := func(tenantID int) {
+		t.Helper()
+		if _, err := repos.Uploaded.DeleteImage(ctx, tenantID, hash, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.MoveToTrash(ctx, storage.Key(tenantID, hash, "photo.jpg")); err != nil {
+			t.Fatal(err)
+		}
+		if purged, err := purger.PurgeExpired(ctx); err != nil || purged != 1 {
+			t.Fatalf("PurgeExpired = %d, %v, want the trashed image purged", purged, err)
+		}
+	}
+
+	trash(tenants[0])
+	if keys := previewKeys(t, store); len(keys) != 2 {
+		t.Fatalf("previews left %v while another tenant still has the image", keys)
+	}
+
+	trash(tenants[1])
+	if keys := previewKeys(t, store); len(keys) != 0 {
+		t.Fatalf("previews left %v after the last copy of the image was purged", keys)
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+// purgeBatchSize is the number of trashed images purged per query
+const purgeBatchSize = 100
+
+// PurgeUsername is recorded in image_events for images purged automatically
+const PurgeUsername = "system"
+
+// TrashPurger permanently removes images that have been in the trash longer than the retention period
+type TrashPurger struct {
+	repositories *repositories.Repositories
+	store        *storage.Store
+	retention    time.Duration
+}
+
+// NewTrashPurger creates a purger using the configured trash retention
+func NewTrashPurger(repositories *repositories.Repositories, store *storage.Store) *TrashPurger {
+	return &TrashPurger{
+		repositories: repositories,
+		store:        store,
+		retention:    time.Duration(config.AppConfig.FileHandling.TrashRetentionDays) * 24 * time.Hour,
+	}
+}
+
+// Run purges expired trash every interval until ctx is cancelled
+func (p *TrashPurger) Run(ctx context.Context, interval time.Duration) {
+	runPeriodically(ctx, "Trash purge", interval, p.PurgeExpired)
+}
+
+// PurgeExpired deletes files and rows of images trashed before the retention period and returns how many were purged
+func (p *TrashPurger) PurgeExpired(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-p.retention)
+	purged := 0
+
+	for {
+		images, err := p.repositories.Uploaded.ListTrashedBefore(ctx, cutoff, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+
+		failed := 0
+		for _, img := range images {
+			if err := p.store.RemoveFromTrash(ctx, img.FilePath); err != nil {
+				// keep the row so the purge is retried on the next run
+				logger.Error("Failed to remove trashed file of %s: %v", img.FileHash, err)
+				failed++
+				continue
+			}
+			if err := p.removePreviews(ctx, img.FileHash); err != nil {
+				// keep the row so the purge is retried on the next run
+				logger.Error("Failed to remove previews of %s: %v", img.FileHash, err)
+				failed++
+				continue
+			}
+
+			if _, err := p.repositories.Uploaded.PurgeImage(ctx, img.TenantID, img.FileHash, PurgeUsername); err != nil {
+				return purged, err
+			}
+
+			logger.Info("Purged %s (deleted at %s)", img.FileHash, img.DeletedAt.Format(time.RFC3339))
+			purged++
+		}
+
+		// a batch with failures would be fetched again, leave them for the next run
+		if len(images) < purgeBatchSize || failed > 0 {
+			break
+		}
+	}
+
+	if purged > 0 {
+		logger.Info("Trash purge removed %d images", purged)
+	}
+
+	return purged, nil
+}
+
+// removePreviews deletes the previews of hash unless another tenant still has the image,
+// previews are rendered once per hash and shared
+func (p *TrashPurger) removePreviews(ctx context.Context, hash string) error {
+	count, err := p.repositories.Uploaded.CountImagesByHash(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if count > 1 {
+		return nil
+	}
+	return p.store.RemovePreviews(ctx, hash)
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+// ReconcileUsername is recorded in image_events for images deleted because their file is gone
+const ReconcileUsername = "reconcile"
+
+// reconcileBatchSize is the number of rows read per query while collecting known files
+const reconcileBatchSize = 1000
+
+// Reconciler compares stored uploads, the trash and the temp directory with uploaded_images.
+//
+// Uploads are inserted and stored independently, so a crash or a failed query between the
+// two leaves files without rows, rows without files or temp files nobody removes. Anything
+// younger than the grace period may still be in flight and is skipped.
+type Reconciler struct {
+	repositories *repositories.Repositories
+	store        *storage.Store
+	grace        time.Duration
+}
+
+func NewReconciler(repositories *repositories.Repositories, store *storage.Store) *Reconciler {
+	return &Reconciler{
+		repositories: repositories,
+		store:        store,
+		grace:        time.Duration(config.AppConfig.FileHandling.OrphanGraceMin) * time.Minute,
+	}
+}
+
+// Run checks every interval until ctx is cancelled, repairing what it finds if repair is set
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration, repair bool) {
+	runPeriodically(ctx, "Reconciliation", interval, func(ctx context.Context) (int, error) {
+		report, err := r.Reconcile(ctx, repair)
+		return report.Total(), err
+	})
+}
+
+// Reconcile finds files and rows that disagree. Files are reported as uploads/<key>,
+// trash/<key> or previews/<key>, images by hash. With repair set it also:
+//   - deletes orphan files and stale temp files
+//   - moves misplaced files to the area matching the image's trash state
+//   - moves images whose file is gone to the trash
+func (r *Reconciler) Reconcile(ctx context.Context, repair bool) (models.ReconcileReport, error) {
+	report := models.ReconcileReport{
+		OrphanFiles:    []string{},
+		MissingFiles:   []string{},
+		MisplacedFiles: []string{},
+		StaleTempFiles: []string{},
+		Repaired:       repair,
+	}
+	cutoff := time.Now().Add(-r.grace)
+
+	live, err := r.collect(ctx, r.repositories.Uploaded.ListUploadsCursor)
+	if err != nil {
+		return report, fmt.Errorf("failed to list uploads: %w", err)
+	}
+
+	trashed, err := r.collect(ctx, func(ctx context.Context, tenantID, cursorID, limit int, _ *bool) ([]models.UploadedImage, error) {
+		return r.repositories.Uploaded.ListTrashCursor(ctx, tenantID, cursorID, limit)
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list trash: %w", err)
+	}
+
+	uploadFiles, err := r.list(ctx, r.store.Uploads)
+	if err != nil {
+		return report, fmt.Errorf("failed to list stored uploads: %w", err)
+	}
+
+	trashFiles, err := r.list(ctx, r.store.Trash)
+	if err != nil {
+		return report, fmt.Errorf("failed to list stored trash: %w", err)
+	}
+
+	previewFiles, err := r.list(ctx, r.store.Previews)
+	if err != nil {
+		return report, fmt.Errorf("failed to list stored previews: %w", err)
+	}
+
+	for key, img := range live {
+		if _, ok := uploadFiles[key]; ok || img.CreatedAt.After(cutoff) {
+			continue
+		}
+
+		if _, ok := trashFiles[key]; ok {
+			report.MisplacedFiles = append(report.MisplacedFiles, "trash/"+key)
+			if repair {
+				r.repairf(r.store.RestoreFromTrash(ctx, key), "restore %s from trash", key)
+			}
+			continue
+		}
+
+		report.MissingFiles = append(report.MissingFiles, img.FileHash)
+		if repair {
+			_, err := r.repositories.Uploaded.DeleteImage(ctx, img.TenantID, img.FileHash, ReconcileUsername)
+			r.repairf(err, "move %s without a file to trash", img.FileHash)
+		}
+	}
+
+	for key, info := range uploadFiles {
+		if _, ok := live[key]; ok || info.ModTime.After(cutoff) {
+			continue
+		}
+
+		if _, ok := trashed[key]; ok {
+			report.MisplacedFiles = append(report.MisplacedFiles, "uploads/"+key)
+			if repair {
+				r.repairf(r.store.MoveToTrash(ctx, key), "move %s to trash", key)
+			}
+			continue
+		}
+
+		report.OrphanFiles = append(report.OrphanFiles, "uploads/"+key)
+		if repair {
+			r.repairf(r.store.Uploads.Delete(ctx, key), "remove orphan file uploads/%s", key)
+		}
+	}
+
+	for key, info := range trashFiles {
+		if _, ok := trashed[key]; ok || info.ModTime.After(cutoff) {
+			continue
+		}
+		// a live image's file here was already reported from the row side
+		if _, ok := live[key]; ok {
+			continue
+		}
+
+		report.OrphanFiles = append(report.OrphanFiles, "trash/"+key)
+		if repair {
+			r.repairf(r.store.Trash.Delete(ctx, key), "remove orphan file trash/%s", key)
+		}
+	}
+
+	hashes := make(map[string]bool, len(live)+len(trashed))
+	for _, img := range live {
+		hashes[img.FileHash] = true
+	}
+	for _, img := range trashed {
+		hashes[img.FileHash] = true
+	}
+
+	// previews are named after the image hash, a missing preview is not a problem
+	for key, info := range previewFiles {
+		if hashes[strings.TrimSuffix(path.Base(key), path.Ext(key))] || info.ModTime.After(cutoff) {
+			continue
+		}
+
+		report.OrphanFiles = append(report.OrphanFiles, "previews/"+key)
+		if repair {
+			r.repairf(r.store.Previews.Delete(ctx, key), "remove orphan file previews/%s", key)
+		}
+	}
+
+	err = r.walkStale(config.AppConfig.FileHandling.TempUploadDir, cutoff, func(path string) {
+		report.StaleTempFiles = append(report.StaleTempFiles, path)
+		if repair {
+			r.repairf(os.RemoveAll(path), "remove stale temp file %s", path)
+		}
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if report.Total() > 0 {
+		logger.Info("Reconciliation found %d orphan files, %d missing files, %d misplaced files and %d stale temp files (repair: %t)",
+			len(report.OrphanFiles), len(report.MissingFiles), len(report.MisplacedFiles), len(report.StaleTempFiles), repair)
+	}
+
+	return report, nil
+}
+
+// collect pages through a cursor listing of every tenant and indexes the images by storage key
+func (r *Reconciler) collect(ctx context.Context, list func(ctx context.Context, tenantID, cursorID, limit int, reviewed *bool) ([]models.UploadedImage, error)) (map[string]models.UploadedImage, error) {
+	images := make(map[string]models.UploadedImage)
+	cursor := math.MaxInt32
+
+	for {
+		batch, err := list(ctx, models.AllTenants, cursor, reconcileBatchSize, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, img := range batch {
+			images[img.FilePath] = img
+		}
+
+		if len(batch) < reconcileBatchSize {
+			return images, nil
+		}
+		cursor = batch[len(batch)-1].ID
+	}
+}
+
+// list indexes the objects of b by key
+func (r *Reconciler) list(ctx context.Context, b storage.Backend) (map[string]storage.ObjectInfo, error) {
+	objects := make(map[string]storage.ObjectInfo)
+	err := b.List(ctx, func(info storage.ObjectInfo) error {
+		objects[info.Key] = info
+		return nil
+	})
+	return objects, err
+}
+
+// walkStale calls fn for every entry of dir last modified before cutoff
+func (r *Reconciler) walkStale(dir string, cutoff time.Time, fn func(path string)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		fn(filepath.Join(dir, entry.Name()))
+	}
+
+	return nil
+}
+
+func (r *Reconciler) repairf(err error, format string, args ...interface{}) {
+	action := fmt.Sprintf(format, args...)
+	if err != nil {
+		logger.Error("Reconciliation failed to %s: %v", action, err)
+		return
+	}
+	logger.Info("Reconciliation: %s", action)
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+// put stores a small object under key in b
+func put(t *testing.T, b storage.Backend, key string) {
+	t.Helper()
+	if err := b.Put(context.Background(), key, strings.NewReader("image"), 5); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exists reports whether b holds key
+func exists(t *testing.T, b storage.Backend, key string) bool {
+	t.Helper()
+	if _, err := b.Stat(context.Background(), key); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false
+		}
+		t.Fatal(err)
+	}
+	return true
+}
+
+// sorted sorts the lists of report so reports can be compared
+func sorted(report models.ReconcileReport) models.ReconcileReport {
+	for _, list := range [][]string{report.OrphanFiles, report.MissingFiles, report.MisplacedFiles, report.StaleTempFiles} {
+		sort.Strings(list)
+	}
+	return report
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	store := newStore(t)
+	tenant := models.DefaultTenantID
+
+	tempDir := t.TempDir()
+	previous := config.AppConfig.FileHandling.TempUploadDir
+	config.AppConfig.FileHandling.TempUploadDir = tempDir
+	t.Cleanup(func() { config.AppConfig.FileHandling.TempUploadDir = previous })
+
+	key := func(hash string) string { return storage.Key(tenant, hash, "photo.jpg") }
+
+	// in order: an image with its file and previews
+	healthy := addImage(t, repos, store, tenant, strings.Repeat("a", 64))
+	put(t, store.Previews, storage.ThumbnailKey(healthy))
+
+	// an image whose file is gone
+	missing := addImage(t, repos, store, tenant, strings.Repeat("b", 64))
+	if err := store.Uploads.Delete(ctx, key(missing)); err != nil {
+		t.Fatal(err)
+	}
+
+	// an image whose file is in the trash, a trashed image whose file is not
+	live := addImage(t, repos, store, tenant, strings.Repeat("c", 64))
+	if err := store.MoveToTrash(ctx, key(live)); err != nil {
+		t.Fatal(err)
+	}
+	trashed := addImage(t, repos, store, tenant, strings.Repeat("d", 64))
+	if _, err := repos.Uploaded.DeleteImage(ctx, tenant, trashed, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// files without an image
+	put(t, store.Uploads, key(strings.Repeat("e", 64)))
+	put(t, store.Trash, key(strings.Repeat("f", 64)))
+	put(t, store.Previews, storage.ThumbnailKey(strings.Repeat("0", 64)))
+	if err := os.WriteFile(filepath.Join(tempDir, "upload-123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := sorted(models.ReconcileReport{
+		OrphanFiles: []string{
+			"uploads/" + key(strings.Repeat("e", 64)),
+			"trash/" + key(strings.Repeat("f", 64)),
+			"previews/" + storage.ThumbnailKey(strings.Repeat("0", 64)),
+		},
+		MissingFiles:   []string{missing},
+		MisplacedFiles: []string{"trash/" + key(live), "uploads/" + key(trashed)},
+		StaleTempFiles: []string{filepath.Join(tempDir, "upload-123")},
+	})
+
+	r := &Reconciler{repositories: repos, store: store}
+
+	// a report changes nothing
+	for i := 0; i < 2; i++ {
+		report, err := r.Reconcile(ctx, false)
+		if err != nil {
+			t.Fatalf("Reconcile = %v", err)
+		}
+		if !reflect.DeepEqual(sorted(report), want) {
+			t.Fatalf("Reconcile report = %+v\nwant %+v", report, want)
+		}
+	}
+
+	// repairs touch exactly what the report lists
+	report, err := r.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatalf("Reconcile(repair) = %v", err)
+	}
+	want.Repaired = true
+	if !reflect.DeepEqual(sorted(report), want) {
+		t.Fatalf("Reconcile(repair) = %+v\nwant %+v", report, want)
+	}
+
+	if !exists(t, store.Uploads, key(healthy)) || !exists(t, store.Previews, storage.ThumbnailKey(healthy)) {
+		t.Fatal("repair removed the files of a healthy image")
+	}
+	if !exists(t, store.Uploads, key(live)) || exists(t, store.Trash, key(live)) {
+		t.Fatal("repair left the file of a live image in the trash")
+	}
+	if !exists(t, store.Trash, key(trashed)) || exists(t, store.Uploads, key(trashed)) {
+		t.Fatal("repair left the file of a trashed image among the uploads")
+	}
+	if img, err := repos.Uploaded.GetImageByHash(ctx, tenant, missing); err != nil || img == nil || img.DeletedAt == nil {
+		t.Fatalf("image without a file = %+v, %v, want it in the trash", img, err)
+	}
+	for _, img := range []string{healthy, live} {
+		if got, err := repos.Uploaded.GetImageByHash(ctx, tenant, img); err != nil || got == nil || got.DeletedAt != nil {
+			t.Fatalf("repair changed image %s: %+v, %v", img, got, err)
+		}
+	}
+
+	// the image moved to the trash still has no file, everything else agrees
+	report, err = r.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total() != 0 {
+		t.Fatalf("Reconcile after the repair = %+v, want nothing", report)
+	}
+}
+
+// TestReconcileGrace checks files and rows younger than the grace period are left alone,
+// their upload may still be in flight
+func TestReconcileGrace(t *testing.T) {
+	ctx := context.Background()
+	repos := openRepositories(t)
+	store := newStore(t)
+
+	missing := addImage(t, repos, store, models.DefaultTenantID, strings.Repeat("b", 64))
+	if err := store.Uploads.Delete(ctx, storage.Key(models.DefaultTenantID, missing, "photo.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	orphan := storage.Key(models.DefaultTenantID, strings.Repeat("e", 64), "photo.jpg")
+	put(t, store.Uploads, orphan)
+
+	r := &Reconciler{repositories: repos, store: store, grace: time.Hour}
+	report, err := r.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total() != 0 {
+		t.Fatalf("Reconcile = %+v, want fresh uploads skipped", report)
+	}
+	if !exists(t, store.Uploads, orphan) {
+		t.Fatal("repair removed a fresh file")
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+// RetentionUsername is recorded in image_events for images deleted by a retention rule
+const RetentionUsername = "retention"
+
+// reportSampleSize is the number of matching images listed per rule in a dry-run report
+const reportSampleSize = 20
+
+// RetentionService deletes images matching the configured retention rules.
+// Deleted images go to the trash like manual deletes and are purged with it.
+type RetentionService struct {
+	repositories *repositories.Repositories
+	cache        *cache.Metered
+	store        *storage.Store
+	cfg          config.RetentionConfig
+}
+
+// NewRetentionService creates a service enforcing the rules in cfg. cache may be nil.
+func NewRetentionService(repositories *repositories.Repositories, cache *cache.Metered, store *storage.Store, cfg config.RetentionConfig) *RetentionService {
+	return &RetentionService{
+		repositories: repositories,
+		cache:        cache,
+		store:        store,
+		cfg:          cfg,
+	}
+}
+
+// Run enforces the rules every interval until ctx is cancelled
+func (s *RetentionService) Run(ctx context.Context, interval time.Duration) {
+	runPeriodically(ctx, "Retention", interval, s.Enforce)
+}
+
+func cutoff(rule config.RetentionRule, now time.Time) time.Time {
+	return now.AddDate(0, 0, -rule.MaxAgeDays)
+}
+
+// Report lists what every rule would delete in the tenant of ctx if it ran now, without
+// changing anything
+func (s *RetentionService) Report(ctx context.Context) (models.RetentionReport, error) {
+	tenantID := middleware.TenantID(ctx)
+	now := time.Now()
+	report := models.RetentionReport{
+		Enabled: s.cfg.Enabled,
+		Rules:   []models.RetentionRuleReport{},
+	}
+
+	for _, rule := range s.cfg.Rules {
+		before := cutoff(rule, now)
+
+		matching, err := s.repositories.Uploaded.CountExpired(ctx, tenantID, rule.Label, rule.Reviewed, before)
+		if err != nil {
+			return models.RetentionReport{}, fmt.Errorf("failed to count images for rule %s: %w", rule.Name, err)
+		}
+
+		sample, err := s.repositories.Uploaded.ListExpired(ctx, tenantID, rule.Label, rule.Reviewed, before, reportSampleSize)
+		if err != nil {
+			return models.RetentionReport{}, fmt.Errorf("failed to list images for rule %s: %w", rule.Name, err)
+		}
+		if sample == nil {
+			sample = []models.UploadedImage{}
+		}
+
+		report.Rules = append(report.Rules, models.RetentionRuleReport{
+			Name:       rule.Name,
+			Label:      rule.Label,
+			Reviewed:   rule.Reviewed,
+			MaxAgeDays: rule.MaxAgeDays,
+			Cutoff:     before,
+			Matching:   matching,
+			Sample:     sample,
+		})
+	}
+
+	return report, nil
+}
+
+// Enforce deletes every image matching a rule and returns how many were deleted. The
+// rules apply to every tenant unless ctx is for one.
+func (s *RetentionService) Enforce(ctx context.Context) (int, error) {
+	now := time.Now()
+	total := 0
+
+	for _, rule := range s.cfg.Rules {
+		before := cutoff(rule, now)
+
+		deleted, err := s.enforceRule(ctx, rule, before)
+		total += deleted
+		if err != nil {
+			return total, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+
+		if deleted > 0 {
+			logger.Info("Retention rule %s deleted %d images created before %s", rule.Name, deleted, before.Format(time.RFC3339))
+		}
+	}
+
+	return total, nil
+}
+
+// enforceRule deletes matching images batch by batch
+func (s *RetentionService) enforceRule(ctx context.Context, rule config.RetentionRule, before time.Time) (int, error) {
+	deleted := 0
+
+	for {
+		images, err := s.repositories.Uploaded.ListExpired(ctx, middleware.TenantID(ctx), rule.Label, rule.Reviewed, before, s.cfg.BatchSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		failed := 0
+		for _, img := range images {
+			if err := s.delete(ctx, img); err != nil {
+				logger.Error("Retention rule %s failed to delete %s: %v", rule.Name, img.FileHash, err)
+				failed++
+				continue
+			}
+
+			logger.Info("Retention rule %s deleted %s (%s, created %s)", rule.Name, img.FileHash, img.FilePath, img.CreatedAt.Format(time.RFC3339))
+			deleted++
+		}
+
+		// a batch with failures would be fetched again, leave them for the next run
+		if len(images) < s.cfg.BatchSize || failed > 0 {
+			return deleted, nil
+		}
+	}
+}
+
+func (s *RetentionService) delete(ctx context.Context, img models.UploadedImage) error {
+	if err := s.store.MoveToTrash(ctx, img.FilePath); err != nil {
+		return err
+	}
+
+	if _, err := s.repositories.Uploaded.DeleteImage(ctx, img.TenantID, img.FileHash, RetentionUsername); err != nil {
+		s.store.RestoreFromTrash(ctx, img.FilePath)
+		return err
+	}
+
+	if s.cache != nil {
+		if err := s.cache.Delete(ctx, cache.PredictionKey(img.TenantID, img.FileHash)); err != nil {
+			logger.Error("Failed to invalidate cache for %s: %v", img.FileHash, err)
+		}
+	}
+
+	return nil
+}
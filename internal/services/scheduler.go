@@ -0,0 +1,26 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+)
+
+// runPeriodically calls job right away and then every interval until ctx is cancelled
+func runPeriodically(ctx context.Context, name string, interval time.Duration, job func(ctx context.Context) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := job(ctx); err != nil {
+			logger.Error("%s failed: %v", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/driver/database"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/migrate"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testPassword is the password of every user created by addUser
+const testPassword = "Correct-Horse-42"
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "services-test")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := logger.Init(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+
+	config.AppConfig.Security = config.SecurityConfig{
+		JWTKeys:              []config.JWTKey{{ID: "test", Secret: "test secret"}},
+		AccessTokenTTLMin:    15,
+		RefreshTokenTTLHours: 24,
+		LoginMaxAttempts:     3,
+		LoginIPMaxAttempts:   10,
+		LoginWindowMin:       15,
+		LoginLockoutMin:      15,
+		MFAIssuer:            "NSFW Detector",
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// openRepositories returns the repositories of a migrated, empty SQLite database
+func openRepositories(t *testing.T) *repositories.Repositories {
+	t.Helper()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_foreign_keys=on"
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	migrator, err := migrate.ForDriver(conn, database.SQLite)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	return repositories.NewRepositories(conn, database.SQLite)
+}
+
+// authServices wires the session services the way cmd/server does, with in-process state
+type authServices struct {
+	tokens   *auth.Tokens
+	guard    *auth.LoginGuard
+	mfa      *MFAService
+	sessions *AuthService
+}
+
+func newAuthServices(repos *repositories.Repositories) *authServices {
+	tokens := auth.New(config.AppConfig.Security, cache.NewMemoryStore())
+	guard := auth.NewLoginGuard(config.AppConfig.Security, cache.NewMemoryCounter())
+	mfa := NewMFAService(repos, guard)
+
+	return &authServices{
+		tokens:   tokens,
+		guard:    guard,
+		mfa:      mfa,
+		sessions: NewAuthService(repos, tokens, guard, mfa),
+	}
+}
+
+// addUser creates username with testPassword in tenantID
+func addUser(t *testing.T, repos *repositories.Repositories, username, role string, tenantID int) {
+	t.Helper()
+
+	hashed, err := utils.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{Username: username, Password: hashed, Role: role, TenantID: tenantID}
+	if err := repos.User.AddUser(context.Background(), user); err != nil {
+		t.Fatalf("AddUser(%s): %v", username, err)
+	}
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/validation"
+)
+
+var (
+	// ErrSSOState is returned for callbacks that do not belong to a login in progress
+	ErrSSOState = errors.New("Login expired, please try again")
+	// ErrSSODenied is returned for identity provider users without a mapped role and for disabled users
+	ErrSSODenied = errors.New("Your account has no access to this service")
+	// ErrSSOUsername is returned when the identity provider's username cannot be used locally
+	ErrSSOUsername = errors.New("Your username is already taken or not valid here, please contact an admin")
+)
+
+// SSOService logs users in through an OpenID Connect identity provider. Users are
+// matched by the provider's subject and created on their first login. Their role follows
+// their groups on every login, so removing someone from a group at the provider takes
+// effect the next time they sign in.
+//
+// The callback does not hand out a session itself, it ends in a redirect to the admin
+// panel. It carries a short lived single use token instead, which the panel exchanges
+// for the session.
+type SSOService struct {
+	repositories *repositories.Repositories
+	provider     *auth.OIDCProvider
+	sessions     *AuthService
+	roleMapping  map[string]string
+	defaultRole  string
+}
+
+func NewSSOService(repositories *repositories.Repositories, provider *auth.OIDCProvider, sessions *AuthService) *SSOService {
+	return &SSOService{
+		repositories: repositories,
+		provider:     provider,
+		sessions:     sessions,
+		roleMapping:  config.AppConfig.OIDC.RoleMapping,
+		defaultRole:  config.AppConfig.OIDC.DefaultRole,
+	}
+}
+
+// Start returns the identity provider URL a login begins at
+func (s *SSOService) Start(ctx context.Context) (string, error) {
+	authURL, err := s.provider.AuthURL(ctx)
+	if err != nil {
+		logger.Error("Failed to start SSO login: %v", err)
+		return "", fmt.Errorf("Single sign-on is unavailable")
+	}
+	return authURL, nil
+}
+
+// Callback completes the login at the identity provider and returns the token the admin
+// panel exchanges for a session with AuthService.CompleteSSO
+func (s *SSOService) Callback(ctx context.Context, state, code, ip string) (string, error) {
+	identity, err := s.provider.Exchange(ctx, state, code)
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCState) {
+			return "", ErrSSOState
+		}
+		logger.Error("SSO login from %s failed: %v", ip, err)
+		return "", fmt.Errorf("Single sign-on failed")
+	}
+
+	role := s.role(identity.Groups)
+	if role == "" {
+		logger.Info("SSO login of %q from %s refused, none of the groups %v maps to a role", identity.Username, ip, identity.Groups)
+		return "", ErrSSODenied
+	}
+
+	user, err := s.user(ctx, identity, role)
+	if err != nil {
+		return "", err
+	}
+	if user.DisabledAt != nil {
+		logger.Info("SSO login of disabled user %s from %s refused", user.Username, ip)
+		return "", ErrSSODenied
+	}
+
+	token, _, err := s.sessions.tokens.IssueChallenge(user.Username, user.Role, auth.PurposeSSO)
+	if err != nil {
+		logger.Error("Failed to sign token: %v", err)
+		return "", fmt.Errorf("Failed to generate token")
+	}
+
+	return token, nil
+}
+
+// user returns the local user of identity with role, creating them on their first login
+func (s *SSOService) user(ctx context.Context, identity *auth.OIDCIdentity, role string) (*models.User, error) {
+	user, err := s.repositories.User.GetUserByOIDCSubject(ctx, identity.Subject)
+	if err != nil {
+		logger.Error("Failed to fetch SSO user %s: %v", identity.Subject, err)
+		return nil, fmt.Errorf("Single sign-on failed")
+	}
+
+	if user == nil {
+		return s.provision(ctx, identity, role)
+	}
+
+	if user.Role != role {
+		if _, err := s.repositories.User.UpdateRole(ctx, user.Username, role); err != nil {
+			logger.Error("Failed to update role of %s: %v", user.Username, err)
+			return nil, fmt.Errorf("Single sign-on failed")
+		}
+		logger.Info("User %s changed from %s to %s by their identity provider groups", user.Username, user.Role, role)
+		if err := s.sessions.RevokeAccessTokens(ctx, user.Username); err != nil {
+			logger.Error("Failed to revoke sessions of %s: %v", user.Username, err)
+		}
+		user.Role = role
+	}
+
+	return user, nil
+}
+
+// provision creates the local user of a first SSO login. A local account with the same
+// username is never taken over. Provisioned users join the default tenant.
+func (s *SSOService) provision(ctx context.Context, identity *auth.OIDCIdentity, role string) (*models.User, error) {
+	if err := validation.ValidateUsername(identity.Username); err != nil {
+		logger.Info("SSO user %q cannot be provisioned: %v", identity.Username, err)
+		return nil, ErrSSOUsername
+	}
+
+	existing, err := s.repositories.User.GetUser(ctx, identity.Username)
+	if err != nil {
+		logger.Error("Failed to fetch user %s: %v", identity.Username, err)
+		return nil, fmt.Errorf("Single sign-on failed")
+	}
+	if existing != nil {
+		logger.Info("SSO user %q cannot be provisioned, the username is taken", identity.Username)
+		return nil, ErrSSOUsername
+	}
+
+	// provisioned users have no password and can only log in through the provider
+	user := models.User{Username: identity.Username, Role: role, OIDCSubject: identity.Subject, TenantID: models.DefaultTenantID}
+	if err := s.repositories.User.AddUser(ctx, user); err != nil {
+		logger.Error("Failed to provision SSO user %s: %v", identity.Username, err)
+		return nil, fmt.Errorf("Single sign-on failed")
+	}
+
+	logger.Info("User %s provisioned with role %s by single sign-on", identity.Username, role)
+	return s.repositories.User.GetUser(ctx, identity.Username)
+}
+
+// role returns the highest role any of groups maps to, or the default role
+func (s *SSOService) role(groups []string) string {
+	role := ""
+	for _, group := range groups {
+		mapped, ok := s.roleMapping[group]
+		if ok && (role == "" || !models.HasRole(role, mapped)) {
+			role = mapped
+		}
+	}
+	if role == "" {
+		return s.defaultRole
+	}
+	return role
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/auth"
+	"github.com/mlvieira/nsfwdetection/internal/cache"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+	"github.com/mlvieira/nsfwdetection/internal/urlsign"
+	"github.com/mlvieira/nsfwdetection/internal/websockets"
+)
+
+// addTenant creates a tenant called name and returns its ID
+func addTenant(t *testing.T, repos *repositories.Repositories, name string) int {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := repos.Tenants.CreateTenant(ctx, models.Tenant{Name: name, NSFWThreshold: 50}); err != nil {
+		t.Fatal(err)
+	}
+	tenant, err := repos.Tenants.GetTenantByName(ctx, name)
+	if err != nil || tenant == nil {
+		t.Fatalf("GetTenantByName(%s) = %v, %v", name, tenant, err)
+	}
+	return tenant.ID
+}
+
+// asUser returns the context of a request made by username with role in tenantID
+func asUser(username, role string, tenantID int) context.Context {
+	ctx := context.WithValue(context.Background(), middleware.UserKey, username)
+	ctx = context.WithValue(ctx, middleware.RoleKey, role)
+	return middleware.WithTenant(ctx, tenantID)
+}
+
+// addImage stores an unreviewed image of tenantID with its file and returns its hash
+func addImage(t *testing.T, repos *repositories.Repositories, store *storage.Store, tenantID int, hash string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	key := storage.Key(tenantID, hash, "photo.jpg")
+	if err := store.Uploads.Put(ctx, key, strings.NewReader("image"), 5); err != nil {
+		t.Fatal(err)
+	}
+
+	img := models.UploadedImage{TenantID: tenantID, FilePath: key, FileHash: hash, Label: "SFW", Confidence: 90}
+	if _, err := repos.Uploaded.UploadImage(ctx, img); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func newStore(t *testing.T) *storage.Store {
+	dir := t.TempDir()
+	return &storage.Store{
+		Uploads:  storage.NewLocal(filepath.Join(dir, "uploads")),
+		Trash:    storage.NewLocal(filepath.Join(dir, "trash")),
+		Previews: storage.NewLocal(filepath.Join(dir, "previews")),
+	}
+}
+
+func TestTenantIsolationImages(t *testing.T) {
+	repos := openRepositories(t)
+	store := newStore(t)
+	hub := websockets.NewHub()
+	go hub.Run()
+	predictions := cache.NewMetered(cache.NewMemoryStore())
+	s := NewAPIService(hub, repos, predictions, store, urlsign.New("test", time.Minute), nil)
+
+	acme := addTenant(t, repos, "acme")
+	theirs := addImage(t, repos, store, models.DefaultTenantID, strings.Repeat("a", 64))
+	ours := addImage(t, repos, store, acme, strings.Repeat("b", 64))
+
+	owner := asUser("alice", models.RoleAdmin, models.DefaultTenantID)
+	other := asUser("bob", models.RoleAdmin, acme)
+
+	page, err := s.PaginationUploads(other, math.MaxInt32, 50, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Data) != 1 || page.Data[0].FileHash != ours {
+		t.Fatalf("PaginationUploads = %+v, want only the image of the tenant", page)
+	}
+
+	if _, err := s.LabelImage(other, theirs, models.LabelRequest{Event: "rate", Rating: "NSFW", Sha256: theirs}, hub); err == nil {
+		t.Fatal("LabelImage labeled the image of another tenant")
+	}
+	if _, err := s.DeleteImage(other, theirs, models.LabelRequest{Event: "delete", Sha256: theirs}, hub); err == nil {
+		t.Fatal("DeleteImage trashed the image of another tenant")
+	}
+
+	img, err := repos.Uploaded.GetImageByHash(context.Background(), models.DefaultTenantID, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Reviewed || img.DeletedAt != nil {
+		t.Fatalf("image of the default tenant was changed: %+v", img)
+	}
+
+	// the owner trashes it, the other tenant can neither see nor restore it
+	if _, err := s.DeleteImage(owner, theirs, models.LabelRequest{Event: "delete", Sha256: theirs}, hub); err != nil {
+		t.Fatalf("DeleteImage by the owner = %v", err)
+	}
+	trash, err := s.ListTrash(other, 0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trash.Total != 0 || len(trash.Data) != 0 {
+		t.Fatalf("ListTrash = %+v, want the trash of the tenant only", trash)
+	}
+	if _, err := s.RestoreImage(other, theirs, models.LabelRequest{Event: "restore", Sha256: theirs}); err == nil {
+		t.Fatal("RestoreImage restored the image of another tenant")
+	}
+
+	history, err := s.ImageHistory(other, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("ImageHistory = %+v, want nothing of another tenant", history)
+	}
+	feed, err := s.AuditFeed(other, 0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Data) != 0 {
+		t.Fatalf("AuditFeed = %+v, want nothing of another tenant", feed.Data)
+	}
+
+	predictions.Get(owner, cache.PredictionKey(models.DefaultTenantID, theirs))
+	stats, err := s.FetchStats(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalImages != 1 {
+		t.Fatalf("FetchStats counted %d images, want those of the tenant", stats.TotalImages)
+	}
+	if stats.Cache == nil || stats.Cache.Hits+stats.Cache.Misses != 0 {
+		t.Fatalf("FetchStats cache = %+v, want no lookups of another tenant", stats.Cache)
+	}
+}
+
+// TestMissingTenant checks a context that was given no tenant sees and changes nothing
+func TestMissingTenant(t *testing.T) {
+	repos := openRepositories(t)
+	store := newStore(t)
+	hub := websockets.NewHub()
+	go hub.Run()
+	s := NewAPIService(hub, repos, nil, store, urlsign.New("test", time.Minute), nil)
+	sessions := newAuthServices(repos)
+	users := NewUserService(repos, sessions.sessions, sessions.mfa)
+	keys := NewAPIKeyService(repos, auth.NewAPIKeyLimiter(cache.NewMemoryCounter()))
+
+	hash := addImage(t, repos, store, models.DefaultTenantID, strings.Repeat("a", 64))
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+
+	ctx := context.WithValue(context.Background(), middleware.RoleKey, models.RoleAdmin)
+
+	if page, err := s.PaginationUploads(ctx, math.MaxInt32, 50, nil); err != nil || page.Total != 0 || len(page.Data) != 0 {
+		t.Fatalf("PaginationUploads = %+v, %v, want nothing", page, err)
+	}
+	if _, err := s.LabelImage(ctx, hash, models.LabelRequest{Event: "rate", Rating: "NSFW", Sha256: hash}, hub); err == nil {
+		t.Fatal("LabelImage without a tenant labeled an image")
+	}
+	if list, err := users.ListUsers(ctx); err != nil || len(list) != 0 {
+		t.Fatalf("ListUsers = %+v, %v, want nothing", list, err)
+	}
+	if _, err := users.SetRole(ctx, "alice", models.RoleViewer); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("SetRole = %v, want ErrUserNotFound", err)
+	}
+	if _, err := users.CreateUser(ctx, models.CreateUserRequest{Username: "mallory", Password: testPassword, Role: models.RoleAdmin}); !errors.Is(err, ErrTenantNotFound) {
+		t.Fatalf("CreateUser = %v, want ErrTenantNotFound", err)
+	}
+	if _, err := keys.Create(ctx, models.CreateAPIKeyRequest{ClientName: "mallory"}); !errors.Is(err, ErrTenantNotFound) {
+		t.Fatalf("Create = %v, want ErrTenantNotFound", err)
+	}
+
+	// the CLI and background jobs ask for every tenant
+	all := middleware.WithTenant(ctx, models.AllTenants)
+	if page, err := s.PaginationUploads(all, math.MaxInt32, 50, nil); err != nil || page.Total != 1 {
+		t.Fatalf("PaginationUploads on all tenants = %+v, %v", page, err)
+	}
+	if _, err := users.SetRole(all, "alice", models.RoleReviewer); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("SetRole on all tenants = %v, want the user found", err)
+	}
+}
+
+func TestTenantIsolationUsers(t *testing.T) {
+	repos := openRepositories(t)
+	sessions := newAuthServices(repos)
+	s := NewUserService(repos, sessions.sessions, sessions.mfa)
+
+	acme := addTenant(t, repos, "acme")
+	addUser(t, repos, "alice", models.RoleAdmin, models.DefaultTenantID)
+	addUser(t, repos, "bob", models.RoleAdmin, acme)
+	other := asUser("bob", models.RoleAdmin, acme)
+
+	list, err := s.ListUsers(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Username != "bob" {
+		t.Fatalf("ListUsers = %+v, want the users of the tenant only", list)
+	}
+
+	calls := map[string]func() error{
+		"SetRole": func() error {
+			_, err := s.SetRole(other, "alice", models.RoleViewer)
+			return err
+		},
+		"SetDisabled": func() error {
+			_, err := s.SetDisabled(other, "alice", true)
+			return err
+		},
+		"ResetPassword": func() error {
+			_, err := s.ResetPassword(other, "alice", "Another-Horse-42")
+			return err
+		},
+		"Unlock": func() error {
+			_, err := s.Unlock(other, "alice")
+			return err
+		},
+		"ResetMFA": func() error {
+			_, err := s.ResetMFA(other, "alice")
+			return err
+		},
+		"SetTenant": func() error {
+			_, err := s.SetTenant(other, "alice", "acme")
+			return err
+		},
+		"DeleteUser": func() error {
+			return s.DeleteUser(other, "alice")
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("%s(user of another tenant) = %v, want ErrUserNotFound", name, err)
+		}
+	}
+
+	alice, err := repos.User.GetUser(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice == nil || alice.Role != models.RoleAdmin || alice.DisabledAt != nil || alice.TenantID != models.DefaultTenantID {
+		t.Fatalf("user of the default tenant was changed: %+v", alice)
+	}
+	if _, err := sessions.sessions.Login(context.Background(), "alice", testPassword, "10.0.0.1"); err != nil {
+		t.Fatalf("password of the default tenant's user was changed: %v", err)
+	}
+
+	created, err := s.CreateUser(other, models.CreateUserRequest{Username: "carol", Password: testPassword, Role: models.RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.TenantID != acme {
+		t.Fatalf("CreateUser put the user in tenant %d, want %d", created.TenantID, acme)
+	}
+}
+
+func TestTenantIsolationAPIKeys(t *testing.T) {
+	repos := openRepositories(t)
+	s := NewAPIKeyService(repos, auth.NewAPIKeyLimiter(cache.NewMemoryCounter()))
+
+	acme := addTenant(t, repos, "acme")
+	owner := asUser("alice", models.RoleAdmin, models.DefaultTenantID)
+	other := asUser("bob", models.RoleAdmin, acme)
+
+	theirs, err := s.Create(owner, models.CreateAPIKeyRequest{ClientName: "gallery"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ours, err := s.Create(other, models.CreateAPIKeyRequest{ClientName: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theirs.TenantID != models.DefaultTenantID || ours.TenantID != acme {
+		t.Fatalf("keys created in tenants %d and %d", theirs.TenantID, ours.TenantID)
+	}
+
+	list, err := s.List(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != ours.ID {
+		t.Fatalf("List = %+v, want the keys of the tenant only", list)
+	}
+	usage, err := s.Usage(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].ID != ours.ID {
+		t.Fatalf("Usage = %+v, want the keys of the tenant only", usage)
+	}
+
+	if _, err := s.Revoke(other, theirs.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("Revoke(key of another tenant) = %v, want ErrAPIKeyNotFound", err)
+	}
+	key, err := s.Authenticate(context.Background(), theirs.Key)
+	if err != nil || key == nil {
+		t.Fatalf("key of the default tenant no longer authenticates: %v, %v", key, err)
+	}
+}
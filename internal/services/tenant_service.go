@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/validation"
+)
+
+var (
+	// ErrInvalidTenant wraps rejected tenant names and policies
+	ErrInvalidTenant  = errors.New("Invalid tenant")
+	ErrTenantNotFound = errors.New("Tenant not found")
+	ErrTenantExists   = errors.New("Tenant name already taken")
+)
+
+// TenantService manages the tenants sharing the service and their policies. Requests
+// work on the tenant of their API key or user, the CLI picks one by name.
+type TenantService struct {
+	repositories *repositories.Repositories
+}
+
+func NewTenantService(repositories *repositories.Repositories) *TenantService {
+	return &TenantService{repositories: repositories}
+}
+
+// Create adds a tenant called name which flags images above nsfwThreshold percent
+func (s *TenantService) Create(ctx context.Context, name string, nsfwThreshold float32) (*models.Tenant, error) {
+	if err := validation.ValidateTenantName(name); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTenant, err)
+	}
+	if err := validateThreshold(nsfwThreshold); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.repositories.Tenants.GetTenantByName(ctx, name)
+	if err != nil {
+		logger.Error("Failed to fetch tenant %s: %v", name, err)
+		return nil, fmt.Errorf("Failed to create tenant")
+	}
+	if existing != nil {
+		return nil, ErrTenantExists
+	}
+
+	if err := s.repositories.Tenants.CreateTenant(ctx, models.Tenant{Name: name, NSFWThreshold: nsfwThreshold}); err != nil {
+		logger.Error("Failed to create tenant %s: %v", name, err)
+		return nil, fmt.Errorf("Failed to create tenant")
+	}
+
+	logger.Info("Tenant %s created by %s", name, middleware.Username(ctx))
+	return s.Resolve(ctx, name)
+}
+
+func (s *TenantService) List(ctx context.Context) ([]models.Tenant, error) {
+	tenants, err := s.repositories.Tenants.ListTenants(ctx)
+	if err != nil {
+		logger.Error("Failed to list tenants: %v", err)
+		return nil, fmt.Errorf("Failed to list tenants")
+	}
+	return tenants, nil
+}
+
+// Resolve returns the tenant called name
+func (s *TenantService) Resolve(ctx context.Context, name string) (*models.Tenant, error) {
+	tenant, err := s.repositories.Tenants.GetTenantByName(ctx, name)
+	if err != nil {
+		logger.Error("Failed to fetch tenant %s: %v", name, err)
+		return nil, fmt.Errorf("Failed to fetch tenant")
+	}
+	if tenant == nil {
+		return nil, ErrTenantNotFound
+	}
+	return tenant, nil
+}
+
+// Current returns the tenant of ctx, the default tenant outside of requests
+func (s *TenantService) Current(ctx context.Context) (*models.Tenant, error) {
+	return currentTenant(ctx, s.repositories)
+}
+
+// SetPolicy changes the NSFW threshold of the tenant of ctx. Predictions made before
+// are relabeled when they are next looked up, human labels are kept.
+func (s *TenantService) SetPolicy(ctx context.Context, req models.TenantPolicyRequest) (*models.Tenant, error) {
+	if err := validateThreshold(req.NSFWThreshold); err != nil {
+		return nil, err
+	}
+
+	tenant, err := s.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.repositories.Tenants.UpdatePolicy(ctx, tenant.ID, req.NSFWThreshold); err != nil {
+		logger.Error("Failed to update policy of tenant %s: %v", tenant.Name, err)
+		return nil, fmt.Errorf("Failed to update tenant policy")
+	}
+
+	logger.Info("NSFW threshold of tenant %s changed from %.1f to %.1f by %s", tenant.Name, tenant.NSFWThreshold, req.NSFWThreshold, middleware.Username(ctx))
+	return s.Current(ctx)
+}
+
+func validateThreshold(nsfwThreshold float32) error {
+	if nsfwThreshold < 0 || nsfwThreshold > 100 {
+		return fmt.Errorf("%w: NSFW threshold must be between 0 and 100", ErrInvalidTenant)
+	}
+	return nil
+}
+
+// currentTenant fetches the tenant of ctx, see ownTenant
+func currentTenant(ctx context.Context, repositories *repositories.Repositories) (*models.Tenant, error) {
+	tenantID, err := ownTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := repositories.Tenants.GetTenant(ctx, tenantID)
+	if err != nil {
+		logger.Error("Failed to fetch tenant %d: %v", tenantID, err)
+		return nil, fmt.Errorf("Failed to fetch tenant")
+	}
+	if tenant == nil {
+		return nil, ErrTenantNotFound
+	}
+	return tenant, nil
+}
+
+// ownTenant returns the tenant records created for ctx belong to. Requests always have
+// one, the CLI works in the default tenant unless it picks another. A context without a
+// tenant gets ErrTenantNotFound, it must not create anything.
+func ownTenant(ctx context.Context) (int, error) {
+	switch tenantID := middleware.TenantID(ctx); tenantID {
+	case models.NoTenant:
+		logger.Error("No tenant given for %q", middleware.Username(ctx))
+		return 0, ErrTenantNotFound
+	case models.AllTenants:
+		return models.DefaultTenantID, nil
+	default:
+		return tenantID, nil
+	}
+}
+
+// inTenant reports whether a record of tenantID can be seen and changed for ctx. Nothing
+// can be for a context without a tenant.
+func inTenant(ctx context.Context, tenantID int) bool {
+	switch own := middleware.TenantID(ctx); own {
+	case models.NoTenant:
+		return false
+	case models.AllTenants:
+		return true
+	default:
+		return own == tenantID
+	}
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+	"github.com/mlvieira/nsfwdetection/internal/urlsign"
+)
+
+// FilesURL and PreviewsURL are the paths under which the server serves stored uploads and
+// their previews by key. Links are only valid with the signature added by signedURL.
+const (
+	FilesURL    = "/admin/files/"
+	PreviewsURL = "/admin/previews/"
+)
+
+// signedURL returns a signed, expiring link to the object under key
+func signedURL(signer *urlsign.Signer, prefix, key string) string {
+	return signer.Sign(prefix + key)
+}
+
+// withURLs fills in where the frontend loads an image and its previews from
+func withURLs(signer *urlsign.Signer, img *models.UploadedImage) {
+	img.URL = signedURL(signer, FilesURL, img.FilePath)
+	img.ThumbnailURL = signedURL(signer, PreviewsURL, storage.ThumbnailKey(img.FileHash))
+	img.PreviewURL = signedURL(signer, PreviewsURL, storage.BlurredKey(img.FileHash))
+}
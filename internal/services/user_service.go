@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/middleware"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+	"github.com/mlvieira/nsfwdetection/internal/repositories"
+	"github.com/mlvieira/nsfwdetection/internal/utils"
+	"github.com/mlvieira/nsfwdetection/internal/validation"
+)
+
+var (
+	// ErrInvalidUser wraps rejected usernames, passwords and roles
+	ErrInvalidUser  = errors.New("Invalid user")
+	ErrUserNotFound = errors.New("User not found")
+	ErrUserExists   = errors.New("Username already taken")
+	// ErrLastAdmin is returned for changes that would leave no enabled admin to manage users
+	ErrLastAdmin = errors.New("At least one enabled admin is required")
+	// ErrSelfChange is returned when admins try to disable or delete their own account
+	ErrSelfChange = errors.New("You cannot disable or delete your own account")
+)
+
+// UserService manages admin users for the REST API and the CLI. The acting user is
+// taken from the request context. Changes that take rights away end the sessions of
+// the user through sessions.
+type UserService struct {
+	repositories *repositories.Repositories
+	sessions     *AuthService
+	mfa          *MFAService
+}
+
+func NewUserService(repositories *repositories.Repositories, sessions *AuthService, mfa *MFAService) *UserService {
+	return &UserService{repositories: repositories, sessions: sessions, mfa: mfa}
+}
+
+// ListUsers returns the users of the tenant of ctx
+func (s *UserService) ListUsers(ctx context.Context) ([]models.User, error) {
+	users, err := s.repositories.User.ListUsers(ctx, middleware.TenantID(ctx))
+	if err != nil {
+		logger.Error("Failed to list users: %v", err)
+		return nil, fmt.Errorf("Failed to list users")
+	}
+	return users, nil
+}
+
+func (s *UserService) CreateUser(ctx context.Context, req models.CreateUserRequest) (*models.User, error) {
+	if err := validation.ValidateUsername(req.Username); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUser, err)
+	}
+	if !models.ValidRole(req.Role) {
+		return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidUser, req.Role)
+	}
+	if err := validation.ValidatePassword(req.Password, req.Username); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUser, err)
+	}
+
+	existing, err := s.repositories.User.GetUser(ctx, req.Username)
+	if err != nil {
+		logger.Error("Failed to fetch user %s: %v", req.Username, err)
+		return nil, fmt.Errorf("Failed to create user")
+	}
+	if existing != nil {
+		return nil, ErrUserExists
+	}
+
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		logger.Error("Failed to hash password: %v", err)
+		return nil, fmt.Errorf("Failed to create user")
+	}
+
+	tenantID, err := ownTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.User{Username: req.Username, Password: hashedPassword, Role: req.Role, TenantID: tenantID}
+	if err := s.repositories.User.AddUser(ctx, user); err != nil {
+		logger.Error("Failed to create user %s: %v", req.Username, err)
+		return nil, fmt.Errorf("Failed to create user")
+	}
+
+	logger.Info("User %s created with role %s by %s", req.Username, req.Role, middleware.Username(ctx))
+	return s.getUser(ctx, req.Username)
+}
+
+func (s *UserService) SetRole(ctx context.Context, username, role string) (*models.User, error) {
+	if !models.ValidRole(role) {
+		return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidUser, role)
+	}
+
+	user, err := s.getUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if role != models.RoleAdmin {
+		if err := s.ensureOtherAdmin(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := s.repositories.User.UpdateRole(ctx, username, role); err != nil {
+		logger.Error("Failed to update role of %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to update role")
+	}
+
+	logger.Info("User %s changed from %s to %s by %s", username, user.Role, role, middleware.Username(ctx))
+	if role != user.Role {
+		s.revoke(s.sessions.RevokeAccessTokens(ctx, username), username)
+	}
+	return s.getUser(ctx, username)
+}
+
+// SetTenant moves username to the tenant called tenantName. They keep their role and
+// see the uploads of the new tenant from their next token on.
+func (s *UserService) SetTenant(ctx context.Context, username, tenantName string) (*models.User, error) {
+	user, err := s.getUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := s.repositories.Tenants.GetTenantByName(ctx, tenantName)
+	if err != nil {
+		logger.Error("Failed to fetch tenant %s: %v", tenantName, err)
+		return nil, fmt.Errorf("Failed to update tenant")
+	}
+	if tenant == nil {
+		return nil, ErrTenantNotFound
+	}
+	if tenant.ID == user.TenantID {
+		return user, nil
+	}
+
+	if err := s.ensureOtherAdmin(ctx, user); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.repositories.User.SetTenant(ctx, username, tenant.ID); err != nil {
+		logger.Error("Failed to update tenant of %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to update tenant")
+	}
+
+	logger.Info("User %s moved to tenant %s by %s", username, tenant.Name, middleware.Username(ctx))
+	s.revoke(s.sessions.RevokeAccessTokens(ctx, username), username)
+	return s.getUser(ctx, username)
+}
+
+func (s *UserService) ResetPassword(ctx context.Context, username, password string) (*models.User, error) {
+	if err := validation.ValidatePassword(password, username); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUser, err)
+	}
+
+	if _, err := s.getUser(ctx, username); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		logger.Error("Failed to hash password: %v", err)
+		return nil, fmt.Errorf("Failed to update password")
+	}
+
+	if _, err := s.repositories.User.UpdatePassword(ctx, username, hashedPassword); err != nil {
+		logger.Error("Failed to update password of %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to update password")
+	}
+
+	logger.Info("Password of %s reset by %s", username, middleware.Username(ctx))
+	s.revoke(s.sessions.EndSessions(ctx, username), username)
+	return s.getUser(ctx, username)
+}
+
+// SetDisabled disables a user, who can no longer log in, or enables them again
+func (s *UserService) SetDisabled(ctx context.Context, username string, disabled bool) (*models.User, error) {
+	user, err := s.getUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if disabled {
+		if username == middleware.Username(ctx) {
+			return nil, ErrSelfChange
+		}
+		if err := s.ensureOtherAdmin(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := s.repositories.User.SetDisabled(ctx, username, disabled); err != nil {
+		logger.Error("Failed to update disabled state of %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to update user")
+	}
+
+	state := "enabled"
+	if disabled {
+		state = "disabled"
+	}
+	logger.Info("User %s %s by %s", username, state, middleware.Username(ctx))
+	if disabled {
+		s.revoke(s.sessions.EndSessions(ctx, username), username)
+	}
+	return s.getUser(ctx, username)
+}
+
+// Unlock lifts a login lockout of username, so they can try again right away
+func (s *UserService) Unlock(ctx context.Context, username string) (*models.User, error) {
+	user, err := s.getUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sessions.Unlock(ctx, user.Username); err != nil {
+		logger.Error("Failed to unlock %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to unlock user")
+	}
+
+	logger.Info("Login lockout of %s lifted by %s", username, middleware.Username(ctx))
+	return user, nil
+}
+
+// ResetMFA removes the two-factor setup of username, for users who lost their device
+// and their recovery codes
+func (s *UserService) ResetMFA(ctx context.Context, username string) (*models.User, error) {
+	if _, err := s.getUser(ctx, username); err != nil {
+		return nil, err
+	}
+
+	if err := s.mfa.Reset(ctx, username); err != nil {
+		logger.Error("Failed to reset two-factor authentication of %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to reset two-factor authentication")
+	}
+
+	logger.Info("Two-factor authentication of %s reset by %s", username, middleware.Username(ctx))
+	return s.getUser(ctx, username)
+}
+
+func (s *UserService) DeleteUser(ctx context.Context, username string) error {
+	user, err := s.getUser(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if username == middleware.Username(ctx) {
+		return ErrSelfChange
+	}
+	if err := s.ensureOtherAdmin(ctx, user); err != nil {
+		return err
+	}
+
+	if _, err := s.repositories.User.DeleteUser(ctx, username); err != nil {
+		logger.Error("Failed to delete user %s: %v", username, err)
+		return fmt.Errorf("Failed to delete user")
+	}
+
+	logger.Info("User %s deleted by %s", username, middleware.Username(ctx))
+	s.revoke(s.sessions.EndSessions(ctx, username), username)
+	return nil
+}
+
+// revoke logs a failure to end sessions after a change. The change itself is already
+// stored, and the tokens left run out on their own.
+func (s *UserService) revoke(err error, username string) {
+	if err != nil {
+		logger.Error("Failed to revoke sessions of %s: %v", username, err)
+	}
+}
+
+func (s *UserService) getUser(ctx context.Context, username string) (*models.User, error) {
+	user, err := s.repositories.User.GetUser(ctx, username)
+	if err != nil {
+		logger.Error("Failed to fetch user %s: %v", username, err)
+		return nil, fmt.Errorf("Failed to fetch user")
+	}
+	if user == nil || !inTenant(ctx, user.TenantID) {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// ensureOtherAdmin fails if user is the only enabled admin of their tenant, so its users
+// can always be managed
+func (s *UserService) ensureOtherAdmin(ctx context.Context, user *models.User) error {
+	if user.Role != models.RoleAdmin || user.DisabledAt != nil {
+		return nil
+	}
+
+	users, err := s.repositories.User.ListUsers(ctx, user.TenantID)
+	if err != nil {
+		logger.Error("Failed to list users: %v", err)
+		return fmt.Errorf("Failed to list users")
+	}
+
+	for _, u := range users {
+		if u.Username != user.Username && u.Role == models.RoleAdmin && u.DisabledAt == nil {
+			return nil
+		}
+	}
+	return ErrLastAdmin
+}
@@ -0,0 +1,186 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/storage"
+)
+
+// openStore returns an empty store of backend or skips the test.
+// The local backend always runs, S3 runs against NSFW_TEST_S3_ENDPOINT (MinIO works)
+// with NSFW_TEST_S3_BUCKET, NSFW_TEST_S3_ACCESS_KEY and NSFW_TEST_S3_SECRET_KEY.
+func openStore(t *testing.T, backend string) *storage.Store {
+	t.Helper()
+
+	cfg := config.StorageConfig{Backend: backend, Region: "us-east-1"}
+	files := config.FileHandlingConfig{UploadDir: t.TempDir(), TrashDir: t.TempDir(), PreviewDir: t.TempDir()}
+
+	if backend == storage.BackendS3 {
+		cfg.Endpoint = os.Getenv("NSFW_TEST_S3_ENDPOINT")
+		if cfg.Endpoint == "" {
+			t.Skip("NSFW_TEST_S3_ENDPOINT not set")
+		}
+		cfg.Bucket = os.Getenv("NSFW_TEST_S3_BUCKET")
+		cfg.AccessKey = os.Getenv("NSFW_TEST_S3_ACCESS_KEY")
+		cfg.SecretKey = os.Getenv("NSFW_TEST_S3_SECRET_KEY")
+		cfg.PathStyle = true
+
+		// a fresh prefix per run keeps runs from seeing each other's objects
+		run := "test-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "/"
+		cfg.UploadsPrefix = run + "uploads/"
+		cfg.TrashPrefix = run + "trash/"
+		cfg.PreviewsPrefix = run + "previews/"
+	}
+
+	store, err := storage.New(cfg, files)
+	if err != nil {
+		t.Fatalf("failed to create %s store: %v", backend, err)
+	}
+	return store
+}
+
+func forEachStore(t *testing.T, fn func(t *testing.T, store *storage.Store)) {
+	for _, backend := range []string{storage.BackendLocal, storage.BackendS3} {
+		t.Run(backend, func(t *testing.T) {
+			fn(t, openStore(t, backend))
+		})
+	}
+}
+
+func put(t *testing.T, b storage.Backend, key, content string) {
+	t.Helper()
+	if err := b.Put(context.Background(), key, bytes.NewReader([]byte(content)), int64(len(content))); err != nil {
+		t.Fatalf("Put(%s): %v", key, err)
+	}
+}
+
+func keys(t *testing.T, b storage.Backend) []string {
+	t.Helper()
+
+	var list []string
+	err := b.List(context.Background(), func(info storage.ObjectInfo) error {
+		list = append(list, info.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestBackend(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		b := store.Uploads
+
+		if _, err := b.Stat(ctx, "missing.jpg"); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("Stat(missing) = %v, want ErrNotFound", err)
+		}
+		if _, err := b.Open(ctx, "missing.jpg"); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("Open(missing) = %v, want ErrNotFound", err)
+		}
+		if err := b.Delete(ctx, "missing.jpg"); err != nil {
+			t.Fatalf("Delete(missing): %v", err)
+		}
+
+		put(t, b, "a.jpg", "0123456789")
+		put(t, b, "b.png", "old")
+		put(t, b, "b.png", "new")
+
+		info, err := b.Stat(ctx, "a.jpg")
+		if err != nil || info.Size != 10 || info.Key != "a.jpg" {
+			t.Fatalf("Stat = %+v, %v", info, err)
+		}
+
+		obj, err := b.Open(ctx, "a.jpg")
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer obj.Close()
+
+		if _, err := obj.Seek(4, io.SeekStart); err != nil {
+			t.Fatalf("Seek: %v", err)
+		}
+		rest, err := io.ReadAll(obj)
+		if err != nil || string(rest) != "456789" {
+			t.Fatalf("read after seek = %q, %v", rest, err)
+		}
+		if end, err := obj.Seek(0, io.SeekEnd); err != nil || end != 10 {
+			t.Fatalf("Seek(end) = %d, %v", end, err)
+		}
+
+		obj, err = b.Open(ctx, "b.png")
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		content, _ := io.ReadAll(obj)
+		obj.Close()
+		if string(content) != "new" {
+			t.Fatalf("overwritten object = %q", content)
+		}
+
+		if got := keys(t, b); len(got) != 2 || got[0] != "a.jpg" || got[1] != "b.png" {
+			t.Fatalf("List = %v", got)
+		}
+
+		if err := b.Delete(ctx, "b.png"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if got := keys(t, b); len(got) != 1 {
+			t.Fatalf("List after delete = %v", got)
+		}
+	})
+}
+
+func TestStoreTrash(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *storage.Store) {
+		ctx := context.Background()
+		put(t, store.Uploads, "a.jpg", "image")
+
+		if err := store.MoveToTrash(ctx, "a.jpg"); err != nil {
+			t.Fatalf("MoveToTrash: %v", err)
+		}
+		if got := keys(t, store.Uploads); len(got) != 0 {
+			t.Fatalf("uploads after trash = %v", got)
+		}
+		if got := keys(t, store.Trash); len(got) != 1 || got[0] != "a.jpg" {
+			t.Fatalf("trash = %v", got)
+		}
+
+		// moving a missing file is not an error
+		if err := store.MoveToTrash(ctx, "a.jpg"); err != nil {
+			t.Fatalf("MoveToTrash(missing): %v", err)
+		}
+
+		if err := store.RestoreFromTrash(ctx, "a.jpg"); err != nil {
+			t.Fatalf("RestoreFromTrash: %v", err)
+		}
+		obj, err := store.Uploads.Open(ctx, "a.jpg")
+		if err != nil {
+			t.Fatalf("Open(restored): %v", err)
+		}
+		content, _ := io.ReadAll(obj)
+		obj.Close()
+		if string(content) != "image" {
+			t.Fatalf("restored content = %q", content)
+		}
+
+		store.MoveToTrash(ctx, "a.jpg")
+		if err := store.RemoveFromTrash(ctx, "a.jpg"); err != nil {
+			t.Fatalf("RemoveFromTrash: %v", err)
+		}
+		if got := keys(t, store.Trash); len(got) != 0 {
+			t.Fatalf("trash after remove = %v", got)
+		}
+	})
+}
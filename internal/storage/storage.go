@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when a key does not exist
+	ErrNotFound = errors.New("object not found")
+	// ErrInvalidKey is returned for keys that are empty or would escape the storage area
+	ErrInvalidKey = errors.New("invalid storage key")
+)
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Object is an open stored object. Seeking is supported so it can be served with
+// http.ServeContent, which handles Range requests.
+type Object interface {
+	io.ReadSeekCloser
+	Info() ObjectInfo
+}
+
+// Backend stores objects by key. Keys are slash separated and never start with a slash.
+type Backend interface {
+	// Put stores size bytes read from r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open returns the object stored under key or ErrNotFound
+	Open(ctx context.Context, key string) (Object, error)
+	// Stat describes the object stored under key or returns ErrNotFound
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes the object stored under key. A missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every stored object
+	List(ctx context.Context, fn func(ObjectInfo) error) error
+}
+
+// mover is implemented by backends that can move an object to another backend
+// without streaming it through this process. ok is false when dst is not supported.
+type mover interface {
+	moveTo(ctx context.Context, key string, dst Backend) (ok bool, err error)
+}
+
+// Store keeps the files of uploaded images. Deleted images are moved to Trash
+// until purged, under the same key. Previews holds the thumbnail and blurred
+// preview of each image, which stay in place until the image is purged.
+type Store struct {
+	Uploads  Backend
+	Trash    Backend
+	Previews Backend
+}
+
+// New creates the store selected by cfg
+func New(cfg config.StorageConfig, files config.FileHandlingConfig) (*Store, error) {
+	switch cfg.Backend {
+	case BackendLocal:
+		return &Store{
+			Uploads:  NewLocal(files.UploadDir),
+			Trash:    NewLocal(files.TrashDir),
+			Previews: NewLocal(files.PreviewDir),
+		}, nil
+	case BackendS3:
+		client, err := NewS3Client(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &Store{
+			Uploads:  client.Bucket(cfg.UploadsPrefix),
+			Trash:    client.Bucket(cfg.TrashPrefix),
+			Previews: client.Bucket(cfg.PreviewsPrefix),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %s", cfg.Backend)
+	}
+}
+
+// Key returns the storage key of an image of a tenant from its hash and original file
+// name. Images of the default tenant keep the keys they had before tenants existed, the
+// others live under tenants/<id>/ so every tenant can trash and purge its own copy.
+func Key(tenantID int, hash, filename string) string {
+	if tenantID == models.DefaultTenantID {
+		return hash + path.Ext(filename)
+	}
+	return "tenants/" + strconv.Itoa(tenantID) + "/" + hash + path.Ext(filename)
+}
+
+// ThumbnailKey returns the key of an image's thumbnail in Previews
+func ThumbnailKey(hash string) string {
+	return "thumb/" + hash + ".jpg"
+}
+
+// BlurredKey returns the key of an image's blurred preview in Previews
+func BlurredKey(hash string) string {
+	return "blur/" + hash + ".jpg"
+}
+
+// ContentType guesses the media type of key from its extension
+func ContentType(key string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// PutFile copies the local file at filePath into the uploads under key
+func (s *Store) PutFile(ctx context.Context, key, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return s.Uploads.Put(ctx, key, file, info.Size())
+}
+
+// MoveToTrash moves the file of an image to the trash. A missing file is not an error.
+func (s *Store) MoveToTrash(ctx context.Context, key string) error {
+	return move(ctx, s.Uploads, s.Trash, key)
+}
+
+// RestoreFromTrash moves the file of an image back from the trash. A missing file is not an error.
+func (s *Store) RestoreFromTrash(ctx context.Context, key string) error {
+	return move(ctx, s.Trash, s.Uploads, key)
+}
+
+// RemoveFromTrash permanently deletes the trashed file of an image
+func (s *Store) RemoveFromTrash(ctx context.Context, key string) error {
+	return s.Trash.Delete(ctx, key)
+}
+
+// RemovePreviews deletes the thumbnail and blurred preview of an image
+func (s *Store) RemovePreviews(ctx context.Context, hash string) error {
+	if err := s.Previews.Delete(ctx, ThumbnailKey(hash)); err != nil {
+		return err
+	}
+	return s.Previews.Delete(ctx, BlurredKey(hash))
+}
+
+// move moves key from src to dst, natively when the backends allow it
+func move(ctx context.Context, src, dst Backend, key string) error {
+	if m, ok := src.(mover); ok {
+		moved, err := m.moveTo(ctx, key, dst)
+		if moved || err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	obj, err := src.Open(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	defer obj.Close()
+
+	if err := dst.Put(ctx, key, obj, obj.Info().Size); err != nil {
+		return err
+	}
+
+	return src.Delete(ctx, key)
+}
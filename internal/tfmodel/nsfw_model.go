@@ -1,3 +1,5 @@
+//go:build !notensorflow
+
 package tfmodel
 
 import (
@@ -17,20 +19,6 @@ type Model struct {
 	model *tensorflow.SavedModel
 }
 
-// Prediction represents the output for NSFW detection
-type Prediction struct {
-	ID             int     `json:"id"`              // Job ID (used by worker)
-	NSFWPercentage float32 `json:"nsfw_percentage"` // NSFW percentage
-	SFWPercentage  float32 `json:"sfw_percentage"`  // SFW percentage
-	Duration       float64 `json:"duration"`        // Processing time in seconds
-	Timestamp      int64   `json:"timestamp"`       // UNIX timestamp
-	UUID           string  `json:"uuid"`            // Unique identifier
-	SHA256         string  `json:"sha256"`          // SHA256 hash
-	Error          string  `json:"error,omitempty"` // Error message
-	Trace          string  `json:"trace,omitempty"` // Error trace
-	Success        bool    `json:"success"`         // Success flag
-}
-
 // LoadModel initializes and loads the TensorFlow model
 func LoadModel(modelPath string) error {
 	model, err := tensorflow.LoadSavedModel(modelPath, []string{"serve"}, nil)
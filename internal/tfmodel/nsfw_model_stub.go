@@ -0,0 +1,27 @@
+//go:build notensorflow
+
+package tfmodel
+
+import "errors"
+
+// ErrNoTensorFlow is returned by LoadModel in builds without the TensorFlow C library
+var ErrNoTensorFlow = errors.New("built with the notensorflow tag, use the fake model instead")
+
+// SharedNSFWModel is the global, shared model loaded at startup
+var SharedNSFWModel *Model
+
+// Model is a placeholder for the TensorFlow model in builds without TensorFlow
+type Model struct{}
+
+// LoadModel always fails, the binary was built without TensorFlow
+func LoadModel(modelPath string) error {
+	return ErrNoTensorFlow
+}
+
+// DetectNSFW always fails, the binary was built without TensorFlow
+func (m *Model) DetectNSFW(imagePath string) (*Prediction, error) {
+	return nil, ErrNoTensorFlow
+}
+
+// Close is a no-op
+func (m *Model) Close() {}
@@ -0,0 +1,66 @@
+package tfmodel
+
+import "time"
+
+// Sources of the moderation decision in a Prediction
+const (
+	SourceModel = "model"
+	SourceHuman = "human"
+)
+
+// Detector classifies a single image file. *Model is the TensorFlow implementation,
+// FakeModel a deterministic stand-in for development and integration tests.
+type Detector interface {
+	DetectNSFW(imagePath string) (*Prediction, error)
+	Close()
+}
+
+// Prediction represents the output for NSFW detection
+type Prediction struct {
+	ID             int     `json:"id"`              // Job ID (used by worker)
+	NSFWPercentage float32 `json:"nsfw_percentage"` // NSFW percentage
+	SFWPercentage  float32 `json:"sfw_percentage"`  // SFW percentage
+	Duration       float64 `json:"duration"`        // Processing time in seconds
+	Timestamp      int64   `json:"timestamp"`       // UNIX timestamp
+	UUID           string  `json:"uuid"`            // Unique identifier
+	SHA256         string  `json:"sha256"`          // SHA256 hash
+	Error          string  `json:"error,omitempty"` // Error message
+	Trace          string  `json:"trace,omitempty"` // Error trace
+	Success        bool    `json:"success"`         // Success flag
+
+	Decision      string     `json:"decision,omitempty"`       // Final label, a moderator's verdict takes precedence over the model
+	Source        string     `json:"source,omitempty"`         // Who made the decision: "model" or "human"
+	ReviewedLabel string     `json:"reviewed_label,omitempty"` // Label set by a moderator
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`    // When the moderator decided
+}
+
+// Label returns the winning class ("NSFW" or "SFW") and its percentage
+func (p *Prediction) Label() (string, float32) {
+	if p.NSFWPercentage > p.SFWPercentage {
+		return "NSFW", p.NSFWPercentage
+	}
+	return "SFW", p.SFWPercentage
+}
+
+// LabelAt returns "NSFW" with its percentage when the NSFW percentage is above threshold,
+// "SFW" with its percentage otherwise
+func (p *Prediction) LabelAt(threshold float32) (string, float32) {
+	if p.NSFWPercentage > threshold {
+		return "NSFW", p.NSFWPercentage
+	}
+	return "SFW", p.SFWPercentage
+}
+
+// SetModelDecision makes the model's label at threshold the decision
+func (p *Prediction) SetModelDecision(threshold float32) {
+	p.Decision, _ = p.LabelAt(threshold)
+	p.Source = SourceModel
+}
+
+// ApplyReview makes a moderator's verdict the decision, keeping the model scores for reference
+func (p *Prediction) ApplyReview(label string, reviewedAt *time.Time) {
+	p.Decision = label
+	p.Source = SourceHuman
+	p.ReviewedLabel = label
+	p.ReviewedAt = reviewedAt
+}
@@ -0,0 +1,75 @@
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrExpired is returned for URLs used after their expiry
+	ErrExpired = errors.New("signed URL expired")
+	// ErrInvalidSignature is returned for URLs that were not issued by this server or were altered
+	ErrInvalidSignature = errors.New("invalid URL signature")
+)
+
+// Signer issues and checks short lived links to stored files. A link is the file's path
+// with an expiry time and an HMAC-SHA256 of both, so anyone holding it can fetch that one
+// file until it expires without any other credentials.
+type Signer struct {
+	key []byte
+	ttl time.Duration
+}
+
+func New(key string, ttl time.Duration) *Signer {
+	return &Signer{key: []byte(key), ttl: ttl}
+}
+
+// Sign returns path with the query parameters that make it valid for the signer's TTL
+func (s *Signer) Sign(path string) string {
+	expires := strconv.FormatInt(time.Now().Add(s.ttl).Unix(), 10)
+
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("sig", s.signature(path, expires))
+
+	return path + "?" + query.Encode()
+}
+
+// Verify checks the query of a request for path and returns how long the link remains valid
+func (s *Signer) Verify(path string, query url.Values) (time.Duration, error) {
+	expires := query.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+
+	sig, err := hex.DecodeString(query.Get("sig"))
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+
+	want, _ := hex.DecodeString(s.signature(path, expires))
+	if !hmac.Equal(sig, want) {
+		return 0, ErrInvalidSignature
+	}
+
+	remaining := time.Unix(unix, 0).Sub(time.Now())
+	if remaining <= 0 {
+		return 0, ErrExpired
+	}
+
+	return remaining, nil
+}
+
+func (s *Signer) signature(path, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
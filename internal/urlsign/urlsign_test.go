@@ -0,0 +1,133 @@
+package urlsign
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const path = "/admin/files/tenants/2/abc.jpg"
+
+// split separates a link returned by Sign into its path and query
+func split(t *testing.T, link string) (string, url.Values) {
+	t.Helper()
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("Sign returned an invalid URL %q: %v", link, err)
+	}
+	return u.Path, u.Query()
+}
+
+func TestVerify(t *testing.T) {
+	signer := New("secret", time.Minute)
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		// alter changes the path and query of a freshly signed link
+		alter func(path string, query url.Values) string
+		want  error
+	}{
+		{
+			name:  "valid",
+			alter: func(p string, q url.Values) string { return p },
+		},
+		{
+			name:   "expired",
+			signer: New("secret", -time.Second),
+			alter:  func(p string, q url.Values) string { return p },
+			want:   ErrExpired,
+		},
+		{
+			name:   "signed with another key",
+			signer: New("other secret", time.Minute),
+			alter:  func(p string, q url.Values) string { return p },
+			want:   ErrInvalidSignature,
+		},
+		{
+			name:  "other file",
+			alter: func(p string, q url.Values) string { return strings.Replace(p, "abc", "abd", 1) },
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "other tenant",
+			alter: func(p string, q url.Values) string { return strings.Replace(p, "/2/", "/3/", 1) },
+			want:  ErrInvalidSignature,
+		},
+		{
+			name: "extended expiry",
+			alter: func(p string, q url.Values) string {
+				expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+				q.Set("expires", strconv.FormatInt(expires+3600, 10))
+				return p
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "flipped signature",
+			alter: func(p string, q url.Values) string {
+				sig := []byte(q.Get("sig"))
+				if sig[0] == '0' {
+					sig[0] = '1'
+				} else {
+					sig[0] = '0'
+				}
+				q.Set("sig", string(sig))
+				return p
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "truncated signature",
+			alter: func(p string, q url.Values) string {
+				q.Set("sig", q.Get("sig")[:32])
+				return p
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name:  "missing signature",
+			alter: func(p string, q url.Values) string { q.Del("sig"); return p },
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "malformed signature",
+			alter: func(p string, q url.Values) string { q.Set("sig", "not hex"); return p },
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "missing expiry",
+			alter: func(p string, q url.Values) string { q.Del("expires"); return p },
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "malformed expiry",
+			alter: func(p string, q url.Values) string { q.Set("expires", "tomorrow"); return p },
+			want:  ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := signer
+			if tt.signer != nil {
+				issuer = tt.signer
+			}
+
+			p, query := split(t, issuer.Sign(path))
+			p = tt.alter(p, query)
+
+			remaining, err := signer.Verify(p, query)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Verify = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (remaining <= 0 || remaining > time.Minute) {
+				t.Fatalf("Verify left %v, want up to a minute", remaining)
+			}
+		})
+	}
+}
@@ -4,24 +4,56 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 
+	"github.com/mlvieira/nsfwdetection/internal/config"
 	"github.com/mlvieira/nsfwdetection/internal/logger"
 	"github.com/mlvieira/nsfwdetection/internal/models"
 )
 
+// GetClientIP returns the address of the client. X-Forwarded-For is only believed when
+// the request comes from one of server.trusted_proxies, and then read from the right,
+// skipping the trusted proxies, as everything to the left of them is client supplied.
 func GetClientIP(r *http.Request) string {
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		return strings.Split(forwarded, ",")[0]
-	}
-
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return r.RemoteAddr
+		ip = r.RemoteAddr
+	}
+
+	trusted := config.AppConfig.Server.TrustedProxyNets
+	if len(trusted) == 0 || !isTrustedProxy(ip, trusted) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !isTrustedProxy(hop, trusted) {
+			break
+		}
 	}
 	return ip
 }
 
+func isTrustedProxy(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 // WriteJSONError sends a JSON-formatted error response
 func WriteJSONError(w http.ResponseWriter, statusCode int, message string, details ...string) {
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,84 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// MinPasswordLength is the shortest password accepted for admin users
+	MinPasswordLength = 12
+	// maxPasswordBytes is the most bcrypt hashes, anything longer would be silently cut
+	maxPasswordBytes = 72
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{2,49}$`)
+
+// ValidateUsername accepts 3 to 50 letters, digits, dots, dashes and underscores,
+// starting with a letter or digit
+func ValidateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return errors.New("username must be 3 to 50 letters, digits, dots, dashes or underscores")
+	}
+	return nil
+}
+
+// ValidateClientName applies the username rules to the client names of API keys
+func ValidateClientName(name string) error {
+	if !usernamePattern.MatchString(name) {
+		return errors.New("client name must be 3 to 50 letters, digits, dots, dashes or underscores")
+	}
+	return nil
+}
+
+// ValidateTenantName applies the username rules to the names of tenants
+func ValidateTenantName(name string) error {
+	if !usernamePattern.MatchString(name) {
+		return errors.New("tenant name must be 3 to 50 letters, digits, dots, dashes or underscores")
+	}
+	return nil
+}
+
+// ValidatePassword enforces the password rules for admin users: at least
+// MinPasswordLength characters, at most 72 bytes, three of lowercase, uppercase,
+// digits and symbols, and not containing the username
+func ValidatePassword(password, username string) error {
+	if len([]rune(password)) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("password must not contain the username")
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	if classes < 3 {
+		return errors.New("password must mix at least three of lowercase letters, uppercase letters, digits and symbols")
+	}
+
+	return nil
+}
@@ -6,16 +6,23 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-// Client represents a single WebSocket connection
+// Client represents a single WebSocket connection of a user of TenantID
 type Client struct {
-	Conn *websocket.Conn
-	Send chan []byte
+	Conn     *websocket.Conn
+	Send     chan []byte
+	TenantID int
+}
+
+// Message is broadcast to the clients of TenantID only
+type Message struct {
+	TenantID int
+	Data     []byte
 }
 
 // Hub manages connected clients and broadcasts messages
 type Hub struct {
 	Clients    map[*Client]bool
-	Broadcast  chan []byte
+	Broadcast  chan Message
 	Register   chan *Client
 	Unregister chan *Client
 	mu         sync.Mutex
@@ -25,7 +32,7 @@ type Hub struct {
 func NewHub() *Hub {
 	return &Hub{
 		Clients:    make(map[*Client]bool),
-		Broadcast:  make(chan []byte),
+		Broadcast:  make(chan Message),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 	}
@@ -49,7 +56,9 @@ func (h *Hub) Run() {
 		case message := <-h.Broadcast:
 			h.mu.Lock()
 			for client := range h.Clients {
-				client.Send <- message
+				if client.TenantID == message.TenantID {
+					client.Send <- message.Data
+				}
 			}
 			h.mu.Unlock()
 		}
@@ -0,0 +1,51 @@
+package worker
+
+import "sync"
+
+// Queue is a backend capable of running NSFW detection jobs.
+// Results are delivered on job.ResultsChan.
+type Queue interface {
+	Submit(job Job)
+	Shutdown()
+}
+
+// localQueue runs jobs on the in-process worker pool
+type localQueue struct{}
+
+func (localQueue) Submit(job Job) {
+	submitLocal(job)
+}
+
+func (localQueue) Shutdown() {
+	ShutdownWorkerPool()
+}
+
+var (
+	activeQueue Queue = localQueue{}
+	queueMux    sync.Mutex
+)
+
+// UseQueue replaces the backend used by SubmitJob
+func UseQueue(q Queue) {
+	queueMux.Lock()
+	defer queueMux.Unlock()
+
+	activeQueue = q
+}
+
+// SubmitJob hands a job to the active queue (the in-process pool unless UseQueue was called)
+func SubmitJob(job Job) {
+	queueMux.Lock()
+	q := activeQueue
+	queueMux.Unlock()
+
+	q.Submit(job)
+}
+
+// ShutdownQueue stops the active queue backend
+func ShutdownQueue() {
+	queueMux.Lock()
+	defer queueMux.Unlock()
+
+	activeQueue.Shutdown()
+}
@@ -0,0 +1,286 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/tfmodel"
+)
+
+// RedisConsumer pulls jobs from the Redis stream, runs the model and publishes results.
+// Jobs left unacknowledged for longer than the visibility timeout (e.g. because the
+// worker holding them crashed) are reclaimed and delivered again.
+type RedisConsumer struct {
+	client            *redis.Client
+	model             tfmodel.Detector
+	stream            string
+	group             string
+	name              string
+	tempDir           string
+	visibilityTimeout time.Duration
+	resultTTL         time.Duration
+	maxDeliveries     int64
+}
+
+// NewRedisConsumer creates a consumer identified by name within the configured group
+func NewRedisConsumer(client *redis.Client, model tfmodel.Detector, cfg config.WorkerConfig, name, tempDir string) *RedisConsumer {
+	return &RedisConsumer{
+		client:            client,
+		model:             model,
+		stream:            cfg.QueueStream,
+		group:             cfg.ConsumerGroup,
+		name:              name,
+		tempDir:           tempDir,
+		visibilityTimeout: time.Duration(cfg.VisibilityTimeoutSec) * time.Second,
+		resultTTL:         2 * time.Duration(cfg.ResultTimeoutSec) * time.Second,
+		maxDeliveries:     cfg.MaxDeliveries,
+	}
+}
+
+// EnsureGroup creates the stream and consumer group if they don't exist yet
+func (c *RedisConsumer) EnsureGroup(ctx context.Context) error {
+	err := c.client.XGroupCreateMkStream(ctx, c.stream, c.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group: %w", err)
+	}
+	return nil
+}
+
+// Run processes jobs until ctx is cancelled
+func (c *RedisConsumer) Run(ctx context.Context) error {
+	if err := c.EnsureGroup(ctx); err != nil {
+		return err
+	}
+
+	for ctx.Err() == nil {
+		msgs, err := c.next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logger.Error("Consumer %s: failed to fetch jobs: %v", c.name, err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		for _, msg := range msgs {
+			c.handle(msg)
+		}
+	}
+
+	logger.Info("Consumer %s stopped", c.name)
+	return nil
+}
+
+// next returns expired jobs reclaimed from crashed workers first, then new jobs
+func (c *RedisConsumer) next(ctx context.Context) ([]redis.XMessage, error) {
+	claimed, _, err := c.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   c.stream,
+		Group:    c.group,
+		Consumer: c.name,
+		MinIdle:  c.visibilityTimeout,
+		Start:    "0-0",
+		Count:    1,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(claimed) > 0 {
+		logger.Info("Consumer %s: reclaimed job %s", c.name, claimed[0].ID)
+		return claimed, nil
+	}
+
+	streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.group,
+		Consumer: c.name,
+		Streams:  []string{c.stream, ">"},
+		Count:    1,
+		Block:    c.blockTimeout(),
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []redis.XMessage
+	for _, s := range streams {
+		msgs = append(msgs, s.Messages...)
+	}
+	return msgs, nil
+}
+
+// blockTimeout is how long next waits for new jobs. It is capped at the visibility timeout
+// so jobs of crashed workers are reclaimed within twice that even when the stream is idle.
+func (c *RedisConsumer) blockTimeout() time.Duration {
+	if c.visibilityTimeout < 5*time.Second {
+		return c.visibilityTimeout
+	}
+	return 5 * time.Second
+}
+
+// handle runs a single job. It is not acknowledged until the result has been published,
+// so a crash at any point leaves it pending for another worker.
+func (c *RedisConsumer) handle(msg redis.XMessage) {
+	ctx := context.Background()
+
+	raw, _ := msg.Values["job"].(string)
+	var job queuedJob
+	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+		logger.Error("Consumer %s: dropping malformed job %s: %v", c.name, msg.ID, err)
+		c.ack(ctx, msg.ID)
+		return
+	}
+
+	deliveries, err := c.deliveryCount(ctx, msg.ID)
+	if err != nil {
+		logger.Error("Consumer %s: failed to read delivery count for job %s: %v", c.name, job.JobID, err)
+	}
+	if deliveries > c.maxDeliveries {
+		logger.Error("Consumer %s: job %s exceeded %d deliveries, giving up", c.name, job.JobID, c.maxDeliveries)
+		c.publish(ctx, msg.ID, job, &tfmodel.Prediction{
+			ID:        job.ID,
+			Error:     "job exceeded maximum deliveries",
+			Trace:     "RedisConsumer",
+			Timestamp: time.Now().Unix(),
+			Success:   false,
+		})
+		return
+	}
+
+	stopHeartbeat := c.heartbeat(msg.ID)
+	prediction := c.detect(job)
+	stopHeartbeat()
+
+	c.publish(ctx, msg.ID, job, prediction)
+}
+
+// detect writes the job image to a temp file and runs the model on it
+func (c *RedisConsumer) detect(job queuedJob) *tfmodel.Prediction {
+	startTime := time.Now()
+
+	tempFile, err := os.CreateTemp(c.tempDir, "job-*"+job.Ext)
+	if err != nil {
+		return failedPrediction(job.ID, fmt.Errorf("failed to create temp file: %w", err), startTime)
+	}
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.Write(job.Data)
+	tempFile.Close()
+	if err != nil {
+		return failedPrediction(job.ID, fmt.Errorf("failed to write temp file: %w", err), startTime)
+	}
+
+	prediction, err := processJobWithRetries(0, Job{ID: job.ID, FilePath: tempFile.Name()}, c.model)
+	if err != nil {
+		return failedPrediction(job.ID, err, startTime)
+	}
+
+	return prediction
+}
+
+// publish pushes the result for the API to pick up and acknowledges the job
+func (c *RedisConsumer) publish(ctx context.Context, msgID string, job queuedJob, prediction *tfmodel.Prediction) {
+	payload, err := json.Marshal(prediction)
+	if err != nil {
+		logger.Error("Consumer %s: failed to encode result for job %s: %v", c.name, job.JobID, err)
+		return
+	}
+
+	key := resultKey(c.stream, job.JobID)
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, payload)
+		pipe.Expire(ctx, key, c.resultTTL)
+		return nil
+	})
+	if err != nil {
+		logger.Error("Consumer %s: failed to publish result for job %s: %v", c.name, job.JobID, err)
+		return
+	}
+
+	c.ack(ctx, msgID)
+	logger.Info("Consumer %s: finished job %s", c.name, job.JobID)
+}
+
+// ack acknowledges a message and removes it from the stream
+func (c *RedisConsumer) ack(ctx context.Context, msgID string) {
+	if err := c.client.XAck(ctx, c.stream, c.group, msgID).Err(); err != nil {
+		logger.Error("Consumer %s: failed to ack %s: %v", c.name, msgID, err)
+		return
+	}
+	c.client.XDel(ctx, c.stream, msgID)
+}
+
+// deliveryCount returns how many times msgID has been handed to a consumer
+func (c *RedisConsumer) deliveryCount(ctx context.Context, msgID string) (int64, error) {
+	pending, err := c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: c.stream,
+		Group:  c.group,
+		Start:  msgID,
+		End:    msgID,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	return pending[0].RetryCount, nil
+}
+
+// heartbeat keeps resetting the idle time of msgID so slow inference isn't mistaken for a crash.
+// The returned function stops it.
+func (c *RedisConsumer) heartbeat(msgID string) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(c.visibilityTimeout / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := c.client.XClaimJustID(ctx, &redis.XClaimArgs{
+					Stream:   c.stream,
+					Group:    c.group,
+					Consumer: c.name,
+					Messages: []string{msgID},
+				}).Err()
+				if err != nil && ctx.Err() == nil {
+					logger.Error("Consumer %s: heartbeat failed for %s: %v", c.name, msgID, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// failedPrediction builds the error result published for a job that could not be processed
+func failedPrediction(id int, err error, startTime time.Time) *tfmodel.Prediction {
+	return &tfmodel.Prediction{
+		ID:        id,
+		Error:     err.Error(),
+		Trace:     "RedisConsumer",
+		Timestamp: time.Now().Unix(),
+		Duration:  float64(time.Since(startTime).Seconds()),
+		Success:   false,
+	}
+}
@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/tfmodel"
+)
+
+// queuedJob is the payload stored in the Redis stream.
+// The image travels with the job so workers don't need access to the API's disk.
+type queuedJob struct {
+	JobID string `json:"job_id"`
+	ID    int    `json:"id"`
+	Ext   string `json:"ext"`
+	Data  []byte `json:"data"`
+}
+
+// RedisQueue publishes jobs to a Redis stream consumed by cmd/worker
+type RedisQueue struct {
+	client        *redis.Client
+	stream        string
+	resultTimeout time.Duration
+
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	shutdown bool
+}
+
+// NewRedisQueue creates a queue that publishes to the configured stream
+func NewRedisQueue(client *redis.Client, cfg config.WorkerConfig) *RedisQueue {
+	return &RedisQueue{
+		client:        client,
+		stream:        cfg.QueueStream,
+		resultTimeout: time.Duration(cfg.ResultTimeoutSec) * time.Second,
+	}
+}
+
+// resultKey is the list a worker pushes the prediction for jobID onto
+func resultKey(stream, jobID string) string {
+	return fmt.Sprintf("%s:result:%s", stream, jobID)
+}
+
+// Submit publishes the job and waits for its result in the background
+func (q *RedisQueue) Submit(job Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.shutdown {
+		logger.Error("Cannot submit job %d: Redis queue is shutting down", job.ID)
+		return
+	}
+
+	data, err := os.ReadFile(job.FilePath)
+	if err != nil {
+		logger.Error("Failed to read file for job %d: %v", job.ID, err)
+		return
+	}
+
+	jobID := uuid.New().String()
+	payload, err := json.Marshal(queuedJob{
+		JobID: jobID,
+		ID:    job.ID,
+		Ext:   filepath.Ext(job.FilePath),
+		Data:  data,
+	})
+	if err != nil {
+		logger.Error("Failed to encode job %d: %v", job.ID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.stream,
+		Values: map[string]interface{}{"job": payload},
+	}).Err()
+	if err != nil {
+		logger.Error("Failed to publish job %d: %v", job.ID, err)
+		return
+	}
+
+	q.wg.Add(1)
+	go q.awaitResult(job, jobID)
+}
+
+// awaitResult blocks until a worker publishes the prediction or the result timeout passes
+func (q *RedisQueue) awaitResult(job Job, jobID string) {
+	defer q.wg.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), q.resultTimeout+time.Second)
+	defer cancel()
+
+	key := resultKey(q.stream, jobID)
+	res, err := q.client.BLPop(ctx, q.resultTimeout, key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			logger.Error("Failed waiting for result of job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	var prediction tfmodel.Prediction
+	if err := json.Unmarshal([]byte(res[1]), &prediction); err != nil {
+		logger.Error("Failed to decode result of job %d: %v", job.ID, err)
+		return
+	}
+	prediction.ID = job.ID
+
+	select {
+	case job.ResultsChan <- &prediction:
+	default:
+		logger.Error("Failed to send result for job %d - nobody waiting", job.ID)
+	}
+}
+
+// Shutdown stops accepting jobs and waits for pending results
+func (q *RedisQueue) Shutdown() {
+	q.mu.Lock()
+	if q.shutdown {
+		q.mu.Unlock()
+		logger.Info("Redis queue is already shutting down")
+		return
+	}
+	q.shutdown = true
+	q.mu.Unlock()
+
+	q.wg.Wait()
+	logger.Info("Redis queue shut down successfully")
+}
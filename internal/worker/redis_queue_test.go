@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/mlvieira/nsfwdetection/internal/config"
+	"github.com/mlvieira/nsfwdetection/internal/logger"
+	"github.com/mlvieira/nsfwdetection/internal/tfmodel"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "worker-test")
+	if err != nil {
+		panic(err)
+	}
+
+	if err := logger.Init(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// openRedis connects to the server in NSFW_TEST_REDIS_ADDR or skips the test
+func openRedis(t *testing.T) *redis.Client {
+	t.Helper()
+
+	addr := os.Getenv("NSFW_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("NSFW_TEST_REDIS_ADDR not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("failed to connect to Redis at %s: %v", addr, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// TestRedeliveryReachesCaller abandons a job the way a crashed worker would and checks
+// the prediction of the worker reclaiming it still reaches the waiting caller.
+func TestRedeliveryReachesCaller(t *testing.T) {
+	client := openRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.WorkerConfig{
+		QueueStream:          "nsfw:test:" + uuid.New().String(),
+		ConsumerGroup:        "nsfw-test",
+		VisibilityTimeoutSec: 1,
+		MaxDeliveries:        3,
+		ResultTimeoutSec:     3,
+	}
+	t.Cleanup(func() { client.Del(context.Background(), cfg.QueueStream) })
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "image.jpg")
+	if err := os.WriteFile(path, []byte("not really a jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	model := tfmodel.NewFakeModel()
+	crashed := NewRedisConsumer(client, model, cfg, "crashed", dir)
+	if err := crashed.EnsureGroup(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := NewRedisQueue(client, cfg)
+	results := make(chan *tfmodel.Prediction, 1)
+	queue.Submit(Job{ID: 7, FilePath: path, ResultsChan: results})
+	defer queue.Shutdown()
+
+	// The job is delivered to a worker that dies before acknowledging it
+	msgs, err := crashed.next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("crashed worker got %d jobs, want 1", len(msgs))
+	}
+
+	survivor := NewRedisConsumer(client, model, cfg, "survivor", dir)
+	go survivor.Run(ctx)
+
+	select {
+	case prediction := <-results:
+		if !prediction.Success || prediction.ID != 7 {
+			t.Fatalf("got %+v, want a successful prediction for job 7", prediction)
+		}
+	case <-time.After(time.Duration(cfg.ResultTimeoutSec+1) * time.Second):
+		t.Fatal("redelivered job never reached the caller")
+	}
+}
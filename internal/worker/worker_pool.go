@@ -28,7 +28,7 @@ var (
 )
 
 // InitWorkerPool initializes the global job queue and spawns worker goroutines
-func InitWorkerPool(nsfwModel *tfmodel.Model) {
+func InitWorkerPool(nsfwModel tfmodel.Detector) {
 	once.Do(func() {
 		jobQueue = make(chan Job, MaxJobs)
 
@@ -40,7 +40,7 @@ func InitWorkerPool(nsfwModel *tfmodel.Model) {
 }
 
 // workerLoop continuously processes jobs from the jobQueue.
-func workerLoop(workerID int, nsfwModel *tfmodel.Model) {
+func workerLoop(workerID int, nsfwModel tfmodel.Detector) {
 	defer wg.Done()
 
 	for job := range jobQueue {
@@ -59,7 +59,7 @@ func workerLoop(workerID int, nsfwModel *tfmodel.Model) {
 }
 
 // processJobWithRetries tries nsfwModel.DetectNSFW up to MaxRetries times.
-func processJobWithRetries(workerID int, job Job, nsfwModel *tfmodel.Model) (*tfmodel.Prediction, error) {
+func processJobWithRetries(workerID int, job Job, nsfwModel tfmodel.Detector) (*tfmodel.Prediction, error) {
 	var prediction *tfmodel.Prediction
 	var err error
 
@@ -105,8 +105,8 @@ func sendSuccessfulPrediction(workerID int, job Job, prediction *tfmodel.Predict
 	}
 }
 
-// SubmitJob places a job on the global queue
-func SubmitJob(job Job) {
+// submitLocal places a job on the in-process job queue
+func submitLocal(job Job) {
 	shutdownMux.Lock()
 	defer shutdownMux.Unlock()
 
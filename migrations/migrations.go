@@ -0,0 +1,18 @@
+// Package migrations embeds the SQL schema migrations so binaries can apply them without external tools.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// files holds one directory of migrations per database driver,
+// named <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// For returns the migrations of a driver
+func For(driver string) (fs.FS, error) {
+	return fs.Sub(files, driver)
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `uploaded_images` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `file_path` varchar(255) NOT NULL,
+  `file_hash` varchar(64) NOT NULL,
+  `label` varchar(10) NOT NULL DEFAULT 'unlabeled',
+  `new_label` varchar(10) NOT NULL DEFAULT 'unlabeled',
+  `confidence` float NOT NULL,
+  `reviewed` tinyint(1) NOT NULL DEFAULT 0,
+  `created_at` datetime NOT NULL,
+  `updated_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uploaded_images_file_hash_idx` (`file_hash`),
+  KEY `uploaded_images_reviewed_id_idx` (`reviewed`,`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
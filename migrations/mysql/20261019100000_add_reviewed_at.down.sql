@@ -0,0 +1 @@
+ALTER TABLE `uploaded_images` DROP COLUMN `reviewed_at`;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `image_events` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `file_hash` varchar(64) NOT NULL,
+  `action` varchar(16) NOT NULL,
+  `username` varchar(255) NOT NULL,
+  `previous_label` varchar(10) NOT NULL DEFAULT '',
+  `new_label` varchar(10) NOT NULL DEFAULT '',
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `image_events_file_hash_id_idx` (`file_hash`,`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,2 @@
+DROP INDEX `uploaded_images_deleted_at_idx` ON `uploaded_images`;
+ALTER TABLE `uploaded_images` DROP COLUMN `deleted_at`;
@@ -0,0 +1 @@
+UPDATE `uploaded_images` SET `file_path` = CONCAT('/static/uploads/', `file_path`) WHERE `file_path` NOT LIKE '/static/uploads/%';
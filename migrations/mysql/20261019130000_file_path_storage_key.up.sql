@@ -0,0 +1,2 @@
+-- file_path holds the storage key instead of the URL nginx served the file at
+UPDATE `uploaded_images` SET `file_path` = SUBSTRING(`file_path`, 17) WHERE `file_path` LIKE '/static/uploads/%';
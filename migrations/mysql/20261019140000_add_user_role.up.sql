@@ -0,0 +1,2 @@
+ALTER TABLE `users` ADD COLUMN `role` varchar(20) NOT NULL DEFAULT 'viewer';
+UPDATE `users` SET `role` = 'admin';
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS `refresh_tokens` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `token_hash` char(64) NOT NULL,
+  `family_id` char(36) NOT NULL,
+  `username` varchar(255) NOT NULL,
+  `expires_at` datetime NOT NULL,
+  `used_at` datetime DEFAULT NULL,
+  `revoked_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `refresh_tokens_token_hash_idx` (`token_hash`),
+  KEY `refresh_tokens_family_id_idx` (`family_id`),
+  KEY `refresh_tokens_username_idx` (`username`),
+  KEY `refresh_tokens_expires_at_idx` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,12 @@
+ALTER TABLE `users` ADD COLUMN `totp_secret` varchar(64) NULL DEFAULT NULL;
+ALTER TABLE `users` ADD COLUMN `totp_enabled_at` datetime NULL DEFAULT NULL;
+ALTER TABLE `users` ADD COLUMN `totp_last_step` bigint NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS `recovery_codes` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `username` varchar(255) NOT NULL,
+  `code_hash` char(64) NOT NULL,
+  `used_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `recovery_codes_username_idx` (`username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,2 @@
+ALTER TABLE `users` DROP INDEX `users_oidc_subject_idx`;
+ALTER TABLE `users` DROP COLUMN `oidc_subject`;
@@ -0,0 +1,2 @@
+ALTER TABLE `users` ADD COLUMN `oidc_subject` varchar(255) NULL DEFAULT NULL;
+ALTER TABLE `users` ADD UNIQUE KEY `users_oidc_subject_idx` (`oidc_subject`);
@@ -0,0 +1,2 @@
+ALTER TABLE `uploaded_images` DROP COLUMN `client_name`;
+DROP TABLE IF EXISTS `api_keys`;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS `api_keys` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `prefix` varchar(16) NOT NULL,
+  `key_hash` char(64) NOT NULL,
+  `client_name` varchar(255) NOT NULL,
+  `rate_limit_per_min` int(11) NOT NULL DEFAULT 0,
+  `daily_quota` int(11) NOT NULL DEFAULT 0,
+  `monthly_quota` int(11) NOT NULL DEFAULT 0,
+  `created_by` varchar(255) NOT NULL,
+  `last_used_at` datetime DEFAULT NULL,
+  `revoked_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `api_keys_key_hash_idx` (`key_hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+ALTER TABLE `uploaded_images` ADD COLUMN `client_name` varchar(255) NULL DEFAULT NULL;
@@ -0,0 +1,10 @@
+ALTER TABLE `uploaded_images` DROP INDEX `uploaded_images_tenant_reviewed_id_idx`;
+ALTER TABLE `uploaded_images` DROP INDEX `uploaded_images_file_hash_idx`;
+ALTER TABLE `uploaded_images` DROP INDEX `uploaded_images_tenant_file_hash_idx`;
+ALTER TABLE `uploaded_images` ADD UNIQUE KEY `uploaded_images_file_hash_idx` (`file_hash`);
+ALTER TABLE `uploaded_images` DROP COLUMN `tenant_id`;
+ALTER TABLE `image_events` DROP INDEX `image_events_tenant_id_idx`;
+ALTER TABLE `image_events` DROP COLUMN `tenant_id`;
+ALTER TABLE `api_keys` DROP COLUMN `tenant_id`;
+ALTER TABLE `users` DROP COLUMN `tenant_id`;
+DROP TABLE IF EXISTS `tenants`;
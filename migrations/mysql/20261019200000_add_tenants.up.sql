@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS `tenants` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `name` varchar(50) NOT NULL,
+  `nsfw_threshold` float NOT NULL DEFAULT 50,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `tenants_name_idx` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+INSERT INTO `tenants` (`id`, `name`, `nsfw_threshold`, `created_at`) VALUES (1, 'default', 50, CURRENT_TIMESTAMP);
+ALTER TABLE `users` ADD COLUMN `tenant_id` int(11) NOT NULL DEFAULT 1;
+ALTER TABLE `api_keys` ADD COLUMN `tenant_id` int(11) NOT NULL DEFAULT 1;
+ALTER TABLE `image_events` ADD COLUMN `tenant_id` int(11) NOT NULL DEFAULT 1;
+ALTER TABLE `image_events` ADD KEY `image_events_tenant_id_idx` (`tenant_id`,`id`);
+ALTER TABLE `uploaded_images` ADD COLUMN `tenant_id` int(11) NOT NULL DEFAULT 1;
+ALTER TABLE `uploaded_images` DROP INDEX `uploaded_images_file_hash_idx`;
+ALTER TABLE `uploaded_images` ADD UNIQUE KEY `uploaded_images_tenant_file_hash_idx` (`tenant_id`,`file_hash`);
+ALTER TABLE `uploaded_images` ADD KEY `uploaded_images_file_hash_idx` (`file_hash`);
+ALTER TABLE `uploaded_images` ADD KEY `uploaded_images_tenant_reviewed_id_idx` (`tenant_id`,`reviewed`,`id`);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS uploaded_images (
+  id SERIAL PRIMARY KEY,
+  file_path VARCHAR(255) NOT NULL,
+  file_hash VARCHAR(64) NOT NULL,
+  label VARCHAR(10) NOT NULL DEFAULT 'unlabeled',
+  new_label VARCHAR(10) NOT NULL DEFAULT 'unlabeled',
+  confidence REAL NOT NULL,
+  reviewed BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uploaded_images_file_hash_idx ON uploaded_images (file_hash);
+CREATE INDEX IF NOT EXISTS uploaded_images_reviewed_id_idx ON uploaded_images (reviewed, id);
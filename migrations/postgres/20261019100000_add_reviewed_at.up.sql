@@ -0,0 +1,2 @@
+ALTER TABLE uploaded_images ADD COLUMN reviewed_at TIMESTAMP NULL;
+UPDATE uploaded_images SET reviewed_at = updated_at WHERE reviewed = TRUE;
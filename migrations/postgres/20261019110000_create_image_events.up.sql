@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS image_events (
+  id SERIAL PRIMARY KEY,
+  file_hash VARCHAR(64) NOT NULL,
+  action VARCHAR(16) NOT NULL,
+  username VARCHAR(255) NOT NULL,
+  previous_label VARCHAR(10) NOT NULL DEFAULT '',
+  new_label VARCHAR(10) NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS image_events_file_hash_id_idx ON image_events (file_hash, id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uploaded_images_deleted_at_idx;
+ALTER TABLE uploaded_images DROP COLUMN deleted_at;
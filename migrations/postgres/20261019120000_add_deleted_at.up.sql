@@ -0,0 +1,2 @@
+ALTER TABLE uploaded_images ADD COLUMN deleted_at TIMESTAMP NULL;
+CREATE INDEX IF NOT EXISTS uploaded_images_deleted_at_idx ON uploaded_images (deleted_at);
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN oidc_subject VARCHAR(255) NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_subject_idx ON users (oidc_subject);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+  id SERIAL PRIMARY KEY,
+  prefix VARCHAR(16) NOT NULL,
+  key_hash CHAR(64) NOT NULL,
+  client_name VARCHAR(255) NOT NULL,
+  rate_limit_per_min INTEGER NOT NULL DEFAULT 0,
+  daily_quota INTEGER NOT NULL DEFAULT 0,
+  monthly_quota INTEGER NOT NULL DEFAULT 0,
+  created_by VARCHAR(255) NOT NULL,
+  last_used_at TIMESTAMP NULL,
+  revoked_at TIMESTAMP NULL,
+  created_at TIMESTAMP NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_key_hash_idx ON api_keys (key_hash);
+ALTER TABLE uploaded_images ADD COLUMN client_name VARCHAR(255) NULL;
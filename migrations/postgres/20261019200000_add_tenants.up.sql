@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS tenants (
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(50) NOT NULL,
+  nsfw_threshold REAL NOT NULL DEFAULT 50,
+  created_at TIMESTAMP NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS tenants_name_idx ON tenants (name);
+INSERT INTO tenants (id, name, nsfw_threshold, created_at) VALUES (1, 'default', 50, CURRENT_TIMESTAMP);
+SELECT setval('tenants_id_seq', (SELECT MAX(id) FROM tenants));
+ALTER TABLE users ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE api_keys ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE image_events ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS image_events_tenant_id_idx ON image_events (tenant_id, id);
+ALTER TABLE uploaded_images ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+DROP INDEX IF EXISTS uploaded_images_file_hash_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS uploaded_images_tenant_file_hash_idx ON uploaded_images (tenant_id, file_hash);
+CREATE INDEX IF NOT EXISTS uploaded_images_file_hash_idx ON uploaded_images (file_hash);
+CREATE INDEX IF NOT EXISTS uploaded_images_tenant_reviewed_id_idx ON uploaded_images (tenant_id, reviewed, id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_oidc_subject_idx;
+ALTER TABLE users DROP COLUMN oidc_subject;
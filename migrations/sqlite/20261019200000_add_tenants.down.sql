@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS uploaded_images_tenant_reviewed_id_idx;
+DROP INDEX IF EXISTS uploaded_images_file_hash_idx;
+DROP INDEX IF EXISTS uploaded_images_tenant_file_hash_idx;
+ALTER TABLE uploaded_images DROP COLUMN tenant_id;
+CREATE UNIQUE INDEX IF NOT EXISTS uploaded_images_file_hash_idx ON uploaded_images (file_hash);
+DROP INDEX IF EXISTS image_events_tenant_id_idx;
+ALTER TABLE image_events DROP COLUMN tenant_id;
+ALTER TABLE api_keys DROP COLUMN tenant_id;
+ALTER TABLE users DROP COLUMN tenant_id;
+DROP TABLE IF EXISTS tenants;